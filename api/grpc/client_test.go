@@ -3,31 +3,40 @@ package grpc
 import (
 	"context"
 	"fmt"
+	"github.com/awakari/source-telegram/auth"
 	"github.com/awakari/source-telegram/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"io"
 	"log/slog"
 	"os"
 	"testing"
+	"time"
 )
 
 var port uint16 = 50051
 
 var log = slog.Default()
 
-var chCode = make(chan string)
+const adminToken = "admin0"
+
+var auths = map[int]auth.Authorizer{
+	0: auth.NewAuthorizerMock(0),
+}
 
 func TestMain(m *testing.M) {
 	svc := service.NewServiceMock()
 	svc = service.NewServiceLogging(svc, log)
-	c := NewController(chCode)
+	c := NewController(auths)
 	c.SetService(svc)
 	go func() {
-		err := Serve(c, port)
+		err := Serve(c, port, health.NewServer(), adminToken)
 		if err != nil {
 			log.Error(err.Error())
 		}
@@ -89,6 +98,16 @@ func TestServiceClient_Create(t *testing.T) {
 			},
 			err: status.Error(codes.PermissionDenied, "chat/message contains the #nobot tag"),
 		},
+		"full": {
+			ch: &Channel{
+				Id:      -123456789,
+				GroupId: "group0",
+				UserId:  "user0",
+				Name:    "full",
+				Link:    "https://t.me/channel0",
+			},
+			err: status.Error(codes.ResourceExhausted, "all replicas reached the joined channels limit"),
+		},
 	}
 	//
 	for k, c := range cases {
@@ -183,6 +202,113 @@ func TestServiceClient_Delete(t *testing.T) {
 	}
 }
 
+func TestServiceClient_Restore(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewServiceClient(conn)
+	//
+	cases := map[string]struct {
+		link string
+		ch   *Channel
+		err  error
+	}{
+		"ok": {
+			link: "https://t.me/channel0",
+			ch: &Channel{
+				Id:      -1001801930101,
+				GroupId: "group0",
+				UserId:  "user0",
+				Name:    "channel0",
+				Link:    "https://t.me/channel0",
+				Label:   "1",
+			},
+		},
+		"fail": {
+			link: "fail",
+			err:  status.Error(codes.Internal, "internal failure"),
+		},
+		"missing": {
+			link: "missing",
+			err:  status.Error(codes.NotFound, "channel not found"),
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var resp *RestoreResponse
+			resp, err = client.Restore(context.TODO(), &RestoreRequest{
+				Link: c.link,
+			})
+			if c.err == nil {
+				require.Nil(t, err)
+				assert.Equal(t, c.ch.Id, resp.Channel.Id)
+				assert.Equal(t, c.ch.Link, resp.Channel.Link)
+				assert.Equal(t, c.ch.Label, resp.Channel.Label)
+			}
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func TestServiceClient_ListAudit(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewServiceClient(conn)
+	//
+	cases := map[string]struct {
+		req        *ListAuditRequest
+		count      int
+		cursorNext string
+		err        error
+	}{
+		"by channel": {
+			req: &ListAuditRequest{
+				ChannelId: -1001801930101,
+			},
+			count: 1,
+		},
+		"by user, full page": {
+			req: &ListAuditRequest{
+				GroupId: "group0",
+				UserId:  "user0",
+				Limit:   1,
+			},
+			count:      1,
+			cursorNext: "2ob2MB7IGTwakXv4bdXkZ5vt8Br",
+		},
+		"nothing specified": {
+			req: &ListAuditRequest{},
+			err: status.Error(codes.InvalidArgument, "either channel or user should be specified"),
+		},
+		"fail": {
+			req: &ListAuditRequest{
+				Link: "fail",
+			},
+			err: status.Error(codes.Internal, "internal failure"),
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var resp *ListAuditResponse
+			resp, err = client.ListAudit(context.TODO(), c.req)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				require.Len(t, resp.Page, c.count)
+				rec := resp.Page[0]
+				assert.Equal(t, AuditAction_DELETE, rec.Action)
+				assert.Equal(t, "stale", rec.Actor.System)
+				assert.Equal(t, "user0", rec.UserId)
+				assert.Equal(t, c.cursorNext, resp.Cursor)
+			}
+		})
+	}
+}
+
 func TestServiceClient_List(t *testing.T) {
 	//
 	addr := fmt.Sprintf("localhost:%d", port)
@@ -191,29 +317,101 @@ func TestServiceClient_List(t *testing.T) {
 	client := NewServiceClient(conn)
 	//
 	cases := map[string]struct {
-		cursor string
-		page   []int64
-		err    error
+		cursor     string
+		limit      uint32
+		stats      bool
+		page       []int64
+		cursorNext string
+		err        error
 	}{
 		"basic": {
+			limit: 10,
 			page: []int64{
 				-1001801930101,
 				-1001754252633,
 			},
 		},
+		"full page": {
+			limit: 2,
+			page: []int64{
+				-1001801930101,
+				-1001754252633,
+			},
+			cursorNext: "https://t.me/c/1/2",
+		},
 		"end of results": {
 			cursor: "channel1",
+			limit:  10,
+		},
+		"with stats": {
+			limit: 10,
+			stats: true,
+			page: []int64{
+				-1001801930101,
+				-1001754252633,
+			},
 		},
 	}
 	//
 	for k, c := range cases {
 		t.Run(k, func(t *testing.T) {
 			var resp *ListResponse
-			resp, err = client.List(context.TODO(), &ListRequest{Cursor: c.cursor, Limit: 10})
+			resp, err = client.List(context.TODO(), &ListRequest{Cursor: c.cursor, Limit: c.limit, Stats: c.stats})
 			assert.ErrorIs(t, err, c.err)
 			if c.page != nil {
 				assert.Equal(t, len(c.page), len(resp.Page))
 			}
+			for _, ch := range resp.Page {
+				switch c.stats {
+				case true:
+					assert.Equal(t, uint64(3), ch.Stats.Total.Seen)
+				default:
+					assert.Nil(t, ch.Stats)
+				}
+			}
+			assert.Equal(t, c.cursorNext, resp.Cursor)
+		})
+	}
+}
+
+func TestServiceClient_GetChannelStats(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewServiceClient(conn)
+	//
+	cases := map[string]struct {
+		link string
+		err  error
+	}{
+		"ok": {
+			link: "https://t.me/channel0",
+		},
+		"fail": {
+			link: "fail",
+			err:  status.Error(codes.Internal, "internal failure"),
+		},
+		"missing": {
+			link: "missing",
+			err:  status.Error(codes.NotFound, "channel not found"),
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var resp *GetChannelStatsResponse
+			resp, err = client.GetChannelStats(context.TODO(), &GetChannelStatsRequest{
+				Link: c.link,
+				Days: 7,
+			})
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				assert.Equal(t, uint64(2), resp.Stats.Total.Published)
+				assert.Equal(t, map[string]uint64{"unsupported": 1}, resp.Stats.Total.Dropped)
+				require.Len(t, resp.Stats.Days, 1)
+				assert.Equal(t, uint64(42), resp.Stats.Days[0].Counters.TextBytes)
+			}
 		})
 	}
 }
@@ -270,8 +468,17 @@ func TestServiceClient_Login(t *testing.T) {
 		replicaMatch bool
 		err          error
 	}{
-		"ok but no match": {
-			code: "12345",
+		"ok": {
+			code:    "12345",
+			success: true,
+		},
+		"invalid code": {
+			code: "invalid",
+		},
+		"account not hosted": {
+			code:       "12345",
+			replicaIdx: 1,
+			err:        status.Error(codes.NotFound, "account 1 is not hosted by this replica"),
 		},
 	}
 	//
@@ -279,7 +486,8 @@ func TestServiceClient_Login(t *testing.T) {
 		t.Run(k, func(t *testing.T) {
 			var resp *LoginResponse
 			resp, err = client.Login(context.TODO(), &LoginRequest{
-				Code: c.code,
+				Code:  c.code,
+				Index: c.replicaIdx,
 			})
 			assert.ErrorIs(t, err, c.err)
 			if c.err == nil {
@@ -289,3 +497,535 @@ func TestServiceClient_Login(t *testing.T) {
 	}
 
 }
+
+func TestAdminClient_GetAuthState(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	cases := map[string]struct {
+		token string
+		idx   uint32
+		state *AuthState
+		err   error
+	}{
+		"ok": {
+			token: adminToken,
+			state: &AuthState{
+				Type: AuthStateType_AUTH_WAIT_CODE,
+				Hint: "authenticationCodeTypeSms to +123456789",
+			},
+		},
+		"account not hosted": {
+			token: adminToken,
+			idx:   1,
+			err:   status.Error(codes.NotFound, "account 1 is not hosted by this replica"),
+		},
+		"no admin token": {
+			err: status.Error(codes.Unauthenticated, "invalid admin token"),
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var resp *GetAuthStateResponse
+			resp, err = client.GetAuthState(adminCtx(c.token), &GetAuthStateRequest{
+				Index: c.idx,
+			})
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				assert.Equal(t, c.state.Index, resp.State.Index)
+				assert.Equal(t, c.state.Type, resp.State.Type)
+				assert.Equal(t, c.state.Hint, resp.State.Hint)
+			}
+		})
+	}
+}
+
+func TestAdminClient_SubmitAuth(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	cases := map[string]struct {
+		req  *SubmitAuthRequest
+		code codes.Code
+	}{
+		"ok": {
+			req: &SubmitAuthRequest{
+				Code: "12345",
+			},
+		},
+		"invalid code": {
+			req: &SubmitAuthRequest{
+				Code: "invalid",
+			},
+			code: codes.InvalidArgument,
+		},
+		"expired code": {
+			req: &SubmitAuthRequest{
+				Code: "expired",
+			},
+			code: codes.FailedPrecondition,
+		},
+		"unexpected input": {
+			req: &SubmitAuthRequest{
+				Password: "secret",
+			},
+			code: codes.FailedPrecondition,
+		},
+		"account not hosted": {
+			req: &SubmitAuthRequest{
+				Index: 1,
+				Code:  "12345",
+			},
+			code: codes.NotFound,
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var resp *SubmitAuthResponse
+			resp, err = client.SubmitAuth(adminCtx(adminToken), c.req)
+			assert.Equal(t, c.code, status.Code(err))
+			if c.code == codes.OK {
+				assert.Equal(t, AuthStateType_AUTH_WAIT_CODE, resp.State.Type)
+			}
+		})
+	}
+}
+
+func TestAdminClient_RequestQrCode(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	cases := map[string]struct {
+		req  *RequestQrCodeRequest
+		png  bool
+		code codes.Code
+	}{
+		"link only": {
+			req: &RequestQrCodeRequest{},
+		},
+		"png": {
+			req: &RequestQrCodeRequest{
+				Png:  true,
+				Size: 128,
+			},
+			png: true,
+		},
+		"account not hosted": {
+			req: &RequestQrCodeRequest{
+				Index: 1,
+			},
+			code: codes.NotFound,
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var resp *RequestQrCodeResponse
+			resp, err = client.RequestQrCode(adminCtx(adminToken), c.req)
+			assert.Equal(t, c.code, status.Code(err))
+			if c.code == codes.OK {
+				assert.Equal(t, "tg://login?token=AQID", resp.Link)
+				switch c.png {
+				case true:
+					assert.Equal(t, []byte("\x89PNG"), resp.Png[:4])
+				default:
+					assert.Empty(t, resp.Png)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminClient_WatchAuthState(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	stream, err := client.WatchAuthState(adminCtx(adminToken), &WatchAuthStateRequest{})
+	require.Nil(t, err)
+	var types []AuthStateType
+	for {
+		var s *AuthState
+		s, err = stream.Recv()
+		if err != nil {
+			break
+		}
+		types = append(types, s.Type)
+	}
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []AuthStateType{AuthStateType_AUTH_WAIT_CODE, AuthStateType_AUTH_READY}, types)
+	//
+	stream, err = client.WatchAuthState(adminCtx(adminToken), &WatchAuthStateRequest{Index: 1})
+	require.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func adminCtx(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.TODO(), "authorization", "Bearer "+token)
+}
+
+func TestAdminClient_Auth(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	cases := map[string]struct {
+		ctx  context.Context
+		code codes.Code
+	}{
+		"ok": {
+			ctx:  adminCtx(adminToken),
+			code: codes.OK,
+		},
+		"missing": {
+			ctx:  context.TODO(),
+			code: codes.Unauthenticated,
+		},
+		"invalid": {
+			ctx:  adminCtx("admin1"),
+			code: codes.Unauthenticated,
+		},
+		"no scheme": {
+			ctx:  metadata.AppendToOutgoingContext(context.TODO(), "authorization", adminToken),
+			code: codes.Unauthenticated,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			_, err = client.RefreshJoined(c.ctx, &RefreshJoinedRequest{})
+			assert.Equal(t, c.code, status.Code(err))
+		})
+	}
+}
+
+func TestAdminClient_ListJoined(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	var resp *ListJoinedResponse
+	resp, err = client.ListJoined(adminCtx(adminToken), &ListJoinedRequest{})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(resp.Page))
+	jc := resp.Page[0]
+	assert.Equal(t, uint32(1), jc.Account)
+	assert.Equal(t, "https://t.me/channel0", jc.Channel.Link)
+	assert.Equal(t, time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC), jc.Channel.Last.AsTime())
+	assert.Equal(t, []int64{-1001801930101, -1001801930102}, jc.ChatIds)
+	assert.Equal(t, uint64(3), jc.Stats.Messages)
+	assert.Equal(t, uint64(2), jc.Stats.Published)
+	assert.Equal(t, uint64(1), jc.Stats.Failed)
+	assert.Equal(t, "fail", jc.Stats.LastError)
+	assert.Equal(t, time.Date(2024, 11, 4, 18, 50, 0, 0, time.UTC), jc.Stats.LastErrorTime.AsTime())
+}
+
+func TestAdminClient_GetInfo(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	var resp *GetInfoResponse
+	resp, err = client.GetInfo(adminCtx(adminToken), &GetInfoRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, "1.8.44", resp.TdlibVersion)
+	require.Equal(t, 1, len(resp.Accounts))
+	assert.Equal(t, uint32(1), resp.Accounts[0].Index)
+	assert.Equal(t, int64(123), resp.Accounts[0].UserId)
+	assert.Equal(t, []string{"johndoe"}, resp.Accounts[0].Usernames)
+}
+
+func TestAdminClient_ListFloodWaits(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	_, err = client.ListFloodWaits(context.TODO(), &ListFloodWaitsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	var resp *ListFloodWaitsResponse
+	resp, err = client.ListFloodWaits(adminCtx(adminToken), &ListFloodWaitsRequest{})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(resp.Waits))
+	assert.Equal(t, uint32(1), resp.Waits[0].Account)
+	assert.Equal(t, "join", resp.Waits[0].Class)
+	assert.Equal(t, time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC), resp.Waits[0].Until.AsTime())
+}
+
+func TestAdminClient_ListStale(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	_, err = client.ListStale(context.TODO(), &ListStaleRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	var resp *ListStaleResponse
+	resp, err = client.ListStale(adminCtx(adminToken), &ListStaleRequest{})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(resp.Page))
+	assert.Equal(t, "https://t.me/channel0", resp.Page[0].Channel.Link)
+	assert.Equal(t, StaleAction_FLAG, resp.Page[0].Action)
+	assert.Equal(t, "https://t.me/channel1", resp.Page[1].Channel.Link)
+	assert.Equal(t, StaleAction_REMOVE, resp.Page[1].Action)
+}
+
+func TestAdminClient_Rebalance(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	cases := map[string]struct {
+		limit uint32
+		moves []*ChannelMove
+	}{
+		"ok": {
+			limit: 10,
+			moves: []*ChannelMove{
+				{
+					Channel: &Channel{
+						Id:   -1001801930101,
+						Name: "channel0",
+						Link: "https://t.me/channel0",
+					},
+					LabelTo: "1",
+				},
+			},
+		},
+		"zero limit": {
+			moves: []*ChannelMove{
+				{
+					Channel: &Channel{
+						Id:   -1001801930101,
+						Name: "channel0",
+						Link: "https://t.me/channel0",
+					},
+					LabelTo: "1",
+				},
+			},
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var resp *RebalanceResponse
+			resp, err = client.Rebalance(adminCtx(adminToken), &RebalanceRequest{
+				Limit:  c.limit,
+				DryRun: true,
+			})
+			require.Nil(t, err)
+			require.Equal(t, len(c.moves), len(resp.Moves))
+			for i, m := range c.moves {
+				assert.Equal(t, m.Channel.Link, resp.Moves[i].Channel.Link)
+				assert.Equal(t, m.LabelFrom, resp.Moves[i].LabelFrom)
+				assert.Equal(t, m.LabelTo, resp.Moves[i].LabelTo)
+			}
+		})
+	}
+}
+
+func TestAdminClient_Export(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	cases := map[string]struct {
+		format  DumpFormat
+		pattern string
+		out     string
+		err     error
+	}{
+		"jsonl": {
+			format: DumpFormat_JSONL,
+			out: `{"id":-1001801930101,"groupId":"group0","userId":"user0","name":"channel0","link":"https://t.me/channel0","created":"2024-11-04T18:49:25Z","label":"1"}
+{"id":-1001754252633,"name":"channel1","link":"https://t.me/c/1/2"}
+`,
+		},
+		"csv": {
+			format: DumpFormat_CSV,
+			out: `id,groupId,userId,name,link,created,last,subId,terms,label,discussion,stale,deleted,deleteReason
+-1001801930101,group0,user0,channel0,https://t.me/channel0,2024-11-04T18:49:25Z,,,,1,false,,,
+-1001754252633,,,channel1,https://t.me/c/1/2,,,,,,false,,,
+`,
+		},
+		"unknown format": {
+			format: DumpFormat(42),
+			err:    status.Error(codes.InvalidArgument, "unsupported dump format: \"42\""),
+		},
+		"fail": {
+			pattern: "fail",
+			err:     status.Error(codes.Internal, "internal failure"),
+		},
+	}
+	//
+	stream, err := client.Export(context.TODO(), &ExportRequest{})
+	require.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			stream, err = client.Export(adminCtx(adminToken), &ExportRequest{
+				Format: c.format,
+				Filter: &Filter{
+					Pattern: c.pattern,
+				},
+			})
+			require.Nil(t, err)
+			var out []byte
+			for {
+				var resp *ExportResponse
+				resp, err = stream.Recv()
+				if err != nil {
+					break
+				}
+				out = append(out, resp.Data...)
+			}
+			switch c.err {
+			case nil:
+				assert.ErrorIs(t, err, io.EOF)
+				assert.Equal(t, c.out, string(out))
+			default:
+				assert.ErrorIs(t, err, c.err)
+			}
+		})
+	}
+}
+
+func TestAdminClient_Import(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewAdminClient(conn)
+	//
+	cases := map[string]struct {
+		format   DumpFormat
+		conflict ImportConflict
+		in       string
+		resp     *ImportResponse
+		err      error
+	}{
+		"empty": {
+			resp: &ImportResponse{},
+		},
+		"jsonl skip": {
+			format: DumpFormat_JSONL,
+			in: `{"id":-1001,"link":"@chan0"}
+{"id":"x"}
+{"id":-1002,"link":"conflict"}
+{"id":-1003,"link":"fail"}
+{"id":-1004,"link":"@chan4"}
+`,
+			resp: &ImportResponse{
+				Created: 2,
+				Skipped: 1,
+				Failed:  2,
+				Errors: []*ImportError{
+					{
+						Row:   2,
+						Error: "invalid row: json: cannot unmarshal string into Go struct field recJson.id of type int64",
+					},
+					{
+						Row:   4,
+						Link:  "fail",
+						Error: "internal failure",
+					},
+				},
+			},
+		},
+		"csv overwrite": {
+			format:   DumpFormat_CSV,
+			conflict: ImportConflict_OVERWRITE,
+			in:       "link,id\n@chan0,-1001\nconflict,-1002\n",
+			resp: &ImportResponse{
+				Created:     1,
+				Overwritten: 1,
+			},
+		},
+		"csv fail": {
+			format:   DumpFormat_CSV,
+			conflict: ImportConflict_FAIL,
+			in:       "link,id\n@chan0,-1001\nconflict,-1002\n@chan2,-1003\n",
+			resp: &ImportResponse{
+				Created: 1,
+				Failed:  1,
+				Errors: []*ImportError{
+					{
+						Row:   2,
+						Link:  "conflict",
+						Error: "channel with the same id is already present",
+					},
+				},
+				Aborted: true,
+			},
+		},
+		"csv unknown column": {
+			format: DumpFormat_CSV,
+			in:     "link,foo\n@chan0,bar\n",
+			err:    status.Error(codes.InvalidArgument, "unsupported dump format: unknown CSV column \"foo\""),
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var stream Admin_ImportClient
+			stream, err = client.Import(adminCtx(adminToken))
+			require.Nil(t, err)
+			// the rows are split across the chunks
+			in := []byte(c.in)
+			for i := 0; i < len(in); i += 7 {
+				err = stream.Send(&ImportRequest{
+					Format:   c.format,
+					Conflict: c.conflict,
+					Data:     in[i:min(i+7, len(in))],
+				})
+				require.Nil(t, err)
+			}
+			var resp *ImportResponse
+			resp, err = stream.CloseAndRecv()
+			switch c.err {
+			case nil:
+				require.Nil(t, err)
+				assert.Equal(t, c.resp.Created, resp.Created)
+				assert.Equal(t, c.resp.Overwritten, resp.Overwritten)
+				assert.Equal(t, c.resp.Skipped, resp.Skipped)
+				assert.Equal(t, c.resp.Failed, resp.Failed)
+				assert.Equal(t, c.resp.Aborted, resp.Aborted)
+				require.Len(t, resp.Errors, len(c.resp.Errors))
+				for i, e := range c.resp.Errors {
+					assert.Equal(t, e.Row, resp.Errors[i].Row)
+					assert.Equal(t, e.Link, resp.Errors[i].Link)
+					assert.Equal(t, e.Error, resp.Errors[i].Error)
+				}
+			default:
+				assert.ErrorIs(t, err, c.err)
+			}
+		})
+	}
+}
@@ -1,30 +1,44 @@
 package grpc
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"github.com/awakari/source-telegram/auth"
 	"github.com/awakari/source-telegram/model"
 	"github.com/awakari/source-telegram/service"
 	"github.com/awakari/source-telegram/storage"
+	"github.com/awakari/source-telegram/transfer"
+	"github.com/skip2/go-qrcode"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
 	"time"
 )
 
+const qrCodeSizeDefault = 256
+const auditLimitDefault = 100
+const exportChunkSize = 64 * 1024
+const importErrorsMax = 1000
+
 type Controller interface {
 	SetService(svc service.Service)
 	ServiceServer
+	AdminServer
 }
 
 type controller struct {
-	svc    service.Service
-	chCode chan string
+	svc   service.Service
+	auths map[int]auth.Authorizer
 }
 
-func NewController(chCode chan string) Controller {
+// NewController accepts the authorizers by the hosted Telegram account index.
+func NewController(auths map[int]auth.Authorizer) Controller {
 	return &controller{
-		chCode: chCode,
+		auths: auths,
 	}
 }
 
@@ -43,13 +57,14 @@ func (c *controller) Create(ctx context.Context, req *CreateRequest) (resp *Crea
 	}
 	if err == nil {
 		ch := model.Channel{
-			Id:      req.Channel.Id,
-			GroupId: req.Channel.GroupId,
-			UserId:  req.Channel.UserId,
-			Name:    req.Channel.Name,
-			Link:    req.Channel.Link,
-			Created: time.Now().UTC(),
-			Label:   req.Channel.Label,
+			Id:         req.Channel.Id,
+			GroupId:    req.Channel.GroupId,
+			UserId:     req.Channel.UserId,
+			Name:       req.Channel.Name,
+			Link:       req.Channel.Link,
+			Created:    time.Now().UTC(),
+			Label:      req.Channel.Label,
+			Discussion: req.Channel.Discussion,
 		}
 		err = c.svc.Create(ctx, ch)
 		err = encodeError(err)
@@ -68,22 +83,7 @@ func (c *controller) Read(ctx context.Context, req *ReadRequest) (resp *ReadResp
 	}
 	switch err {
 	case nil:
-		resp.Channel = &Channel{
-			Id:      ch.Id,
-			GroupId: ch.GroupId,
-			UserId:  ch.UserId,
-			Name:    ch.Name,
-			Link:    ch.Link,
-			SubId:   ch.SubId,
-			Terms:   ch.Terms,
-			Label:   ch.Label,
-		}
-		if !ch.Created.IsZero() {
-			resp.Channel.Created = timestamppb.New(ch.Created)
-		}
-		if !ch.Last.IsZero() {
-			resp.Channel.Last = timestamppb.New(ch.Last)
-		}
+		resp.Channel = encodeChannel(ch)
 	default:
 		err = encodeError(err)
 	}
@@ -96,7 +96,25 @@ func (c *controller) Delete(ctx context.Context, req *DeleteRequest) (resp *Dele
 		err = status.Error(codes.FailedPrecondition, "service not initialized")
 	}
 	if err == nil {
-		err = c.svc.Delete(ctx, req.Link)
+		err = c.svc.Delete(ctx, req.Link, actorFromMetadata(ctx))
+		err = encodeError(err)
+	}
+	return
+}
+
+func (c *controller) Restore(ctx context.Context, req *RestoreRequest) (resp *RestoreResponse, err error) {
+	resp = &RestoreResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	var ch model.Channel
+	if err == nil {
+		ch, err = c.svc.Restore(ctx, req.Link, actorFromMetadata(ctx))
+	}
+	switch err {
+	case nil:
+		resp.Channel = encodeChannel(ch)
+	default:
 		err = encodeError(err)
 	}
 	return
@@ -109,13 +127,7 @@ func (c *controller) List(ctx context.Context, req *ListRequest) (resp *ListResp
 	}
 	var page []model.Channel
 	if err == nil {
-		filter := model.ChannelFilter{}
-		if req.Filter != nil {
-			filter.GroupId = req.Filter.GroupId
-			filter.UserId = req.Filter.UserId
-			filter.Pattern = req.Filter.Pattern
-			filter.SubId = req.Filter.SubId
-		}
+		filter := decodeFilter(req.Filter)
 		var order model.Order
 		switch req.Order {
 		case Order_DESC:
@@ -125,28 +137,462 @@ func (c *controller) List(ctx context.Context, req *ListRequest) (resp *ListResp
 		}
 		page, err = c.svc.GetPage(ctx, filter, req.Limit, req.Cursor, order)
 	}
-	if len(page) > 0 {
-		for _, ch := range page {
-			resp.Page = append(resp.Page, &Channel{
-				Id:      ch.Id,
-				GroupId: ch.GroupId,
-				UserId:  ch.UserId,
-				Name:    ch.Name,
-				Link:    ch.Link,
-			})
+	var stats map[int64]model.ChannelStatsHistory
+	if err == nil && req.Stats && len(page) > 0 {
+		chanIds := make([]int64, len(page))
+		for i, ch := range page {
+			chanIds[i] = ch.Id
+		}
+		stats, err = c.svc.GetStats(ctx, chanIds, statsSince(req.StatsDays))
+	}
+	for _, ch := range page {
+		chEnc := encodeChannel(ch)
+		if req.Stats {
+			chEnc.Stats = encodeStatsHistory(stats[ch.Id])
 		}
+		resp.Page = append(resp.Page, chEnc)
+	}
+	if len(page) > 0 && uint32(len(page)) == req.Limit {
+		// the next page may be empty still
+		resp.Cursor = page[len(page)-1].Link
+	}
+	err = encodeError(err)
+	return
+}
+
+func (c *controller) GetChannelStats(ctx context.Context, req *GetChannelStatsRequest) (resp *GetChannelStatsResponse, err error) {
+	resp = &GetChannelStatsResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	var ch model.Channel
+	if err == nil {
+		ch, err = c.svc.Read(ctx, req.Link)
+	}
+	var stats map[int64]model.ChannelStatsHistory
+	if err == nil {
+		stats, err = c.svc.GetStats(ctx, []int64{ch.Id}, statsSince(req.Days))
+	}
+	switch err {
+	case nil:
+		resp.Stats = encodeStatsHistory(stats[ch.Id])
+	default:
+		err = encodeError(err)
+	}
+	return
+}
+
+// statsSince returns the start of the earliest daily bucket to return, zero time when all are requested.
+func statsSince(days uint32) (since time.Time) {
+	if days > 0 {
+		since = model.Day(time.Now()).AddDate(0, 0, 1-int(days))
+	}
+	return
+}
+
+func decodeFilter(src *Filter) (dst model.ChannelFilter) {
+	if src != nil {
+		dst.GroupId = src.GroupId
+		dst.UserId = src.UserId
+		dst.Pattern = src.Pattern
+		dst.SubId = src.SubId
+		dst.Regex = src.Regex
+		dst.IgnoreCase = src.IgnoreCase
+		dst.Text = src.Text
+		dst.Deleted = src.Deleted
+	}
+	return
+}
+
+func (c *controller) Export(req *ExportRequest, stream Admin_ExportServer) (err error) {
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+		return
+	}
+	var enc transfer.Encoder
+	w := bufio.NewWriterSize(exportWriter{stream: stream}, exportChunkSize)
+	enc, err = transfer.NewEncoder(w, decodeDumpFormat(req.Format))
+	if err != nil {
+		err = status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
+	err = c.svc.Export(stream.Context(), decodeFilter(req.Filter), enc.Encode)
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err == nil {
+		err = w.Flush()
 	}
 	err = encodeError(err)
 	return
 }
 
+// exportWriter sends every write as the separate chunk.
+type exportWriter struct {
+	stream Admin_ExportServer
+}
+
+func (w exportWriter) Write(p []byte) (n int, err error) {
+	err = w.stream.Send(&ExportResponse{
+		Data: p,
+	})
+	if err == nil {
+		n = len(p)
+	}
+	return
+}
+
+func (c *controller) Import(stream Admin_ImportServer) (err error) {
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+		return
+	}
+	r := &importReader{stream: stream}
+	err = r.next()
+	switch {
+	case err == io.EOF:
+		return stream.SendAndClose(&ImportResponse{})
+	case err != nil:
+		return
+	}
+	var dec transfer.Decoder
+	dec, err = transfer.NewDecoder(r, decodeDumpFormat(r.first.Format))
+	if err != nil {
+		err = status.Error(codes.InvalidArgument, err.Error())
+		return
+	}
+	ctx := stream.Context()
+	actor := actorFromMetadata(ctx)
+	conflict := decodeImportConflict(r.first.Conflict)
+	resp := &ImportResponse{}
+	fail := func(row uint32, link string, err error) {
+		resp.Failed++
+		if len(resp.Errors) < importErrorsMax {
+			resp.Errors = append(resp.Errors, &ImportError{
+				Row:   row,
+				Link:  link,
+				Error: err.Error(),
+			})
+		}
+	}
+	for {
+		var ch model.Channel
+		var row uint32
+		ch, row, err = dec.Decode()
+		switch {
+		case err == io.EOF:
+			err = nil
+		case errors.Is(err, transfer.ErrRow):
+			fail(row, ch.Link, err)
+			continue
+		case err != nil:
+			if status.Code(err) == codes.Unknown {
+				err = status.Error(codes.InvalidArgument, err.Error())
+			}
+			return
+		default:
+			var result model.ImportResult
+			result, err = c.svc.Import(ctx, ch, conflict, r.first.DryRun, actor)
+			switch {
+			case err == nil:
+				switch result {
+				case model.ImportResultCreated:
+					resp.Created++
+				case model.ImportResultOverwritten:
+					resp.Overwritten++
+				case model.ImportResultSkipped:
+					resp.Skipped++
+				}
+				continue
+			case errors.Is(err, storage.ErrConflict) && conflict == model.ImportConflictFail:
+				fail(row, ch.Link, err)
+				resp.Aborted = true
+			case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+				err = encodeError(err)
+				return
+			default:
+				fail(row, ch.Link, err)
+				continue
+			}
+			err = nil
+		}
+		break
+	}
+	err = stream.SendAndClose(resp)
+	return
+}
+
+// importReader joins the import request chunks, the options are taken from the first request.
+type importReader struct {
+	stream Admin_ImportServer
+	first  *ImportRequest
+	data   []byte
+}
+
+func (r *importReader) next() (err error) {
+	var req *ImportRequest
+	req, err = r.stream.Recv()
+	if err == nil {
+		if r.first == nil {
+			r.first = req
+		}
+		r.data = req.Data
+	}
+	return
+}
+
+func (r *importReader) Read(p []byte) (n int, err error) {
+	for len(r.data) == 0 && err == nil {
+		err = r.next()
+	}
+	if err == nil {
+		n = copy(p, r.data)
+		r.data = r.data[n:]
+	}
+	return
+}
+
+func decodeDumpFormat(src DumpFormat) (dst string) {
+	switch src {
+	case DumpFormat_CSV:
+		dst = transfer.FormatCsv
+	case DumpFormat_JSONL:
+		dst = transfer.FormatJsonl
+	default:
+		dst = src.String()
+	}
+	return
+}
+
+func decodeImportConflict(src ImportConflict) (dst model.ImportConflict) {
+	switch src {
+	case ImportConflict_OVERWRITE:
+		dst = model.ImportConflictOverwrite
+	case ImportConflict_FAIL:
+		dst = model.ImportConflictFail
+	default:
+		dst = model.ImportConflictSkip
+	}
+	return
+}
+
+// actorFromMetadata returns the user identified by the API gateway, the empty one when the caller is internal.
+func actorFromMetadata(ctx context.Context) (actor model.Actor) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(model.KeyGroupId); len(v) > 0 {
+		actor.GroupId = v[0]
+	}
+	if v := md.Get(model.KeyUserId); len(v) > 0 {
+		actor.UserId = v[0]
+	}
+	return
+}
+
+func (c *controller) ListAudit(ctx context.Context, req *ListAuditRequest) (resp *ListAuditResponse, err error) {
+	resp = &ListAuditResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	filter := model.AuditFilter{
+		ChannelId: req.ChannelId,
+		Link:      req.Link,
+		GroupId:   req.GroupId,
+		UserId:    req.UserId,
+	}
+	if err == nil && filter.IsEmpty() {
+		err = status.Error(codes.InvalidArgument, "either channel or user should be specified")
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = auditLimitDefault
+	}
+	var page []model.AuditRecord
+	if err == nil {
+		page, err = c.svc.GetAuditPage(ctx, filter, limit, req.Cursor)
+		err = encodeError(err)
+	}
+	for _, rec := range page {
+		resp.Page = append(resp.Page, encodeAuditRecord(rec))
+	}
+	if len(page) > 0 && uint32(len(page)) == limit {
+		resp.Cursor = page[len(page)-1].Id
+	}
+	return
+}
+
+func encodeAuditRecord(src model.AuditRecord) (dst *AuditRecord) {
+	dst = &AuditRecord{
+		Id:        src.Id,
+		Time:      timestamppb.New(src.Time),
+		ChannelId: src.ChannelId,
+		Link:      src.Link,
+		GroupId:   src.GroupId,
+		UserId:    src.UserId,
+		Actor: &Actor{
+			GroupId: src.Actor.GroupId,
+			UserId:  src.Actor.UserId,
+			System:  src.Actor.System,
+		},
+		Reason: src.Reason,
+	}
+	switch src.Action {
+	case model.ChannelChangeUpdate:
+		dst.Action = AuditAction_UPDATE
+	case model.ChannelChangeDelete:
+		dst.Action = AuditAction_DELETE
+	default:
+		dst.Action = AuditAction_CREATE
+	}
+	return
+}
+
 func (c *controller) SearchAndAdd(ctx context.Context, req *SearchAndAddRequest) (resp *SearchAndAddResponse, err error) {
 	resp = &SearchAndAddResponse{}
 	if c.svc == nil {
 		err = status.Error(codes.FailedPrecondition, "service not initialized")
 	}
 	if err == nil {
-		resp.CountAdded, err = c.svc.SearchAndAdd(ctx, req.GroupId, req.SubId, req.Terms, req.Limit)
+		resp.CountAdded, err = c.svc.SearchAndAdd(ctx, req.GroupId, req.SubId, req.Terms, req.Limit, req.Groups)
+		err = encodeError(err)
+	}
+	return
+}
+
+func (c *controller) ListStale(ctx context.Context, req *ListStaleRequest) (resp *ListStaleResponse, err error) {
+	resp = &ListStaleResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	var report []model.ChannelCleanup
+	if err == nil {
+		report, err = c.svc.CleanStale(ctx, true)
+	}
+	for _, cleanup := range report {
+		sc := &StaleChannel{
+			Channel: encodeChannel(cleanup.Channel),
+		}
+		switch cleanup.Action {
+		case model.CleanupActionUnflag:
+			sc.Action = StaleAction_UNFLAG
+		case model.CleanupActionRemove:
+			sc.Action = StaleAction_REMOVE
+		default:
+			sc.Action = StaleAction_FLAG
+		}
+		resp.Page = append(resp.Page, sc)
+	}
+	err = encodeError(err)
+	return
+}
+
+func (c *controller) Rebalance(ctx context.Context, req *RebalanceRequest) (resp *RebalanceResponse, err error) {
+	resp = &RebalanceResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	var moves []model.ChannelMove
+	if err == nil {
+		moves, err = c.svc.Rebalance(ctx, req.Limit, req.DryRun, req.Drain)
+	}
+	for _, m := range moves {
+		resp.Moves = append(resp.Moves, &ChannelMove{
+			Channel:   encodeChannel(m.Channel),
+			LabelFrom: m.LabelFrom,
+			LabelTo:   m.LabelTo,
+		})
+	}
+	err = encodeError(err)
+	return
+}
+
+func (c *controller) ListFloodWaits(ctx context.Context, req *ListFloodWaitsRequest) (resp *ListFloodWaitsResponse, err error) {
+	resp = &ListFloodWaitsResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	var waits []model.FloodWait
+	if err == nil {
+		waits, err = c.svc.FloodWaits(ctx)
+	}
+	for _, w := range waits {
+		resp.Waits = append(resp.Waits, &FloodWait{
+			Account: uint32(w.Account),
+			Class:   w.Class,
+			Until:   timestamppb.New(w.Until),
+		})
+	}
+	err = encodeError(err)
+	return
+}
+
+func (c *controller) ListJoined(ctx context.Context, req *ListJoinedRequest) (resp *ListJoinedResponse, err error) {
+	resp = &ListJoinedResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	var chans []model.JoinedChannel
+	if err == nil {
+		chans, err = c.svc.Joined(ctx)
+	}
+	for _, jc := range chans {
+		stats := &ChannelStats{
+			Messages:  jc.Stats.Messages,
+			Published: jc.Stats.Published,
+			Failed:    jc.Stats.Failed,
+			LastError: jc.Stats.LastErr,
+			Seen:      jc.Stats.Seen,
+			Dropped:   jc.Stats.Dropped,
+			TextBytes: jc.Stats.TextBytes,
+		}
+		if !jc.Stats.LastErrTime.IsZero() {
+			stats.LastErrorTime = timestamppb.New(jc.Stats.LastErrTime)
+		}
+		resp.Page = append(resp.Page, &JoinedChannel{
+			Account: uint32(jc.Account),
+			Channel: encodeChannel(jc.Channel),
+			ChatIds: jc.ChatIds,
+			Stats:   stats,
+		})
+	}
+	err = encodeError(err)
+	return
+}
+
+func (c *controller) GetInfo(ctx context.Context, req *GetInfoRequest) (resp *GetInfoResponse, err error) {
+	resp = &GetInfoResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	var info model.Info
+	if err == nil {
+		info, err = c.svc.Info(ctx)
+	}
+	switch err {
+	case nil:
+		resp.TdlibVersion = info.TdlibVersion
+		for _, acc := range info.Accounts {
+			resp.Accounts = append(resp.Accounts, &Account{
+				Index:     uint32(acc.Index),
+				UserId:    acc.UserId,
+				FirstName: acc.FirstName,
+				LastName:  acc.LastName,
+				Usernames: acc.Usernames,
+				Phone:     acc.Phone,
+			})
+		}
+	default:
+		err = encodeError(err)
+	}
+	return
+}
+
+func (c *controller) RefreshJoined(ctx context.Context, req *RefreshJoinedRequest) (resp *RefreshJoinedResponse, err error) {
+	resp = &RefreshJoinedResponse{}
+	if c.svc == nil {
+		err = status.Error(codes.FailedPrecondition, "service not initialized")
+	}
+	if err == nil {
+		err = c.svc.RefreshJoined(ctx)
 		err = encodeError(err)
 	}
 	return
@@ -154,11 +600,168 @@ func (c *controller) SearchAndAdd(ctx context.Context, req *SearchAndAddRequest)
 
 func (c *controller) Login(ctx context.Context, req *LoginRequest) (resp *LoginResponse, err error) {
 	resp = &LoginResponse{}
-	select {
-	case c.chCode <- req.Code:
-		resp.Success = true
+	var a auth.Authorizer
+	a, err = c.authorizer(req.Index)
+	if err == nil {
+		resp.Success = a.Submit(ctx, model.AuthInput{Code: req.Code}) == nil
+	}
+	return
+}
+
+func (c *controller) GetAuthState(ctx context.Context, req *GetAuthStateRequest) (resp *GetAuthStateResponse, err error) {
+	resp = &GetAuthStateResponse{}
+	var a auth.Authorizer
+	a, err = c.authorizer(req.Index)
+	if err == nil {
+		resp.State = encodeAuthState(a.State())
+	}
+	return
+}
+
+func (c *controller) SubmitAuth(ctx context.Context, req *SubmitAuthRequest) (resp *SubmitAuthResponse, err error) {
+	resp = &SubmitAuthResponse{}
+	var a auth.Authorizer
+	a, err = c.authorizer(req.Index)
+	if err == nil {
+		err = a.Submit(ctx, model.AuthInput{
+			Code:      req.Code,
+			Password:  req.Password,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Email:     req.Email,
+			EmailCode: req.EmailCode,
+			Resend:    req.Resend,
+		})
+		resp.State = encodeAuthState(a.State())
+		err = encodeAuthError(err)
+	}
+	return
+}
+
+func (c *controller) RequestQrCode(ctx context.Context, req *RequestQrCodeRequest) (resp *RequestQrCodeResponse, err error) {
+	resp = &RequestQrCodeResponse{}
+	var a auth.Authorizer
+	a, err = c.authorizer(req.Index)
+	if err == nil {
+		resp.Link, err = a.RequestQrCode(ctx)
+		err = encodeAuthError(err)
+	}
+	if err == nil && req.Png {
+		size := int(req.Size)
+		if size == 0 {
+			size = qrCodeSizeDefault
+		}
+		resp.Png, err = qrcode.Encode(resp.Link, qrcode.Medium, size)
+		if err != nil {
+			err = status.Error(codes.Internal, err.Error())
+		}
+	}
+	return
+}
+
+func (c *controller) WatchAuthState(req *WatchAuthStateRequest, stream Admin_WatchAuthStateServer) (err error) {
+	var a auth.Authorizer
+	a, err = c.authorizer(req.Index)
+	if err != nil {
+		return
+	}
+	ctx := stream.Context()
+	s := a.State()
+	for {
+		err = stream.Send(encodeAuthState(s))
+		if err != nil || s.Type == model.AuthStateReady || s.Type == model.AuthStateClosed {
+			break
+		}
+		s, err = a.Next(ctx, s)
+		if err != nil {
+			err = encodeError(err)
+			break
+		}
+	}
+	return
+}
+
+func (c *controller) authorizer(idx uint32) (a auth.Authorizer, err error) {
+	a = c.auths[int(idx)]
+	if a == nil {
+		err = status.Error(codes.NotFound, fmt.Sprintf("account %d is not hosted by this replica", idx))
+	}
+	return
+}
+
+func encodeAuthState(src model.AuthState) (dst *AuthState) {
+	dst = &AuthState{
+		Index: uint32(src.Account),
+		Type:  AuthStateType(src.Type),
+		Hint:  src.Hint,
+		Error: src.Err,
+	}
+	return
+}
+
+func encodeAuthError(src error) (dst error) {
+	switch {
+	case src == nil:
+	case errors.Is(src, auth.ErrInvalid):
+		dst = status.Error(codes.InvalidArgument, src.Error())
+	case errors.Is(src, auth.ErrExpired), errors.Is(src, auth.ErrUnexpected):
+		dst = status.Error(codes.FailedPrecondition, src.Error())
 	default:
-		resp.Success = false // doesn't accept anymore
+		dst = encodeError(src)
+	}
+	return
+}
+
+func encodeChannel(ch model.Channel) (dst *Channel) {
+	dst = &Channel{
+		Id:           ch.Id,
+		GroupId:      ch.GroupId,
+		UserId:       ch.UserId,
+		Name:         ch.Name,
+		Link:         ch.Link,
+		SubId:        ch.SubId,
+		Terms:        ch.Terms,
+		Label:        ch.Label,
+		Discussion:   ch.Discussion,
+		DeleteReason: ch.DeleteReason,
+	}
+	if !ch.Created.IsZero() {
+		dst.Created = timestamppb.New(ch.Created)
+	}
+	if !ch.Last.IsZero() {
+		dst.Last = timestamppb.New(ch.Last)
+	}
+	if !ch.Stale.IsZero() {
+		dst.Stale = timestamppb.New(ch.Stale)
+	}
+	if !ch.Deleted.IsZero() {
+		dst.Deleted = timestamppb.New(ch.Deleted)
+	}
+	return
+}
+
+func encodeCounters(c model.ChannelCounters) *ChannelCounters {
+	return &ChannelCounters{
+		Seen:      c.Seen,
+		Published: c.Published,
+		Failed:    c.Failed,
+		Dropped:   c.Dropped,
+		TextBytes: c.TextBytes,
+	}
+}
+
+func encodeStatsHistory(h model.ChannelStatsHistory) (dst *ChannelStatsHistory) {
+	dst = &ChannelStatsHistory{
+		Total: encodeCounters(h.Total),
+	}
+	for _, d := range h.Days {
+		dst.Days = append(dst.Days, &ChannelStatsDay{
+			Date:     timestamppb.New(d.Date),
+			Counters: encodeCounters(d.Counters),
+		})
+	}
+	if !h.Updated.IsZero() {
+		dst.Updated = timestamppb.New(h.Updated)
 	}
 	return
 }
@@ -170,10 +773,16 @@ func encodeError(src error) (dst error) {
 		dst = status.Error(codes.AlreadyExists, src.Error())
 	case errors.Is(src, storage.ErrNotFound):
 		dst = status.Error(codes.NotFound, src.Error())
+	case errors.Is(src, storage.ErrInvalidFilter):
+		dst = status.Error(codes.InvalidArgument, src.Error())
 	case errors.Is(src, storage.ErrInternal):
 		dst = status.Error(codes.Internal, src.Error())
+	case errors.Is(src, service.ErrImportInvalid):
+		dst = status.Error(codes.InvalidArgument, src.Error())
 	case errors.Is(src, service.ErrNoBot):
 		dst = status.Error(codes.PermissionDenied, src.Error())
+	case errors.Is(src, service.ErrReplicasFull):
+		dst = status.Error(codes.ResourceExhausted, src.Error())
 	case errors.Is(src, context.DeadlineExceeded):
 		dst = status.Error(codes.DeadlineExceeded, src.Error())
 	case errors.Is(src, context.Canceled):
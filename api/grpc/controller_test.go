@@ -0,0 +1,16 @@
+package grpc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestController_ServiceNotInitialized(t *testing.T) {
+	c := NewController(nil)
+	err := c.Export(&ExportRequest{}, nil)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	err = c.Import(nil)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
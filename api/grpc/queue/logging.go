@@ -2,7 +2,6 @@ package queue
 
 import (
 	"context"
-	"fmt"
 	"github.com/awakari/source-telegram/util"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
 	"log/slog"
@@ -23,14 +22,14 @@ func NewLoggingMiddleware(svc Service, log *slog.Logger) Service {
 func (l logging) SetConsumer(ctx context.Context, name, subj string) (err error) {
 	err = l.svc.SetConsumer(ctx, name, subj)
 	ll := l.logLevel(err)
-	l.log.Log(ctx, ll, fmt.Sprintf("queue.SetConsumer(name=%s, subj=%s): err=%s", name, subj, err))
+	l.log.LogAttrs(ctx, ll, "queue.SetConsumer", slog.String("queue", name), slog.String("subj", subj), util.LogErr(err))
 	return
 }
 
 func (l logging) ReceiveMessages(ctx context.Context, queue, subj string, batchSize uint32, consume util.ConsumeFunc[[]*pb.CloudEvent]) (err error) {
 	err = l.svc.ReceiveMessages(ctx, queue, subj, batchSize, consume)
 	ll := l.logLevel(err)
-	l.log.Log(ctx, ll, fmt.Sprintf("queue.ReceiveMessages(queue=%s, subj=%s, batchSize=%d): err=%s", queue, subj, batchSize, err))
+	l.log.LogAttrs(ctx, ll, "queue.ReceiveMessages", slog.String("queue", queue), slog.String("subj", subj), slog.Any("batchSize", batchSize), util.LogErr(err))
 	return
 }
 
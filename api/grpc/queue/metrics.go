@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/util"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type metrics struct {
+	svc Service
+}
+
+var metricEventsConsumed = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "awakari_source_telegram_queue_events_total",
+		Help: "Awakari source telegram: events received from the queue by the consume result",
+	},
+	[]string{"queue", "subj", "result"},
+)
+
+func NewMetricsMiddleware(svc Service) Service {
+	return metrics{
+		svc: svc,
+	}
+}
+
+func (m metrics) SetConsumer(ctx context.Context, name, subj string) (err error) {
+	return m.svc.SetConsumer(ctx, name, subj)
+}
+
+func (m metrics) ReceiveMessages(ctx context.Context, queue, subj string, batchSize uint32, consume util.ConsumeFunc[[]*pb.CloudEvent]) (err error) {
+	err = m.svc.ReceiveMessages(ctx, queue, subj, batchSize, func(evts []*pb.CloudEvent) (err error) {
+		err = consume(evts)
+		result := "ok"
+		if err != nil {
+			result = "fail"
+		}
+		metricEventsConsumed.WithLabelValues(queue, subj, result).Add(float64(len(evts)))
+		return
+	})
+	return
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/tracing"
+	"github.com/awakari/source-telegram/util"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracingMiddleware struct {
+	svc Service
+}
+
+func NewTracingMiddleware(svc Service) Service {
+	return tracingMiddleware{
+		svc: svc,
+	}
+}
+
+func (tm tracingMiddleware) SetConsumer(ctx context.Context, name, subj string) (err error) {
+	return tm.svc.SetConsumer(ctx, name, subj)
+}
+
+// ReceiveMessages wraps every consumed batch into the span linked to the traces of the events.
+func (tm tracingMiddleware) ReceiveMessages(ctx context.Context, queue, subj string, batchSize uint32, consume util.ConsumeFunc[[]*pb.CloudEvent]) (err error) {
+	err = tm.svc.ReceiveMessages(ctx, queue, subj, batchSize, func(evts []*pb.CloudEvent) (err error) {
+		var links []trace.Link
+		for _, evt := range evts {
+			sc := trace.SpanContextFromContext(tracing.Extract(ctx, evt))
+			if sc.IsValid() {
+				links = append(links, trace.Link{SpanContext: sc})
+			}
+		}
+		_, span := tracing.Tracer().Start(
+			ctx,
+			"queue.consume",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithLinks(links...),
+			trace.WithAttributes(
+				attribute.String("messaging.destination.name", queue),
+				attribute.String("messaging.destination.subscription.name", subj),
+				attribute.Int("messaging.batch.message_count", len(evts)),
+			),
+		)
+		defer span.End()
+		err = consume(evts)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return
+	})
+	return
+}
@@ -1,22 +1,111 @@
 package grpc
 
 import (
+	"context"
+	"crypto/subtle"
 	"fmt"
+	"github.com/awakari/source-telegram/util"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"log/slog"
 	"net"
+	"strings"
 )
 
-func Serve(c ServiceServer, port uint16) (err error) {
-	srv := grpc.NewServer()
+// Serve blocks serving the API, the health server reports the status maintained externally. The admin methods are
+// rejected when the admin token is empty.
+func Serve(c Controller, port uint16, hs grpc_health_v1.HealthServer, adminToken string) (err error) {
+	aa := adminAuth{
+		token: adminToken,
+	}
+	srv := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(logAttrsUnary, aa.unary),
+		grpc.ChainStreamInterceptor(logAttrsStream, aa.stream),
+	)
 	RegisterServiceServer(srv, c)
+	RegisterAdminServer(srv, c)
 	reflection.Register(srv)
-	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	grpc_health_v1.RegisterHealthServer(srv, hs)
 	conn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err == nil {
 		err = srv.Serve(conn)
 	}
 	return
 }
+
+// logAttrsUnary adds the called method to the request-scoped log attributes.
+func logAttrsUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	ctx = util.WithLogAttrs(ctx, slog.String("rpc", info.FullMethod))
+	resp, err = handler(ctx, req)
+	return
+}
+
+func logAttrsStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	err = handler(srv, logAttrsServerStream{
+		ServerStream: ss,
+		ctx:          util.WithLogAttrs(ss.Context(), slog.String("rpc", info.FullMethod)),
+	})
+	return
+}
+
+type logAttrsServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s logAttrsServerStream) Context() context.Context {
+	return s.ctx
+}
+
+const adminMethodPrefix = "/awakari.source.telegram.Admin/"
+const adminAuthKey = "authorization"
+const adminAuthScheme = "Bearer "
+
+type adminAuth struct {
+	token string
+}
+
+func (aa adminAuth) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	err = aa.authorize(ctx, info.FullMethod)
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	return
+}
+
+func (aa adminAuth) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	err = aa.authorize(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, ss)
+	}
+	return
+}
+
+// authorize passes the non-admin methods through, the admin ones require the matching token.
+func (aa adminAuth) authorize(ctx context.Context, method string) (err error) {
+	if !strings.HasPrefix(method, adminMethodPrefix) {
+		return
+	}
+	if aa.token == "" {
+		err = status.Error(codes.PermissionDenied, "admin API is disabled")
+		return
+	}
+	var tok string
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(adminAuthKey) {
+		if strings.HasPrefix(v, adminAuthScheme) {
+			tok = strings.TrimPrefix(v, adminAuthScheme)
+			break
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(tok), []byte(aa.token)) != 1 {
+		err = status.Error(codes.Unauthenticated, "invalid admin token")
+	}
+	return
+}
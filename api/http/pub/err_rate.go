@@ -0,0 +1,73 @@
+package pub
+
+import (
+	"context"
+	"errors"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"sync"
+)
+
+// ErrRate is the publishing service which tracks the share of the recent failures.
+type ErrRate interface {
+	Service
+	// ErrRate returns the share of the failed among the recent publish attempts, 0 until there are enough attempts.
+	ErrRate() (rate float64)
+}
+
+type errRate struct {
+	svc  Service
+	lock *sync.Mutex
+	// failures is the ring of the recent attempts results
+	failures []bool
+	next     int
+	count    int
+}
+
+const errRateAttemptsMin = 10
+
+func NewErrRate(svc Service, window int) ErrRate {
+	return &errRate{
+		svc:      svc,
+		lock:     &sync.Mutex{},
+		failures: make([]bool, window),
+	}
+}
+
+func (er *errRate) Publish(ctx context.Context, evt *pb.CloudEvent, groupId, userId string) (err error) {
+	err = er.svc.Publish(ctx, evt, groupId, userId)
+	switch {
+	case errors.Is(err, ErrLimitReached), errors.Is(err, ErrInvalid):
+		// the user's limit or the particular event, not the writer failure
+	default:
+		er.record(err != nil)
+	}
+	return
+}
+
+func (er *errRate) ErrRate() (rate float64) {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+	if er.count >= errRateAttemptsMin || (er.count > 0 && er.count == len(er.failures)) {
+		var n int
+		for _, f := range er.failures[:er.count] {
+			if f {
+				n++
+			}
+		}
+		rate = float64(n) / float64(er.count)
+	}
+	return
+}
+
+func (er *errRate) record(failed bool) {
+	er.lock.Lock()
+	defer er.lock.Unlock()
+	if len(er.failures) == 0 {
+		return
+	}
+	er.failures[er.next] = failed
+	er.next = (er.next + 1) % len(er.failures)
+	if er.count < len(er.failures) {
+		er.count++
+	}
+}
@@ -2,7 +2,6 @@ package pub
 
 import (
 	"context"
-	"fmt"
 	"github.com/awakari/source-telegram/util"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
 	"log/slog"
@@ -22,6 +21,6 @@ func NewLogging(svc Service, log *slog.Logger) Service {
 
 func (l logging) Publish(ctx context.Context, evt *pb.CloudEvent, groupId, userId string) (err error) {
 	err = l.svc.Publish(ctx, evt, groupId, userId)
-	l.log.Log(ctx, util.LogLevel(err), fmt.Sprintf("pub.Publish(%s, %s, %s): err=%s", evt.Id, groupId, userId, err))
+	l.log.LogAttrs(ctx, util.LogLevel(err), "pub.Publish", slog.String("evtId", evt.Id), slog.String("evtSource", evt.Source), slog.String("groupId", groupId), slog.String("userId", userId), util.LogErr(err))
 	return
 }
@@ -0,0 +1,56 @@
+package pub
+
+import (
+	"context"
+	"errors"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+type metrics struct {
+	svc Service
+}
+
+var metricPublishDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "awakari_source_telegram_publish_duration_seconds",
+		Help:    "Awakari source telegram: event publishing duration by outcome",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"outcome"},
+)
+
+func NewMetrics(svc Service) Service {
+	return metrics{
+		svc: svc,
+	}
+}
+
+func (m metrics) Publish(ctx context.Context, evt *pb.CloudEvent, groupId, userId string) (err error) {
+	t := time.Now()
+	err = m.svc.Publish(ctx, evt, groupId, userId)
+	metricPublishDuration.WithLabelValues(outcome(err)).Observe(time.Since(t).Seconds())
+	return
+}
+
+func outcome(err error) (o string) {
+	switch {
+	case err == nil:
+		o = "ok"
+	case errors.Is(err, ErrNoAck):
+		o = "noack"
+	case errors.Is(err, ErrNoAuth):
+		o = "noauth"
+	case errors.Is(err, ErrInvalid):
+		o = "invalid"
+	case errors.Is(err, ErrLimitReached):
+		o = "limit"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		o = "timeout"
+	default:
+		o = "other"
+	}
+	return
+}
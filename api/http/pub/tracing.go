@@ -0,0 +1,43 @@
+package pub
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/tracing"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracingSvc struct {
+	svc Service
+}
+
+// NewTracing wraps every publish attempt into the span and passes the trace context downstream in the event.
+func NewTracing(svc Service) Service {
+	return tracingSvc{
+		svc: svc,
+	}
+}
+
+func (ts tracingSvc) Publish(ctx context.Context, evt *pb.CloudEvent, groupId, userId string) (err error) {
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		"pub.Publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("cloudevents.event_id", evt.Id),
+			attribute.String("cloudevents.event_source", evt.Source),
+			attribute.String("awakari.group_id", groupId),
+		),
+	)
+	defer span.End()
+	tracing.Inject(ctx, evt)
+	err = ts.svc.Publish(ctx, evt, groupId, userId)
+	span.SetAttributes(attribute.String("awakari.publish.outcome", outcome(err)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return
+}
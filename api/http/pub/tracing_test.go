@@ -0,0 +1,46 @@
+package pub
+
+import (
+	"context"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestTracing_Publish(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	otel.SetTracerProvider(tp)
+	svc := NewTracing(NewMock())
+	//
+	ctx, parent := tp.Tracer("test").Start(context.TODO(), "message.publish")
+	evt := &pb.CloudEvent{
+		Id:     "evt0",
+		Source: "https://t.me/chan0",
+	}
+	err := svc.Publish(ctx, evt, "group0", "noack")
+	assert.ErrorIs(t, err, ErrNoAck)
+	err = svc.Publish(ctx, evt, "group0", "user0")
+	assert.Nil(t, err)
+	parent.End()
+	//
+	spans := exp.GetSpans()
+	require.Len(t, spans, 3)
+	// every attempt is the own span under the same parent
+	for i, o := range []string{"noack", "ok"} {
+		s := spans[i]
+		assert.Equal(t, "pub.Publish", s.Name)
+		assert.Equal(t, parent.SpanContext().SpanID(), s.Parent.SpanID())
+		assert.Contains(t, s.Attributes, attribute.String("awakari.publish.outcome", o))
+	}
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+	// the latest attempt is continued downstream
+	assert.Contains(t, evt.Attributes["traceparent"].GetCeString(), spans[1].SpanContext.SpanID().String())
+}
@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/model"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authorizer handles the TDLib authorization states of a single account. Unlike the default TDLib authorizer, it
+// doesn't close the client on a wrong input but waits for another one submitted externally.
+type Authorizer interface {
+	client.AuthorizationStateHandler
+	State() (s model.AuthState)
+	// Submit passes the input to the waiting authorization state, returns the input check result.
+	Submit(ctx context.Context, in model.AuthInput) (err error)
+	// RequestQrCode switches the waiting authorization to the confirmation from another logged in device, returns the
+	// tg://login link to encode as a QR code.
+	RequestQrCode(ctx context.Context) (link string, err error)
+	// Next blocks until the state differs from the previous one.
+	Next(ctx context.Context, prev model.AuthState) (s model.AuthState, err error)
+}
+
+// tdlib is the subset of the TDLib client API used for the authorization.
+type tdlib interface {
+	SetTdlibParameters(req *client.SetTdlibParametersRequest) (*client.Ok, error)
+	SetAuthenticationPhoneNumber(req *client.SetAuthenticationPhoneNumberRequest) (*client.Ok, error)
+	ResendAuthenticationCode() (*client.Ok, error)
+	CheckAuthenticationCode(req *client.CheckAuthenticationCodeRequest) (*client.Ok, error)
+	CheckAuthenticationPassword(req *client.CheckAuthenticationPasswordRequest) (*client.Ok, error)
+	RegisterUser(req *client.RegisterUserRequest) (*client.Ok, error)
+	SetAuthenticationEmailAddress(req *client.SetAuthenticationEmailAddressRequest) (*client.Ok, error)
+	CheckAuthenticationEmailCode(req *client.CheckAuthenticationEmailCodeRequest) (*client.Ok, error)
+	RequestQrCodeAuthentication(req *client.RequestQrCodeAuthenticationRequest) (*client.Ok, error)
+	GetAuthorizationState() (client.AuthorizationState, error)
+}
+
+type submission struct {
+	in    model.AuthInput
+	qr    bool
+	chErr chan error
+}
+
+type authorizer struct {
+	account  int
+	phone    string
+	password string
+	params   *client.SetTdlibParametersRequest
+	log      *slog.Logger
+
+	// pollInterval is the period to check whether the QR code is confirmed or the link is refreshed.
+	pollInterval time.Duration
+
+	chIn          chan submission
+	lock          *sync.Mutex
+	chChanged     chan struct{}
+	state         model.AuthState
+	passwordTried bool
+	fatal         bool
+}
+
+var ErrUnexpected = errors.New("the account doesn't wait for this input")
+var ErrInvalid = errors.New("invalid input")
+var ErrExpired = errors.New("input expired")
+
+func NewAuthorizer(account int, phone, password string, params *client.SetTdlibParametersRequest, log *slog.Logger) Authorizer {
+	return &authorizer{
+		account:  account,
+		phone:    phone,
+		password: password,
+		params:   params,
+		log:      log,
+
+		pollInterval: time.Second,
+
+		chIn:      make(chan submission),
+		lock:      &sync.Mutex{},
+		chChanged: make(chan struct{}),
+		state: model.AuthState{
+			Account: account,
+		},
+	}
+}
+
+func (a *authorizer) Handle(c *client.Client, st client.AuthorizationState) (err error) {
+	return a.handle(c, st)
+}
+
+func (a *authorizer) handle(c tdlib, st client.AuthorizationState) (err error) {
+	t, hint := decodeState(st)
+	a.setState(t, hint)
+	a.log.Info(fmt.Sprintf("Account %d authorization state: %s %s", a.account, t, hint))
+	switch t {
+	case model.AuthStateWaitParameters:
+		_, err = c.SetTdlibParameters(a.params)
+	case model.AuthStateWaitPhoneNumber:
+		_, err = c.SetAuthenticationPhoneNumber(&client.SetAuthenticationPhoneNumberRequest{
+			PhoneNumber: a.phone,
+			Settings:    &client.PhoneNumberAuthenticationSettings{},
+		})
+	case model.AuthStateWaitPassword:
+		if a.password != "" && !a.passwordTried {
+			// try the configured password once, then wait for the submitted one
+			a.passwordTried = true
+			a.setErr(check(c, t, model.AuthInput{Password: a.password}))
+			break
+		}
+		a.awaitInput(c, t)
+	case model.AuthStateWaitCode, model.AuthStateWaitRegistration, model.AuthStateWaitEmailAddress, model.AuthStateWaitEmailCode:
+		a.awaitInput(c, t)
+	case model.AuthStateWaitOtherDevice:
+		err = a.awaitConfirmation(c, hint)
+	case model.AuthStateReady, model.AuthStateClosed:
+	default:
+		err = client.ErrNotSupportedAuthorizationState
+	}
+	if err != nil {
+		a.lock.Lock()
+		a.fatal = true
+		a.state.Err = err.Error()
+		a.notify()
+		a.lock.Unlock()
+	}
+	return
+}
+
+// awaitInput blocks until the input is submitted, the wrong input is reported back to the submitter and the same
+// state is handled again then.
+func (a *authorizer) awaitInput(c tdlib, t model.AuthStateType) {
+	s := <-a.chIn
+	var err error
+	switch s.qr {
+	case true:
+		_, err = c.RequestQrCodeAuthentication(&client.RequestQrCodeAuthenticationRequest{})
+		err = decodeError(err)
+	default:
+		err = check(c, t, s.in)
+	}
+	a.setErr(err)
+	s.chErr <- err
+}
+
+// awaitConfirmation blocks until the QR code is confirmed from another device or the link is refreshed by TDLib,
+// otherwise the authorization loop would spin requesting the same state.
+func (a *authorizer) awaitConfirmation(c tdlib, link string) (err error) {
+	t := time.NewTicker(a.pollInterval)
+	defer t.Stop()
+	for range t.C {
+		var st client.AuthorizationState
+		st, err = c.GetAuthorizationState()
+		if err != nil {
+			break
+		}
+		tNext, linkNext := decodeState(st)
+		if tNext != model.AuthStateWaitOtherDevice || linkNext != link {
+			break
+		}
+	}
+	return
+}
+
+func check(c tdlib, t model.AuthStateType, in model.AuthInput) (err error) {
+	switch t {
+	case model.AuthStateWaitCode:
+		switch {
+		case in.Resend:
+			_, err = c.ResendAuthenticationCode()
+		case in.Code == "":
+			err = fmt.Errorf("%w: code is required", ErrInvalid)
+		default:
+			_, err = c.CheckAuthenticationCode(&client.CheckAuthenticationCodeRequest{
+				Code: in.Code,
+			})
+		}
+	case model.AuthStateWaitPassword:
+		switch in.Password {
+		case "":
+			err = fmt.Errorf("%w: password is required", ErrInvalid)
+		default:
+			_, err = c.CheckAuthenticationPassword(&client.CheckAuthenticationPasswordRequest{
+				Password: in.Password,
+			})
+		}
+	case model.AuthStateWaitRegistration:
+		switch in.FirstName {
+		case "":
+			err = fmt.Errorf("%w: first name is required", ErrInvalid)
+		default:
+			_, err = c.RegisterUser(&client.RegisterUserRequest{
+				FirstName: in.FirstName,
+				LastName:  in.LastName,
+			})
+		}
+	case model.AuthStateWaitEmailAddress:
+		switch in.Email {
+		case "":
+			err = fmt.Errorf("%w: email is required", ErrInvalid)
+		default:
+			_, err = c.SetAuthenticationEmailAddress(&client.SetAuthenticationEmailAddressRequest{
+				EmailAddress: in.Email,
+			})
+		}
+	case model.AuthStateWaitEmailCode:
+		switch in.EmailCode {
+		case "":
+			err = fmt.Errorf("%w: email code is required", ErrInvalid)
+		default:
+			_, err = c.CheckAuthenticationEmailCode(&client.CheckAuthenticationEmailCodeRequest{
+				Code: &client.EmailAddressAuthenticationCode{
+					Code: in.EmailCode,
+				},
+			})
+		}
+	}
+	err = decodeError(err)
+	return
+}
+
+func (a *authorizer) Close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	// the authorization finishes either when ready or when the client is closed after a fatal failure
+	switch a.fatal {
+	case true:
+		a.state.Type = model.AuthStateClosed
+	default:
+		a.state.Type = model.AuthStateReady
+		a.state.Hint = ""
+		a.state.Err = ""
+	}
+	a.notify()
+}
+
+func (a *authorizer) State() (s model.AuthState) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	s = a.state
+	return
+}
+
+func (a *authorizer) Submit(ctx context.Context, in model.AuthInput) (err error) {
+	err = a.submit(ctx, submission{
+		in:    in,
+		chErr: make(chan error, 1),
+	})
+	return
+}
+
+func (a *authorizer) RequestQrCode(ctx context.Context) (link string, err error) {
+	s := a.State()
+	if s.Type != model.AuthStateWaitOtherDevice {
+		err = a.submit(ctx, submission{
+			qr:    true,
+			chErr: make(chan error, 1),
+		})
+	}
+	for err == nil && s.Type != model.AuthStateWaitOtherDevice {
+		s, err = a.Next(ctx, s)
+		if err == nil && (s.Type == model.AuthStateReady || s.Type == model.AuthStateClosed) {
+			err = fmt.Errorf("%w, state: %s", ErrUnexpected, s.Type)
+		}
+	}
+	if err == nil {
+		link = s.Hint
+	}
+	return
+}
+
+func (a *authorizer) Next(ctx context.Context, prev model.AuthState) (s model.AuthState, err error) {
+	for {
+		a.lock.Lock()
+		s = a.state
+		chChanged := a.chChanged
+		a.lock.Unlock()
+		if s != prev {
+			break
+		}
+		select {
+		case <-chChanged:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+	return
+}
+
+func (a *authorizer) submit(ctx context.Context, s submission) (err error) {
+	if !a.State().Type.AwaitsInput() {
+		err = fmt.Errorf("%w, state: %s", ErrUnexpected, a.State().Type)
+		return
+	}
+	select {
+	case a.chIn <- s:
+		select {
+		case err = <-s.chErr:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+func (a *authorizer) setState(t model.AuthStateType, hint string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.state.Type != t {
+		a.state.Err = "" // the previous input failure is not relevant anymore
+	}
+	a.state.Type = t
+	a.state.Hint = hint
+	a.notify()
+}
+
+func (a *authorizer) setErr(err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch err {
+	case nil:
+		a.state.Err = ""
+	default:
+		a.state.Err = err.Error()
+	}
+	a.notify()
+}
+
+// notify wakes up everybody waiting for the next state, the lock should be held.
+func (a *authorizer) notify() {
+	close(a.chChanged)
+	a.chChanged = make(chan struct{})
+}
+
+func decodeState(st client.AuthorizationState) (t model.AuthStateType, hint string) {
+	switch s := st.(type) {
+	case *client.AuthorizationStateWaitTdlibParameters:
+		t = model.AuthStateWaitParameters
+	case *client.AuthorizationStateWaitPhoneNumber:
+		t = model.AuthStateWaitPhoneNumber
+	case *client.AuthorizationStateWaitCode:
+		t = model.AuthStateWaitCode
+		if s.CodeInfo != nil && s.CodeInfo.Type != nil {
+			hint = fmt.Sprintf("%s to %s", s.CodeInfo.Type.AuthenticationCodeTypeType(), s.CodeInfo.PhoneNumber)
+		}
+	case *client.AuthorizationStateWaitPassword:
+		t = model.AuthStateWaitPassword
+		hint = s.PasswordHint
+	case *client.AuthorizationStateWaitRegistration:
+		t = model.AuthStateWaitRegistration
+	case *client.AuthorizationStateWaitEmailAddress:
+		t = model.AuthStateWaitEmailAddress
+	case *client.AuthorizationStateWaitEmailCode:
+		t = model.AuthStateWaitEmailCode
+		if s.CodeInfo != nil {
+			hint = s.CodeInfo.EmailAddressPattern
+		}
+	case *client.AuthorizationStateWaitOtherDeviceConfirmation:
+		t = model.AuthStateWaitOtherDevice
+		hint = s.Link
+	case *client.AuthorizationStateReady:
+		t = model.AuthStateReady
+	case *client.AuthorizationStateClosing, *client.AuthorizationStateClosed, *client.AuthorizationStateLoggingOut:
+		t = model.AuthStateClosed
+	}
+	return
+}
+
+// decodeError classifies the TDLib input check failure, e.g. PHONE_CODE_INVALID or PHONE_CODE_EXPIRED.
+func decodeError(src error) (dst error) {
+	var respErr client.ResponseError
+	switch {
+	case src == nil:
+	case errors.As(src, &respErr) && respErr.Err != nil:
+		msg := respErr.Err.Message
+		switch {
+		case strings.HasSuffix(msg, "_EXPIRED"):
+			dst = fmt.Errorf("%w: %s", ErrExpired, msg)
+		case strings.HasSuffix(msg, "_INVALID"), strings.HasSuffix(msg, "_EMPTY"):
+			dst = fmt.Errorf("%w: %s", ErrInvalid, msg)
+		default:
+			dst = src
+		}
+	default:
+		dst = src
+	}
+	return
+}
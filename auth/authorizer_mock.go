@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/model"
+)
+
+type authorizerMock struct {
+	account int
+}
+
+func NewAuthorizerMock(account int) Authorizer {
+	return authorizerMock{
+		account: account,
+	}
+}
+
+func (am authorizerMock) Handle(c *client.Client, st client.AuthorizationState) (err error) {
+	return
+}
+
+func (am authorizerMock) Close() {
+}
+
+func (am authorizerMock) State() (s model.AuthState) {
+	s = model.AuthState{
+		Account: am.account,
+		Type:    model.AuthStateWaitCode,
+		Hint:    "authenticationCodeTypeSms to +123456789",
+	}
+	return
+}
+
+func (am authorizerMock) Submit(ctx context.Context, in model.AuthInput) (err error) {
+	switch {
+	case in.Password != "":
+		err = fmt.Errorf("%w, state: %s", ErrUnexpected, model.AuthStateWaitCode)
+	case in.Code == "invalid":
+		err = fmt.Errorf("%w: PHONE_CODE_INVALID", ErrInvalid)
+	case in.Code == "expired":
+		err = fmt.Errorf("%w: PHONE_CODE_EXPIRED", ErrExpired)
+	}
+	return
+}
+
+func (am authorizerMock) RequestQrCode(ctx context.Context) (link string, err error) {
+	link = "tg://login?token=AQID"
+	return
+}
+
+func (am authorizerMock) Next(ctx context.Context, prev model.AuthState) (s model.AuthState, err error) {
+	s = model.AuthState{
+		Account: am.account,
+		Type:    model.AuthStateReady,
+	}
+	return
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tdlibFake struct {
+	code     string
+	password string
+	calls    []string
+
+	stateLock sync.Mutex
+	state     client.AuthorizationState
+}
+
+func (tf *tdlibFake) SetTdlibParameters(req *client.SetTdlibParametersRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "SetTdlibParameters")
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) SetAuthenticationPhoneNumber(req *client.SetAuthenticationPhoneNumberRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "SetAuthenticationPhoneNumber")
+	if req.PhoneNumber == "" {
+		return nil, errResponse("PHONE_NUMBER_INVALID")
+	}
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) ResendAuthenticationCode() (*client.Ok, error) {
+	tf.calls = append(tf.calls, "ResendAuthenticationCode")
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) CheckAuthenticationCode(req *client.CheckAuthenticationCodeRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "CheckAuthenticationCode")
+	switch req.Code {
+	case tf.code:
+		return &client.Ok{}, nil
+	case "00000":
+		return nil, errResponse("PHONE_CODE_EXPIRED")
+	}
+	return nil, errResponse("PHONE_CODE_INVALID")
+}
+
+func (tf *tdlibFake) CheckAuthenticationPassword(req *client.CheckAuthenticationPasswordRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "CheckAuthenticationPassword")
+	if req.Password != tf.password {
+		return nil, errResponse("PASSWORD_HASH_INVALID")
+	}
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) RegisterUser(req *client.RegisterUserRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "RegisterUser")
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) SetAuthenticationEmailAddress(req *client.SetAuthenticationEmailAddressRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "SetAuthenticationEmailAddress")
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) CheckAuthenticationEmailCode(req *client.CheckAuthenticationEmailCodeRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "CheckAuthenticationEmailCode")
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) RequestQrCodeAuthentication(req *client.RequestQrCodeAuthenticationRequest) (*client.Ok, error) {
+	tf.calls = append(tf.calls, "RequestQrCodeAuthentication")
+	return &client.Ok{}, nil
+}
+
+func (tf *tdlibFake) GetAuthorizationState() (client.AuthorizationState, error) {
+	tf.stateLock.Lock()
+	defer tf.stateLock.Unlock()
+	return tf.state, nil
+}
+
+func (tf *tdlibFake) setState(st client.AuthorizationState) {
+	tf.stateLock.Lock()
+	defer tf.stateLock.Unlock()
+	tf.state = st
+}
+
+func errResponse(msg string) error {
+	return client.ResponseError{
+		Err: &client.Error{
+			Code:    400,
+			Message: msg,
+		},
+	}
+}
+
+func TestAuthorizer_Code(t *testing.T) {
+	tf := &tdlibFake{
+		code: "12345",
+	}
+	a := NewAuthorizer(1, "+123456789", "", &client.SetTdlibParametersRequest{}, slog.Default()).(*authorizer)
+	st := &client.AuthorizationStateWaitCode{
+		CodeInfo: &client.AuthenticationCodeInfo{
+			PhoneNumber: "+123456789",
+			Type:        &client.AuthenticationCodeTypeSms{},
+		},
+	}
+	// nothing is waiting yet
+	err := a.Submit(context.TODO(), model.AuthInput{Code: "12345"})
+	assert.ErrorIs(t, err, ErrUnexpected)
+	//
+	cases := []struct {
+		in  model.AuthInput
+		err error
+	}{
+		{
+			in:  model.AuthInput{},
+			err: ErrInvalid,
+		},
+		{
+			in:  model.AuthInput{Code: "54321"},
+			err: ErrInvalid,
+		},
+		{
+			in:  model.AuthInput{Code: "00000"},
+			err: ErrExpired,
+		},
+		{
+			in: model.AuthInput{Resend: true},
+		},
+		{
+			in: model.AuthInput{Code: "12345"},
+		},
+	}
+	for _, c := range cases {
+		chHandled := make(chan error)
+		go func() {
+			chHandled <- a.handle(tf, st)
+		}()
+		require.Eventually(t, func() bool {
+			return a.State().Type == model.AuthStateWaitCode
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 1, a.State().Account)
+		assert.Equal(t, "authenticationCodeTypeSms to +123456789", a.State().Hint)
+		err = a.Submit(context.TODO(), c.in)
+		assert.ErrorIs(t, err, c.err)
+		// the wrong input doesn't break the authorization
+		assert.Nil(t, <-chHandled)
+		switch c.err {
+		case nil:
+			assert.Empty(t, a.State().Err)
+		default:
+			assert.NotEmpty(t, a.State().Err)
+		}
+	}
+	assert.Contains(t, tf.calls, "ResendAuthenticationCode")
+	a.Close()
+	assert.Equal(t, model.AuthStateReady, a.State().Type)
+}
+
+func TestAuthorizer_Password(t *testing.T) {
+	tf := &tdlibFake{
+		password: "secret",
+	}
+	a := NewAuthorizer(0, "+123456789", "wrong", &client.SetTdlibParametersRequest{}, slog.Default()).(*authorizer)
+	st := &client.AuthorizationStateWaitPassword{
+		PasswordHint: "the usual one",
+	}
+	// the configured password is tried first w/o waiting for the input
+	err := a.handle(tf, st)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"CheckAuthenticationPassword"}, tf.calls)
+	assert.Equal(t, model.AuthStateWaitPassword, a.State().Type)
+	assert.Equal(t, "the usual one", a.State().Hint)
+	assert.Contains(t, a.State().Err, "PASSWORD_HASH_INVALID")
+	// then the submitted one
+	chHandled := make(chan error)
+	go func() {
+		chHandled <- a.handle(tf, st)
+	}()
+	err = a.Submit(context.TODO(), model.AuthInput{Password: "secret"})
+	assert.Nil(t, err)
+	assert.Nil(t, <-chHandled)
+	assert.Empty(t, a.State().Err)
+}
+
+func TestAuthorizer_Fatal(t *testing.T) {
+	tf := &tdlibFake{}
+	a := NewAuthorizer(0, "", "", &client.SetTdlibParametersRequest{}, slog.Default()).(*authorizer)
+	err := a.handle(tf, &client.AuthorizationStateWaitTdlibParameters{})
+	assert.Nil(t, err)
+	err = a.handle(tf, &client.AuthorizationStateWaitPhoneNumber{})
+	assert.NotNil(t, err)
+	assert.Equal(t, model.AuthStateWaitPhoneNumber, a.State().Type)
+	a.Close()
+	assert.Equal(t, model.AuthStateClosed, a.State().Type)
+	assert.Contains(t, a.State().Err, "PHONE_NUMBER_INVALID")
+}
+
+func TestAuthorizer_SubmitTimeout(t *testing.T) {
+	a := NewAuthorizer(0, "", "", &client.SetTdlibParametersRequest{}, slog.Default()).(*authorizer)
+	a.setState(model.AuthStateWaitCode, "")
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	err := a.Submit(ctx, model.AuthInput{Code: "12345"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAuthorizer_QrCode(t *testing.T) {
+	tf := &tdlibFake{}
+	a := NewAuthorizer(0, "+123456789", "", &client.SetTdlibParametersRequest{}, slog.Default()).(*authorizer)
+	a.pollInterval = time.Millisecond
+	// nothing is waiting yet
+	_, err := a.RequestQrCode(context.TODO())
+	assert.ErrorIs(t, err, ErrUnexpected)
+	//
+	chHandled := make(chan error)
+	go func() {
+		chHandled <- a.handle(tf, &client.AuthorizationStateWaitCode{})
+	}()
+	require.Eventually(t, func() bool {
+		return a.State().Type == model.AuthStateWaitCode
+	}, time.Second, time.Millisecond)
+	chLink := make(chan string)
+	go func() {
+		link, err := a.RequestQrCode(context.TODO())
+		assert.Nil(t, err)
+		chLink <- link
+	}()
+	assert.Nil(t, <-chHandled)
+	assert.Equal(t, []string{"RequestQrCodeAuthentication"}, tf.calls)
+	// TDLib switches to the confirmation state
+	stConfirm := &client.AuthorizationStateWaitOtherDeviceConfirmation{
+		Link: "tg://login?token=AQID",
+	}
+	tf.setState(stConfirm)
+	go func() {
+		chHandled <- a.handle(tf, stConfirm)
+	}()
+	assert.Equal(t, "tg://login?token=AQID", <-chLink)
+	s := a.State()
+	assert.Equal(t, model.AuthStateWaitOtherDevice, s.Type)
+	// the link is already there, no need to request again
+	link, err := a.RequestQrCode(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "tg://login?token=AQID", link)
+	// nothing changes until confirmed
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	_, err = a.Next(ctx, s)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// confirmed from another device
+	tf.setState(&client.AuthorizationStateReady{})
+	assert.Nil(t, <-chHandled)
+	a.Close()
+	s, err = a.Next(context.TODO(), s)
+	assert.Nil(t, err)
+	assert.Equal(t, model.AuthStateReady, s.Type)
+}
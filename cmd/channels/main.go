@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	apiGrpc "github.com/awakari/source-telegram/api/grpc"
+	"github.com/awakari/source-telegram/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"os"
+	"os/signal"
+)
+
+const importChunkSize = 64 * 1024
+
+const usage = `Usage:
+  channels [-addr host:port] [-token admin-token] export [-format jsonl|csv] [-deleted] > channels.jsonl
+  channels [-addr host:port] [-token admin-token] import [-format jsonl|csv] [-conflict skip|overwrite|fail] [-dry-run] < channels.jsonl
+
+Both commands call the admin API, the token defaults to the API_TOKEN_ADMIN environment variable. The import is done
+on behalf of the group and user set by the -group and -user flags.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) (code int) {
+	flags := flag.NewFlagSet("channels", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:50051", "source-telegram gRPC API address")
+	token := flags.String("token", os.Getenv("API_TOKEN_ADMIN"), "admin API token")
+	groupId := flags.String("group", "", "actor group id to record in the audit log")
+	userId := flags.String("user", "", "actor user id to record in the audit log")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	cmd, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	//
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+	client := apiGrpc.NewAdminClient(conn)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+	if *groupId != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, model.KeyGroupId, *groupId)
+	}
+	if *userId != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, model.KeyUserId, *userId)
+	}
+	//
+	switch cmd {
+	case "export":
+		code = export(ctx, client, cmdArgs)
+	case "import":
+		code = load(ctx, client, cmdArgs)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		flags.Usage()
+		code = 2
+	}
+	return
+}
+
+func export(ctx context.Context, client apiGrpc.AdminClient, args []string) (code int) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "dump format: jsonl or csv")
+	deleted := flags.Bool("deleted", false, "export the deleted channels instead of the active ones")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	var req apiGrpc.ExportRequest
+	req.Format, err = parseFormat(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	req.Filter = &apiGrpc.Filter{
+		Deleted: *deleted,
+	}
+	var stream apiGrpc.Admin_ExportClient
+	stream, err = client.Export(ctx, &req)
+	for err == nil {
+		var resp *apiGrpc.ExportResponse
+		resp, err = stream.Recv()
+		if err == nil {
+			_, err = os.Stdout.Write(resp.Data)
+		}
+	}
+	if err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		code = 1
+	}
+	return
+}
+
+func load(ctx context.Context, client apiGrpc.AdminClient, args []string) (code int) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "dump format: jsonl or csv")
+	conflict := flags.String("conflict", "skip", "the policy for the channel already stored: skip, overwrite or fail")
+	dryRun := flags.Bool("dry-run", false, "report the results without changing anything")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	req := apiGrpc.ImportRequest{
+		DryRun: *dryRun,
+	}
+	req.Format, err = parseFormat(*format)
+	if err == nil {
+		req.Conflict, err = parseConflict(*conflict)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var stream apiGrpc.Admin_ImportClient
+	stream, err = client.Import(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	buf := make([]byte, importChunkSize)
+	for {
+		var n int
+		n, err = os.Stdin.Read(buf)
+		if n > 0 {
+			req.Data = buf[:n]
+			// the server has stopped reading when the send returns io.EOF, the reason is returned by CloseAndRecv
+			if errSend := stream.Send(&req); errSend != nil {
+				if errSend != io.EOF {
+					err = errSend
+				}
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var resp *apiGrpc.ImportResponse
+	resp, err = stream.CloseAndRecv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, e := range resp.Errors {
+		fmt.Fprintf(os.Stderr, "row %d %s: %s\n", e.Row, e.Link, e.Error)
+	}
+	if n := int(resp.Failed) - len(resp.Errors); n > 0 {
+		fmt.Fprintf(os.Stderr, "... %d more failed rows\n", n)
+	}
+	fmt.Fprintf(
+		os.Stderr, "created: %d, overwritten: %d, skipped: %d, failed: %d\n",
+		resp.Created, resp.Overwritten, resp.Skipped, resp.Failed,
+	)
+	switch {
+	case resp.Aborted:
+		fmt.Fprintln(os.Stderr, "aborted at the first conflict")
+		code = 1
+	case resp.Failed > 0:
+		code = 1
+	}
+	return
+}
+
+func parseFormat(s string) (f apiGrpc.DumpFormat, err error) {
+	switch s {
+	case "jsonl":
+		f = apiGrpc.DumpFormat_JSONL
+	case "csv":
+		f = apiGrpc.DumpFormat_CSV
+	default:
+		err = errors.New("unsupported format: " + s)
+	}
+	return
+}
+
+func parseConflict(s string) (c apiGrpc.ImportConflict, err error) {
+	switch s {
+	case "skip":
+		c = apiGrpc.ImportConflict_SKIP
+	case "overwrite":
+		c = apiGrpc.ImportConflict_OVERWRITE
+	case "fail":
+		c = apiGrpc.ImportConflict_FAIL
+	default:
+		err = errors.New("unsupported conflict policy: " + s)
+	}
+	return
+}
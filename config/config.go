@@ -13,6 +13,9 @@ type Config struct {
 			Hashes   []string `envconfig:"API_TELEGRAM_HASHES" required:"true"`
 			Phones   []string `envconfig:"API_TELEGRAM_PHONES" required:"true"`
 			Password string   `envconfig:"API_TELEGRAM_PASS" default:""`
+			// Accounts are the indices of the accounts to host, defaults to the replica index only.
+			Accounts []int  `envconfig:"API_TELEGRAM_ACCOUNTS" default:""`
+			DbDir    string `envconfig:"API_TELEGRAM_DB_DIR" default:""`
 			Bot      struct {
 				Token string `envconfig:"API_TELEGRAM_BOT_TOKEN" default:""`
 			}
@@ -22,14 +25,27 @@ type Config struct {
 		}
 		Token struct {
 			Internal string `envconfig:"API_TOKEN_INTERNAL" required:"true"`
+			// Admin guards the admin API methods, the admin API is disabled when empty.
+			Admin string `envconfig:"API_TOKEN_ADMIN" default:""`
+		}
+		Queue   QueueConfig
+		Metrics struct {
+			Port uint16 `envconfig:"API_METRICS_PORT" default:"9090" required:"true"`
 		}
-		Queue QueueConfig
 	}
 	Db  DbConfig
 	Log struct {
 		Level int `envconfig:"LOG_LEVEL" default:"-4" required:"true"`
+		// Format is either "text" or "json".
+		Format string `envconfig:"LOG_FORMAT" default:"text" required:"true"`
 	}
 	Replica ReplicaConfig
+	Stale   StaleConfig
+	Orphans OrphansConfig
+	Flood   FloodConfig
+	Health  HealthConfig
+	Tracing TracingConfig
+	Stats   StatsConfig
 	Search  struct {
 		ChanMembersCountMin int32 `envconfig:"SEARCH_CHAN_MEMBERS_COUNT_MIN" default:"12345"`
 	}
@@ -45,15 +61,72 @@ type DbConfig struct {
 		Retention       time.Duration `envconfig:"DB_TABLE_RETENTION" default:"2160h" required:"true"`
 		Shard           bool          `envconfig:"DB_TABLE_SHARD" default:"true"`
 		RefreshInterval time.Duration `envconfig:"DB_TABLE_REFRESH_INTERVAL" default:"15m" required:"true"`
+		// DeleteGrace is the period to keep the deleted channel for before the removal, it may be restored meanwhile.
+		DeleteGrace time.Duration `envconfig:"DB_TABLE_DELETE_GRACE" default:"168h" required:"true"`
+	}
+	Audit struct {
+		// Retention is the period to keep the channel changes audit records for.
+		Retention time.Duration `envconfig:"DB_AUDIT_RETENTION" default:"8760h" required:"true"`
+	}
+	Stats struct {
+		// Retention is the period to keep the daily channel stats for, the channel total expires when not updated longer.
+		Retention time.Duration `envconfig:"DB_STATS_RETENTION" default:"2160h" required:"true"`
 	}
 	Tls struct {
 		Enabled  bool `envconfig:"DB_TLS_ENABLED" default:"false" required:"true"`
 		Insecure bool `envconfig:"DB_TLS_INSECURE" default:"false" required:"true"`
 	}
+	// Type is either "mongo" or "bolt", the latter is the embedded single file database for the single node deployments.
+	Type string `envconfig:"DB_TYPE" default:"mongo" required:"true"`
+	// Path is the database file used by the embedded backend, the retention is applied the same way.
+	Path string `envconfig:"DB_PATH" default:"/var/lib/source-telegram/channels.db"`
 }
 
 type ReplicaConfig struct {
-	Name string `envconfig:"REPLICA_NAME" required:"true"`
+	Name      string `envconfig:"REPLICA_NAME" required:"true"`
+	Count     int    `envconfig:"REPLICA_COUNT" default:"1" required:"true"`
+	JoinLimit uint32 `envconfig:"REPLICA_JOIN_LIMIT" default:"500" required:"true"`
+}
+
+type StaleConfig struct {
+	Period   time.Duration `envconfig:"STALE_PERIOD" default:"720h" required:"true"`
+	Grace    time.Duration `envconfig:"STALE_GRACE" default:"168h" required:"true"`
+	Interval time.Duration `envconfig:"STALE_INTERVAL" default:"1h" required:"true"`
+}
+
+type OrphansConfig struct {
+	LeaveLimit uint32  `envconfig:"ORPHANS_LEAVE_LIMIT" default:"10"`
+	RatioMax   float64 `envconfig:"ORPHANS_RATIO_MAX" default:"0.5"`
+}
+
+type FloodConfig struct {
+	// WaitMax is the longest flood wait to queue the calls for, the calls fail immediately during the longer waits.
+	WaitMax time.Duration `envconfig:"FLOOD_WAIT_MAX" default:"5m" required:"true"`
+}
+
+type HealthConfig struct {
+	// Interval is the period to update the gRPC health status.
+	Interval time.Duration `envconfig:"HEALTH_INTERVAL" default:"10s" required:"true"`
+	Timeout  time.Duration `envconfig:"HEALTH_TIMEOUT" default:"5s" required:"true"`
+	Pub      struct {
+		// Window is the count of the recent publish attempts to calculate the error rate for.
+		Window     int     `envconfig:"HEALTH_PUB_WINDOW" default:"100" required:"true"`
+		ErrRateMax float64 `envconfig:"HEALTH_PUB_ERR_RATE_MAX" default:"0.5" required:"true"`
+	}
+	// ListenerIdleMax is the longest period w/o any update received by an account before the replica is marked not
+	// ready, 0 disables.
+	ListenerIdleMax time.Duration `envconfig:"HEALTH_LISTENER_IDLE_MAX" default:"0"`
+}
+
+type StatsConfig struct {
+	// FlushInterval is the period to persist the channel message counters accumulated in memory.
+	FlushInterval time.Duration `envconfig:"STATS_FLUSH_INTERVAL" default:"1m" required:"true"`
+}
+
+type TracingConfig struct {
+	// Endpoint is the OTLP gRPC collector address, the spans are not exported when empty.
+	Endpoint    string  `envconfig:"TRACING_ENDPOINT" default:""`
+	SampleRatio float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"0.1" required:"true"`
 }
 
 type QueueConfig struct {
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
@@ -23,7 +24,20 @@ func TestConfig(t *testing.T) {
 	cfg, err := NewConfigFromEnv()
 	assert.Nil(t, err)
 	assert.Equal(t, "writer:56789", cfg.Api.Writer.Uri)
+	assert.Empty(t, cfg.Api.Token.Admin)
+	assert.Equal(t, "mongo", cfg.Db.Type)
+	assert.Equal(t, 8760*time.Hour, cfg.Db.Audit.Retention)
+	assert.Equal(t, 168*time.Hour, cfg.Db.Table.DeleteGrace)
+	assert.Equal(t, 2160*time.Hour, cfg.Db.Stats.Retention)
+	assert.Equal(t, time.Minute, cfg.Stats.FlushInterval)
 	assert.Equal(t, slog.LevelWarn, slog.Level(cfg.Log.Level))
+	assert.Equal(t, "text", cfg.Log.Format)
 	assert.Equal(t, []int32{123, 456, 789}, cfg.Api.Telegram.Ids)
 	assert.Equal(t, []string{"deadcode", "cafebeef"}, cfg.Api.Telegram.Hashes)
+	assert.Empty(t, cfg.Api.Telegram.Accounts)
+	assert.Equal(t, uint32(10), cfg.Orphans.LeaveLimit)
+	assert.Equal(t, 0.5, cfg.Orphans.RatioMax)
+	assert.Equal(t, 5*time.Minute, cfg.Flood.WaitMax)
+	assert.Equal(t, 100, cfg.Health.Pub.Window)
+	assert.Equal(t, time.Duration(0), cfg.Health.ListenerIdleMax)
 }
@@ -2,9 +2,13 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"github.com/akurilov/go-tdlib/client"
 )
 
 type Handler[U client.Type] interface {
 	Handle(ctx context.Context, u U) (err error)
 }
+
+// ErrDropped is returned when the update is skipped intentionally, it's not a failure.
+var ErrDropped = errors.New("dropped")
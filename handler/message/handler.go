@@ -9,9 +9,15 @@ import (
 	"github.com/awakari/source-telegram/handler"
 	"github.com/awakari/source-telegram/model"
 	"github.com/awakari/source-telegram/service"
+	"github.com/awakari/source-telegram/telegram"
+	"github.com/awakari/source-telegram/tracing"
+	"github.com/awakari/source-telegram/util"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"log/slog"
 	"strconv"
@@ -22,13 +28,25 @@ import (
 
 type msgHandler struct {
 	svcPub          pub.Service
-	clientTg        *client.Client
+	clientTg        telegram.Gateway
 	chansJoined     map[int64]*model.Channel
 	chansJoinedLock *sync.Mutex
+	chansStats      map[int64]*model.ChannelStats
+	statsUnflushed  map[int64]*model.ChannelCounters
 	log             *slog.Logger
 	indexShard      int
+	// posts contains the commented channel post id by the discussion thread
+	posts *lru.Cache[thread, int64]
 }
 
+type thread struct {
+	chatId int64
+	id     int64
+}
+
+// postsCacheSize is the count of the recent discussion threads to remember the commented channel posts for.
+const postsCacheSize = 10_000
+
 type FileType int32
 
 const (
@@ -45,6 +63,18 @@ const attrKeyLatitude = "latitude"
 const attrKeyLongitude = "longitude"
 const attrKeyMsgId = "tgmessageid"
 const attrKeyTime = "time"
+const attrKeySenderType = "tgsendertype"
+const attrKeyThreadId = "tgthreadid"
+
+// the reasons to drop the message
+var ErrUnsupported = fmt.Errorf("%w: unsupported message content", handler.ErrDropped)
+var ErrNoBot = fmt.Errorf("%w: %w", handler.ErrDropped, service.ErrNoBot)
+var ErrNoChannel = fmt.Errorf("%w: no joined channel", handler.ErrDropped)
+var ErrDiscussionDisabled = fmt.Errorf("%w: comments are disabled", handler.ErrDropped)
+var ErrDiscussionCopy = fmt.Errorf("%w: automatic copy of the channel post", handler.ErrDropped)
+
+const attrValSenderTypeChat = "chat"
+const attrValSenderTypeUser = "user"
 
 // file attrs
 const attrKeyFileId = "tgfileid"
@@ -56,39 +86,62 @@ const attrKeyFileType = "tgfiletype"
 
 func NewHandler(
 	svcPub pub.Service,
-	clientTg *client.Client,
+	clientTg telegram.Gateway,
 	chansJoined map[int64]*model.Channel,
 	chansJoinedLock *sync.Mutex,
+	chansStats map[int64]*model.ChannelStats,
+	statsUnflushed map[int64]*model.ChannelCounters,
 	log *slog.Logger,
 	indexShard int,
 ) handler.Handler[*client.Message] {
+	posts, _ := lru.New[thread, int64](postsCacheSize)
 	return msgHandler{
 		svcPub:          svcPub,
 		clientTg:        clientTg,
 		chansJoined:     chansJoined,
 		chansJoinedLock: chansJoinedLock,
+		chansStats:      chansStats,
+		statsUnflushed:  statsUnflushed,
 		log:             log,
 		indexShard:      indexShard,
+		posts:           posts,
 	}
 }
 
 func (h msgHandler) Handle(ctx context.Context, msg *client.Message) (err error) {
 	chanId := msg.ChatId
-	evt := h.convertToEvent(chanId, msg)
+	ctx = util.WithLogAttrs(ctx, slog.Int64("chatId", chanId), slog.Int64("msgId", msg.Id))
+	var evt *pb.CloudEvent
+	_, span := tracing.Tracer().Start(ctx, "message.convert")
+	evt, err = h.convertToEvent(chanId, msg)
 	if evt != nil {
+		span.SetAttributes(attribute.String("cloudevents.event_id", evt.Id))
+	}
+	span.End()
+	switch {
+	case err == nil:
 		err = h.updateChannelAndPublish(ctx, chanId, evt)
+	case errors.Is(err, handler.ErrDropped):
+		h.countDropped(chanId, err)
 	}
 	return
 }
 
-func (h msgHandler) convertToEvent(chanId int64, msg *client.Message) (evt *pb.CloudEvent) {
+func (h msgHandler) convertToEvent(chanId int64, msg *client.Message) (evt *pb.CloudEvent, err error) {
+	err = ErrUnsupported
 	if msg != nil {
 		content := msg.Content
 		if content != nil {
 			var src string
-			ch := h.chansJoined[chanId]
+			ch := h.joined(chanId)
 			if ch != nil {
 				src = ch.Link
+				if ch.Id != chanId {
+					err = h.acceptDiscussionMessage(ch, msg)
+					if err != nil {
+						return
+					}
+				}
 			}
 			evt = &pb.CloudEvent{
 				Id:          ksuid.New().String(),
@@ -108,7 +161,10 @@ func (h msgHandler) convertToEvent(chanId int64, msg *client.Message) (evt *pb.C
 					},
 				},
 			}
-			var err error
+			if !msg.IsChannelPost {
+				h.convertGroupMessage(ch, msg, evt)
+			}
+			err = nil
 			switch content.MessageContentType() {
 			case client.TypeMessageAudio:
 				a := content.(*client.MessageAudio)
@@ -147,6 +203,91 @@ func (h msgHandler) convertToEvent(chanId int64, msg *client.Message) (evt *pb.C
 	return
 }
 
+// joined returns the copy of the joined channel, nil if not found. The runtime state is shared with the service.
+func (h msgHandler) joined(chanId int64) (ch *model.Channel) {
+	h.chansJoinedLock.Lock()
+	defer h.chansJoinedLock.Unlock()
+	chJoined := h.chansJoined[chanId]
+	if chJoined != nil {
+		chCopy := *chJoined
+		ch = &chCopy
+	}
+	return
+}
+
+func (h msgHandler) acceptDiscussionMessage(ch *model.Channel, msg *client.Message) (err error) {
+	switch {
+	case !ch.Discussion:
+		h.log.Debug(fmt.Sprintf("Drop message %d from the discussion chat %d: comments are disabled for the channel %s", msg.Id, msg.ChatId, ch.Link))
+		err = ErrDiscussionDisabled
+	case msg.ForwardInfo != nil && msg.ForwardInfo.FromChatId == ch.Id:
+		// automatic copy of the channel post, the post itself is received from the channel, the copy starts the thread
+		h.posts.Add(thread{chatId: msg.ChatId, id: msg.Id}, msg.ForwardInfo.FromMessageId)
+		err = ErrDiscussionCopy
+	}
+	return
+}
+
+func (h msgHandler) convertGroupMessage(ch *model.Channel, msg *client.Message, evt *pb.CloudEvent) {
+	if msg.SenderId != nil {
+		var senderType string
+		switch msg.SenderId.MessageSenderType() {
+		case client.TypeMessageSenderChat:
+			senderType = attrValSenderTypeChat
+		case client.TypeMessageSenderUser:
+			senderType = attrValSenderTypeUser
+		}
+		if senderType != "" {
+			evt.Attributes[attrKeySenderType] = &pb.CloudEventAttributeValue{
+				Attr: &pb.CloudEventAttributeValue_CeString{
+					CeString: senderType,
+				},
+			}
+		}
+	}
+	threadId := msg.MessageThreadId
+	if threadId != 0 && ch != nil && ch.Id != msg.ChatId {
+		threadId = h.resolveChannelPostId(ch.Id, msg)
+	}
+	if threadId != 0 {
+		evt.Attributes[attrKeyThreadId] = &pb.CloudEventAttributeValue{
+			Attr: &pb.CloudEventAttributeValue_CeString{
+				CeString: strconv.FormatInt(threadId, 10),
+			},
+		}
+	}
+}
+
+// resolveChannelPostId returns the id of the channel post which is commented by the discussion message, 0 if unknown.
+// The thread is requested only when not met before.
+func (h msgHandler) resolveChannelPostId(chanId int64, msg *client.Message) (postId int64) {
+	key := thread{
+		chatId: msg.ChatId,
+		id:     msg.MessageThreadId,
+	}
+	postId, found := h.posts.Get(key)
+	if found {
+		return
+	}
+	info, err := h.clientTg.GetMessageThread(&client.GetMessageThreadRequest{
+		ChatId:    msg.ChatId,
+		MessageId: msg.Id,
+	})
+	switch err {
+	case nil:
+		for _, threadMsg := range info.Messages {
+			if threadMsg.ForwardInfo != nil && threadMsg.ForwardInfo.FromChatId == chanId {
+				postId = threadMsg.ForwardInfo.FromMessageId
+				break
+			}
+		}
+		h.posts.Add(key, postId)
+	default:
+		h.log.Debug(fmt.Sprintf("Failed to resolve the thread of the message %d in the chat %d, cause: %s", msg.Id, msg.ChatId, err))
+	}
+	return
+}
+
 func convertAudio(a *client.Audio, evt *pb.CloudEvent) {
 	convertFile(a.Audio, evt)
 	evt.Attributes[attrKeyFileType] = &pb.CloudEventAttributeValue{
@@ -205,7 +346,7 @@ func convertImage(img *client.PhotoSize, evt *pb.CloudEvent) {
 func convertText(txt *client.FormattedText, evt *pb.CloudEvent) (err error) {
 	for _, w := range strings.Split(txt.Text, " ") {
 		if w == service.TagNoBot {
-			err = service.ErrNoBot
+			err = ErrNoBot
 			return
 		}
 	}
@@ -261,6 +402,7 @@ func (h msgHandler) updateChannelAndPublish(ctx context.Context, chanId int64, e
 	switch ch {
 	case nil:
 		h.log.Debug(fmt.Sprintf("No joined channel found for id = %d", chanId))
+		err = ErrNoChannel
 	default:
 		attrTs, attrTsOk := evt.Attributes[attrKeyTime]
 		if attrTsOk && attrTs != nil {
@@ -276,17 +418,76 @@ func (h msgHandler) updateChannelAndPublish(ctx context.Context, chanId int64, e
 			userId = ch.Link
 		}
 		err = h.publish(ctx, evt, groupId, userId)
+		stats := h.stats(ch.Id)
+		delta := model.ChannelCounters{
+			Seen: 1,
+		}
 		switch {
 		case err == nil:
+			stats.Messages++
+			delta.Published = 1
+			delta.TextBytes = uint64(len(evt.GetTextData()))
+		case errors.Is(err, handler.ErrDropped):
+			delta.Dropped = map[string]uint64{
+				dropReason(err): 1,
+			}
 		default:
-			h.log.Error(fmt.Sprintf("Failed to publish event %s from channel %d, cause: %s", evt.Id, chanId, err))
+			stats.Messages++
+			delta.Failed = 1
+			stats.LastErr = err.Error()
+			stats.LastErrTime = time.Now().UTC()
+			h.log.ErrorContext(ctx, fmt.Sprintf("Failed to publish event %s from channel %d, cause: %s", evt.Id, chanId, err))
 		}
+		h.count(ch.Id, delta)
+	}
+	return
+}
+
+// countDropped counts the message dropped before publishing, unless the chat is not attributed to any joined channel.
+func (h msgHandler) countDropped(chatId int64, err error) {
+	h.chansJoinedLock.Lock()
+	defer h.chansJoinedLock.Unlock()
+	ch := h.chansJoined[chatId]
+	if ch != nil {
+		h.count(ch.Id, model.ChannelCounters{
+			Seen: 1,
+			Dropped: map[string]uint64{
+				dropReason(err): 1,
+			},
+		})
+	}
+}
+
+// stats returns the runtime stats of the channel, the lock should be held.
+func (h msgHandler) stats(chanId int64) (stats *model.ChannelStats) {
+	stats = h.chansStats[chanId]
+	if stats == nil {
+		stats = &model.ChannelStats{}
+		h.chansStats[chanId] = stats
 	}
 	return
 }
 
+// count adds the delta to both the runtime stats and the counters to persist, the lock should be held.
+func (h msgHandler) count(chanId int64, delta model.ChannelCounters) {
+	h.stats(chanId).Add(delta)
+	unflushed := h.statsUnflushed[chanId]
+	if unflushed == nil {
+		unflushed = &model.ChannelCounters{}
+		h.statsUnflushed[chanId] = unflushed
+	}
+	unflushed.Add(delta)
+}
+
 func (h msgHandler) publish(ctx context.Context, evt *pb.CloudEvent, groupId, userId string) (err error) {
-	if evt.Data != nil {
+	switch evt.Data {
+	case nil:
+		// e.g. the location or unsupported content type
+		err = ErrUnsupported
+	default:
+		var span trace.Span
+		ctx, span = tracing.Tracer().Start(ctx, "message.publish")
+		defer span.End()
 		err = h.svcPub.Publish(ctx, evt, groupId, userId)
 		if errors.Is(err, pub.ErrNoAck) {
 			// retry with a backoff
@@ -299,7 +500,8 @@ func (h msgHandler) publish(ctx context.Context, evt *pb.CloudEvent, groupId, us
 				},
 				b,
 				func(err error, d time.Duration) {
-					h.log.Warn(fmt.Sprintf("failed to write event %s, cause: %s, retrying in %s...", evt.Id, err, d))
+					span.AddEvent("retry", trace.WithAttributes(attribute.String("error", err.Error()), attribute.String("backoff", d.String())))
+					h.log.WarnContext(ctx, fmt.Sprintf("failed to write event %s, cause: %s, retrying in %s...", evt.Id, err, d))
 				},
 			)
 		}
@@ -0,0 +1,235 @@
+package message
+
+import (
+	"context"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/api/http/pub"
+	"github.com/awakari/source-telegram/handler"
+	"github.com/awakari/source-telegram/model"
+	"github.com/awakari/source-telegram/telegram"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+func TestMsgHandler_Handle(t *testing.T) {
+	chansJoined := map[int64]*model.Channel{
+		-1001: {
+			Id:     -1001,
+			Link:   "https://t.me/chan1",
+			UserId: "user1",
+		},
+		-1003: {
+			Id:     -1003,
+			Link:   "https://t.me/chan3",
+			UserId: "fail",
+		},
+	}
+	chansStats := map[int64]*model.ChannelStats{}
+	statsUnflushed := map[int64]*model.ChannelCounters{}
+	h := NewHandler(pub.NewMock(), telegram.NewGatewayFake(), chansJoined, &sync.Mutex{}, chansStats, statsUnflushed, slog.Default(), 0)
+	h = NewMetrics(h, 7)
+	text := func(chatId int64, txt string) *client.Message {
+		return &client.Message{
+			ChatId:        chatId,
+			IsChannelPost: true,
+			Content: &client.MessageText{
+				Text: &client.FormattedText{
+					Text: txt,
+				},
+			},
+		}
+	}
+	cases := map[string]struct {
+		msg    *client.Message
+		err    error
+		reason string
+	}{
+		"ok": {
+			msg: text(-1001, "hello"),
+		},
+		"no bot": {
+			msg:    text(-1001, "hello #nobot"),
+			err:    ErrNoBot,
+			reason: "nobot",
+		},
+		"no channel": {
+			msg:    text(-1002, "hello"),
+			err:    ErrNoChannel,
+			reason: "nochannel",
+		},
+		"unsupported": {
+			msg: &client.Message{
+				ChatId:        -1001,
+				IsChannelPost: true,
+				Content:       &client.MessageSticker{},
+			},
+			err:    ErrUnsupported,
+			reason: "unsupported",
+		},
+		"publish failure": {
+			msg: text(-1003, "hello"),
+			err: assert.AnError,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			dropped := testutil.ToFloat64(metricMsgsDropped.WithLabelValues("7", c.reason))
+			converted := testutil.ToFloat64(metricMsgsConverted.WithLabelValues("7"))
+			err := h.Handle(context.TODO(), c.msg)
+			switch {
+			case c.err == nil:
+				assert.Nil(t, err)
+			case c.err == assert.AnError:
+				assert.NotNil(t, err)
+				assert.NotErrorIs(t, err, handler.ErrDropped)
+			default:
+				assert.ErrorIs(t, err, c.err)
+				assert.ErrorIs(t, err, handler.ErrDropped)
+			}
+			switch c.reason {
+			case "":
+				assert.Equal(t, converted+1, testutil.ToFloat64(metricMsgsConverted.WithLabelValues("7")))
+			default:
+				assert.Equal(t, dropped+1, testutil.ToFloat64(metricMsgsDropped.WithLabelValues("7", c.reason)))
+			}
+		})
+	}
+	assert.Equal(t, uint64(1), chansStats[-1001].Messages)
+	assert.Equal(t, uint64(1), chansStats[-1001].Published)
+	assert.Empty(t, chansStats[-1001].LastErr)
+	assert.Equal(t, uint64(1), chansStats[-1003].Messages)
+	assert.Equal(t, uint64(1), chansStats[-1003].Failed)
+	assert.Equal(t, "fail", chansStats[-1003].LastErr)
+	assert.False(t, chansStats[-1003].LastErrTime.IsZero())
+	// the dropped messages are counted too
+	assert.Equal(t, uint64(3), chansStats[-1001].Seen)
+	assert.Equal(t, map[string]uint64{"nobot": 1, "unsupported": 1}, chansStats[-1001].Dropped)
+	assert.Equal(t, uint64(5), chansStats[-1001].TextBytes)
+	assert.Equal(t, model.ChannelCounters{
+		Seen:      3,
+		Published: 1,
+		Dropped:   map[string]uint64{"nobot": 1, "unsupported": 1},
+		TextBytes: 5,
+	}, *statsUnflushed[-1001])
+	assert.Equal(t, model.ChannelCounters{
+		Seen:   1,
+		Failed: 1,
+	}, *statsUnflushed[-1003])
+}
+
+func TestMsgHandler_Discussion(t *testing.T) {
+	chan1 := &model.Channel{
+		Id:         -1001,
+		Link:       "https://t.me/chan1",
+		Discussion: true,
+	}
+	chan3 := &model.Channel{
+		Id:   -1003,
+		Link: "https://t.me/chan3",
+	}
+	chansJoined := map[int64]*model.Channel{
+		-1001: chan1,
+		-2001: chan1,
+		-1003: chan3,
+		-2003: chan3,
+	}
+	gw := telegram.NewGatewayFake()
+	gw.MessageThreads[12] = &client.MessageThreadInfo{
+		ChatId: -2001,
+		Messages: []*client.Message{
+			{
+				Id:     11,
+				ChatId: -2001,
+				ForwardInfo: &client.MessageForwardInfo{
+					FromChatId:    -1001,
+					FromMessageId: 7,
+				},
+			},
+		},
+	}
+	h := NewHandler(pub.NewMock(), gw, chansJoined, &sync.Mutex{}, map[int64]*model.ChannelStats{}, map[int64]*model.ChannelCounters{}, slog.Default(), 0).(msgHandler)
+	comment := func(chatId, id, threadId int64) *client.Message {
+		return &client.Message{
+			Id:              id,
+			ChatId:          chatId,
+			SenderId:        &client.MessageSenderUser{UserId: 1},
+			MessageThreadId: threadId,
+			Content: &client.MessageText{
+				Text: &client.FormattedText{
+					Text: "comment",
+				},
+			},
+		}
+	}
+	// the steps depend on the previous ones
+	cases := []struct {
+		msg      *client.Message
+		threadId string
+		calls    int
+		err      error
+	}{
+		{
+			msg:      comment(-2001, 12, 11),
+			threadId: "7",
+			calls:    1,
+		},
+		{
+			// the same thread is not requested again
+			msg:      comment(-2001, 13, 11),
+			threadId: "7",
+			calls:    1,
+		},
+		{
+			msg: &client.Message{
+				Id:     21,
+				ChatId: -2001,
+				ForwardInfo: &client.MessageForwardInfo{
+					FromChatId:    -1001,
+					FromMessageId: 8,
+				},
+				Content: &client.MessageText{
+					Text: &client.FormattedText{
+						Text: "post",
+					},
+				},
+			},
+			calls: 1,
+			err:   ErrDiscussionCopy,
+		},
+		{
+			// the thread started by the copy seen before
+			msg:      comment(-2001, 22, 21),
+			threadId: "8",
+			calls:    1,
+		},
+		{
+			// the thread is unknown to the client
+			msg:   comment(-2001, 32, 31),
+			calls: 2,
+		},
+		{
+			msg:   comment(-2003, 42, 41),
+			calls: 2,
+			err:   ErrDiscussionDisabled,
+		},
+	}
+	for _, c := range cases {
+		evt, err := h.convertToEvent(c.msg.ChatId, c.msg)
+		switch c.err {
+		case nil:
+			require.Nil(t, err)
+			assert.Equal(t, "https://t.me/chan1", evt.Source)
+			assert.Equal(t, attrValSenderTypeUser, evt.Attributes[attrKeySenderType].GetCeString())
+			assert.Equal(t, c.threadId, evt.Attributes[attrKeyThreadId].GetCeString())
+		default:
+			assert.ErrorIs(t, err, c.err)
+			assert.ErrorIs(t, err, handler.ErrDropped)
+		}
+		// GetMessageThread is the only call expected
+		assert.Len(t, gw.Calls, c.calls)
+	}
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/handler"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+)
+
+type metrics struct {
+	h       handler.Handler[*client.Message]
+	account string
+}
+
+var metricMsgsConverted = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "awakari_source_telegram_messages_converted_total",
+		Help: "Awakari source telegram: messages converted to events",
+	},
+	[]string{"account"},
+)
+
+var metricMsgsDropped = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "awakari_source_telegram_messages_dropped_total",
+		Help: "Awakari source telegram: messages dropped by reason",
+	},
+	[]string{"account", "reason"},
+)
+
+func NewMetrics(h handler.Handler[*client.Message], account int) handler.Handler[*client.Message] {
+	return metrics{
+		h:       h,
+		account: strconv.Itoa(account),
+	}
+}
+
+func (m metrics) Handle(ctx context.Context, msg *client.Message) (err error) {
+	err = m.h.Handle(ctx, msg)
+	switch {
+	case errors.Is(err, handler.ErrDropped):
+		metricMsgsDropped.WithLabelValues(m.account, dropReason(err)).Inc()
+	default:
+		// converted, the publishing failures are counted by the publisher
+		metricMsgsConverted.WithLabelValues(m.account).Inc()
+	}
+	return
+}
+
+func dropReason(err error) (reason string) {
+	switch {
+	case errors.Is(err, ErrUnsupported):
+		reason = "unsupported"
+	case errors.Is(err, ErrNoBot):
+		reason = "nobot"
+	case errors.Is(err, ErrNoChannel):
+		reason = "nochannel"
+	case errors.Is(err, ErrDiscussionDisabled):
+		reason = "discussion"
+	case errors.Is(err, ErrDiscussionCopy):
+		reason = "copy"
+	default:
+		reason = "other"
+	}
+	return
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+)
+
+type metrics[U client.Type] struct {
+	h       Handler[U]
+	account string
+}
+
+var metricUpdatesHandled = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "awakari_source_telegram_updates_total",
+		Help: "Awakari source telegram: updates received from Telegram by type and handling result",
+	},
+	[]string{"account", "type", "result"},
+)
+
+// NewMetrics counts the updates handled by the account.
+func NewMetrics[U client.Type](h Handler[U], account int) Handler[U] {
+	return metrics[U]{
+		h:       h,
+		account: strconv.Itoa(account),
+	}
+}
+
+func (m metrics[U]) Handle(ctx context.Context, u U) (err error) {
+	err = m.h.Handle(ctx, u)
+	var result string
+	switch {
+	case err == nil:
+		result = "ok"
+	case errors.Is(err, ErrDropped):
+		result = "dropped"
+	default:
+		result = "fail"
+	}
+	metricUpdatesHandled.WithLabelValues(m.account, u.GetType(), result).Inc()
+	return
+}
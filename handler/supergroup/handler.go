@@ -0,0 +1,28 @@
+package supergroup
+
+import (
+	"context"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/handler"
+	"github.com/awakari/source-telegram/service"
+)
+
+type sgHandler struct {
+	svc service.Service
+}
+
+// TDLib derives the supergroup/channel chat id from the supergroup id as -100xxxxxxxxxx
+const chatIdOffsetSupergroup int64 = -1_000_000_000_000
+
+func NewHandler(svc service.Service) handler.Handler[*client.Supergroup] {
+	return sgHandler{
+		svc: svc,
+	}
+}
+
+func (h sgHandler) Handle(ctx context.Context, sg *client.Supergroup) (err error) {
+	if sg != nil && sg.Usernames != nil && len(sg.Usernames.ActiveUsernames) > 0 {
+		err = h.svc.UpdateUsernames(ctx, chatIdOffsetSupergroup-sg.Id, sg.Usernames.ActiveUsernames)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracingHandler[U client.Type] struct {
+	h       Handler[U]
+	name    string
+	account int
+}
+
+// NewTracing wraps the handling into the span, the dropped update is not an error.
+func NewTracing[U client.Type](h Handler[U], name string, account int) Handler[U] {
+	return tracingHandler[U]{
+		h:       h,
+		name:    name,
+		account: account,
+	}
+}
+
+func (th tracingHandler[U]) Handle(ctx context.Context, u U) (err error) {
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		th.name,
+		trace.WithAttributes(
+			attribute.Int("telegram.account", th.account),
+			attribute.String("telegram.type", u.GetType()),
+		),
+	)
+	defer span.End()
+	err = th.h.Handle(ctx, u)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrDropped):
+		span.SetAttributes(attribute.String("telegram.dropped", err.Error()))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+type handlerFunc func(ctx context.Context, msg *client.Message) error
+
+func (hf handlerFunc) Handle(ctx context.Context, msg *client.Message) error {
+	return hf(ctx, msg)
+}
+
+func TestTracing_Handle(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	h := NewTracing[*client.Message](handlerFunc(func(ctx context.Context, msg *client.Message) (err error) {
+		switch msg.Id {
+		case 1:
+			err = fmt.Errorf("%w: no channel", ErrDropped)
+		case 2:
+			err = errors.New("fail")
+		}
+		return
+	}), "message.Handle", 3)
+	//
+	for id := int64(0); id < 3; id++ {
+		_ = h.Handle(context.TODO(), &client.Message{Id: id})
+	}
+	spans := exp.GetSpans()
+	require.Len(t, spans, 3)
+	for _, s := range spans {
+		assert.Equal(t, "message.Handle", s.Name)
+		assert.Contains(t, s.Attributes, attribute.Int("telegram.account", 3))
+		assert.Contains(t, s.Attributes, attribute.String("telegram.type", client.TypeMessage))
+	}
+	assert.Equal(t, codes.Unset, spans[0].Status.Code)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+	assert.Contains(t, spans[1].Attributes, attribute.String("telegram.dropped", "dropped: no channel"))
+	assert.Equal(t, codes.Error, spans[2].Status.Code)
+}
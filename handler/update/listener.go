@@ -0,0 +1,55 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/handler"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+type Listener interface {
+	Listen(ctx context.Context) (err error)
+	// Last returns the time of the latest update received, zero when not listening.
+	Last() (t time.Time)
+}
+
+type listener struct {
+	listener *client.Listener
+	h        handler.Handler[client.Type]
+	log      *slog.Logger
+	last     *atomic.Int64
+}
+
+func NewListener(l *client.Listener, h handler.Handler[client.Type], log *slog.Logger) Listener {
+	return listener{
+		listener: l,
+		h:        h,
+		log:      log,
+		last:     &atomic.Int64{},
+	}
+}
+
+func (l listener) Listen(ctx context.Context) (err error) {
+	defer l.log.Info("Exit receiving updates")
+	defer l.last.Store(0)
+	l.last.Store(time.Now().UnixNano())
+	for u := range l.listener.Updates {
+		l.last.Store(time.Now().UnixNano())
+		errH := l.h.Handle(ctx, u)
+		if errH != nil && !errors.Is(errH, handler.ErrDropped) {
+			l.log.ErrorContext(ctx, fmt.Sprintf("Failed to handle the update %+v, cause: %s", u, errH))
+		}
+	}
+	return
+}
+
+func (l listener) Last() (t time.Time) {
+	if nanos := l.last.Load(); nanos > 0 {
+		t = time.Unix(0, nanos)
+	}
+	return
+}
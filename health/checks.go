@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/awakari/source-telegram/api/http/pub"
+	"github.com/awakari/source-telegram/auth"
+	"github.com/awakari/source-telegram/handler/update"
+	"github.com/awakari/source-telegram/model"
+	"time"
+)
+
+// AuthCheck fails until the account is authorized, the closed client is fatal.
+func AuthCheck(a auth.Authorizer) Check {
+	return func(ctx context.Context) (err error) {
+		s := a.State()
+		switch s.Type {
+		case model.AuthStateReady:
+		case model.AuthStateClosed:
+			err = fmt.Errorf("%w: account %d authorization closed: %s", ErrFatal, s.Account, s.Err)
+		default:
+			err = fmt.Errorf("account %d is not authorized, state: %s", s.Account, s.Type)
+		}
+		return
+	}
+}
+
+// PubCheck fails while the share of the recent publishing failures exceeds the limit.
+func PubCheck(svc pub.ErrRate, rateMax float64) Check {
+	return func(ctx context.Context) (err error) {
+		rate := svc.ErrRate()
+		if rate > rateMax {
+			err = fmt.Errorf("publishing error rate %.2f exceeds %.2f", rate, rateMax)
+		}
+		return
+	}
+}
+
+// ListenerCheck fails when the account updates are not received anymore, this is fatal. Receiving no updates for longer
+// than idleMax only marks the replica not ready: a quiet account is not a reason to restart. The idle check is disabled
+// when idleMax is 0.
+func ListenerCheck(account int, l update.Listener, idleMax time.Duration) Check {
+	return func(ctx context.Context) (err error) {
+		last := l.Last()
+		switch {
+		case last.IsZero():
+			err = fmt.Errorf("%w: account %d doesn't listen the updates", ErrFatal, account)
+		case idleMax > 0 && time.Since(last) > idleMax:
+			err = fmt.Errorf("account %d received no updates since %s", account, last.UTC().Format(time.RFC3339))
+		}
+		return
+	}
+}
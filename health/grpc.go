@@ -0,0 +1,29 @@
+package health
+
+import (
+	"context"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"time"
+)
+
+// ServeGrpc periodically reflects the readiness in the gRPC health status of the server ("") and of the services.
+func ServeGrpc(ctx context.Context, m Monitor, srv *health.Server, interval time.Duration, services ...string) {
+	services = append([]string{""}, services...)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		st := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		if m.Ready(ctx).Ok {
+			st = grpc_health_v1.HealthCheckResponse_SERVING
+		}
+		for _, svc := range services {
+			srv.SetServingStatus(svc, st)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// NewHandler responds 200 when the probe passes, otherwise 503 with the failures listed.
+func NewHandler(probe func(ctx context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := probe(req.Context())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		switch r.Ok {
+		case true:
+			w.WriteHeader(http.StatusOK)
+			_, _ = fmt.Fprintln(w, "ok")
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			names := make([]string, 0, len(r.Failures))
+			for name := range r.Failures {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				_, _ = fmt.Fprintf(w, "%s: %s\n", name, r.Failures[name])
+			}
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check returns nil when the component is healthy.
+type Check func(ctx context.Context) (err error)
+
+// Report contains the failed checks by name.
+type Report struct {
+	Ok       bool
+	Failures map[string]string
+}
+
+// Monitor aggregates the component checks. The readiness requires every check to pass, while the liveness fails only
+// on the checks which failures can't be recovered w/o the restart, see ErrFatal.
+type Monitor interface {
+	Register(name string, check Check)
+	// Started marks the startup complete, the replica is not ready before.
+	Started()
+	Live(ctx context.Context) (r Report)
+	Ready(ctx context.Context) (r Report)
+}
+
+type monitor struct {
+	timeout time.Duration
+	lock    *sync.Mutex
+	checks  map[string]Check
+	started *atomic.Bool
+}
+
+// ErrFatal marks the check failure that needs the restart.
+var ErrFatal = errors.New("fatal")
+
+const nameStartup = "startup"
+
+func NewMonitor(timeout time.Duration) Monitor {
+	return &monitor{
+		timeout: timeout,
+		lock:    &sync.Mutex{},
+		checks:  map[string]Check{},
+		started: &atomic.Bool{},
+	}
+}
+
+func (m *monitor) Register(name string, check Check) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.checks[name] = check
+}
+
+func (m *monitor) Started() {
+	m.started.Store(true)
+}
+
+func (m *monitor) Live(ctx context.Context) (r Report) {
+	r = m.run(ctx, true)
+	return
+}
+
+func (m *monitor) Ready(ctx context.Context) (r Report) {
+	r = m.run(ctx, false)
+	if !m.started.Load() {
+		r.Ok = false
+		r.Failures[nameStartup] = "in progress"
+	}
+	return
+}
+
+func (m *monitor) run(ctx context.Context, fatalOnly bool) (r Report) {
+	m.lock.Lock()
+	names := make([]string, 0, len(m.checks))
+	for name := range m.checks {
+		names = append(names, name)
+	}
+	checks := make([]Check, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checks[i] = m.checks[name]
+	}
+	m.lock.Unlock()
+	r.Ok = true
+	r.Failures = map[string]string{}
+	for i, check := range checks {
+		err := m.runCheck(ctx, check)
+		if err != nil && (!fatalOnly || errors.Is(err, ErrFatal)) {
+			r.Ok = false
+			r.Failures[names[i]] = err.Error()
+		}
+	}
+	return
+}
+
+func (m *monitor) runCheck(ctx context.Context, check Check) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	err = check(ctx)
+	return
+}
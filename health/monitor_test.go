@@ -0,0 +1,173 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-telegram/api/http/pub"
+	"github.com/awakari/source-telegram/auth"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	m := NewMonitor(time.Second)
+	m.Register("ok", func(ctx context.Context) (err error) {
+		return
+	})
+	// not ready before started
+	r := m.Ready(context.TODO())
+	assert.False(t, r.Ok)
+	assert.Equal(t, map[string]string{"startup": "in progress"}, r.Failures)
+	assert.True(t, m.Live(context.TODO()).Ok)
+	m.Started()
+	assert.True(t, m.Ready(context.TODO()).Ok)
+	// the non-fatal failure affects the readiness only
+	m.Register("auth-0", AuthCheck(auth.NewAuthorizerMock(0)))
+	r = m.Ready(context.TODO())
+	assert.False(t, r.Ok)
+	assert.Equal(t, map[string]string{"auth-0": "account 0 is not authorized, state: WaitCode"}, r.Failures)
+	assert.True(t, m.Live(context.TODO()).Ok)
+	// the fatal one affects both
+	m.Register("fatal", func(ctx context.Context) (err error) {
+		return fmt.Errorf("%w: closed", ErrFatal)
+	})
+	r = m.Live(context.TODO())
+	assert.False(t, r.Ok)
+	assert.Equal(t, map[string]string{"fatal": "fatal: closed"}, r.Failures)
+	assert.Len(t, m.Ready(context.TODO()).Failures, 2)
+}
+
+func TestMonitor_Timeout(t *testing.T) {
+	m := NewMonitor(10 * time.Millisecond)
+	m.Started()
+	m.Register("storage", func(ctx context.Context) (err error) {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	r := m.Ready(context.TODO())
+	assert.False(t, r.Ok)
+	assert.Equal(t, context.DeadlineExceeded.Error(), r.Failures["storage"])
+}
+
+func TestPubCheck(t *testing.T) {
+	svc := pub.NewErrRate(pub.NewMock(), 20)
+	check := PubCheck(svc, 0.5)
+	evt := &pb.CloudEvent{}
+	for i := 0; i < 9; i++ {
+		_ = svc.Publish(context.TODO(), evt, "group0", "fail")
+	}
+	// not enough attempts yet
+	assert.Nil(t, check(context.TODO()))
+	_ = svc.Publish(context.TODO(), evt, "group0", "fail")
+	assert.ErrorContains(t, check(context.TODO()), "publishing error rate 1.00 exceeds 0.50")
+	for i := 0; i < 10; i++ {
+		_ = svc.Publish(context.TODO(), evt, "group0", "user0")
+	}
+	assert.Nil(t, check(context.TODO()))
+	// the recent attempts only are considered
+	for i := 0; i < 20; i++ {
+		_ = svc.Publish(context.TODO(), evt, "group0", "user0")
+	}
+	assert.Equal(t, 0.0, svc.ErrRate())
+}
+
+type listenerLast time.Time
+
+func (l listenerLast) Listen(ctx context.Context) (err error) {
+	return
+}
+
+func (l listenerLast) Last() (t time.Time) {
+	return time.Time(l)
+}
+
+func TestListenerCheck(t *testing.T) {
+	cases := map[string]struct {
+		last    time.Time
+		idleMax time.Duration
+		err     string
+		fatal   bool
+	}{
+		"not listening": {
+			err:   "account 1 doesn't listen the updates",
+			fatal: true,
+		},
+		"recent update": {
+			last:    time.Now().Add(-time.Minute),
+			idleMax: time.Hour,
+		},
+		"idle check disabled": {
+			last: time.Now().Add(-24 * time.Hour),
+		},
+		"idle is not fatal": {
+			last:    time.Now().Add(-2 * time.Hour),
+			idleMax: time.Hour,
+			err:     "account 1 received no updates since",
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			err := ListenerCheck(1, listenerLast(c.last), c.idleMax)(context.TODO())
+			if c.err == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorContains(t, err, c.err)
+				assert.Equal(t, c.fatal, errors.Is(err, ErrFatal))
+			}
+		})
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	m := NewMonitor(time.Second)
+	m.Register("storage", func(ctx context.Context) (err error) {
+		return errors.New("unreachable")
+	})
+	srv := httptest.NewServer(NewHandler(m.Ready))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Equal(t, "startup: in progress\nstorage: unreachable\n", string(body))
+	//
+	srvLive := httptest.NewServer(NewHandler(m.Live))
+	defer srvLive.Close()
+	resp, err = http.Get(srvLive.URL)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServeGrpc(t *testing.T) {
+	m := NewMonitor(time.Second)
+	hs := health.NewServer()
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go ServeGrpc(ctx, m, hs, time.Millisecond, "svc0")
+	status := func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := hs.Check(context.TODO(), &grpc_health_v1.HealthCheckRequest{Service: "svc0"})
+		if err != nil {
+			return grpc_health_v1.HealthCheckResponse_UNKNOWN
+		}
+		return resp.Status
+	}
+	assert.Eventually(t, func() bool {
+		return status() == grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}, time.Second, time.Millisecond)
+	m.Started()
+	assert.Eventually(t, func() bool {
+		return status() == grpc_health_v1.HealthCheckResponse_SERVING
+	}, time.Second, time.Millisecond)
+}
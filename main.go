@@ -2,23 +2,36 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	apiGrpc "github.com/awakari/source-telegram/api/grpc"
 	"github.com/awakari/source-telegram/api/grpc/queue"
 	"github.com/awakari/source-telegram/api/http/pub"
+	"github.com/awakari/source-telegram/auth"
 	"github.com/awakari/source-telegram/config"
+	"github.com/awakari/source-telegram/handler"
 	"github.com/awakari/source-telegram/handler/message"
+	"github.com/awakari/source-telegram/handler/supergroup"
 	"github.com/awakari/source-telegram/handler/update"
-	"github.com/awakari/source-telegram/model"
+	"github.com/awakari/source-telegram/health"
+	"github.com/awakari/source-telegram/pool"
 	"github.com/awakari/source-telegram/service"
 	"github.com/awakari/source-telegram/storage"
+	"github.com/awakari/source-telegram/telegram"
+	"github.com/awakari/source-telegram/tracing"
+	"github.com/awakari/source-telegram/util"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	grpcHealth "google.golang.org/grpc/health"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -44,7 +57,7 @@ func main() {
 	opts := slog.HandlerOptions{
 		Level: slog.Level(cfg.Log.Level),
 	}
-	log := slog.New(slog.NewTextHandler(os.Stdout, &opts))
+	log := slog.New(util.NewLogHandler(os.Stdout, cfg.Log.Format, &opts))
 
 	// determine the replica index
 	replicaNameParts := strings.Split(cfg.Replica.Name, "-")
@@ -61,51 +74,96 @@ func main() {
 	}
 	log.Info(fmt.Sprintf("Replica: %d", replicaIndex))
 
-	if len(cfg.Api.Telegram.Ids) <= replicaIndex {
-		panic("Not enough telegram client ids, decrease the replica count or fix the config")
+	// init tracing
+	tp, err := tracing.NewProvider(context.TODO(), cfg.Tracing, cfg.Replica.Name)
+	if err != nil {
+		panic(err)
 	}
-	if len(cfg.Api.Telegram.Hashes) <= replicaIndex {
-		panic("Not enough telegram client hashes, decrease the replica count or fix the config")
+	defer tp.Shutdown(context.TODO())
+
+	// determine the hosted accounts
+	accIdxs := cfg.Api.Telegram.Accounts
+	if len(accIdxs) == 0 {
+		accIdxs = []int{replicaIndex}
 	}
-	if len(cfg.Api.Telegram.Phones) <= replicaIndex {
-		panic("Not enough phone numbers, decrease the replica count or fix the config")
+	for _, idx := range accIdxs {
+		if len(cfg.Api.Telegram.Ids) <= idx {
+			panic(fmt.Sprintf("Not enough telegram client ids for the account %d, fix the config", idx))
+		}
+		if len(cfg.Api.Telegram.Hashes) <= idx {
+			panic(fmt.Sprintf("Not enough telegram client hashes for the account %d, fix the config", idx))
+		}
+		if len(cfg.Api.Telegram.Phones) <= idx {
+			panic(fmt.Sprintf("Not enough phone numbers for the account %d, fix the config", idx))
+		}
 	}
+	log.Info(fmt.Sprintf("Accounts: %v", accIdxs))
 
-	// init the Telegram client
-	authorizer := client.ClientAuthorizer()
-	chCode := make(chan string)
-	go client.NonInteractiveCredentialsProvider(authorizer, cfg.Api.Telegram.Phones[replicaIndex], cfg.Api.Telegram.Password, chCode)
-	authorizer.TdlibParameters <- &client.SetTdlibParametersRequest{
-		//
-		UseTestDc:          false,
-		UseSecretChats:     false,
-		ApiId:              cfg.Api.Telegram.Ids[replicaIndex],
-		ApiHash:            cfg.Api.Telegram.Hashes[replicaIndex],
-		SystemLanguageCode: "en",
-		DeviceModel:        "Awakari",
-		SystemVersion:      "1.0.0",
-		ApplicationVersion: "1.0.0",
-		// db opts
-		UseFileDatabase:        true,
-		UseChatInfoDatabase:    true,
-		UseMessageDatabase:     true,
-		EnableStorageOptimizer: true,
-	}
 	_, err = client.SetLogVerbosityLevel(&client.SetLogVerbosityLevelRequest{
 		NewVerbosityLevel: 1,
 	})
 	if err != nil {
 		panic(err)
 	}
+	// the single hosted account used to keep the TDLib database in the root directory, most likely the replica's one
+	dbDirOwner := accIdxs[0]
+	if slices.Contains(accIdxs, replicaIndex) {
+		dbDirOwner = replicaIndex
+	}
+	dbMoved, err := telegram.MigrateDbDir(cfg.Api.Telegram.DbDir, dbDirOwner)
+	switch {
+	case err != nil:
+		log.Warn(fmt.Sprintf("Failed to move the TDLib database to the account %d directory, cause: %s", dbDirOwner, err))
+	case len(dbMoved) > 0:
+		log.Info(fmt.Sprintf("Moved the TDLib database to the account %d directory: %v", dbDirOwner, dbMoved))
+	}
+	auths := map[int]auth.Authorizer{}
+	for _, idx := range accIdxs {
+		dbDir := telegram.DbDir(cfg.Api.Telegram.DbDir, idx)
+		auths[idx] = auth.NewAuthorizer(idx, cfg.Api.Telegram.Phones[idx], cfg.Api.Telegram.Password, tdlibParams(cfg, idx, dbDir), log)
+	}
+
+	// init the health checks, the liveness is served before the authorization which may take a while
+	monitor := health.NewMonitor(cfg.Health.Timeout)
+	for idx, a := range auths {
+		monitor.Register(fmt.Sprintf("auth-%d", idx), health.AuthCheck(a))
+	}
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.Handle("/healthz", health.NewHandler(monitor.Live))
+		mux.Handle("/readyz", health.NewHandler(monitor.Ready))
+		log.Info(fmt.Sprintf("starting to serve the metrics and health @ port #%d...", cfg.Api.Metrics.Port))
+		err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Api.Metrics.Port), mux)
+		if err != nil {
+			panic(err)
+		}
+	}()
+
 	//
-	c := apiGrpc.NewController(chCode)
+	c := apiGrpc.NewController(auths)
+	healthSrv := grpcHealth.NewServer()
+	go health.ServeGrpc(context.Background(), monitor, healthSrv, cfg.Health.Interval, apiGrpc.Service_ServiceDesc.ServiceName)
 	log.Info(fmt.Sprintf("starting to listen the API @ port #%d...", cfg.Api.Port))
-	go apiGrpc.Serve(c, cfg.Api.Port)
-	//
-	clientTg, err := client.NewClient(authorizer)
-	if err != nil {
-		panic(err)
+	go apiGrpc.Serve(c, cfg.Api.Port, healthSrv, cfg.Api.Token.Admin)
+
+	// init the Telegram clients, every account is authorized independently
+	accs := make([]*pool.Account, len(accIdxs))
+	clientsTg := make([]*client.Client, len(accIdxs))
+	var wgAuth sync.WaitGroup
+	for i, idx := range accIdxs {
+		wgAuth.Add(1)
+		go func() {
+			defer wgAuth.Done()
+			clientsTg[i] = newClient(idx, auths[idx], log)
+			gw := telegram.NewGateway(clientsTg[i], telegram.NewFloodControl(idx, cfg.Flood.WaitMax))
+			gw = telegram.NewGatewayMetrics(gw, idx)
+			accs[i] = pool.NewAccount(idx, gw)
+		}()
 	}
+	wgAuth.Wait()
+	accPool := pool.NewPool(accs)
+	prometheus.MustRegister(pool.NewCollector(accPool))
 	optionValue, err := client.GetOption(&client.GetOptionRequest{
 		Name: "version",
 	})
@@ -113,11 +171,6 @@ func main() {
 		panic(err)
 	}
 	log.Info(fmt.Sprintf("TDLib version: %s", optionValue.(*client.OptionValueString).Value))
-	me, err := clientTg.GetMe()
-	if err != nil {
-		panic(err)
-	}
-	log.Info(fmt.Sprintf("Me: %s %s [%v]", me.FirstName, me.LastName, me.Usernames))
 
 	// init the channel storage
 	var stor storage.Storage
@@ -128,9 +181,7 @@ func main() {
 	stor = storage.NewLocalCache(stor, chanCacheSize, chanCacheTtl)
 	stor = storage.NewStorageLogging(stor, log)
 	defer stor.Close()
-
-	chansJoined := map[int64]*model.Channel{}
-	chansJoinedLock := &sync.Mutex{}
+	monitor.Register("storage", stor.Ping)
 
 	tok := cfg.Api.Telegram.Bot.Token
 	tokParts := strings.SplitN(tok, ":", 2)
@@ -143,46 +194,93 @@ func main() {
 		panic(err)
 	}
 
+	svcPub := pub.NewService(http.DefaultClient, cfg.Api.Writer.Uri, cfg.Api.Token.Internal)
+	svcPubErrRate := pub.NewErrRate(svcPub, cfg.Health.Pub.Window)
+	monitor.Register("pub", health.PubCheck(svcPubErrRate, cfg.Health.Pub.ErrRateMax))
+	svcPub = pub.NewMetrics(svcPubErrRate)
+	svcPub = pub.NewTracing(svcPub)
+	svcPub = pub.NewLogging(svcPub, log)
+
 	svc := service.NewService(
-		clientTg,
+		accPool,
 		stor,
-		chansJoined,
-		chansJoinedLock,
 		log,
-		replicaIndex,
 		botUserId,
-		cfg.Db.Table.RefreshInterval,
 		cfg.Search.ChanMembersCountMin,
+		svcPub,
+		cfg.Stale.Period,
+		cfg.Stale.Grace,
+		cfg.Stale.Interval,
+		cfg.Orphans.LeaveLimit,
+		cfg.Orphans.RatioMax,
+		cfg.Replica.Count,
+		cfg.Replica.JoinLimit,
+		cfg.Stats.FlushInterval,
 	)
+	svc = service.NewServiceMetrics(svc)
 	svc = service.NewServiceLogging(svc, log)
 	c.SetService(svc)
 	go func() {
 		b := backoff.NewExponentialBackOff()
-		_ = backoff.RetryNotify(svc.RefreshJoinedLoop, b, func(err error, d time.Duration) {
+		refreshJoinedLoop := func() (err error) {
+			return service.RefreshJoinedLoop(svc, cfg.Db.Table.RefreshInterval)
+		}
+		_ = backoff.RetryNotify(refreshJoinedLoop, b, func(err error, d time.Duration) {
 			log.Error(fmt.Sprintf("Failed to refresh joined channels, cause: %s, retrying in: %s...", err, d))
 		})
 	}()
-
-	svcPub := pub.NewService(http.DefaultClient, cfg.Api.Writer.Uri, cfg.Api.Token.Internal)
-	svcPub = pub.NewLogging(svcPub, log)
-
-	// init handlers
-	msgHandler := message.NewHandler(svcPub, clientTg, chansJoined, chansJoinedLock, log, replicaIndex)
+	go func() {
+		b := backoff.NewExponentialBackOff()
+		errWatch := backoff.RetryNotify(
+			func() (err error) {
+				err = svc.WatchChangesLoop()
+				if errors.Is(err, storage.ErrWatchUnsupported) {
+					err = backoff.Permanent(err)
+				}
+				return
+			},
+			b,
+			func(err error, d time.Duration) {
+				log.Error(fmt.Sprintf("Failed to watch channel changes, cause: %s, retrying in: %s...", err, d))
+			},
+		)
+		if errors.Is(errWatch, storage.ErrWatchUnsupported) {
+			log.Info(fmt.Sprintf("Channel changes watch is not available, relying on the periodic refresh only, cause: %s", errWatch))
+		}
+	}()
+	go func() {
+		b := backoff.NewExponentialBackOff()
+		_ = backoff.RetryNotify(svc.CleanStaleLoop, b, func(err error, d time.Duration) {
+			log.Error(fmt.Sprintf("Failed to clean stale channels, cause: %s, retrying in: %s...", err, d))
+		})
+	}()
+	go func() {
+		b := backoff.NewExponentialBackOff()
+		_ = backoff.RetryNotify(svc.FlushStatsLoop, b, func(err error, d time.Duration) {
+			log.Error(fmt.Sprintf("Failed to flush channel stats, cause: %s, retrying in: %s...", err, d))
+		})
+	}()
 
 	// expose the profiling
 	//go func() {
 	//	_ = http.ListenAndServe("localhost:6060", nil)
 	//}()
 
-	if replicaIndex == cfg.Api.Queue.ReplicaIndex {
+	if consumesQueue(accIdxs, cfg.Api.Queue.ReplicaIndex) {
 		// init queues
-		connQueue, err := grpc.NewClient(cfg.Api.Queue.Uri, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		connQueue, err := grpc.NewClient(
+			cfg.Api.Queue.Uri,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		)
 		if err != nil {
 			panic(err)
 		}
 		log.Info("connected to the queue service")
 		clientQueue := queue.NewServiceClient(connQueue)
 		svcQueue := queue.NewService(clientQueue)
+		svcQueue = queue.NewMetricsMiddleware(svcQueue)
+		svcQueue = queue.NewTracingMiddleware(svcQueue)
 		svcQueue = queue.NewLoggingMiddleware(svcQueue, log)
 		err = svcQueue.SetConsumer(context.TODO(), cfg.Api.Queue.InterestsCreated.Name, cfg.Api.Queue.InterestsCreated.Subj)
 		if err != nil {
@@ -222,23 +320,81 @@ func main() {
 		}()
 	}
 
-	//
-	listener := clientTg.GetListener()
-	defer listener.Close()
-	h := update.NewHandler(listener, msgHandler, log)
-	err = h.Listen(context.Background())
-	if err != nil {
-		panic(err)
-	}
-
 	//
 	ch := make(chan os.Signal, 2)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-ch
-		clientTg.Stop()
+		for _, clientTg := range clientsTg {
+			clientTg.Stop()
+		}
 		os.Exit(1)
 	}()
+
+	// init handlers and listen the updates of every account
+	sgHandler := supergroup.NewHandler(svc)
+	var wgListen sync.WaitGroup
+	for i, acc := range accPool.Accounts() {
+		msgHandler := message.NewHandler(svcPub, acc.Client, acc.ChansJoined, acc.ChansJoinedLock, acc.ChansStats, acc.StatsUnflushed, log, acc.Index)
+		msgHandler = message.NewMetrics(msgHandler, acc.Index)
+		msgHandler = handler.NewTracing(msgHandler, "message.Handle", acc.Index)
+		h := update.NewHandler(msgHandler, sgHandler)
+		h = handler.NewMetrics(h, acc.Index)
+		h = handler.NewTracing(h, "telegram.update", acc.Index)
+		listenerTg := clientsTg[i].GetListener()
+		l := update.NewListener(listenerTg, h, log)
+		monitor.Register(fmt.Sprintf("listener-%d", acc.Index), health.ListenerCheck(acc.Index, l, cfg.Health.ListenerIdleMax))
+		wgListen.Add(1)
+		go func() {
+			defer wgListen.Done()
+			defer listenerTg.Close()
+			err := l.Listen(util.WithLogAttrs(context.Background(), slog.Int("account", acc.Index)))
+			if err != nil {
+				panic(err)
+			}
+		}()
+	}
+	monitor.Started()
+	wgListen.Wait()
+}
+
+// consumesQueue is true when the queue replica index is one of the hosted accounts, a negative index disables the queue.
+func consumesQueue(accIdxs []int, queueReplicaIdx int) bool {
+	return queueReplicaIdx >= 0 && slices.Contains(accIdxs, queueReplicaIdx)
+}
+
+func tdlibParams(cfg config.Config, idx int, dbDir string) *client.SetTdlibParametersRequest {
+	return &client.SetTdlibParametersRequest{
+		//
+		UseTestDc:          false,
+		UseSecretChats:     false,
+		ApiId:              cfg.Api.Telegram.Ids[idx],
+		ApiHash:            cfg.Api.Telegram.Hashes[idx],
+		SystemLanguageCode: "en",
+		DeviceModel:        "Awakari",
+		SystemVersion:      "1.0.0",
+		ApplicationVersion: "1.0.0",
+		// db opts
+		DatabaseDirectory:      dbDir,
+		UseFileDatabase:        true,
+		UseChatInfoDatabase:    true,
+		UseMessageDatabase:     true,
+		EnableStorageOptimizer: true,
+	}
+}
+
+// newClient blocks until the account is authorized, the authorization input is submitted via the API.
+func newClient(idx int, authorizer auth.Authorizer, log *slog.Logger) (clientTg *client.Client) {
+	clientTg, err := client.NewClient(authorizer)
+	if err != nil {
+		panic(err)
+	}
+	me, err := clientTg.GetMe()
+	if err != nil {
+		panic(err)
+	}
+	log.Info(fmt.Sprintf("Account %d, me: %s %s [%v]", idx, me.FirstName, me.LastName, me.Usernames))
+	return
 }
 
 func consumeQueue(
@@ -248,10 +404,14 @@ func consumeQueue(
 	name, subj string,
 	batchSize uint32,
 ) (err error) {
+	ctx = util.WithLogAttrs(ctx, slog.String("queue", name), slog.String("subj", subj))
 	for {
 		err = svcQueue.ReceiveMessages(ctx, name, subj, batchSize, func(evts []*pb.CloudEvent) (err error) {
 			for _, evt := range evts {
-				_ = svc.HandleInterestChange(ctx, evt)
+				// continue the trace of the interest change
+				ctxEvt := tracing.Extract(ctx, evt)
+				ctxEvt = util.WithLogAttrs(ctxEvt, slog.String("evtId", evt.Id))
+				_ = svc.HandleInterestChange(ctxEvt, evt)
 			}
 			return
 		})
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConsumesQueue(t *testing.T) {
+	cases := map[string]struct {
+		accIdxs []int
+		idx     int
+		out     bool
+	}{
+		"hosted": {
+			accIdxs: []int{0, 1},
+			idx:     1,
+			out:     true,
+		},
+		"hosted 1st account": {
+			accIdxs: []int{0},
+			out:     true,
+		},
+		"not hosted": {
+			accIdxs: []int{2, 3},
+			idx:     1,
+		},
+		"disabled": {
+			accIdxs: []int{0, 1},
+			idx:     -1,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			assert.Equal(t, c.out, consumesQueue(c.accIdxs, c.idx))
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+// AccountInfo is the identity of the hosted Telegram account.
+type AccountInfo struct {
+	Index     int
+	UserId    int64
+	FirstName string
+	LastName  string
+	Usernames []string
+	Phone     string
+}
+
+// Info describes the running replica.
+type Info struct {
+	TdlibVersion string
+	Accounts     []AccountInfo
+}
@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// Actor is the one who changed the channel: either the user or the system component acting on its own.
+type Actor struct {
+	GroupId string
+	UserId  string
+	// System is the component name, empty when the change is requested by the user.
+	System string
+}
+
+// the system components changing the channels
+const (
+	ActorSystemRefresh   = "refresh"
+	ActorSystemStale     = "stale"
+	ActorSystemRebalance = "rebalance"
+	ActorSystemRename    = "rename"
+	ActorSystemRetention = "retention"
+)
+
+// AuditRecord is the append-only entry of the channel changes log.
+type AuditRecord struct {
+	// Id is assigned by the storage, the records are ordered by the id the same way as by the time.
+	Id        string
+	Time      time.Time
+	Action    ChannelChangeType
+	ChannelId int64
+	Link      string
+	// GroupId and UserId identify the channel owner, who may be not the actor.
+	GroupId string
+	UserId  string
+	Actor   Actor
+	Reason  string
+}
+
+// AuditFilter selects the audit records of the channel (by the id or the link) and/or of the user, who is either the
+// channel owner or the actor. The empty fields match any.
+type AuditFilter struct {
+	ChannelId int64
+	Link      string
+	GroupId   string
+	UserId    string
+}
+
+// IsEmpty returns true if the filter matches any record.
+func (f AuditFilter) IsEmpty() bool {
+	return f.ChannelId == 0 && f.Link == "" && f.GroupId == "" && f.UserId == ""
+}
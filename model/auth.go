@@ -0,0 +1,64 @@
+package model
+
+type AuthStateType int
+
+const (
+	AuthStateUnknown AuthStateType = iota
+	AuthStateWaitParameters
+	AuthStateWaitPhoneNumber
+	AuthStateWaitCode
+	AuthStateWaitPassword
+	AuthStateWaitRegistration
+	AuthStateWaitEmailAddress
+	AuthStateWaitEmailCode
+	AuthStateWaitOtherDevice
+	AuthStateReady
+	AuthStateClosed
+)
+
+func (t AuthStateType) String() string {
+	return [...]string{
+		"Unknown",
+		"WaitParameters",
+		"WaitPhoneNumber",
+		"WaitCode",
+		"WaitPassword",
+		"WaitRegistration",
+		"WaitEmailAddress",
+		"WaitEmailCode",
+		"WaitOtherDevice",
+		"Ready",
+		"Closed",
+	}[t]
+}
+
+// AwaitsInput returns true if the authorization can't proceed w/o the input submitted externally.
+func (t AuthStateType) AwaitsInput() bool {
+	switch t {
+	case AuthStateWaitCode, AuthStateWaitPassword, AuthStateWaitRegistration, AuthStateWaitEmailAddress, AuthStateWaitEmailCode:
+		return true
+	}
+	return false
+}
+
+// AuthState is the authorization state of the hosted Telegram account.
+type AuthState struct {
+	Account int
+	Type    AuthStateType
+	// Hint is the code destination, the password hint or the email pattern to help with the input.
+	Hint string
+	// Err is the failure of the latest input submitted, e.g. a wrong code.
+	Err string
+}
+
+// AuthInput contains the value expected by the current authorization state, the other fields are ignored.
+type AuthInput struct {
+	Code      string
+	Password  string
+	FirstName string
+	LastName  string
+	Email     string
+	EmailCode string
+	// Resend requests another code instead of submitting one.
+	Resend bool
+}
@@ -0,0 +1,43 @@
+package model
+
+// ChannelChangeType is the kind of the stored channel change.
+type ChannelChangeType int
+
+const (
+	ChannelChangeCreate ChannelChangeType = iota
+	ChannelChangeUpdate
+	ChannelChangeDelete
+)
+
+func (t ChannelChangeType) String() string {
+	return [...]string{
+		"Create",
+		"Update",
+		"Delete",
+	}[t]
+}
+
+// ChannelChange is the notification about the channel created, updated or deleted, possibly by another replica.
+type ChannelChange struct {
+	Type ChannelChangeType
+	// Channel is the state after the change, nil for the deleted channel.
+	Channel *Channel
+	// Before is the state before the change, nil if unknown.
+	Before *Channel
+}
+
+// Matches returns true if the change is relevant to the channels labeled so (any if nil), either before or after the
+// change. The deletion of the unknown channel matches any label.
+func (chg ChannelChange) Matches(lbl *string) (ok bool) {
+	switch {
+	case lbl == nil:
+		ok = true
+	case chg.Channel == nil && chg.Before == nil:
+		ok = true
+	case chg.Channel != nil && chg.Channel.Label == *lbl:
+		ok = true
+	case chg.Before != nil && chg.Before.Label == *lbl:
+		ok = true
+	}
+	return
+}
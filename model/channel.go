@@ -3,14 +3,20 @@ package model
 import "time"
 
 type Channel struct {
-	Id      int64
-	GroupId string
-	UserId  string
-	Name    string
-	Link    string
-	Created time.Time
-	Last    time.Time
-	SubId   string
-	Terms   string
-	Label   string
+	Id         int64
+	GroupId    string
+	UserId     string
+	Name       string
+	Link       string
+	Created    time.Time
+	Last       time.Time
+	SubId      string
+	Terms      string
+	Label      string
+	Discussion bool
+	Stale      time.Time
+	// Deleted is the time the channel was deleted at, zero for the active channel. The deleted channel is kept for the
+	// grace period to be restored.
+	Deleted      time.Time
+	DeleteReason string
 }
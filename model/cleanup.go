@@ -0,0 +1,22 @@
+package model
+
+type CleanupAction int
+
+const (
+	CleanupActionFlag CleanupAction = iota
+	CleanupActionUnflag
+	CleanupActionRemove
+)
+
+func (a CleanupAction) String() string {
+	return [...]string{
+		"Flag",
+		"Unflag",
+		"Remove",
+	}[a]
+}
+
+type ChannelCleanup struct {
+	Channel Channel
+	Action  CleanupAction
+}
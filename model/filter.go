@@ -3,7 +3,17 @@ package model
 type ChannelFilter struct {
 	GroupId string
 	UserId  string
+	// Pattern is matched as a substring of the link or the name unless Regex is set.
 	Pattern string
 	SubId   string
 	Label   *string
+	// Regex enables the regular expression (RE2 syntax without the nested quantifiers) Pattern.
+	Regex bool
+	// IgnoreCase makes the Pattern matching case-insensitive.
+	IgnoreCase bool
+	// Text is the full-text search query over the name, the link and the terms. The matching channels are ranked by
+	// the relevance instead of the link order and returned as the single page.
+	Text string
+	// Deleted selects the deleted channels instead of the active ones.
+	Deleted bool
 }
@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// FloodWait is the Telegram method class paused for the account until the specified time.
+type FloodWait struct {
+	Account int
+	Class   string
+	Until   time.Time
+}
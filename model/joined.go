@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// ChannelStats is the activity of the joined channel observed by the running process since the channel was joined.
+type ChannelStats struct {
+	// Messages is the count of the messages accepted for publishing.
+	Messages uint64
+	ChannelCounters
+	// LastErr is the latest publishing failure, empty if none.
+	LastErr     string
+	LastErrTime time.Time
+}
+
+// JoinedChannel is the runtime state of the channel joined by the hosted account.
+type JoinedChannel struct {
+	Account int
+	Channel Channel
+	// ChatIds contains the ids of the chats attributed to the channel, e.g. the linked discussion chat.
+	ChatIds []int64
+	Stats   ChannelStats
+}
@@ -0,0 +1,7 @@
+package model
+
+type ChannelMove struct {
+	Channel   Channel
+	LabelFrom string
+	LabelTo   string
+}
@@ -0,0 +1,58 @@
+package model
+
+import "time"
+
+// ChannelCounters are the counters of the messages received from the channel. The bson names are the stored stats
+// attributes.
+type ChannelCounters struct {
+	// Seen is the count of all the messages received including the dropped ones.
+	Seen      uint64 `bson:"seen"`
+	Published uint64 `bson:"published"`
+	Failed    uint64 `bson:"failed"`
+	// Dropped is the count of the messages not published by the drop reason, the same as the dropped messages metric
+	// label.
+	Dropped map[string]uint64 `bson:"dropped,omitempty"`
+	// TextBytes is the size of the published messages text.
+	TextBytes uint64 `bson:"textBytes"`
+}
+
+// Add increments the counters by the delta.
+func (c *ChannelCounters) Add(delta ChannelCounters) {
+	c.Seen += delta.Seen
+	c.Published += delta.Published
+	c.Failed += delta.Failed
+	c.TextBytes += delta.TextBytes
+	for reason, n := range delta.Dropped {
+		if c.Dropped == nil {
+			c.Dropped = map[string]uint64{}
+		}
+		c.Dropped[reason] += n
+	}
+}
+
+// IsZero returns true if nothing is counted.
+func (c ChannelCounters) IsZero() bool {
+	return c.Seen == 0 && c.Published == 0 && c.Failed == 0 && c.TextBytes == 0 && len(c.Dropped) == 0
+}
+
+// ChannelStatsDay is the channel counters of the single UTC day.
+type ChannelStatsDay struct {
+	// Date is the day start (UTC midnight).
+	Date     time.Time
+	Counters ChannelCounters
+}
+
+// ChannelStatsHistory is the channel counters persisted by all the replicas.
+type ChannelStatsHistory struct {
+	ChannelId int64
+	// Total is counted since the channel stats are kept, it expires when not updated during the stats retention period.
+	Total ChannelCounters
+	// Days are the daily buckets, the latest first.
+	Days    []ChannelStatsDay
+	Updated time.Time
+}
+
+// Day returns the UTC day start of the time.
+func Day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
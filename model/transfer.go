@@ -0,0 +1,37 @@
+package model
+
+// ImportConflict is the policy to resolve the conflict of the imported channel with the stored one by the link or id.
+type ImportConflict int
+
+const (
+	ImportConflictSkip ImportConflict = iota
+	// ImportConflictOverwrite replaces the stored channel having the same link, the id conflict with another link is
+	// reported still.
+	ImportConflictOverwrite
+	// ImportConflictFail stops the import at the first conflict.
+	ImportConflictFail
+)
+
+func (c ImportConflict) String() string {
+	return [...]string{
+		"Skip",
+		"Overwrite",
+		"Fail",
+	}[c]
+}
+
+type ImportResult int
+
+const (
+	ImportResultCreated ImportResult = iota
+	ImportResultOverwritten
+	ImportResultSkipped
+)
+
+func (r ImportResult) String() string {
+	return [...]string{
+		"Created",
+		"Overwritten",
+		"Skipped",
+	}[r]
+}
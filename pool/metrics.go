@@ -0,0 +1,37 @@
+package pool
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+)
+
+type collector struct {
+	p    Pool
+	desc *prometheus.Desc
+}
+
+// NewCollector reports the count of the channels joined by every hosted account.
+func NewCollector(p Pool) prometheus.Collector {
+	return collector{
+		p: p,
+		desc: prometheus.NewDesc(
+			"awakari_source_telegram_channels_joined",
+			"Awakari source telegram: channels joined by the account",
+			[]string{"account"},
+			nil,
+		),
+	}
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	for _, acc := range c.p.Accounts() {
+		acc.ChansJoinedLock.Lock()
+		n := len(acc.ChansJoined)
+		acc.ChansJoinedLock.Unlock()
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), strconv.Itoa(acc.Index))
+	}
+}
@@ -0,0 +1,87 @@
+package pool
+
+import (
+	"github.com/awakari/source-telegram/model"
+	"github.com/awakari/source-telegram/telegram"
+	"strconv"
+	"sync"
+)
+
+// Account is a single Telegram session hosted by the process.
+type Account struct {
+	Index           int
+	Client          telegram.Gateway
+	ChansJoined     map[int64]*model.Channel
+	ChansJoinedLock *sync.Mutex
+	// ChansStats contains the activity by the channel id, guarded by the ChansJoinedLock too.
+	ChansStats map[int64]*model.ChannelStats
+	// StatsUnflushed contains the counters not persisted yet by the channel id, guarded by the ChansJoinedLock too. These
+	// are kept when the channel is left until flushed.
+	StatsUnflushed map[int64]*model.ChannelCounters
+	// Discussions contains the linked discussion chat id by the joined channel id, 0 when the channel has none, guarded
+	// by the ChansJoinedLock too. Resolved once while the channel is joined.
+	Discussions map[int64]int64
+}
+
+type Pool interface {
+	Accounts() []*Account
+	// Account returns the hosted account assigned to the label, nil if the account is not hosted by this process.
+	Account(lbl string) (acc *Account)
+	// Default returns the 1st hosted account to use for the operations not bound to any specific account.
+	Default() (acc *Account)
+}
+
+type pool struct {
+	accs    []*Account
+	byLabel map[string]*Account
+}
+
+func NewAccount(idx int, clientTg telegram.Gateway) *Account {
+	return &Account{
+		Index:           idx,
+		Client:          clientTg,
+		ChansJoined:     map[int64]*model.Channel{},
+		ChansJoinedLock: &sync.Mutex{},
+		ChansStats:      map[int64]*model.ChannelStats{},
+		StatsUnflushed:  map[int64]*model.ChannelCounters{},
+		Discussions:     map[int64]int64{},
+	}
+}
+
+func (acc *Account) Label() string {
+	return Label(acc.Index)
+}
+
+// Label returns the channel label for the account index, empty for the 1st account.
+func Label(idx int) (lbl string) {
+	if idx > 0 {
+		lbl = strconv.Itoa(idx)
+	}
+	return
+}
+
+func NewPool(accs []*Account) Pool {
+	byLabel := map[string]*Account{}
+	for _, acc := range accs {
+		byLabel[acc.Label()] = acc
+	}
+	return pool{
+		accs:    accs,
+		byLabel: byLabel,
+	}
+}
+
+func (p pool) Accounts() []*Account {
+	return p.accs
+}
+
+func (p pool) Account(lbl string) (acc *Account) {
+	return p.byLabel[lbl]
+}
+
+func (p pool) Default() (acc *Account) {
+	if len(p.accs) > 0 {
+		acc = p.accs[0]
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package pool
+
+import (
+	"github.com/awakari/source-telegram/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestLabel(t *testing.T) {
+	assert.Equal(t, "", Label(0))
+	assert.Equal(t, "1", Label(1))
+	assert.Equal(t, "12", Label(12))
+}
+
+func TestPool_Account(t *testing.T) {
+	p := NewPool([]*Account{
+		NewAccount(2, nil),
+		NewAccount(0, nil),
+	})
+	assert.Equal(t, 2, len(p.Accounts()))
+	assert.Equal(t, 2, p.Default().Index)
+	assert.Equal(t, 0, p.Account("").Index)
+	assert.Equal(t, 2, p.Account("2").Index)
+	assert.Nil(t, p.Account("1"))
+}
+
+func TestNewCollector(t *testing.T) {
+	acc := NewAccount(1, nil)
+	acc.ChansJoined[-1001] = &model.Channel{}
+	acc.ChansJoined[-1002] = &model.Channel{}
+	c := NewCollector(NewPool([]*Account{acc, NewAccount(0, nil)}))
+	expected := `
+# HELP awakari_source_telegram_channels_joined Awakari source telegram: channels joined by the account
+# TYPE awakari_source_telegram_channels_joined gauge
+awakari_source_telegram_channels_joined{account="0"} 0
+awakari_source_telegram_channels_joined{account="1"} 2
+`
+	assert.Nil(t, testutil.CollectAndCompare(c, strings.NewReader(expected)))
+}
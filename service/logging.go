@@ -2,10 +2,11 @@ package service
 
 import (
 	"context"
-	"fmt"
 	"github.com/awakari/source-telegram/model"
+	"github.com/awakari/source-telegram/util"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
 	"log/slog"
+	"time"
 )
 
 type serviceLogging struct {
@@ -22,70 +23,193 @@ func NewServiceLogging(svc Service, log *slog.Logger) Service {
 
 func (sl serviceLogging) Create(ctx context.Context, ch model.Channel) (err error) {
 	err = sl.svc.Create(ctx, ch)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.Create", slog.String("link", ch.Link), slog.Int64("chatId", ch.Id), slog.String("groupId", ch.GroupId), slog.String("userId", ch.UserId), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) Read(ctx context.Context, link string) (ch model.Channel, err error) {
+	ch, err = sl.svc.Read(ctx, link)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.Read", slog.String("link", link), slog.Int64("chatId", ch.Id), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) Delete(ctx context.Context, link string, actor model.Actor) (err error) {
+	err = sl.svc.Delete(ctx, link, actor)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.Delete", slog.String("link", link), slog.Any("actor", actor), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) Restore(ctx context.Context, link string, actor model.Actor) (ch model.Channel, err error) {
+	ch, err = sl.svc.Restore(ctx, link, actor)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.Restore", slog.String("link", link), slog.Any("actor", actor), slog.Int64("chatId", ch.Id), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
+	page, err = sl.svc.GetPage(ctx, filter, limit, cursor, order)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.GetPage", slog.Any("filter", filter), slog.Any("limit", limit), slog.String("cursor", cursor), slog.String("order", order.String()), slog.Int("count", len(page)), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+	page, err = sl.svc.GetAuditPage(ctx, filter, limit, cursor)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.GetAuditPage", slog.Any("filter", filter), slog.Any("limit", limit), slog.String("cursor", cursor), slog.Int("count", len(page)), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32, groups bool) (n uint32, err error) {
+	n, err = sl.svc.SearchAndAdd(ctx, groupId, subId, terms, limit, groups)
+	var ll slog.Level
 	switch err {
 	case nil:
-		sl.log.Debug(fmt.Sprintf("service.Create(%+v): ok", ch))
+		ll = slog.LevelDebug
 	default:
-		sl.log.Error(fmt.Sprintf("service.Create(%+v): %s", ch, err))
+		ll = slog.LevelWarn
 	}
+	sl.log.LogAttrs(ctx, ll, "service.SearchAndAdd", slog.String("groupId", groupId), slog.String("subId", subId), slog.String("terms", terms), slog.Any("limit", limit), slog.Bool("groups", groups), slog.Any("count", n), util.LogErr(err))
 	return
 }
 
-func (sl serviceLogging) Read(ctx context.Context, link string) (ch model.Channel, err error) {
-	ch, err = sl.svc.Read(ctx, link)
+func (sl serviceLogging) WatchChangesLoop() (err error) {
+	return sl.svc.WatchChangesLoop()
+}
+
+func (sl serviceLogging) HandleInterestChange(ctx context.Context, evt *pb.CloudEvent) (err error) {
+	err = sl.svc.HandleInterestChange(ctx, evt)
+	var ll slog.Level
 	switch err {
 	case nil:
-		sl.log.Debug(fmt.Sprintf("service.Read(%s): %+v", link, ch))
+		ll = slog.LevelDebug
 	default:
-		sl.log.Error(fmt.Sprintf("service.Read(%s): %s", link, err))
+		ll = slog.LevelWarn
 	}
+	sl.log.LogAttrs(ctx, ll, "service.HandleInterestChange", slog.String("evtId", evt.Id), slog.String("evtType", evt.Type), slog.String("evtSource", evt.Source), util.LogErr(err))
 	return
 }
 
-func (sl serviceLogging) Delete(ctx context.Context, link string) (err error) {
-	err = sl.svc.Delete(ctx, link)
+func (sl serviceLogging) UpdateUsernames(ctx context.Context, chatId int64, usernames []string) (err error) {
+	err = sl.svc.UpdateUsernames(ctx, chatId, usernames)
+	var ll slog.Level
 	switch err {
 	case nil:
-		sl.log.Debug(fmt.Sprintf("service.Delete(%s): ok", link))
+		ll = slog.LevelDebug
 	default:
-		sl.log.Error(fmt.Sprintf("service.Delete(%s): %s", link, err))
+		ll = slog.LevelWarn
 	}
+	sl.log.LogAttrs(ctx, ll, "service.UpdateUsernames", slog.Int64("chatId", chatId), slog.Any("usernames", usernames), util.LogErr(err))
 	return
 }
 
-func (sl serviceLogging) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
-	page, err = sl.svc.GetPage(ctx, filter, limit, cursor, order)
+func (sl serviceLogging) RefreshJoined(ctx context.Context) (err error) {
+	err = sl.svc.RefreshJoined(ctx)
+	var ll slog.Level
 	switch err {
 	case nil:
-		sl.log.Debug(fmt.Sprintf("service.List(%+v, %d, %s, %s): %d", filter, limit, cursor, order, len(page)))
+		ll = slog.LevelInfo
 	default:
-		sl.log.Error(fmt.Sprintf("service.List(%+v, %d, %s, %s): %s", filter, limit, cursor, order, err))
+		ll = slog.LevelError
 	}
+	sl.log.LogAttrs(ctx, ll, "service.RefreshJoined", util.LogErr(err))
 	return
 }
 
-func (sl serviceLogging) SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32) (n uint32, err error) {
-	n, err = sl.svc.SearchAndAdd(ctx, groupId, subId, terms, limit)
+func (sl serviceLogging) Joined(ctx context.Context) (chans []model.JoinedChannel, err error) {
+	chans, err = sl.svc.Joined(ctx)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.Joined", slog.Int("count", len(chans)), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) Info(ctx context.Context) (info model.Info, err error) {
+	info, err = sl.svc.Info(ctx)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.Info", slog.String("tdlibVersion", info.TdlibVersion), slog.Int("accounts", len(info.Accounts)), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) CleanStale(ctx context.Context, dryRun bool) (report []model.ChannelCleanup, err error) {
+	report, err = sl.svc.CleanStale(ctx, dryRun)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.CleanStale", slog.Bool("dryRun", dryRun), slog.Int("count", len(report)), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) CleanStaleLoop() (err error) {
+	return sl.svc.CleanStaleLoop()
+}
+
+func (sl serviceLogging) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+	stats, err = sl.svc.GetStats(ctx, chanIds, since)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.GetStats", slog.Any("chatIds", chanIds), slog.Time("since", since), slog.Int("count", len(stats)), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) FlushStats(ctx context.Context) (err error) {
+	err = sl.svc.FlushStats(ctx)
+	var ll slog.Level
 	switch err {
 	case nil:
-		sl.log.Debug(fmt.Sprintf("service.SearchAndAdd(%s, %s, %s, %d): %d", groupId, subId, terms, limit, n))
+		ll = slog.LevelDebug
 	default:
-		sl.log.Warn(fmt.Sprintf("service.SearchAndAdd(%s, %s, %s, %d): %d, %s", groupId, subId, terms, limit, n, err))
+		ll = slog.LevelWarn
 	}
+	sl.log.LogAttrs(ctx, ll, "service.FlushStats", util.LogErr(err))
 	return
 }
 
-func (sl serviceLogging) RefreshJoinedLoop() (err error) {
-	return sl.svc.RefreshJoinedLoop()
+func (sl serviceLogging) FlushStatsLoop() (err error) {
+	return sl.svc.FlushStatsLoop()
 }
 
-func (sl serviceLogging) HandleInterestChange(ctx context.Context, evt *pb.CloudEvent) (err error) {
-	err = sl.svc.HandleInterestChange(ctx, evt)
+func (sl serviceLogging) Export(ctx context.Context, filter model.ChannelFilter, consume func(ch model.Channel) (err error)) (err error) {
+	err = sl.svc.Export(ctx, filter, consume)
+	var ll slog.Level
 	switch err {
 	case nil:
-		sl.log.Debug(fmt.Sprintf("service.HandleInterestChange(%+v)", evt))
+		ll = slog.LevelInfo
 	default:
-		sl.log.Warn(fmt.Sprintf("service.HandleInterestChange(%+v): %s", evt, err))
+		ll = slog.LevelError
 	}
+	sl.log.LogAttrs(ctx, ll, "service.Export", slog.Any("filter", filter), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) Import(ctx context.Context, ch model.Channel, conflict model.ImportConflict, dryRun bool, actor model.Actor) (result model.ImportResult, err error) {
+	result, err = sl.svc.Import(ctx, ch, conflict, dryRun, actor)
+	var ll slog.Level
+	switch err {
+	case nil:
+		ll = slog.LevelDebug
+	default:
+		ll = slog.LevelWarn
+	}
+	sl.log.LogAttrs(ctx, ll, "service.Import", slog.String("link", ch.Link), slog.Int64("chatId", ch.Id), slog.String("conflict", conflict.String()), slog.Bool("dryRun", dryRun), slog.Any("actor", actor), slog.String("result", result.String()), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) Rebalance(ctx context.Context, limit uint32, dryRun, drain bool) (moves []model.ChannelMove, err error) {
+	moves, err = sl.svc.Rebalance(ctx, limit, dryRun, drain)
+	var ll slog.Level
+	switch err {
+	case nil:
+		ll = slog.LevelInfo
+	default:
+		ll = slog.LevelError
+	}
+	sl.log.LogAttrs(ctx, ll, "service.Rebalance", slog.Any("limit", limit), slog.Bool("dryRun", dryRun), slog.Bool("drain", drain), slog.Int("count", len(moves)), util.LogErr(err))
+	return
+}
+
+func (sl serviceLogging) FloodWaits(ctx context.Context) (waits []model.FloodWait, err error) {
+	waits, err = sl.svc.FloodWaits(ctx)
+	ll := util.LogLevel(err)
+	sl.log.LogAttrs(ctx, ll, "service.FloodWaits", slog.Any("waits", waits), util.LogErr(err))
 	return
 }
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/model"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+type serviceMetrics struct {
+	svc Service
+}
+
+var metricRefreshDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "awakari_source_telegram_refresh_joined_duration_seconds",
+		Help:    "Awakari source telegram: joined channels refresh duration by result",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	},
+	[]string{"result"},
+)
+
+func NewServiceMetrics(svc Service) Service {
+	return serviceMetrics{
+		svc: svc,
+	}
+}
+
+func (sm serviceMetrics) Create(ctx context.Context, ch model.Channel) (err error) {
+	return sm.svc.Create(ctx, ch)
+}
+
+func (sm serviceMetrics) Read(ctx context.Context, link string) (ch model.Channel, err error) {
+	return sm.svc.Read(ctx, link)
+}
+
+func (sm serviceMetrics) Delete(ctx context.Context, link string, actor model.Actor) (err error) {
+	return sm.svc.Delete(ctx, link, actor)
+}
+
+func (sm serviceMetrics) Restore(ctx context.Context, link string, actor model.Actor) (ch model.Channel, err error) {
+	return sm.svc.Restore(ctx, link, actor)
+}
+
+func (sm serviceMetrics) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
+	return sm.svc.GetPage(ctx, filter, limit, cursor, order)
+}
+
+func (sm serviceMetrics) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+	return sm.svc.GetAuditPage(ctx, filter, limit, cursor)
+}
+
+func (sm serviceMetrics) SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32, groups bool) (n uint32, err error) {
+	return sm.svc.SearchAndAdd(ctx, groupId, subId, terms, limit, groups)
+}
+
+func (sm serviceMetrics) HandleInterestChange(ctx context.Context, evt *pb.CloudEvent) (err error) {
+	return sm.svc.HandleInterestChange(ctx, evt)
+}
+
+func (sm serviceMetrics) UpdateUsernames(ctx context.Context, chatId int64, usernames []string) (err error) {
+	return sm.svc.UpdateUsernames(ctx, chatId, usernames)
+}
+
+func (sm serviceMetrics) WatchChangesLoop() (err error) {
+	return sm.svc.WatchChangesLoop()
+}
+
+func (sm serviceMetrics) RefreshJoined(ctx context.Context) (err error) {
+	t := time.Now()
+	err = sm.svc.RefreshJoined(ctx)
+	result := "ok"
+	if err != nil {
+		result = "fail"
+	}
+	metricRefreshDuration.WithLabelValues(result).Observe(time.Since(t).Seconds())
+	return
+}
+
+func (sm serviceMetrics) Joined(ctx context.Context) (chans []model.JoinedChannel, err error) {
+	return sm.svc.Joined(ctx)
+}
+
+func (sm serviceMetrics) Info(ctx context.Context) (info model.Info, err error) {
+	return sm.svc.Info(ctx)
+}
+
+func (sm serviceMetrics) CleanStale(ctx context.Context, dryRun bool) (report []model.ChannelCleanup, err error) {
+	return sm.svc.CleanStale(ctx, dryRun)
+}
+
+func (sm serviceMetrics) CleanStaleLoop() (err error) {
+	return sm.svc.CleanStaleLoop()
+}
+
+func (sm serviceMetrics) Rebalance(ctx context.Context, limit uint32, dryRun, drain bool) (moves []model.ChannelMove, err error) {
+	return sm.svc.Rebalance(ctx, limit, dryRun, drain)
+}
+
+func (sm serviceMetrics) FloodWaits(ctx context.Context) (waits []model.FloodWait, err error) {
+	return sm.svc.FloodWaits(ctx)
+}
+
+func (sm serviceMetrics) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+	return sm.svc.GetStats(ctx, chanIds, since)
+}
+
+func (sm serviceMetrics) FlushStats(ctx context.Context) (err error) {
+	return sm.svc.FlushStats(ctx)
+}
+
+func (sm serviceMetrics) FlushStatsLoop() (err error) {
+	return sm.svc.FlushStatsLoop()
+}
+
+func (sm serviceMetrics) Export(ctx context.Context, filter model.ChannelFilter, consume func(ch model.Channel) (err error)) (err error) {
+	return sm.svc.Export(ctx, filter, consume)
+}
+
+func (sm serviceMetrics) Import(ctx context.Context, ch model.Channel, conflict model.ImportConflict, dryRun bool, actor model.Actor) (result model.ImportResult, err error) {
+	return sm.svc.Import(ctx, ch, conflict, dryRun, actor)
+}
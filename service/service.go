@@ -5,11 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/api/http/pub"
 	"github.com/awakari/source-telegram/model"
+	"github.com/awakari/source-telegram/pool"
 	"github.com/awakari/source-telegram/storage"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/segmentio/ksuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"log/slog"
-	"strconv"
+	"maps"
+	"math"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,24 +25,68 @@ import (
 type Service interface {
 	Create(ctx context.Context, ch model.Channel) (err error)
 	Read(ctx context.Context, link string) (ch model.Channel, err error)
-	Delete(ctx context.Context, link string) (err error)
+	// Delete removes the channel on behalf of the actor, who is recorded in the audit log. The channel may be restored
+	// until the deletion grace period is over.
+	Delete(ctx context.Context, link string, actor model.Actor) (err error)
+	// Restore brings the deleted channel back on behalf of the actor.
+	Restore(ctx context.Context, link string, actor model.Actor) (ch model.Channel, err error)
 	GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error)
-	SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32) (n uint32, err error)
+	// GetAuditPage returns the channel changes audit records, the latest first.
+	GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error)
+	SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32, groups bool) (n uint32, err error)
 	HandleInterestChange(ctx context.Context, evt *pb.CloudEvent) (err error)
+	UpdateUsernames(ctx context.Context, chatId int64, usernames []string) (err error)
 
-	RefreshJoinedLoop() (err error)
+	// WatchChangesLoop applies the stored channel changes to the hosted accounts as soon as they happen, until the
+	// changes watch fails. Returns storage.ErrWatchUnsupported when the periodic refresh is the only option.
+	WatchChangesLoop() (err error)
+	// RefreshJoined runs a single refresh of the joined channels for every hosted account.
+	RefreshJoined(ctx context.Context) (err error)
+	// Joined returns the runtime state of the channels joined by the hosted accounts.
+	Joined(ctx context.Context) (chans []model.JoinedChannel, err error)
+	// Info returns the TDLib version and the identities of the hosted accounts.
+	Info(ctx context.Context) (info model.Info, err error)
+
+	CleanStale(ctx context.Context, dryRun bool) (report []model.ChannelCleanup, err error)
+	CleanStaleLoop() (err error)
+
+	// Rebalance moves the surplus channels to the least loaded replicas, at most the limit or all if the limit is 0.
+	// The channels assigned to the labels not belonging to any replica are moved only when drain is set.
+	Rebalance(ctx context.Context, limit uint32, dryRun, drain bool) (moves []model.ChannelMove, err error)
+
+	FloodWaits(ctx context.Context) (waits []model.FloodWait, err error)
+
+	// GetStats returns the persisted message counters of the channels by the id with the daily buckets since the given
+	// time, the counters not flushed yet are not included.
+	GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error)
+	// FlushStats persists the channel message counters accumulated in memory since the previous flush.
+	FlushStats(ctx context.Context) (err error)
+	FlushStatsLoop() (err error)
+
+	// Export passes every channel matching the filter with all the fields to the consumer ordered by the link.
+	Export(ctx context.Context, filter model.ChannelFilter, consume func(ch model.Channel) (err error)) (err error)
+	// Import stores the exported channel as is on behalf of the actor, the conflict with the stored channel is resolved
+	// by the policy. Nothing is changed in the dry run mode, the conflict by the id with another link is found by the
+	// actual import only.
+	Import(ctx context.Context, ch model.Channel, conflict model.ImportConflict, dryRun bool, actor model.Actor) (result model.ImportResult, err error)
 }
 
 type service struct {
-	clientTg                  *client.Client
+	accs                      pool.Pool
 	stor                      storage.Storage
-	chansJoined               map[int64]*model.Channel
-	chansJoinedLock           *sync.Mutex
 	log                       *slog.Logger
-	replicaIndex              int
 	botUserId                 int64
-	refreshJoinedInterval     time.Duration
 	searchChanMembersCountMin int32
+	svcPub                    pub.Service
+	stalePeriod               time.Duration
+	staleGrace                time.Duration
+	staleInterval             time.Duration
+	orphansLeaveLimit         uint32
+	orphansRatioMax           float64
+	replicaCount              int
+	replicaJoinLimit          uint32
+	statsFlushInterval        time.Duration
+	refreshLock               *sync.Mutex
 }
 
 const ListLimit = 1_000
@@ -45,36 +96,60 @@ const ceKeyGroupId = "awakarigroupid"
 const ceKeyPublic = "public"
 const ceKeyQueriesBasic = "queriesbasic"
 const ceKeyDescription = "description"
+const ceKeyTime = "time"
+const ceSpecVersion = "1.0"
+const ceTypeStale = "com_awakari_source_telegram_stale_v1"
+
+var linkPrefixes = []string{
+	"https://t.me/",
+	"http://t.me/",
+	"t.me/",
+	"@",
+}
 
 var ErrNoBot = fmt.Errorf("chat/message contains the %s tag", TagNoBot)
+var ErrReplicasFull = errors.New("all replicas reached the joined channels limit")
+var ErrImportInvalid = errors.New("invalid channel to import")
 
 func NewService(
-	clientTg *client.Client,
+	accs pool.Pool,
 	stor storage.Storage,
-	chansJoined map[int64]*model.Channel,
-	chansJoinedLock *sync.Mutex,
 	log *slog.Logger,
-	replicaIndex int,
 	botUserId int64,
-	refreshJoinedInterval time.Duration,
 	searchChanMembersCountMin int32,
+	svcPub pub.Service,
+	stalePeriod time.Duration,
+	staleGrace time.Duration,
+	staleInterval time.Duration,
+	orphansLeaveLimit uint32,
+	orphansRatioMax float64,
+	replicaCount int,
+	replicaJoinLimit uint32,
+	statsFlushInterval time.Duration,
 ) Service {
 	return service{
-		clientTg:                  clientTg,
+		accs:                      accs,
 		stor:                      stor,
-		chansJoined:               chansJoined,
-		chansJoinedLock:           chansJoinedLock,
 		log:                       log,
-		replicaIndex:              replicaIndex,
 		botUserId:                 botUserId,
-		refreshJoinedInterval:     refreshJoinedInterval,
 		searchChanMembersCountMin: searchChanMembersCountMin,
+		svcPub:                    svcPub,
+		stalePeriod:               stalePeriod,
+		staleGrace:                staleGrace,
+		staleInterval:             staleInterval,
+		orphansLeaveLimit:         orphansLeaveLimit,
+		orphansRatioMax:           orphansRatioMax,
+		replicaCount:              replicaCount,
+		replicaJoinLimit:          replicaJoinLimit,
+		statsFlushInterval:        statsFlushInterval,
+		refreshLock:               &sync.Mutex{},
 	}
 }
 
 func (svc service) Create(ctx context.Context, ch model.Channel) (err error) {
+	acc := svc.accs.Default()
 	var newChat *client.Chat
-	newChat, err = svc.clientTg.SearchPublicChat(&client.SearchPublicChatRequest{
+	newChat, err = acc.Client.SearchPublicChat(&client.SearchPublicChatRequest{
 		Username: ch.Link,
 	})
 	if err == nil {
@@ -84,15 +159,21 @@ func (svc service) Create(ctx context.Context, ch model.Channel) (err error) {
 		if ch.Name != newChat.Title {
 			ch.Name = newChat.Title
 		}
-		if svc.chatContainsNoBotTag(ch.Id) {
+		if svc.chatContainsNoBotTag(acc, ch.Id) {
 			err = fmt.Errorf("%w: %+v", ErrNoBot, ch)
 		}
 	}
+	if err == nil && ch.Label == "" {
+		ch.Label, err = svc.assignLabel(ctx)
+	}
 	if err == nil {
 		ch.Created = time.Now().UTC()
 		ch.Last = ch.Created
 		err = svc.stor.Create(ctx, ch)
 	}
+	if err == nil {
+		svc.audit(ctx, model.ChannelChangeCreate, ch, model.Actor{GroupId: ch.GroupId, UserId: ch.UserId}, "")
+	}
 	return
 }
 
@@ -101,98 +182,629 @@ func (svc service) Read(ctx context.Context, link string) (ch model.Channel, err
 	return
 }
 
-func (svc service) Delete(ctx context.Context, link string) (err error) {
-	err = svc.stor.Delete(ctx, link)
+func (svc service) Delete(ctx context.Context, link string, actor model.Actor) (err error) {
+	// the channel id and the owner are audited too
+	ch, readErr := svc.stor.Read(ctx, link)
+	if readErr != nil {
+		ch = model.Channel{
+			Link: link,
+		}
+	}
+	err = svc.delete(ctx, ch, actor, "")
+	return
+}
+
+func (svc service) delete(ctx context.Context, ch model.Channel, actor model.Actor, reason string) (err error) {
+	err = svc.stor.Delete(ctx, ch.Link, reason)
+	if err == nil {
+		svc.audit(ctx, model.ChannelChangeDelete, ch, actor, reason)
+	}
+	return
+}
+
+func (svc service) Restore(ctx context.Context, link string, actor model.Actor) (ch model.Channel, err error) {
+	ch, err = svc.stor.Restore(ctx, link)
+	if err == nil {
+		svc.audit(ctx, model.ChannelChangeCreate, ch, actor, "restored")
+	}
 	return
 }
 
+// audit records the channel change made already, so the failure to record is not the failure of the change.
+func (svc service) audit(ctx context.Context, action model.ChannelChangeType, ch model.Channel, actor model.Actor, reason string) {
+	err := svc.stor.AppendAudit(ctx, model.AuditRecord{
+		Action:    action,
+		ChannelId: ch.Id,
+		Link:      ch.Link,
+		GroupId:   ch.GroupId,
+		UserId:    ch.UserId,
+		Actor:     actor,
+		Reason:    reason,
+	})
+	if err != nil {
+		svc.log.Warn(fmt.Sprintf("Failed to record the channel %s change audit (%s), cause: %s", ch.Link, action, err))
+	}
+}
+
 func (svc service) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
 	page, err = svc.stor.GetPage(ctx, filter, limit, cursor, order)
 	return
 }
 
-func (svc service) RefreshJoinedLoop() (err error) {
+func (svc service) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+	page, err = svc.stor.GetAuditPage(ctx, filter, limit, cursor)
+	return
+}
+
+// RefreshJoinedLoop runs the joined channels refresh periodically until failed. The decorated service is expected, so
+// every run is observed.
+func RefreshJoinedLoop(svc Service, interval time.Duration) (err error) {
 	ctx := context.TODO()
 	for err == nil {
-		err = svc.refreshJoined(ctx)
+		err = svc.RefreshJoined(ctx)
 		if err == nil {
-			time.Sleep(svc.refreshJoinedInterval)
+			time.Sleep(interval)
+		}
+	}
+	return
+}
+
+func (svc service) RefreshJoined(ctx context.Context) (err error) {
+	// the loop and the forced runs should not join the same channels concurrently
+	svc.refreshLock.Lock()
+	defer svc.refreshLock.Unlock()
+	for _, acc := range svc.accs.Accounts() {
+		err = errors.Join(err, svc.refreshJoined(ctx, acc))
+	}
+	return
+}
+
+func (svc service) Joined(ctx context.Context) (chans []model.JoinedChannel, err error) {
+	for _, acc := range svc.accs.Accounts() {
+		chans = append(chans, joined(acc)...)
+	}
+	return
+}
+
+func joined(acc *pool.Account) (chans []model.JoinedChannel) {
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	byChan := map[*model.Channel]int{}
+	for chatId, ch := range acc.ChansJoined {
+		i, found := byChan[ch]
+		if !found {
+			i = len(chans)
+			byChan[ch] = i
+			jc := model.JoinedChannel{
+				Account: acc.Index,
+				Channel: *ch,
+			}
+			if stats := acc.ChansStats[ch.Id]; stats != nil {
+				jc.Stats = *stats
+				// updated by the message handler concurrently
+				jc.Stats.Dropped = maps.Clone(stats.Dropped)
+			}
+			chans = append(chans, jc)
+		}
+		chans[i].ChatIds = append(chans[i].ChatIds, chatId)
+	}
+	for _, jc := range chans {
+		// the channel itself first
+		sort.Slice(jc.ChatIds, func(i, j int) bool {
+			return jc.ChatIds[i] == jc.Channel.Id || (jc.ChatIds[j] != jc.Channel.Id && jc.ChatIds[i] < jc.ChatIds[j])
+		})
+	}
+	sort.Slice(chans, func(i, j int) bool {
+		return chans[i].Channel.Link < chans[j].Channel.Link
+	})
+	return
+}
+
+func (svc service) Info(ctx context.Context) (info model.Info, err error) {
+	for _, acc := range svc.accs.Accounts() {
+		if info.TdlibVersion == "" {
+			var optVal client.OptionValue
+			optVal, err = acc.Client.GetOption(&client.GetOptionRequest{
+				Name: "version",
+			})
+			if err != nil {
+				break
+			}
+			if optValStr, isStr := optVal.(*client.OptionValueString); isStr {
+				info.TdlibVersion = optValStr.Value
+			}
+		}
+		var me *client.User
+		me, err = acc.Client.GetMe()
+		if err != nil {
+			err = fmt.Errorf("account %d: %w", acc.Index, err)
+			break
+		}
+		accInfo := model.AccountInfo{
+			Index:     acc.Index,
+			UserId:    me.Id,
+			FirstName: me.FirstName,
+			LastName:  me.LastName,
+			Phone:     me.PhoneNumber,
+		}
+		if me.Usernames != nil {
+			accInfo.Usernames = me.Usernames.ActiveUsernames
 		}
+		info.Accounts = append(info.Accounts, accInfo)
 	}
 	return
 }
 
-func (svc service) refreshJoined(ctx context.Context) (err error) {
-	svc.log.Debug("Refresh joined channels started")
-	defer svc.log.Debug("Refresh joined channels finished")
+func (svc service) refreshJoined(ctx context.Context, acc *pool.Account) (err error) {
+	svc.log.Debug(fmt.Sprintf("Refresh joined channels started, account %d", acc.Index))
+	defer svc.log.Debug(fmt.Sprintf("Refresh joined channels finished, account %d", acc.Index))
 	// get all previously joined by the client chats
 	var chatsJoined *client.Chats
-	chatsJoined, err = svc.clientTg.GetChats(&client.GetChatsRequest{Limit: ListLimit})
+	chatsJoined, err = acc.Client.GetChats(&client.GetChatsRequest{Limit: ListLimit})
 	var chans []model.Channel
 	if err == nil {
 		svc.log.Debug(fmt.Sprintf("Refresh joined channels: got %d from the client", len(chatsJoined.ChatIds)))
-		//
-		var chanFilter model.ChannelFilter
-		var lbl string
-		if svc.replicaIndex > 0 {
-			lbl = strconv.Itoa(svc.replicaIndex)
-		}
-		chanFilter.Label = &lbl
-		chans, err = svc.stor.GetPage(ctx, chanFilter, ListLimit, "", model.OrderAsc) // it's important to get all at once
+		seen := map[int64]bool{}
+		err = svc.stor.Iterate(ctx, labelFilter(acc.Label()), ListLimit, func(ch model.Channel) (err error) {
+			// the channel renamed meanwhile may be met again by the new link
+			if !seen[ch.Id] {
+				seen[ch.Id] = true
+				svc.joinChannel(ctx, acc, ch, chatsJoined.ChatIds)
+				chans = append(chans, ch)
+			}
+			return
+		})
 	}
 	if err == nil {
+		// the orphans are known only when all stored channels are
 		svc.log.Debug(fmt.Sprintf("Refresh joined channels: got %d from the storage", len(chans)))
-		for _, ch := range chans {
-			var joined bool
-			for _, chatJoinedId := range chatsJoined.ChatIds {
-				if ch.Id == chatJoinedId {
-					joined = true
-					break
+		svc.leaveOrphans(acc, chatsJoined.ChatIds, chans)
+	}
+	return
+}
+
+// joinChannel joins the stored channel unless joined already and puts it into the runtime state.
+func (svc service) joinChannel(ctx context.Context, acc *pool.Account, ch model.Channel, chatIdsJoined []int64) {
+	var err error
+	var joined bool
+	for _, chatJoinedId := range chatIdsJoined {
+		if ch.Id == chatJoinedId {
+			joined = true
+			break
+		}
+	}
+	// resolve by the stable id first, the channel might have been renamed
+	linkErr := svc.refreshLink(ctx, acc, &ch)
+	if linkErr != nil {
+		svc.log.Debug(fmt.Sprintf("Failed to resolve the channel %d link, cause: %s", ch.Id, linkErr))
+	}
+	if !joined {
+		if linkErr != nil {
+			var newChat *client.Chat
+			newChat, err = acc.Client.SearchPublicChat(&client.SearchPublicChatRequest{
+				Username: ch.Link,
+			})
+			svc.log.Debug(fmt.Sprintf("SearchPublicChat(%s): %+v, %s", ch.Name, newChat, err))
+		}
+		_, err = acc.Client.AddRecentlyFoundChat(&client.AddRecentlyFoundChatRequest{
+			ChatId: ch.Id,
+		})
+		svc.log.Debug(fmt.Sprintf("AddRecentlyFoundChat(%d): %s", ch.Id, err))
+		_, err = acc.Client.JoinChat(&client.JoinChatRequest{
+			ChatId: ch.Id,
+		})
+		if err == nil {
+			joined = true
+		}
+	}
+	switch joined {
+	case true:
+		if svc.chatContainsNoBotTag(acc, ch.Id) {
+			svc.log.Debug(fmt.Sprintf("Channel contains the %s tag in the description, removing, id: %d, title: %s, user: %s", TagNoBot, ch.Id, ch.Name, ch.UserId))
+			_ = svc.delete(ctx, ch, model.Actor{System: model.ActorSystemRefresh}, fmt.Sprintf("the channel description contains the %s tag", TagNoBot))
+		} else {
+			svc.log.Debug(fmt.Sprintf("Selected channel id: %d, title: %s, user: %s", ch.Id, ch.Name, ch.UserId))
+			var discussionChatId int64
+			switch ch.Discussion {
+			case true:
+				var discussionErr error
+				discussionChatId, discussionErr = svc.joinDiscussion(acc, ch.Id, chatIdsJoined)
+				if discussionErr != nil {
+					svc.log.Warn(fmt.Sprintf("Failed to join the discussion chat of the channel %d, cause: %s", ch.Id, discussionErr))
 				}
+			default:
+				svc.leaveDiscussion(acc, ch.Id)
 			}
-			if !joined {
-				var newChat *client.Chat
-				newChat, err = svc.clientTg.SearchPublicChat(&client.SearchPublicChatRequest{
-					Username: ch.Link,
-				})
-				svc.log.Debug(fmt.Sprintf("SearchPublicChat(%s): %+v, %s", ch.Name, newChat, err))
-				_, err = svc.clientTg.AddRecentlyFoundChat(&client.AddRecentlyFoundChatRequest{
-					ChatId: ch.Id,
-				})
-				svc.log.Debug(fmt.Sprintf("AddRecentlyFoundChat(%d): %s", ch.Id, err))
-				_, err = svc.clientTg.JoinChat(&client.JoinChatRequest{
-					ChatId: ch.Id,
-				})
-				if err == nil {
-					joined = true
-				}
+			svc.updateJoined(ctx, acc, ch, discussionChatId)
+		}
+	default:
+		svc.log.Warn(fmt.Sprintf("Failed to join channel by id: %d, cause: %s", ch.Id, err))
+	}
+}
+
+func (svc service) WatchChangesLoop() (err error) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	errsLock := &sync.Mutex{}
+	for _, acc := range svc.accs.Accounts() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lbl := acc.Label()
+			errAcc := svc.stor.Watch(ctx, &lbl, func(chg model.ChannelChange) (err error) {
+				svc.handleChange(ctx, acc, chg)
+				return
+			})
+			// the remaining watchers are restarted together with the failed one
+			cancel()
+			if !errors.Is(errAcc, context.Canceled) {
+				errsLock.Lock()
+				defer errsLock.Unlock()
+				err = errors.Join(err, errAcc)
 			}
-			switch joined {
-			case true:
-				if svc.chatContainsNoBotTag(ch.Id) {
-					svc.log.Debug(fmt.Sprintf("Channel contains the %s tag in the description, removing, id: %d, title: %s, user: %s", TagNoBot, ch.Id, ch.Name, ch.UserId))
-					_ = svc.Delete(ctx, ch.Link)
-				} else {
-					svc.log.Debug(fmt.Sprintf("Selected channel id: %d, title: %s, user: %s", ch.Id, ch.Name, ch.UserId))
-					svc.updateJoined(ctx, ch)
-				}
+		}()
+	}
+	wg.Wait()
+	return
+}
+
+// handleChange brings the account runtime state in line with the changed channel. The refresh lock is shared with the
+// periodic refresh, so the same channel is not joined concurrently.
+func (svc service) handleChange(ctx context.Context, acc *pool.Account, chg model.ChannelChange) {
+	svc.refreshLock.Lock()
+	defer svc.refreshLock.Unlock()
+	switch {
+	case chg.Channel != nil && chg.Channel.Label == acc.Label():
+		if !svc.joinedUnchanged(acc, *chg.Channel) {
+			chatsJoined, err := acc.Client.GetChats(&client.GetChatsRequest{Limit: ListLimit})
+			switch err {
+			case nil:
+				svc.joinChannel(ctx, acc, *chg.Channel, chatsJoined.ChatIds)
 			default:
-				svc.log.Warn(fmt.Sprintf("Failed to join channel by id: %d, cause: %s", ch.Id, err))
-				err = nil
+				svc.log.Warn(fmt.Sprintf("Failed to get the joined chats, account %d, cause: %s", acc.Index, err))
+			}
+		}
+	case chg.Channel != nil:
+		// moved to another label
+		svc.leaveJoined(acc, chg.Channel.Id)
+	case chg.Before != nil:
+		svc.leaveJoined(acc, chg.Before.Id)
+	default:
+		// deleted, but it's unknown which one
+		err := svc.refreshJoined(ctx, acc)
+		if err != nil {
+			svc.log.Warn(fmt.Sprintf("Failed to refresh joined channels, account %d, cause: %s", acc.Index, err))
+		}
+	}
+}
+
+// joinedUnchanged returns true if the channel is joined already and the change doesn't affect the runtime state, e.g.
+// only the last post time is updated.
+func (svc service) joinedUnchanged(acc *pool.Account, ch model.Channel) (unchanged bool) {
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	chRuntime := acc.ChansJoined[ch.Id]
+	unchanged = chRuntime != nil && chRuntime.Link == ch.Link && chRuntime.Discussion == ch.Discussion
+	return
+}
+
+// leaveJoined removes the channel from the runtime state and leaves its chats unless administered by the account.
+func (svc service) leaveJoined(acc *pool.Account, chId int64) {
+	acc.ChansJoinedLock.Lock()
+	joined := acc.ChansJoined[chId] != nil
+	acc.ChansJoinedLock.Unlock()
+	if !joined {
+		return
+	}
+	for _, chatId := range svc.forgetJoined(acc, chId) {
+		if svc.isForeignChannel(acc, chatId) {
+			_, err := acc.Client.LeaveChat(&client.LeaveChatRequest{
+				ChatId: chatId,
+			})
+			if err != nil {
+				svc.log.Warn(fmt.Sprintf("Failed to leave the chat %d, cause: %s", chatId, err))
+			}
+		}
+	}
+}
+
+// leaveOrphans leaves the joined chats which are not in the storage anymore, e.g. deleted or expired.
+func (svc service) leaveOrphans(acc *pool.Account, chatIdsJoined []int64, chans []model.Channel) {
+	stored := map[int64]bool{}
+	for _, ch := range chans {
+		stored[ch.Id] = true
+	}
+	acc.ChansJoinedLock.Lock()
+	for chatId, ch := range acc.ChansJoined {
+		switch stored[ch.Id] {
+		case true:
+			stored[chatId] = true // linked discussion chat
+		default:
+			delete(acc.ChansJoined, chatId)
+			delete(acc.Discussions, ch.Id)
+		}
+	}
+	acc.ChansJoinedLock.Unlock()
+	var orphans []int64
+	for _, chatId := range chatIdsJoined {
+		if !stored[chatId] && svc.isForeignChannel(acc, chatId) {
+			orphans = append(orphans, chatId)
+		}
+	}
+	var left, failed int
+	switch {
+	case len(orphans) == 0:
+	case float64(len(orphans)) > svc.orphansRatioMax*float64(len(chatIdsJoined)):
+		svc.log.Warn(fmt.Sprintf("Refresh joined channels: %d orphan chats of %d joined exceed the ratio %f, not leaving any", len(orphans), len(chatIdsJoined), svc.orphansRatioMax))
+	default:
+		for _, chatId := range orphans {
+			if uint32(left+failed) >= svc.orphansLeaveLimit {
+				break
+			}
+			_, err := acc.Client.LeaveChat(&client.LeaveChatRequest{
+				ChatId: chatId,
+			})
+			switch err {
+			case nil:
+				left++
+			default:
+				failed++
+				svc.log.Warn(fmt.Sprintf("Failed to leave the orphan chat %d, cause: %s", chatId, err))
+			}
+		}
+	}
+	svc.log.Info(fmt.Sprintf("Refresh joined channels: orphan chats %d, left %d, failed %d", len(orphans), left, failed))
+}
+
+// isForeignChannel returns true for the joined public channels and supergroups not administered by the account.
+func (svc service) isForeignChannel(acc *pool.Account, chatId int64) (foreign bool) {
+	chat, err := acc.Client.GetChat(&client.GetChatRequest{
+		ChatId: chatId,
+	})
+	var sg *client.Supergroup
+	if err == nil && chat.Type.ChatTypeType() == client.TypeChatTypeSupergroup {
+		sg, err = acc.Client.GetSupergroup(&client.GetSupergroupRequest{
+			SupergroupId: chat.Type.(*client.ChatTypeSupergroup).SupergroupId,
+		})
+	}
+	if err == nil && sg != nil && sg.Status != nil {
+		switch sg.Status.ChatMemberStatusType() {
+		case client.TypeChatMemberStatusMember, client.TypeChatMemberStatusRestricted:
+			foreign = true
+		}
+	}
+	return
+}
+
+func labelFilter(lbl string) (filter model.ChannelFilter) {
+	filter.Label = &lbl
+	return
+}
+
+func (svc service) assignLabel(ctx context.Context) (lbl string, err error) {
+	var counts map[string]int64
+	counts, err = svc.stor.CountByLabel(ctx)
+	if err == nil {
+		lbl, err = svc.leastLoaded(counts, nil)
+	}
+	return
+}
+
+// leastLoaded returns the label of the replica having the least count of channels assigned and not reached the
+// joined channels limit.
+func (svc service) leastLoaded(counts map[string]int64, exclude *string) (lbl string, err error) {
+	var found bool
+	var countMin int64
+	for i := 0; i < svc.replicaCount; i++ {
+		l := pool.Label(i)
+		if exclude != nil && *exclude == l {
+			continue
+		}
+		c := counts[l]
+		if c < int64(svc.replicaJoinLimit) && (!found || c < countMin) {
+			found = true
+			countMin = c
+			lbl = l
+		}
+	}
+	if !found {
+		err = ErrReplicasFull
+	}
+	return
+}
+
+func (svc service) FloodWaits(ctx context.Context) (waits []model.FloodWait, err error) {
+	for _, acc := range svc.accs.Accounts() {
+		for class, until := range acc.Client.FloodWaits() {
+			waits = append(waits, model.FloodWait{
+				Account: acc.Index,
+				Class:   string(class),
+				Until:   until,
+			})
+		}
+	}
+	sort.Slice(waits, func(i, j int) bool {
+		if waits[i].Account != waits[j].Account {
+			return waits[i].Account < waits[j].Account
+		}
+		return waits[i].Class < waits[j].Class
+	})
+	return
+}
+
+func (svc service) Rebalance(ctx context.Context, limit uint32, dryRun, drain bool) (moves []model.ChannelMove, err error) {
+	if limit == 0 {
+		limit = math.MaxUint32
+	}
+	var counts map[string]int64
+	counts, err = svc.stor.CountByLabel(ctx)
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	var target int64
+	if svc.replicaCount > 0 {
+		target = (total + int64(svc.replicaCount) - 1) / int64(svc.replicaCount)
+	}
+	if target > int64(svc.replicaJoinLimit) {
+		target = int64(svc.replicaJoinLimit)
+	}
+	lbls := make([]string, 0, len(counts))
+	for l := range counts {
+		lbls = append(lbls, l)
+	}
+	sort.Strings(lbls)
+	for _, l := range lbls {
+		if err != nil || uint32(len(moves)) >= limit {
+			break
+		}
+		surplus := counts[l]
+		switch {
+		case svc.isReplicaLabel(l):
+			surplus -= target
+		case !drain:
+			continue // unknown replica, possibly a config mismatch
+		}
+		if surplus <= 0 {
+			continue
+		}
+		if surplus > int64(limit)-int64(len(moves)) {
+			surplus = int64(limit) - int64(len(moves))
+		}
+		var chans []model.Channel
+		chans, err = svc.stor.GetPage(ctx, model.ChannelFilter{Label: &l}, uint32(surplus), "", model.OrderAsc)
+		for _, ch := range chans {
+			lblTo, lblErr := svc.leastLoaded(counts, &l)
+			if lblErr != nil || counts[lblTo] >= target {
+				break // nowhere to move
+			}
+			moves = append(moves, model.ChannelMove{
+				Channel:   ch,
+				LabelFrom: l,
+				LabelTo:   lblTo,
+			})
+			counts[l]--
+			counts[lblTo]++
+		}
+	}
+	if err == nil && !dryRun {
+		for _, m := range moves {
+			err = errors.Join(err, svc.move(ctx, m))
+		}
+	}
+	return
+}
+
+func (svc service) isReplicaLabel(lbl string) (ok bool) {
+	for i := 0; i < svc.replicaCount; i++ {
+		if pool.Label(i) == lbl {
+			ok = true
+			break
+		}
+	}
+	return
+}
+
+// move reassigns the channel, the target account joins it on the next refresh. The source account leaves it either
+// immediately if it's hosted by this process or on the next refresh otherwise as an orphan chat.
+func (svc service) move(ctx context.Context, m model.ChannelMove) (err error) {
+	err = svc.stor.UpdateLabel(ctx, m.Channel.Link, m.LabelTo)
+	if err == nil {
+		svc.audit(ctx, model.ChannelChangeUpdate, m.Channel, model.Actor{System: model.ActorSystemRebalance}, fmt.Sprintf("moved from the label \"%s\" to \"%s\"", m.LabelFrom, m.LabelTo))
+	}
+	acc := svc.accs.Account(m.LabelFrom)
+	if err == nil && acc != nil {
+		for _, chatId := range svc.forgetJoined(acc, m.Channel.Id) {
+			_, leaveErr := acc.Client.LeaveChat(&client.LeaveChatRequest{
+				ChatId: chatId,
+			})
+			if leaveErr != nil {
+				svc.log.Warn(fmt.Sprintf("Failed to leave the moved channel chat %d, cause: %s", chatId, leaveErr))
 			}
 		}
 	}
 	return
 }
 
-func (svc service) updateJoined(ctx context.Context, ch model.Channel) {
-	svc.chansJoinedLock.Lock()
-	defer svc.chansJoinedLock.Unlock()
-	chRuntime := svc.chansJoined[ch.Id]
+// joinDiscussion joins the discussion chat linked to the channel unless joined already. The linked chat is resolved once
+// while the channel is joined, then the cached one is used.
+func (svc service) joinDiscussion(acc *pool.Account, chId int64, chatsJoinedIds []int64) (discussionChatId int64, err error) {
+	acc.ChansJoinedLock.Lock()
+	discussionChatId, resolved := acc.Discussions[chId]
+	acc.ChansJoinedLock.Unlock()
+	if !resolved {
+		discussionChatId, err = svc.resolveDiscussion(acc, chId)
+		if err == nil {
+			acc.ChansJoinedLock.Lock()
+			acc.Discussions[chId] = discussionChatId
+			acc.ChansJoinedLock.Unlock()
+		}
+	}
+	if err == nil && discussionChatId != 0 && !slices.Contains(chatsJoinedIds, discussionChatId) {
+		_, err = acc.Client.JoinChat(&client.JoinChatRequest{
+			ChatId: discussionChatId,
+		})
+		if err != nil {
+			discussionChatId = 0
+		}
+	}
+	return
+}
+
+// resolveDiscussion returns the id of the discussion chat linked to the channel, 0 if none.
+func (svc service) resolveDiscussion(acc *pool.Account, chId int64) (discussionChatId int64, err error) {
+	var chat *client.Chat
+	chat, err = acc.Client.GetChat(&client.GetChatRequest{
+		ChatId: chId,
+	})
+	var sgChat *client.ChatTypeSupergroup
+	if err == nil && chat.Type.ChatTypeType() == client.TypeChatTypeSupergroup {
+		sgChat = chat.Type.(*client.ChatTypeSupergroup)
+	}
+	var info *client.SupergroupFullInfo
+	if err == nil && sgChat != nil && sgChat.IsChannel {
+		info, err = acc.Client.GetSupergroupFullInfo(&client.GetSupergroupFullInfoRequest{
+			SupergroupId: sgChat.SupergroupId,
+		})
+	}
+	if err == nil && info != nil {
+		discussionChatId = info.LinkedChatId
+	}
+	return
+}
+
+// leaveDiscussion forgets the discussion chat linked to the channel with the comments disabled and leaves it unless
+// administered by the account.
+func (svc service) leaveDiscussion(acc *pool.Account, chId int64) {
+	acc.ChansJoinedLock.Lock()
+	discussionChatId := acc.Discussions[chId]
+	delete(acc.Discussions, chId)
+	if discussionChatId != 0 {
+		delete(acc.ChansJoined, discussionChatId)
+	}
+	acc.ChansJoinedLock.Unlock()
+	if discussionChatId != 0 && svc.isForeignChannel(acc, discussionChatId) {
+		_, err := acc.Client.LeaveChat(&client.LeaveChatRequest{
+			ChatId: discussionChatId,
+		})
+		if err != nil {
+			svc.log.Warn(fmt.Sprintf("Failed to leave the discussion chat %d of the channel %d, cause: %s", discussionChatId, chId, err))
+		}
+	}
+}
+
+func (svc service) updateJoined(ctx context.Context, acc *pool.Account, ch model.Channel, discussionChatId int64) {
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	chRuntime := acc.ChansJoined[ch.Id]
 	switch chRuntime {
 	case nil:
-		svc.chansJoined[ch.Id] = &ch
+		chRuntime = &ch
+		acc.ChansJoined[ch.Id] = chRuntime
 	default:
+		chRuntime.Link = ch.Link
+		chRuntime.Discussion = ch.Discussion
 		if chRuntime.Last.After(ch.Last) {
 			err := svc.stor.Update(ctx, ch.Link, chRuntime.Last)
 			if err != nil {
@@ -200,14 +812,247 @@ func (svc service) updateJoined(ctx context.Context, ch model.Channel) {
 			}
 		}
 	}
+	if discussionChatId != 0 {
+		// discussion messages are attributed to the parent channel
+		acc.ChansJoined[discussionChatId] = chRuntime
+	}
 	return
 }
 
-func (svc service) SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32) (n uint32, err error) {
-	var chats *client.Chats
-	chats, err = svc.clientTg.SearchPublicChats(&client.SearchPublicChatsRequest{
-		Query: terms,
+func (svc service) UpdateUsernames(ctx context.Context, chatId int64, usernames []string) (err error) {
+	for _, acc := range svc.accs.Accounts() {
+		err = errors.Join(err, svc.updateUsernames(ctx, acc, chatId, usernames))
+	}
+	return
+}
+
+func (svc service) updateUsernames(ctx context.Context, acc *pool.Account, chatId int64, usernames []string) (err error) {
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	ch := acc.ChansJoined[chatId]
+	if ch != nil && ch.Id == chatId {
+		err = svc.updateLink(ctx, ch, usernames)
+	}
+	return
+}
+
+func (svc service) refreshLink(ctx context.Context, acc *pool.Account, ch *model.Channel) (err error) {
+	var chat *client.Chat
+	chat, err = acc.Client.GetChat(&client.GetChatRequest{
+		ChatId: ch.Id,
+	})
+	var sg *client.Supergroup
+	if err == nil && chat.Type.ChatTypeType() == client.TypeChatTypeSupergroup {
+		sg, err = acc.Client.GetSupergroup(&client.GetSupergroupRequest{
+			SupergroupId: chat.Type.(*client.ChatTypeSupergroup).SupergroupId,
+		})
+	}
+	if err == nil && sg != nil && sg.Usernames != nil {
+		err = svc.updateLink(ctx, ch, sg.Usernames.ActiveUsernames)
+	}
+	return
+}
+
+func (svc service) updateLink(ctx context.Context, ch *model.Channel, usernames []string) (err error) {
+	link := renameLink(ch.Link, usernames)
+	if link != ch.Link {
+		var linkOld string
+		linkOld, err = svc.stor.UpdateLink(ctx, ch.Id, link)
+		if err == nil {
+			svc.log.Info(fmt.Sprintf("Channel %d renamed: %s -> %s", ch.Id, linkOld, link))
+			ch.Link = link
+			svc.audit(ctx, model.ChannelChangeUpdate, *ch, model.Actor{System: model.ActorSystemRename}, "renamed from "+linkOld)
+		}
+	}
+	return
+}
+
+// renameLink returns the link with the username replaced by the primary one unless the link already refers to any of
+// the active usernames. Links without a username (e.g. private "https://t.me/c/...") are returned as is.
+func renameLink(link string, usernames []string) (linkNew string) {
+	linkNew = link
+	prefix := ""
+	for _, p := range linkPrefixes {
+		if strings.HasPrefix(link, p) {
+			prefix = p
+			break
+		}
+	}
+	username := strings.TrimPrefix(link, prefix)
+	if len(usernames) == 0 || username == "" || strings.ContainsAny(username, "/?") {
+		return
+	}
+	for _, u := range usernames {
+		if strings.EqualFold(u, username) {
+			return
+		}
+	}
+	linkNew = prefix + usernames[0]
+	return
+}
+
+func (svc service) CleanStaleLoop() (err error) {
+	ctx := context.TODO()
+	for err == nil {
+		_, err = svc.CleanStale(ctx, false)
+		if err == nil {
+			time.Sleep(svc.staleInterval)
+		}
+	}
+	return
+}
+
+func (svc service) CleanStale(ctx context.Context, dryRun bool) (report []model.ChannelCleanup, err error) {
+	for _, acc := range svc.accs.Accounts() {
+		var accReport []model.ChannelCleanup
+		accReport, err = svc.cleanStale(ctx, acc, dryRun)
+		report = append(report, accReport...)
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (svc service) cleanStale(ctx context.Context, acc *pool.Account, dryRun bool) (report []model.ChannelCleanup, err error) {
+	now := time.Now().UTC()
+	err = svc.stor.Iterate(ctx, labelFilter(acc.Label()), ListLimit, func(ch model.Channel) (err error) {
+		last := svc.lastRuntime(acc, ch)
+		if last.IsZero() {
+			return // neither posted nor created time is known
+		}
+		cleanup := model.ChannelCleanup{
+			Channel: ch,
+		}
+		switch {
+		case now.Sub(last) < svc.stalePeriod:
+			if ch.Stale.IsZero() {
+				return
+			}
+			cleanup.Action = model.CleanupActionUnflag
+		case ch.Stale.IsZero():
+			cleanup.Action = model.CleanupActionFlag
+		case now.Sub(ch.Stale) >= svc.staleGrace:
+			cleanup.Action = model.CleanupActionRemove
+		default:
+			return // flagged, still in the grace period
+		}
+		report = append(report, cleanup)
+		if !dryRun {
+			cleanupErr := svc.cleanup(ctx, acc, ch, cleanup.Action, now)
+			if cleanupErr != nil {
+				svc.log.Warn(fmt.Sprintf("Failed to %s the stale channel %s, cause: %s", cleanup.Action, ch.Link, cleanupErr))
+			}
+		}
+		return
 	})
+	return
+}
+
+// lastRuntime returns the latest post time known, the storage is updated with the runtime value periodically only.
+// Falls back to the creation time when no post is known, e.g. for the imported channel.
+func (svc service) lastRuntime(acc *pool.Account, ch model.Channel) (last time.Time) {
+	last = ch.Last
+	if last.IsZero() {
+		last = ch.Created
+	}
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	chRuntime := acc.ChansJoined[ch.Id]
+	if chRuntime != nil && chRuntime.Last.After(last) {
+		last = chRuntime.Last
+	}
+	return
+}
+
+func (svc service) cleanup(ctx context.Context, acc *pool.Account, ch model.Channel, action model.CleanupAction, now time.Time) (err error) {
+	actor := model.Actor{
+		System: model.ActorSystemStale,
+	}
+	switch action {
+	case model.CleanupActionFlag:
+		err = svc.stor.SetStale(ctx, ch.Link, now)
+		if err == nil {
+			svc.audit(ctx, model.ChannelChangeUpdate, ch, actor, fmt.Sprintf("flagged stale, no posts since %s", ch.Last.Format(time.DateOnly)))
+			err = svc.notifyStale(ctx, ch, now.Add(svc.staleGrace))
+		}
+	case model.CleanupActionUnflag:
+		err = svc.stor.SetStale(ctx, ch.Link, time.Time{})
+		if err == nil {
+			svc.audit(ctx, model.ChannelChangeUpdate, ch, actor, "unflagged stale, active again")
+		}
+	case model.CleanupActionRemove:
+		for _, chatId := range svc.forgetJoined(acc, ch.Id) {
+			_, leaveErr := acc.Client.LeaveChat(&client.LeaveChatRequest{
+				ChatId: chatId,
+			})
+			err = errors.Join(err, leaveErr)
+		}
+		reason := fmt.Sprintf("flagged stale since %s, the grace period is over", ch.Stale.Format(time.DateOnly))
+		err = errors.Join(err, svc.delete(ctx, ch, actor, reason))
+	}
+	return
+}
+
+// forgetJoined removes the channel from the runtime state, returns the ids of the chats to leave including the linked
+// discussion chat if any.
+func (svc service) forgetJoined(acc *pool.Account, chId int64) (chatIds []int64) {
+	chatIds = append(chatIds, chId)
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	chRuntime := acc.ChansJoined[chId]
+	if chRuntime != nil {
+		for chatId, c := range acc.ChansJoined {
+			if c == chRuntime && chatId != chId {
+				chatIds = append(chatIds, chatId)
+			}
+		}
+	}
+	for _, chatId := range chatIds {
+		delete(acc.ChansJoined, chatId)
+	}
+	delete(acc.ChansStats, chId)
+	delete(acc.Discussions, chId)
+	return
+}
+
+func (svc service) notifyStale(ctx context.Context, ch model.Channel, removeAfter time.Time) (err error) {
+	if ch.UserId == "" {
+		return // nobody to notify
+	}
+	evt := &pb.CloudEvent{
+		Id:          ksuid.New().String(),
+		Source:      ch.Link,
+		SpecVersion: ceSpecVersion,
+		Type:        ceTypeStale,
+		Attributes: map[string]*pb.CloudEventAttributeValue{
+			ceKeyTime: {
+				Attr: &pb.CloudEventAttributeValue_CeTimestamp{
+					CeTimestamp: timestamppb.Now(),
+				},
+			},
+		},
+		Data: &pb.CloudEvent_TextData{
+			TextData: fmt.Sprintf(
+				"Telegram channel %s has no new posts since %s and will be removed after %s unless it becomes active again",
+				ch.Link, ch.Last.Format(time.DateOnly), removeAfter.Format(time.DateOnly),
+			),
+		},
+	}
+	err = svc.svcPub.Publish(ctx, evt, ch.GroupId, ch.UserId)
+	return
+}
+
+func (svc service) SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32, groups bool) (n uint32, err error) {
+	acc := svc.accs.Default()
+	var counts map[string]int64
+	counts, err = svc.stor.CountByLabel(ctx)
+	var chats *client.Chats
+	if err == nil {
+		chats, err = acc.Client.SearchPublicChats(&client.SearchPublicChatsRequest{
+			Query: terms,
+		})
+	}
 	if err == nil && chats != nil {
 		for i, chatId := range chats.ChatIds {
 			if i >= int(limit) {
@@ -215,18 +1060,18 @@ func (svc service) SearchAndAdd(ctx context.Context, groupId, subId, terms strin
 			}
 			var chat *client.Chat
 			var chatErr error
-			chat, chatErr = svc.clientTg.GetChat(&client.GetChatRequest{
+			chat, chatErr = acc.Client.GetChat(&client.GetChatRequest{
 				ChatId: chatId,
 			})
 			var sg *client.Supergroup
 			if chatErr == nil && chat.Type.ChatTypeType() == client.TypeChatTypeSupergroup {
 				sgChat := chat.Type.(*client.ChatTypeSupergroup)
-				if svc.supergroupContainsNoBotTag(chatId, sgChat.SupergroupId) {
+				if svc.supergroupContainsNoBotTag(acc, chatId, sgChat.SupergroupId) {
 					err = errors.Join(err, fmt.Errorf("%w: \"%s\"", ErrNoBot, chat.Title))
 					continue
 				}
-				if sgChat.IsChannel {
-					sg, chatErr = svc.clientTg.GetSupergroup(&client.GetSupergroupRequest{
+				if sgChat.IsChannel || groups {
+					sg, chatErr = acc.Client.GetSupergroup(&client.GetSupergroupRequest{
 						SupergroupId: sgChat.SupergroupId,
 					})
 				} else {
@@ -239,8 +1084,10 @@ func (svc service) SearchAndAdd(ctx context.Context, groupId, subId, terms strin
 					name = sg.Usernames.ActiveUsernames[0]
 				}
 				if name != "" && sg.MemberCount > svc.searchChanMembersCountMin {
+					var lbl string
+					lbl, chatErr = svc.leastLoaded(counts, nil)
 					now := time.Now().UTC()
-					chatErr = svc.stor.Create(ctx, model.Channel{
+					ch := model.Channel{
 						Id:      chatId,
 						GroupId: groupId,
 						Name:    name,
@@ -249,7 +1096,15 @@ func (svc service) SearchAndAdd(ctx context.Context, groupId, subId, terms strin
 						Terms:   terms,
 						Last:    now,
 						Created: now,
-					})
+						Label:   lbl,
+					}
+					if chatErr == nil {
+						chatErr = svc.stor.Create(ctx, ch)
+					}
+					if chatErr == nil {
+						svc.audit(ctx, model.ChannelChangeCreate, ch, model.Actor{GroupId: groupId}, "found by the search: "+terms)
+						counts[lbl]++
+					}
 				}
 			}
 			switch chatErr {
@@ -263,9 +1118,9 @@ func (svc service) SearchAndAdd(ctx context.Context, groupId, subId, terms strin
 	return
 }
 
-func (svc service) chatContainsNoBotTag(chId int64) (contains bool) {
+func (svc service) chatContainsNoBotTag(acc *pool.Account, chId int64) (contains bool) {
 	var chat *client.Chat
-	chat, err := svc.clientTg.GetChat(&client.GetChatRequest{
+	chat, err := acc.Client.GetChat(&client.GetChatRequest{
 		ChatId: chId,
 	})
 	var sgChat *client.ChatTypeSupergroup
@@ -273,13 +1128,13 @@ func (svc service) chatContainsNoBotTag(chId int64) (contains bool) {
 		sgChat = chat.Type.(*client.ChatTypeSupergroup)
 	}
 	if err == nil && sgChat != nil {
-		contains = svc.supergroupContainsNoBotTag(chId, sgChat.SupergroupId)
+		contains = svc.supergroupContainsNoBotTag(acc, chId, sgChat.SupergroupId)
 	}
 	return
 }
 
-func (svc service) supergroupContainsNoBotTag(chId, sgId int64) (contains bool) {
-	info, err := svc.clientTg.GetSupergroupFullInfo(&client.GetSupergroupFullInfoRequest{
+func (svc service) supergroupContainsNoBotTag(acc *pool.Account, chId, sgId int64) (contains bool) {
+	info, err := acc.Client.GetSupergroupFullInfo(&client.GetSupergroupFullInfoRequest{
 		SupergroupId: sgId,
 	})
 	svc.log.Debug(fmt.Sprintf("GetSupergroupFullInfo(%d, %d): %+v, %s", chId, sgId, info, err))
@@ -305,12 +1160,12 @@ func (svc service) HandleInterestChange(ctx context.Context, evt *pb.CloudEvent)
 	}
 	publicAttr, publicAttrPresent := evt.Attributes[ceKeyPublic]
 	if publicAttrPresent && publicAttr.GetCeBoolean() {
-		err = svc.handlePublicInterestChange(ctx, evt)
+		err = svc.handlePublicInterestChange(ctx, svc.accs.Default(), evt)
 	}
 	return
 }
 
-func (svc service) handlePublicInterestChange(ctx context.Context, evt *pb.CloudEvent) (err error) {
+func (svc service) handlePublicInterestChange(ctx context.Context, acc *pool.Account, evt *pb.CloudEvent) (err error) {
 
 	interestId := evt.GetTextData()
 	var descr string
@@ -339,25 +1194,25 @@ func (svc service) handlePublicInterestChange(ctx context.Context, evt *pb.Cloud
 	}
 	name = "awk_" + name
 
-	c, _ := svc.getPublicChan(name)
+	c, _ := svc.getPublicChan(acc, name)
 	switch c {
 	case nil:
-		c, err = svc.createChan(interestId, descr, tags)
+		c, err = svc.createChan(acc, interestId, descr, tags)
 		if err == nil {
-			err = errors.Join(err, svc.setChanPublic(c, interestId, name))
-			err = errors.Join(err, svc.setChanLogo(c, interestId))
+			err = errors.Join(err, svc.setChanPublic(acc, c, interestId, name))
+			err = errors.Join(err, svc.setChanLogo(acc, c, interestId))
 		}
 	}
 	if err == nil {
-		err = errors.Join(err, svc.setChanAdminBot(c, interestId))
-		err = errors.Join(err, svc.subscribeChan(c, interestId))
+		err = errors.Join(err, svc.setChanAdminBot(acc, c, interestId))
+		err = errors.Join(err, svc.subscribeChan(acc, c, interestId))
 	}
 
 	return
 }
 
-func (svc service) getPublicChan(name string) (c *client.Chat, err error) {
-	c, err = svc.clientTg.SearchPublicChat(&client.SearchPublicChatRequest{
+func (svc service) getPublicChan(acc *pool.Account, name string) (c *client.Chat, err error) {
+	c, err = acc.Client.SearchPublicChat(&client.SearchPublicChatRequest{
 		Username: name,
 	})
 	if err != nil || c.Type.ChatTypeType() != client.TypeChatTypeSupergroup || !c.Type.(*client.ChatTypeSupergroup).IsChannel {
@@ -366,8 +1221,8 @@ func (svc service) getPublicChan(name string) (c *client.Chat, err error) {
 	return
 }
 
-func (svc service) createChan(interestId, descr string, tags []string) (c *client.Chat, err error) {
-	c, err = svc.clientTg.CreateNewSupergroupChat(&client.CreateNewSupergroupChatRequest{
+func (svc service) createChan(acc *pool.Account, interestId, descr string, tags []string) (c *client.Chat, err error) {
+	c, err = acc.Client.CreateNewSupergroupChat(&client.CreateNewSupergroupChatRequest{
 		Title:     descr,
 		IsChannel: true,
 		Description: fmt.Sprintf(
@@ -381,9 +1236,9 @@ func (svc service) createChan(interestId, descr string, tags []string) (c *clien
 	return
 }
 
-func (svc service) setChanPublic(c *client.Chat, interestId, name string) (err error) {
+func (svc service) setChanPublic(acc *pool.Account, c *client.Chat, interestId, name string) (err error) {
 	sgChat := c.Type.(*client.ChatTypeSupergroup)
-	_, err = svc.clientTg.SetSupergroupUsername(&client.SetSupergroupUsernameRequest{
+	_, err = acc.Client.SetSupergroupUsername(&client.SetSupergroupUsernameRequest{
 		SupergroupId: sgChat.SupergroupId,
 		Username:     name,
 	})
@@ -393,8 +1248,8 @@ func (svc service) setChanPublic(c *client.Chat, interestId, name string) (err e
 	return
 }
 
-func (svc service) setChanLogo(c *client.Chat, interestId string) (err error) {
-	_, err = svc.clientTg.SetChatPhoto(&client.SetChatPhotoRequest{
+func (svc service) setChanLogo(acc *pool.Account, c *client.Chat, interestId string) (err error) {
+	_, err = acc.Client.SetChatPhoto(&client.SetChatPhotoRequest{
 		ChatId: c.Id,
 		Photo: &client.InputChatPhotoStatic{
 			Photo: &client.InputFileLocal{
@@ -408,8 +1263,8 @@ func (svc service) setChanLogo(c *client.Chat, interestId string) (err error) {
 	return
 }
 
-func (svc service) setChanAdminBot(c *client.Chat, interestId string) (err error) {
-	_, err = svc.clientTg.SetChatMemberStatus(&client.SetChatMemberStatusRequest{
+func (svc service) setChanAdminBot(acc *pool.Account, c *client.Chat, interestId string) (err error) {
+	_, err = acc.Client.SetChatMemberStatus(&client.SetChatMemberStatusRequest{
 		ChatId: c.Id,
 		MemberId: &client.MessageSenderUser{
 			UserId: svc.botUserId,
@@ -431,8 +1286,8 @@ func (svc service) setChanAdminBot(c *client.Chat, interestId string) (err error
 	return
 }
 
-func (svc service) subscribeChan(c *client.Chat, interestId string) (err error) {
-	_, err = svc.clientTg.SendMessage(&client.SendMessageRequest{
+func (svc service) subscribeChan(acc *pool.Account, c *client.Chat, interestId string) (err error) {
+	_, err = acc.Client.SendMessage(&client.SendMessageRequest{
 		ChatId: c.Id,
 		InputMessageContent: &client.InputMessageText{
 			Text: &client.FormattedText{
@@ -450,3 +1305,104 @@ func (svc service) subscribeChan(c *client.Chat, interestId string) (err error)
 	}
 	return
 }
+
+func (svc service) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+	stats, err = svc.stor.GetStats(ctx, chanIds, since)
+	return
+}
+
+func (svc service) FlushStatsLoop() (err error) {
+	ctx := context.TODO()
+	for err == nil {
+		time.Sleep(svc.statsFlushInterval)
+		err = svc.FlushStats(ctx)
+	}
+	return
+}
+
+// FlushStats attributes the counters to the day of the flush, the counters failed to persist are kept for the next
+// attempt.
+func (svc service) FlushStats(ctx context.Context) (err error) {
+	t := time.Now().UTC()
+	for _, acc := range svc.accs.Accounts() {
+		for chanId, delta := range takeStatsUnflushed(acc) {
+			errChan := svc.stor.AddStats(ctx, chanId, t, delta)
+			if errChan != nil {
+				putStatsUnflushed(acc, chanId, delta)
+			}
+			err = errors.Join(err, errChan)
+		}
+	}
+	return
+}
+
+func takeStatsUnflushed(acc *pool.Account) (unflushed map[int64]model.ChannelCounters) {
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	unflushed = make(map[int64]model.ChannelCounters, len(acc.StatsUnflushed))
+	for chanId, delta := range acc.StatsUnflushed {
+		unflushed[chanId] = *delta
+		delete(acc.StatsUnflushed, chanId)
+	}
+	return
+}
+
+func putStatsUnflushed(acc *pool.Account, chanId int64, delta model.ChannelCounters) {
+	acc.ChansJoinedLock.Lock()
+	defer acc.ChansJoinedLock.Unlock()
+	unflushed := acc.StatsUnflushed[chanId]
+	if unflushed == nil {
+		unflushed = &model.ChannelCounters{}
+		acc.StatsUnflushed[chanId] = unflushed
+	}
+	unflushed.Add(delta)
+}
+
+func (svc service) Export(ctx context.Context, filter model.ChannelFilter, consume func(ch model.Channel) (err error)) (err error) {
+	err = svc.stor.Iterate(ctx, filter, ListLimit, consume)
+	return
+}
+
+// Import keeps the channel label, the channels of the accounts not hosted in this environment are moved by the
+// rebalance.
+func (svc service) Import(ctx context.Context, ch model.Channel, conflict model.ImportConflict, dryRun bool, actor model.Actor) (result model.ImportResult, err error) {
+	if ch.Id == 0 || ch.Link == "" {
+		err = fmt.Errorf("%w: both the id and the link are required", ErrImportInvalid)
+		return
+	}
+	var existing model.Channel
+	existing, err = svc.stor.Read(ctx, ch.Link)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		err = nil
+		result = model.ImportResultCreated
+	case err != nil:
+	case conflict == model.ImportConflictSkip:
+		result = model.ImportResultSkipped
+	case conflict == model.ImportConflictOverwrite:
+		result = model.ImportResultOverwritten
+		if !dryRun {
+			err = svc.stor.Delete(ctx, existing.Link, "overwritten by the import")
+		}
+	default:
+		err = fmt.Errorf("%w: %s", storage.ErrConflict, ch.Link)
+	}
+	if err == nil && !dryRun && result != model.ImportResultSkipped {
+		err = svc.stor.Create(ctx, ch)
+		switch {
+		case err == nil && result == model.ImportResultOverwritten:
+			svc.audit(ctx, model.ChannelChangeUpdate, ch, actor, "overwritten by the import")
+		case err == nil:
+			svc.audit(ctx, model.ChannelChangeCreate, ch, actor, "imported")
+		case errors.Is(err, storage.ErrConflict) && conflict == model.ImportConflictSkip:
+			// the id is used by another link
+			err = nil
+			result = model.ImportResultSkipped
+		case result == model.ImportResultOverwritten:
+			// keep the overwritten channel
+			_, errRestore := svc.stor.Restore(ctx, existing.Link)
+			err = errors.Join(err, errRestore)
+		}
+	}
+	return
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/awakari/source-telegram/model"
 	"github.com/awakari/source-telegram/storage"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"time"
 )
 
 type serviceMock struct {
@@ -23,6 +24,8 @@ func (s serviceMock) Create(ctx context.Context, ch model.Channel) (err error) {
 		err = storage.ErrConflict
 	case "nobot":
 		err = ErrNoBot
+	case "full":
+		err = ErrReplicasFull
 	}
 	return
 }
@@ -44,7 +47,7 @@ func (s serviceMock) Read(ctx context.Context, link string) (ch model.Channel, e
 	return
 }
 
-func (s serviceMock) Delete(ctx context.Context, link string) (err error) {
+func (s serviceMock) Delete(ctx context.Context, link string, actor model.Actor) (err error) {
 	switch link {
 	case "fail":
 		err = storage.ErrInternal
@@ -54,15 +57,60 @@ func (s serviceMock) Delete(ctx context.Context, link string) (err error) {
 	return
 }
 
+func (s serviceMock) Restore(ctx context.Context, link string, actor model.Actor) (ch model.Channel, err error) {
+	switch link {
+	case "fail":
+		err = storage.ErrInternal
+	case "missing":
+		err = storage.ErrNotFound
+	default:
+		ch.Id = -1001801930101
+		ch.GroupId = "group0"
+		ch.UserId = "user0"
+		ch.Name = "channel0"
+		ch.Link = "https://t.me/channel0"
+		ch.Label = "1"
+	}
+	return
+}
+
+func (s serviceMock) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+	switch filter.Link {
+	case "fail":
+		err = storage.ErrInternal
+	default:
+		if cursor == "" {
+			page = []model.AuditRecord{
+				{
+					Id:        "2ob2MB7IGTwakXv4bdXkZ5vt8Br",
+					Time:      time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC),
+					Action:    model.ChannelChangeDelete,
+					ChannelId: -1001801930101,
+					Link:      "https://t.me/channel0",
+					GroupId:   "group0",
+					UserId:    "user0",
+					Actor: model.Actor{
+						System: model.ActorSystemStale,
+					},
+					Reason: "flagged stale since 2024-10-28, the grace period is over",
+				},
+			}
+		}
+	}
+	return
+}
+
 func (s serviceMock) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
 	switch cursor {
 	case "":
 		page = []model.Channel{
 			{
+				Id:   -1001801930101,
 				Name: "channel0",
 				Link: "https://t.me/channel0",
 			},
 			{
+				Id:   -1001754252633,
 				Name: "channel1",
 				Link: "https://t.me/c/1/2",
 			},
@@ -71,7 +119,7 @@ func (s serviceMock) GetPage(ctx context.Context, filter model.ChannelFilter, li
 	return
 }
 
-func (s serviceMock) SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32) (n uint32, err error) {
+func (s serviceMock) SearchAndAdd(ctx context.Context, groupId, subId, terms string, limit uint32, groups bool) (n uint32, err error) {
 	switch terms {
 	case "fail":
 		err = errors.New("fail")
@@ -81,12 +129,207 @@ func (s serviceMock) SearchAndAdd(ctx context.Context, groupId, subId, terms str
 	return
 }
 
-func (s serviceMock) RefreshJoinedLoop() (err error) {
+func (s serviceMock) WatchChangesLoop() (err error) {
 	//TODO implement me
 	panic("implement me")
 }
 
+func (s serviceMock) RefreshJoined(ctx context.Context) (err error) {
+	return
+}
+
+func (s serviceMock) Joined(ctx context.Context) (chans []model.JoinedChannel, err error) {
+	chans = []model.JoinedChannel{
+		{
+			Account: 1,
+			Channel: model.Channel{
+				Id:         -1001801930101,
+				Name:       "channel0",
+				Link:       "https://t.me/channel0",
+				Last:       time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC),
+				Discussion: true,
+			},
+			ChatIds: []int64{
+				-1001801930101,
+				-1001801930102,
+			},
+			Stats: model.ChannelStats{
+				Messages: 3,
+				ChannelCounters: model.ChannelCounters{
+					Published: 2,
+					Failed:    1,
+				},
+				LastErr:     "fail",
+				LastErrTime: time.Date(2024, 11, 4, 18, 50, 0, 0, time.UTC),
+			},
+		},
+	}
+	return
+}
+
+func (s serviceMock) Info(ctx context.Context) (info model.Info, err error) {
+	info = model.Info{
+		TdlibVersion: "1.8.44",
+		Accounts: []model.AccountInfo{
+			{
+				Index:     1,
+				UserId:    123,
+				FirstName: "John",
+				LastName:  "Doe",
+				Usernames: []string{
+					"johndoe",
+				},
+			},
+		},
+	}
+	return
+}
+
 func (s serviceMock) HandleInterestChange(ctx context.Context, evt *pb.CloudEvent) (err error) {
 	//TODO implement me
 	panic("implement me")
 }
+
+func (s serviceMock) UpdateUsernames(ctx context.Context, chatId int64, usernames []string) (err error) {
+	return
+}
+
+func (s serviceMock) CleanStale(ctx context.Context, dryRun bool) (report []model.ChannelCleanup, err error) {
+	report = []model.ChannelCleanup{
+		{
+			Channel: model.Channel{
+				Id:   -1001801930101,
+				Name: "channel0",
+				Link: "https://t.me/channel0",
+			},
+			Action: model.CleanupActionFlag,
+		},
+		{
+			Channel: model.Channel{
+				Id:   -1001754252633,
+				Name: "channel1",
+				Link: "https://t.me/channel1",
+			},
+			Action: model.CleanupActionRemove,
+		},
+	}
+	return
+}
+
+func (s serviceMock) CleanStaleLoop() (err error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (s serviceMock) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+	stats = map[int64]model.ChannelStatsHistory{}
+	for _, chanId := range chanIds {
+		if chanId == 0 {
+			err = storage.ErrInternal
+			break
+		}
+		day := time.Date(2024, 11, 4, 0, 0, 0, 0, time.UTC)
+		counters := model.ChannelCounters{
+			Seen:      3,
+			Published: 2,
+			Dropped: map[string]uint64{
+				"unsupported": 1,
+			},
+			TextBytes: 42,
+		}
+		stats[chanId] = model.ChannelStatsHistory{
+			ChannelId: chanId,
+			Total:     counters,
+			Days: []model.ChannelStatsDay{
+				{
+					Date:     day,
+					Counters: counters,
+				},
+			},
+			Updated: day.Add(18 * time.Hour),
+		}
+	}
+	return
+}
+
+func (s serviceMock) FlushStats(ctx context.Context) (err error) {
+	return
+}
+
+func (s serviceMock) FlushStatsLoop() (err error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (s serviceMock) Export(ctx context.Context, filter model.ChannelFilter, consume func(ch model.Channel) (err error)) (err error) {
+	switch filter.Pattern {
+	case "fail":
+		err = storage.ErrInternal
+	default:
+		for _, ch := range []model.Channel{
+			{
+				Id:      -1001801930101,
+				GroupId: "group0",
+				UserId:  "user0",
+				Name:    "channel0",
+				Link:    "https://t.me/channel0",
+				Created: time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC),
+				Label:   "1",
+			},
+			{
+				Id:   -1001754252633,
+				Name: "channel1",
+				Link: "https://t.me/c/1/2",
+			},
+		} {
+			err = consume(ch)
+			if err != nil {
+				break
+			}
+		}
+	}
+	return
+}
+
+func (s serviceMock) Import(ctx context.Context, ch model.Channel, conflict model.ImportConflict, dryRun bool, actor model.Actor) (result model.ImportResult, err error) {
+	switch ch.Link {
+	case "fail":
+		err = storage.ErrInternal
+	case "conflict":
+		switch conflict {
+		case model.ImportConflictSkip:
+			result = model.ImportResultSkipped
+		case model.ImportConflictOverwrite:
+			result = model.ImportResultOverwritten
+		default:
+			err = storage.ErrConflict
+		}
+	}
+	return
+}
+
+func (s serviceMock) Rebalance(ctx context.Context, limit uint32, dryRun, drain bool) (moves []model.ChannelMove, err error) {
+	moves = []model.ChannelMove{
+		{
+			Channel: model.Channel{
+				Id:   -1001801930101,
+				Name: "channel0",
+				Link: "https://t.me/channel0",
+			},
+			LabelFrom: "",
+			LabelTo:   "1",
+		},
+	}
+	return
+}
+
+func (s serviceMock) FloodWaits(ctx context.Context) (waits []model.FloodWait, err error) {
+	waits = []model.FloodWait{
+		{
+			Account: 1,
+			Class:   "join",
+			Until:   time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC),
+		},
+	}
+	return
+}
@@ -0,0 +1,1206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/awakari/source-telegram/api/http/pub"
+	"github.com/awakari/source-telegram/model"
+	"github.com/awakari/source-telegram/pool"
+	"github.com/awakari/source-telegram/storage"
+	"github.com/awakari/source-telegram/telegram"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+const testBotUserId = 123456789
+
+func newTestService(gw telegram.Gateway, stor storage.Storage, replicaJoinLimit uint32) (svc service, acc *pool.Account) {
+	acc = pool.NewAccount(0, gw)
+	svc = NewService(
+		pool.NewPool([]*pool.Account{acc}),
+		stor,
+		slog.Default(),
+		testBotUserId,
+		100,
+		pub.NewMock(),
+		720*time.Hour,
+		168*time.Hour,
+		time.Hour,
+		10,
+		0.5,
+		2,
+		replicaJoinLimit,
+		time.Minute,
+	).(service)
+	return
+}
+
+func TestService_Create(t *testing.T) {
+	cases := map[string]struct {
+		setup     func(gw *telegram.GatewayFake, stor *storageMem)
+		ch        model.Channel
+		joinLimit uint32
+		stored    *model.Channel
+		err       error
+	}{
+		"ok": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 0", "channel0", true)
+			},
+			ch: model.Channel{
+				Link:   "https://t.me/channel0",
+				UserId: "user0",
+			},
+			stored: &model.Channel{
+				Id:     -1_000_000_000_001,
+				Name:   "Channel 0",
+				Link:   "https://t.me/channel0",
+				UserId: "user0",
+			},
+		},
+		"least loaded label": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 0", "channel0", true)
+				_ = stor.Create(context.TODO(), model.Channel{Id: 2, Link: "@channel2"})
+			},
+			ch: model.Channel{
+				Link: "@channel0",
+			},
+			stored: &model.Channel{
+				Id:    -1_000_000_000_001,
+				Name:  "Channel 0",
+				Link:  "@channel0",
+				Label: "1",
+			},
+		},
+		"label is preserved": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 0", "channel0", true)
+			},
+			ch: model.Channel{
+				Link:  "@channel0",
+				Label: "5",
+			},
+			stored: &model.Channel{
+				Id:    -1_000_000_000_001,
+				Name:  "Channel 0",
+				Link:  "@channel0",
+				Label: "5",
+			},
+		},
+		"not found": {
+			ch: model.Channel{
+				Link: "@missing",
+			},
+			err: client.ResponseError{},
+		},
+		"nobot": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				_, info := gw.AddSupergroup(1, "Channel 0", "channel0", true)
+				info.Description = "do not touch #nobot please"
+			},
+			ch: model.Channel{
+				Link: "@channel0",
+			},
+			err: ErrNoBot,
+		},
+		"conflict": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 0", "channel0", true)
+				_ = stor.Create(context.TODO(), model.Channel{Id: -1_000_000_000_001, Link: "@channel0"})
+			},
+			ch: model.Channel{
+				Link: "@channel0",
+			},
+			err: storage.ErrConflict,
+		},
+		"replicas full": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 0", "channel0", true)
+				_ = stor.Create(context.TODO(), model.Channel{Id: 2, Link: "@channel2"})
+				_ = stor.Create(context.TODO(), model.Channel{Id: 3, Link: "@channel3", Label: "1"})
+			},
+			ch: model.Channel{
+				Link: "@channel0",
+			},
+			joinLimit: 1,
+			err:       ErrReplicasFull,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			gw := telegram.NewGatewayFake()
+			stor := newStorageMem()
+			if c.setup != nil {
+				c.setup(gw, stor)
+			}
+			joinLimit := c.joinLimit
+			if joinLimit == 0 {
+				joinLimit = 10
+			}
+			svc, _ := newTestService(gw, stor, joinLimit)
+			err := svc.Create(context.TODO(), c.ch)
+			switch c.err.(type) {
+			case client.ResponseError:
+				assert.ErrorAs(t, err, &client.ResponseError{})
+			default:
+				assert.ErrorIs(t, err, c.err)
+			}
+			if c.stored != nil {
+				ch, readErr := stor.Read(context.TODO(), c.stored.Link)
+				require.Nil(t, readErr)
+				assert.Equal(t, c.stored.Id, ch.Id)
+				assert.Equal(t, c.stored.Name, ch.Name)
+				assert.Equal(t, c.stored.UserId, ch.UserId)
+				assert.Equal(t, c.stored.Label, ch.Label)
+				assert.False(t, ch.Created.IsZero())
+				assert.Equal(t, ch.Created, ch.Last)
+			}
+		})
+	}
+}
+
+func TestService_Delete(t *testing.T) {
+	ch := model.Channel{
+		Id:      -1001,
+		Link:    "@channel1",
+		GroupId: "group0",
+		UserId:  "user0",
+	}
+	cases := map[string]struct {
+		link  string
+		err   error
+		audit []model.AuditRecord
+	}{
+		"ok": {
+			link: "@channel1",
+			audit: []model.AuditRecord{
+				{
+					Action:    model.ChannelChangeDelete,
+					ChannelId: -1001,
+					Link:      "@channel1",
+					GroupId:   "group0",
+					UserId:    "user0",
+					Actor: model.Actor{
+						GroupId: "group1",
+						UserId:  "user1",
+					},
+				},
+			},
+		},
+		"missing": {
+			link: "@channel2",
+			err:  storage.ErrNotFound,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			stor := newStorageMem(ch)
+			svc, _ := newTestService(telegram.NewGatewayFake(), stor, 10)
+			err := svc.Delete(context.TODO(), c.link, model.Actor{GroupId: "group1", UserId: "user1"})
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.audit, stor.audit)
+		})
+	}
+}
+
+func TestService_Restore(t *testing.T) {
+	ch := model.Channel{
+		Id:      -1001,
+		Link:    "@channel1",
+		GroupId: "group0",
+		UserId:  "user0",
+		Label:   "1",
+	}
+	stor := newStorageMem(ch)
+	svc, _ := newTestService(telegram.NewGatewayFake(), stor, 10)
+	actor := model.Actor{GroupId: "group1", UserId: "user1"}
+	_, err := svc.Restore(context.TODO(), "@channel1", actor)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	require.Nil(t, svc.Delete(context.TODO(), "@channel1", actor))
+	_, err = svc.Read(context.TODO(), "@channel1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	restored, err := svc.Restore(context.TODO(), "@channel1", actor)
+	require.Nil(t, err)
+	assert.Equal(t, ch, restored)
+	require.Len(t, stor.audit, 2)
+	assert.Equal(t, model.AuditRecord{
+		Action:    model.ChannelChangeCreate,
+		ChannelId: -1001,
+		Link:      "@channel1",
+		GroupId:   "group0",
+		UserId:    "user0",
+		Actor:     actor,
+		Reason:    "restored",
+	}, stor.audit[1])
+}
+
+func TestService_Rebalance(t *testing.T) {
+	cases := map[string]struct {
+		limit   uint32
+		drain   bool
+		unknown int
+		moves   int
+	}{
+		"no limit": {
+			moves: 2,
+		},
+		"limit": {
+			limit: 1,
+			moves: 1,
+		},
+		"unknown replica is kept": {
+			unknown: 2,
+			moves:   1,
+		},
+		"unknown replica is drained": {
+			unknown: 2,
+			drain:   true,
+			moves:   3,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			// 4 channels on the 1st of 2 replicas
+			chans := []model.Channel{
+				{Id: -1001, Link: "@channel1"},
+				{Id: -1002, Link: "@channel2"},
+				{Id: -1003, Link: "@channel3"},
+				{Id: -1004, Link: "@channel4"},
+			}
+			// plus some on the replica beyond the replicas count
+			for i := 0; i < c.unknown; i++ {
+				chans = append(chans, model.Channel{Id: int64(-2001 - i), Link: fmt.Sprintf("@other%d", i), Label: pool.Label(2)})
+			}
+			stor := newStorageMem(chans...)
+			svc, _ := newTestService(telegram.NewGatewayFake(), stor, 10)
+			moves, err := svc.Rebalance(context.TODO(), c.limit, true, c.drain)
+			require.Nil(t, err)
+			assert.Len(t, moves, c.moves)
+			for _, m := range moves {
+				assert.NotEqual(t, pool.Label(2), m.LabelTo)
+				if !c.drain {
+					assert.NotEqual(t, pool.Label(2), m.LabelFrom)
+				}
+			}
+		})
+	}
+}
+
+func TestService_CleanStale(t *testing.T) {
+	now := time.Now().UTC()
+	cases := map[string]struct {
+		ch     model.Channel
+		action model.CleanupAction
+		skip   bool
+	}{
+		"active": {
+			ch: model.Channel{
+				Last: now.Add(-time.Hour),
+			},
+			skip: true,
+		},
+		"inactive": {
+			ch: model.Channel{
+				Last: now.Add(-1000 * time.Hour),
+			},
+			action: model.CleanupActionFlag,
+		},
+		"inactive, flagged, grace period is over": {
+			ch: model.Channel{
+				Last:  now.Add(-1000 * time.Hour),
+				Stale: now.Add(-200 * time.Hour),
+			},
+			action: model.CleanupActionRemove,
+		},
+		"active again": {
+			ch: model.Channel{
+				Last:  now.Add(-time.Hour),
+				Stale: now.Add(-time.Hour),
+			},
+			action: model.CleanupActionUnflag,
+		},
+		"no last, created recently": {
+			ch: model.Channel{
+				Created: now.Add(-time.Hour),
+			},
+			skip: true,
+		},
+		"no last, created long ago": {
+			ch: model.Channel{
+				Created: now.Add(-1000 * time.Hour),
+			},
+			action: model.CleanupActionFlag,
+		},
+		"no last, no created": {
+			skip: true,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			c.ch.Id = -1001
+			c.ch.Link = "@channel1"
+			svc, _ := newTestService(telegram.NewGatewayFake(), newStorageMem(c.ch), 10)
+			report, err := svc.CleanStale(context.TODO(), true)
+			require.Nil(t, err)
+			if c.skip {
+				assert.Empty(t, report)
+			} else {
+				require.Len(t, report, 1)
+				assert.Equal(t, c.action, report[0].Action)
+			}
+		})
+	}
+}
+
+func TestService_FlushStats(t *testing.T) {
+	stor := newStorageMem()
+	svc, acc := newTestService(telegram.NewGatewayFake(), stor, 10)
+	acc.StatsUnflushed[-1001] = &model.ChannelCounters{
+		Seen:      2,
+		Published: 1,
+		Dropped: map[string]uint64{
+			"nobot": 1,
+		},
+	}
+	// kept for the next attempt on failure
+	stor.statsErr = storage.ErrInternal
+	err := svc.FlushStats(context.TODO())
+	assert.ErrorIs(t, err, storage.ErrInternal)
+	acc.StatsUnflushed[-1001].Seen++
+	stor.statsErr = nil
+	require.Nil(t, svc.FlushStats(context.TODO()))
+	assert.Empty(t, acc.StatsUnflushed)
+	stats, err := svc.GetStats(context.TODO(), []int64{-1001, -1002}, time.Time{})
+	require.Nil(t, err)
+	assert.Equal(t, map[int64]model.ChannelStatsHistory{
+		-1001: {
+			ChannelId: -1001,
+			Total: model.ChannelCounters{
+				Seen:      3,
+				Published: 1,
+				Dropped: map[string]uint64{
+					"nobot": 1,
+				},
+			},
+		},
+	}, stats)
+}
+
+func TestService_Import(t *testing.T) {
+	stored := model.Channel{
+		Id:    -1001,
+		Link:  "@channel1",
+		Name:  "channel1",
+		Label: "1",
+	}
+	imported := model.Channel{
+		Id:      -1001,
+		Link:    "@channel1",
+		Name:    "channel1 imported",
+		GroupId: "group0",
+		UserId:  "user0",
+		Label:   "2",
+		Stale:   time.Date(2024, 11, 4, 0, 0, 0, 0, time.UTC),
+	}
+	cases := map[string]struct {
+		ch       model.Channel
+		conflict model.ImportConflict
+		dryRun   bool
+		result   model.ImportResult
+		err      error
+		stored   model.Channel
+		audit    int
+	}{
+		"create": {
+			ch: model.Channel{
+				Id:   -1002,
+				Link: "@channel2",
+			},
+			result: model.ImportResultCreated,
+			audit:  1,
+		},
+		"create dry run": {
+			ch: model.Channel{
+				Id:   -1002,
+				Link: "@channel2",
+			},
+			dryRun: true,
+			result: model.ImportResultCreated,
+			stored: stored,
+		},
+		"invalid": {
+			ch: model.Channel{
+				Link: "@channel2",
+			},
+			err:    ErrImportInvalid,
+			stored: stored,
+		},
+		"skip": {
+			ch:     imported,
+			result: model.ImportResultSkipped,
+			stored: stored,
+		},
+		"skip the id used by another link": {
+			ch: model.Channel{
+				Id:   -1001,
+				Link: "@channel2",
+			},
+			result: model.ImportResultSkipped,
+			stored: stored,
+		},
+		"overwrite": {
+			ch:       imported,
+			conflict: model.ImportConflictOverwrite,
+			result:   model.ImportResultOverwritten,
+			stored:   imported,
+			audit:    1,
+		},
+		"overwrite fails with the id used by another link": {
+			ch: model.Channel{
+				Id:   -1003,
+				Link: "@channel1",
+			},
+			conflict: model.ImportConflictOverwrite,
+			result:   model.ImportResultOverwritten,
+			err:      storage.ErrConflict,
+			stored:   stored,
+		},
+		"overwrite dry run": {
+			ch:       imported,
+			conflict: model.ImportConflictOverwrite,
+			dryRun:   true,
+			result:   model.ImportResultOverwritten,
+			stored:   stored,
+		},
+		"fail": {
+			ch:       imported,
+			conflict: model.ImportConflictFail,
+			err:      storage.ErrConflict,
+			stored:   stored,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			stor := newStorageMem(stored, model.Channel{Id: -1003, Link: "@channel3"})
+			svc, _ := newTestService(telegram.NewGatewayFake(), stor, 10)
+			result, err := svc.Import(context.TODO(), c.ch, c.conflict, c.dryRun, model.Actor{UserId: "admin"})
+			assert.Equal(t, c.result, result)
+			assert.ErrorIs(t, err, c.err)
+			if c.stored.Link != "" {
+				ch, err := stor.Read(context.TODO(), c.stored.Link)
+				require.Nil(t, err)
+				assert.Equal(t, c.stored, ch)
+			}
+			assert.Len(t, stor.audit, c.audit)
+		})
+	}
+}
+
+func TestService_Export(t *testing.T) {
+	stor := newStorageMem(
+		model.Channel{
+			Id:   -1002,
+			Link: "@channel2",
+		},
+		model.Channel{
+			Id:   -1001,
+			Link: "@channel1",
+		},
+	)
+	svc, _ := newTestService(telegram.NewGatewayFake(), stor, 10)
+	var links []string
+	err := svc.Export(context.TODO(), model.ChannelFilter{}, func(ch model.Channel) (err error) {
+		links = append(links, ch.Link)
+		return
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"@channel1", "@channel2"}, links)
+}
+
+func TestService_refreshJoined(t *testing.T) {
+	cases := map[string]struct {
+		setup func(gw *telegram.GatewayFake, stor *storageMem)
+		check func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account)
+		err   error
+	}{
+		"join stored": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.Equal(t, []int64{gw.ChatId(1)}, gw.Joined)
+				require.NotNil(t, acc.ChansJoined[gw.ChatId(1)])
+				assert.Equal(t, "@channel1", acc.ChansJoined[gw.ChatId(1)].Link)
+			},
+		},
+		"already joined": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(1)})
+				gw.Calls = nil
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.NotContains(t, gw.Calls, "JoinChat")
+				assert.NotContains(t, gw.Calls, "LeaveChat")
+				assert.NotNil(t, acc.ChansJoined[gw.ChatId(1)])
+			},
+		},
+		"other label is not joined": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1", Label: "1"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.Empty(t, gw.Joined)
+				assert.Empty(t, acc.ChansJoined)
+			},
+		},
+		"nobot is removed": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				_, info := gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				info.Description = "#nobot"
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				_, err := stor.Read(context.TODO(), "@channel1")
+				assert.ErrorIs(t, err, storage.ErrNotFound)
+				assert.Empty(t, acc.ChansJoined)
+				require.Len(t, stor.audit, 1)
+				assert.Equal(t, model.ChannelChangeDelete, stor.audit[0].Action)
+				assert.Equal(t, gw.ChatId(1), stor.audit[0].ChannelId)
+				assert.Equal(t, model.ActorSystemRefresh, stor.audit[0].Actor.System)
+				assert.Contains(t, stor.audit[0].Reason, TagNoBot)
+			},
+		},
+		"join failure is skipped": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+				gw.Errors["JoinChat"] = errors.New("CHANNEL_PRIVATE")
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.Empty(t, gw.Joined)
+				assert.Empty(t, acc.ChansJoined)
+				_, err := stor.Read(context.TODO(), "@channel1")
+				assert.Nil(t, err)
+			},
+		},
+		"renamed": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 1", "channel1new", true)
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "https://t.me/channel1"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				ch, err := stor.Read(context.TODO(), "https://t.me/channel1new")
+				require.Nil(t, err)
+				assert.Equal(t, gw.ChatId(1), ch.Id)
+				assert.Equal(t, "https://t.me/channel1new", acc.ChansJoined[gw.ChatId(1)].Link)
+				require.Len(t, stor.audit, 1)
+				assert.Equal(t, model.ChannelChangeUpdate, stor.audit[0].Action)
+				assert.Equal(t, model.ActorSystemRename, stor.audit[0].Actor.System)
+				assert.Equal(t, "renamed from https://t.me/channel1", stor.audit[0].Reason)
+			},
+		},
+		"discussion": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				_, info := gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				info.LinkedChatId = gw.ChatId(2)
+				gw.AddSupergroup(2, "Channel 1 Chat", "", false)
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1", Discussion: true})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.ElementsMatch(t, []int64{gw.ChatId(1), gw.ChatId(2)}, gw.Joined)
+				require.NotNil(t, acc.ChansJoined[gw.ChatId(2)])
+				assert.Same(t, acc.ChansJoined[gw.ChatId(1)], acc.ChansJoined[gw.ChatId(2)])
+			},
+		},
+		"orphan is left": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				gw.AddSupergroup(2, "Channel 2", "channel2", true)
+				gw.AddSupergroup(3, "Channel 3", "channel3", true)
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(2)})
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(3)})
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(3), Link: "@channel3"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.ElementsMatch(t, []int64{gw.ChatId(1), gw.ChatId(3)}, gw.Joined)
+			},
+		},
+		"own channel is not an orphan": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_, _ = gw.CreateNewSupergroupChat(&client.CreateNewSupergroupChatRequest{Title: "Own", IsChannel: true})
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.Len(t, gw.Joined, 2)
+				assert.NotContains(t, gw.Calls, "LeaveChat")
+			},
+		},
+		"orphans ratio exceeded": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				for i := int64(1); i <= 3; i++ {
+					gw.AddSupergroup(i, "", "", true)
+					_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(i)})
+				}
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.Len(t, gw.Joined, 3)
+				assert.NotContains(t, gw.Calls, "LeaveChat")
+			},
+		},
+		"orphans ratio at the threshold": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				for i := int64(1); i <= 4; i++ {
+					gw.AddSupergroup(i, "", fmt.Sprintf("channel%d", i), true)
+					_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(i)})
+				}
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(2), Link: "@channel2"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.ElementsMatch(t, []int64{gw.ChatId(1), gw.ChatId(2)}, gw.Joined)
+			},
+		},
+		"orphans ratio slightly exceeded": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				for i := int64(1); i <= 4; i++ {
+					gw.AddSupergroup(i, "", fmt.Sprintf("channel%d", i), true)
+					_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(i)})
+				}
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.Len(t, gw.Joined, 4)
+				assert.NotContains(t, gw.Calls, "LeaveChat")
+			},
+		},
+		"orphans leave limit": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				for i := int64(1); i <= 25; i++ {
+					gw.AddSupergroup(i, "", fmt.Sprintf("channel%d", i), true)
+					_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(i)})
+					if i <= 13 {
+						_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(i), Link: fmt.Sprintf("@channel%d", i)})
+					}
+				}
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				// 12 orphans of 25 joined are under the ratio, 10 are left per refresh
+				assert.Len(t, gw.Joined, 15)
+			},
+		},
+		"stored channels beyond the list limit are not orphans": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				for i := int64(1); i <= ListLimit+1; i++ {
+					// the first joined chat is the last one stored by the link
+					link := fmt.Sprintf("channel%04d", ListLimit+2-i)
+					gw.AddSupergroup(i, "", link, true)
+					_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(i)})
+					_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(i), Link: "@" + link})
+				}
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) {
+				assert.Len(t, gw.Joined, ListLimit+1)
+				assert.NotContains(t, gw.Calls, "LeaveChat")
+			},
+		},
+		"get chats failure": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem) {
+				gw.Errors["GetChats"] = errors.New("fail")
+			},
+			err: errors.New("fail"),
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			gw := telegram.NewGatewayFake()
+			stor := newStorageMem()
+			c.setup(gw, stor)
+			svc, acc := newTestService(gw, stor, 10)
+			err := svc.refreshJoined(context.TODO(), acc)
+			switch c.err {
+			case nil:
+				assert.Nil(t, err)
+			default:
+				assert.EqualError(t, err, c.err.Error())
+			}
+			if c.check != nil {
+				c.check(t, gw, stor, acc)
+			}
+		})
+	}
+}
+
+func TestService_discussion(t *testing.T) {
+	gw := telegram.NewGatewayFake()
+	_, info := gw.AddSupergroup(1, "Channel 1", "channel1", true)
+	info.LinkedChatId = gw.ChatId(2)
+	gw.AddSupergroup(2, "Channel 1 Chat", "", false)
+	gw.AddSupergroup(3, "Channel 1 New Chat", "", false)
+	ch := model.Channel{Id: gw.ChatId(1), Link: "@channel1", Discussion: true}
+	stor := newStorageMem(ch)
+	svc, acc := newTestService(gw, stor, 10)
+	require.Nil(t, svc.refreshJoined(context.TODO(), acc))
+	assert.ElementsMatch(t, []int64{gw.ChatId(1), gw.ChatId(2)}, gw.Joined)
+	assert.Equal(t, map[int64]int64{gw.ChatId(1): gw.ChatId(2)}, acc.Discussions)
+	// the linked chat is resolved once while the channel is joined
+	info.LinkedChatId = gw.ChatId(3)
+	require.Nil(t, svc.refreshJoined(context.TODO(), acc))
+	assert.ElementsMatch(t, []int64{gw.ChatId(1), gw.ChatId(2)}, gw.Joined)
+	// the comments are disabled
+	ch.Discussion = false
+	svc.handleChange(context.TODO(), acc, model.ChannelChange{Channel: &ch})
+	assert.Equal(t, []int64{gw.ChatId(1)}, gw.Joined)
+	assert.Nil(t, acc.ChansJoined[gw.ChatId(2)])
+	assert.Empty(t, acc.Discussions)
+	// enabled again, the new linked chat is resolved
+	ch.Discussion = true
+	svc.handleChange(context.TODO(), acc, model.ChannelChange{Channel: &ch})
+	assert.ElementsMatch(t, []int64{gw.ChatId(1), gw.ChatId(3)}, gw.Joined)
+	assert.Same(t, acc.ChansJoined[gw.ChatId(1)], acc.ChansJoined[gw.ChatId(3)])
+	// deleted
+	svc.handleChange(context.TODO(), acc, model.ChannelChange{Before: &ch})
+	assert.Empty(t, gw.Joined)
+	assert.Empty(t, acc.ChansJoined)
+	assert.Empty(t, acc.Discussions)
+}
+
+func TestService_SearchAndAdd(t *testing.T) {
+	setup := func(gw *telegram.GatewayFake) {
+		sg, _ := gw.AddSupergroup(1, "Channel 1", "channel1", true)
+		sg.MemberCount = 1_000
+		sg, info := gw.AddSupergroup(2, "Channel 2", "channel2", true)
+		sg.MemberCount = 1_000
+		info.Description = "#nobot"
+		sg, _ = gw.AddSupergroup(3, "Group 3", "group3", false)
+		sg.MemberCount = 1_000
+		sg, _ = gw.AddSupergroup(4, "Channel 4", "channel4", true)
+		sg.MemberCount = 10
+		sg, _ = gw.AddSupergroup(5, "Channel 5", "channel5", true)
+		sg.MemberCount = 1_000
+		gw.SearchResults["foo"] = []int64{gw.ChatId(1), gw.ChatId(2), gw.ChatId(3), gw.ChatId(4), gw.ChatId(5)}
+	}
+	cases := map[string]struct {
+		limit  uint32
+		groups bool
+		fail   bool
+		n      uint32
+		links  []string
+		err    error
+	}{
+		// the small channel is counted too
+		"channels": {
+			limit: 10,
+			n:     3,
+			links: []string{"@channel1", "@channel5"},
+			err:   ErrNoBot,
+		},
+		"channels and groups": {
+			limit:  10,
+			groups: true,
+			n:      4,
+			links:  []string{"@channel1", "@channel5", "@group3"},
+			err:    ErrNoBot,
+		},
+		"limit": {
+			limit: 1,
+			n:     1,
+			links: []string{"@channel1"},
+		},
+		"search failure": {
+			limit: 10,
+			fail:  true,
+			err:   errors.New("fail"),
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			gw := telegram.NewGatewayFake()
+			setup(gw)
+			if c.fail {
+				gw.Errors["SearchPublicChats"] = c.err
+			}
+			stor := newStorageMem()
+			svc, _ := newTestService(gw, stor, 10)
+			n, err := svc.SearchAndAdd(context.TODO(), "group0", "sub0", "foo", c.limit, c.groups)
+			assert.Equal(t, c.n, n)
+			assert.ErrorIs(t, err, c.err)
+			page, _ := stor.GetPage(context.TODO(), model.ChannelFilter{}, 10, "", model.OrderAsc)
+			var links []string
+			lbls := map[string]int{}
+			for _, ch := range page {
+				links = append(links, ch.Link)
+				lbls[ch.Label]++
+				assert.Equal(t, "group0", ch.GroupId)
+				assert.Equal(t, "sub0", ch.SubId)
+				assert.Equal(t, "foo", ch.Terms)
+			}
+			assert.Equal(t, c.links, links)
+			if len(page) > 1 {
+				// spread over the replicas
+				assert.Less(t, lbls[""]-lbls["1"], 2)
+				assert.Less(t, lbls["1"]-lbls[""], 2)
+			}
+		})
+	}
+}
+
+func TestService_HandleInterestChange(t *testing.T) {
+	newEvent := func(interestId string, public bool) *pb.CloudEvent {
+		return &pb.CloudEvent{
+			Id: "evt0",
+			Attributes: map[string]*pb.CloudEventAttributeValue{
+				ceKeyGroupId: {
+					Attr: &pb.CloudEventAttributeValue_CeString{CeString: "group0"},
+				},
+				ceKeyPublic: {
+					Attr: &pb.CloudEventAttributeValue_CeBoolean{CeBoolean: public},
+				},
+				ceKeyDescription: {
+					Attr: &pb.CloudEventAttributeValue_CeString{CeString: "Interest 0"},
+				},
+				ceKeyQueriesBasic: {
+					Attr: &pb.CloudEventAttributeValue_CeString{CeString: "foo\n#bar"},
+				},
+			},
+			Data: &pb.CloudEvent_TextData{
+				TextData: interestId,
+			},
+		}
+	}
+	cases := map[string]struct {
+		setup func(gw *telegram.GatewayFake)
+		evt   *pb.CloudEvent
+		check func(t *testing.T, gw *telegram.GatewayFake)
+		err   bool
+	}{
+		"no group id": {
+			evt: &pb.CloudEvent{
+				Data: &pb.CloudEvent_TextData{
+					TextData: "interest0",
+				},
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				assert.Empty(t, gw.Calls)
+			},
+			err: true,
+		},
+		"not public": {
+			evt: newEvent("interest0", false),
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				assert.Empty(t, gw.Calls)
+			},
+		},
+		"create": {
+			evt: newEvent("Interest-0", true),
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				chat, err := gw.SearchPublicChat(&client.SearchPublicChatRequest{Username: "awk_interest_0"})
+				require.Nil(t, err)
+				assert.Equal(t, "Interest 0", chat.Title)
+				sgId := chat.Type.(*client.ChatTypeSupergroup).SupergroupId
+				assert.Contains(t, gw.SupergroupFullInfos[sgId].Description, "Awakari Interest: Interest-0")
+				assert.Contains(t, gw.SupergroupFullInfos[sgId].Description, "#foo #bar")
+				assert.NotNil(t, gw.Photos[chat.Id])
+				require.Len(t, gw.MemberStatuses, 1)
+				assert.Equal(t, chat.Id, gw.MemberStatuses[0].ChatId)
+				assert.Equal(t, int64(testBotUserId), gw.MemberStatuses[0].MemberId.(*client.MessageSenderUser).UserId)
+				require.Len(t, gw.Sent, 1)
+				assert.Equal(t, chat.Id, gw.Sent[0].ChatId)
+				assert.Equal(t, "/start Interest-0", gw.Sent[0].InputMessageContent.(*client.InputMessageText).Text.Text)
+			},
+		},
+		"long name": {
+			evt: newEvent("2pTHfDPEMiT0g5tahPnMGF3j5xA-extra", true),
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				_, err := gw.SearchPublicChat(&client.SearchPublicChatRequest{Username: "awk_2pthfdpemit0g5tahpnmgf3j5xae"})
+				assert.Nil(t, err)
+			},
+		},
+		"existing": {
+			setup: func(gw *telegram.GatewayFake) {
+				gw.AddSupergroup(1, "Interest 0", "awk_interest0", true)
+			},
+			evt: newEvent("interest0", true),
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				assert.NotContains(t, gw.Calls, "CreateNewSupergroupChat")
+				assert.NotContains(t, gw.Calls, "SetSupergroupUsername")
+				require.Len(t, gw.MemberStatuses, 1)
+				assert.Equal(t, gw.ChatId(1), gw.MemberStatuses[0].ChatId)
+				require.Len(t, gw.Sent, 1)
+				assert.Equal(t, gw.ChatId(1), gw.Sent[0].ChatId)
+			},
+		},
+		"existing group is not reused": {
+			setup: func(gw *telegram.GatewayFake) {
+				gw.AddSupergroup(1, "Interest 0", "awk_interest0", false)
+			},
+			evt: newEvent("interest0", true),
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				assert.Contains(t, gw.Calls, "CreateNewSupergroupChat")
+			},
+			err: true, // the username is occupied
+		},
+		"create failure": {
+			setup: func(gw *telegram.GatewayFake) {
+				gw.Errors["CreateNewSupergroupChat"] = errors.New("fail")
+			},
+			evt: newEvent("interest0", true),
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				assert.Empty(t, gw.MemberStatuses)
+				assert.Empty(t, gw.Sent)
+			},
+			err: true,
+		},
+		"send failure": {
+			setup: func(gw *telegram.GatewayFake) {
+				gw.Errors["SendMessage"] = errors.New("fail")
+			},
+			evt: newEvent("interest0", true),
+			check: func(t *testing.T, gw *telegram.GatewayFake) {
+				assert.Len(t, gw.MemberStatuses, 1)
+			},
+			err: true,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			gw := telegram.NewGatewayFake()
+			if c.setup != nil {
+				c.setup(gw)
+			}
+			svc, _ := newTestService(gw, newStorageMem(), 10)
+			err := svc.HandleInterestChange(context.TODO(), c.evt)
+			assert.Equal(t, c.err, err != nil, err)
+			if c.check != nil {
+				c.check(t, gw)
+			}
+		})
+	}
+}
+
+func TestService_FloodWaits(t *testing.T) {
+	gw := telegram.NewGatewayFake()
+	until := time.Now().Add(time.Minute)
+	gw.Waits[telegram.MethodClassSearch] = until
+	gw.Waits[telegram.MethodClassJoin] = until
+	svc, _ := newTestService(gw, newStorageMem(), 10)
+	waits, err := svc.FloodWaits(context.TODO())
+	require.Nil(t, err)
+	classes := make([]string, 0, len(waits))
+	for _, w := range waits {
+		classes = append(classes, w.Class)
+		assert.Equal(t, 0, w.Account)
+		assert.Equal(t, until, w.Until)
+	}
+	assert.True(t, slices.IsSorted(classes))
+	assert.Equal(t, []string{"join", "search"}, classes)
+}
+
+func TestService_Joined(t *testing.T) {
+	svc, acc := newTestService(telegram.NewGatewayFake(), newStorageMem(), 10)
+	ch0 := &model.Channel{
+		Id:         -1002,
+		Link:       "https://t.me/channel0",
+		Discussion: true,
+	}
+	ch1 := &model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/channel1",
+	}
+	acc.ChansJoined[-1002] = ch0
+	acc.ChansJoined[-1003] = ch0
+	acc.ChansJoined[-1001] = ch1
+	acc.ChansStats[-1002] = &model.ChannelStats{
+		Messages: 2,
+		ChannelCounters: model.ChannelCounters{
+			Published: 1,
+			Failed:    1,
+		},
+		LastErr: "fail",
+	}
+	chans, err := svc.Joined(context.TODO())
+	require.Nil(t, err)
+	require.Equal(t, 2, len(chans))
+	assert.Equal(t, *ch0, chans[0].Channel)
+	assert.Equal(t, []int64{-1002, -1003}, chans[0].ChatIds)
+	assert.Equal(t, "fail", chans[0].Stats.LastErr)
+	assert.Equal(t, uint64(2), chans[0].Stats.Messages)
+	assert.Equal(t, *ch1, chans[1].Channel)
+	assert.Equal(t, []int64{-1001}, chans[1].ChatIds)
+	assert.Zero(t, chans[1].Stats)
+	// forgetting the channel drops its stats too
+	svc.forgetJoined(acc, -1002)
+	assert.Empty(t, acc.ChansStats)
+}
+
+func TestService_Info(t *testing.T) {
+	cases := map[string]struct {
+		errs map[string]error
+		info model.Info
+		err  bool
+	}{
+		"ok": {
+			info: model.Info{
+				TdlibVersion: "1.8.44",
+				Accounts: []model.AccountInfo{
+					{
+						UserId:    123,
+						FirstName: "John",
+						Usernames: []string{
+							"johndoe",
+						},
+						Phone: "1234",
+					},
+				},
+			},
+		},
+		"fail": {
+			errs: map[string]error{
+				"GetMe": errors.New("fail"),
+			},
+			info: model.Info{
+				TdlibVersion: "1.8.44",
+			},
+			err: true,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			gw := telegram.NewGatewayFake()
+			gw.Options["version"] = &client.OptionValueString{
+				Value: "1.8.44",
+			}
+			gw.Me = &client.User{
+				Id:        123,
+				FirstName: "John",
+				Usernames: &client.Usernames{
+					ActiveUsernames: []string{
+						"johndoe",
+					},
+				},
+				PhoneNumber: "1234",
+			}
+			for method, err := range c.errs {
+				gw.Errors[method] = err
+			}
+			svc, _ := newTestService(gw, newStorageMem(), 10)
+			info, err := svc.Info(context.TODO())
+			assert.Equal(t, c.info, info)
+			assert.Equal(t, c.err, err != nil)
+		})
+	}
+}
+
+func TestService_handleChange(t *testing.T) {
+	cases := map[string]struct {
+		setup func(gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) (chg model.ChannelChange)
+		check func(t *testing.T, gw *telegram.GatewayFake, acc *pool.Account)
+	}{
+		"created is joined": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) (chg model.ChannelChange) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				chg.Type = model.ChannelChangeCreate
+				chg.Channel = &model.Channel{Id: gw.ChatId(1), Link: "@channel1"}
+				return
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, acc *pool.Account) {
+				assert.Equal(t, []int64{gw.ChatId(1)}, gw.Joined)
+				assert.NotNil(t, acc.ChansJoined[gw.ChatId(1)])
+			},
+		},
+		"last post update is ignored": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) (chg model.ChannelChange) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(1)})
+				gw.Calls = nil
+				acc.ChansJoined[gw.ChatId(1)] = &model.Channel{Id: gw.ChatId(1), Link: "@channel1"}
+				chg.Type = model.ChannelChangeUpdate
+				chg.Channel = &model.Channel{Id: gw.ChatId(1), Link: "@channel1", Last: time.Now()}
+				return
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, acc *pool.Account) {
+				assert.Empty(t, gw.Calls)
+			},
+		},
+		"moved to another label is left": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) (chg model.ChannelChange) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(1)})
+				acc.ChansJoined[gw.ChatId(1)] = &model.Channel{Id: gw.ChatId(1), Link: "@channel1"}
+				chg.Type = model.ChannelChangeUpdate
+				chg.Channel = &model.Channel{Id: gw.ChatId(1), Link: "@channel1", Label: "1"}
+				return
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, acc *pool.Account) {
+				assert.Empty(t, gw.Joined)
+				assert.Empty(t, acc.ChansJoined)
+			},
+		},
+		"deleted is left": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) (chg model.ChannelChange) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(1)})
+				acc.ChansJoined[gw.ChatId(1)] = &model.Channel{Id: gw.ChatId(1), Link: "@channel1"}
+				chg.Type = model.ChannelChangeDelete
+				chg.Before = &model.Channel{Id: gw.ChatId(1), Link: "@channel1"}
+				return
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, acc *pool.Account) {
+				assert.Empty(t, gw.Joined)
+				assert.Empty(t, acc.ChansJoined)
+			},
+		},
+		"deleted own channel is not left": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) (chg model.ChannelChange) {
+				c, _ := gw.CreateNewSupergroupChat(&client.CreateNewSupergroupChatRequest{Title: "Own", IsChannel: true})
+				acc.ChansJoined[c.Id] = &model.Channel{Id: c.Id, Link: "@own"}
+				chg.Type = model.ChannelChangeDelete
+				chg.Before = &model.Channel{Id: c.Id, Link: "@own"}
+				return
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, acc *pool.Account) {
+				assert.Len(t, gw.Joined, 1)
+				assert.NotContains(t, gw.Calls, "LeaveChat")
+				assert.Empty(t, acc.ChansJoined)
+			},
+		},
+		"deleted unknown refreshes": {
+			setup: func(gw *telegram.GatewayFake, stor *storageMem, acc *pool.Account) (chg model.ChannelChange) {
+				gw.AddSupergroup(1, "Channel 1", "channel1", true)
+				gw.AddSupergroup(2, "Channel 2", "channel2", true)
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(1)})
+				_, _ = gw.JoinChat(&client.JoinChatRequest{ChatId: gw.ChatId(2)})
+				_ = stor.Create(context.TODO(), model.Channel{Id: gw.ChatId(1), Link: "@channel1"})
+				chg.Type = model.ChannelChangeDelete
+				return
+			},
+			check: func(t *testing.T, gw *telegram.GatewayFake, acc *pool.Account) {
+				assert.Equal(t, []int64{gw.ChatId(1)}, gw.Joined)
+				assert.NotNil(t, acc.ChansJoined[gw.ChatId(1)])
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			gw := telegram.NewGatewayFake()
+			stor := newStorageMem()
+			svc, acc := newTestService(gw, stor, 10)
+			chg := c.setup(gw, stor, acc)
+			svc.handleChange(context.TODO(), acc, chg)
+			c.check(t, gw, acc)
+		})
+	}
+}
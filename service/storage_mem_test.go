@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/model"
+	"github.com/awakari/source-telegram/storage"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// storageMem is the minimal stateful storage for the service tests.
+type storageMem struct {
+	lock    *sync.Mutex
+	chans   map[string]model.Channel
+	deleted map[string]model.Channel
+	audit   []model.AuditRecord
+	// stats contains the totals only, AddStats fails when statsErr is set
+	stats    map[int64]model.ChannelCounters
+	statsErr error
+}
+
+func newStorageMem(chans ...model.Channel) *storageMem {
+	s := &storageMem{
+		lock:    &sync.Mutex{},
+		chans:   map[string]model.Channel{},
+		deleted: map[string]model.Channel{},
+		stats:   map[int64]model.ChannelCounters{},
+	}
+	for _, ch := range chans {
+		s.chans[ch.Link] = ch
+	}
+	return s
+}
+
+func (s *storageMem) Close() error {
+	return nil
+}
+
+func (s *storageMem) Ping(ctx context.Context) (err error) {
+	return
+}
+
+func (s *storageMem) Create(ctx context.Context, ch model.Channel) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.chans {
+		if c.Link == ch.Link || c.Id == ch.Id {
+			err = storage.ErrConflict
+			return
+		}
+	}
+	// the deleted channel with the same id or link is replaced like the real storage does
+	for link, c := range s.deleted {
+		if c.Link == ch.Link || c.Id == ch.Id {
+			delete(s.deleted, link)
+		}
+	}
+	s.chans[ch.Link] = ch
+	return
+}
+
+func (s *storageMem) Read(ctx context.Context, link string) (ch model.Channel, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var found bool
+	ch, found = s.chans[link]
+	if !found {
+		err = storage.ErrNotFound
+	}
+	return
+}
+
+func (s *storageMem) Update(ctx context.Context, link string, last time.Time) (err error) {
+	err = s.update(link, func(ch *model.Channel) {
+		ch.Last = last
+	})
+	return
+}
+
+func (s *storageMem) UpdateLink(ctx context.Context, id int64, link string) (linkOld string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = storage.ErrNotFound
+	for l, ch := range s.chans {
+		if ch.Id == id {
+			linkOld = l
+			delete(s.chans, l)
+			ch.Link = link
+			s.chans[link] = ch
+			// the deleted channel with the same link is replaced like the real storage does
+			delete(s.deleted, link)
+			err = nil
+			break
+		}
+	}
+	return
+}
+
+func (s *storageMem) SetStale(ctx context.Context, link string, since time.Time) (err error) {
+	err = s.update(link, func(ch *model.Channel) {
+		ch.Stale = since
+	})
+	return
+}
+
+func (s *storageMem) UpdateLabel(ctx context.Context, link, label string) (err error) {
+	err = s.update(link, func(ch *model.Channel) {
+		ch.Label = label
+	})
+	return
+}
+
+func (s *storageMem) update(link string, f func(ch *model.Channel)) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ch, found := s.chans[link]
+	switch found {
+	case true:
+		f(&ch)
+		s.chans[link] = ch
+	default:
+		err = storage.ErrNotFound
+	}
+	return
+}
+
+func (s *storageMem) CountByLabel(ctx context.Context) (counts map[string]int64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts = map[string]int64{}
+	for _, ch := range s.chans {
+		counts[ch.Label]++
+	}
+	return
+}
+
+func (s *storageMem) Delete(ctx context.Context, link, reason string) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ch, found := s.chans[link]
+	switch found {
+	case true:
+		delete(s.chans, link)
+		ch.Deleted = time.Now().UTC()
+		ch.DeleteReason = reason
+		s.deleted[link] = ch
+	default:
+		err = storage.ErrNotFound
+	}
+	return
+}
+
+func (s *storageMem) Restore(ctx context.Context, link string) (ch model.Channel, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var found bool
+	ch, found = s.deleted[link]
+	switch found {
+	case true:
+		delete(s.deleted, link)
+		ch.Deleted = time.Time{}
+		ch.DeleteReason = ""
+		s.chans[link] = ch
+	default:
+		err = storage.ErrNotFound
+	}
+	return
+}
+
+func (s *storageMem) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, ch := range s.chans {
+		if filter.Label != nil && ch.Label != *filter.Label {
+			continue
+		}
+		if filter.GroupId != "" && ch.GroupId != filter.GroupId {
+			continue
+		}
+		page = append(page, ch)
+	}
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].Link < page[j].Link
+	})
+	if uint32(len(page)) > limit {
+		page = page[:limit]
+	}
+	return
+}
+
+func (s *storageMem) Iterate(ctx context.Context, filter model.ChannelFilter, batchSize uint32, consume func(ch model.Channel) (err error)) (err error) {
+	var chans []model.Channel
+	chans, err = s.GetPage(ctx, filter, math.MaxUint32, "", model.OrderAsc)
+	for _, ch := range chans {
+		if err != nil {
+			break
+		}
+		err = consume(ch)
+	}
+	return
+}
+
+func (s *storageMem) Watch(ctx context.Context, lbl *string, consume func(chg model.ChannelChange) (err error)) (err error) {
+	err = storage.ErrWatchUnsupported
+	return
+}
+
+func (s *storageMem) AppendAudit(ctx context.Context, rec model.AuditRecord) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.audit = append(s.audit, rec)
+	return
+}
+
+func (s *storageMem) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := len(s.audit) - 1; i >= 0 && uint32(len(page)) < limit; i-- {
+		rec := s.audit[i]
+		if filter.Link == "" || rec.Link == filter.Link {
+			page = append(page, rec)
+		}
+	}
+	return
+}
+
+func (s *storageMem) AddStats(ctx context.Context, chanId int64, t time.Time, delta model.ChannelCounters) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = s.statsErr
+	if err == nil {
+		total := s.stats[chanId]
+		total.Add(delta)
+		s.stats[chanId] = total
+	}
+	return
+}
+
+func (s *storageMem) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats = map[int64]model.ChannelStatsHistory{}
+	for _, chanId := range chanIds {
+		if total, found := s.stats[chanId]; found {
+			stats[chanId] = model.ChannelStatsHistory{
+				ChannelId: chanId,
+				Total:     total,
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/awakari/source-telegram/model"
+	"github.com/segmentio/ksuid"
+	"sync"
+	"time"
+)
+
+// recAudit is the audit record stored by both backends. The id is the KSUID, so the records are ordered by the id the
+// same way as by the time, see auditIdSeq for the records of the same second.
+type recAudit struct {
+	Id           string    `bson:"_id"`
+	Time         time.Time `bson:"time"`
+	Action       string    `bson:"action"`
+	ChannelId    int64     `bson:"channelId"`
+	Link         string    `bson:"link"`
+	GroupId      string    `bson:"groupId,omitempty"`
+	UserId       string    `bson:"userId,omitempty"`
+	ActorGroupId string    `bson:"actorGroupId,omitempty"`
+	ActorUserId  string    `bson:"actorUserId,omitempty"`
+	ActorSystem  string    `bson:"actorSystem,omitempty"`
+	Reason       string    `bson:"reason,omitempty"`
+}
+
+const attrAuditId = "_id"
+const attrAuditTime = "time"
+const attrAuditChannelId = "channelId"
+const attrAuditLink = "link"
+const attrAuditGroupId = "groupId"
+const attrAuditUserId = "userId"
+const attrAuditActorGroupId = "actorGroupId"
+const attrAuditActorUserId = "actorUserId"
+
+// auditCollSuffix is appended to the channels collection name to get the audit collection name.
+const auditCollSuffix = "-audit"
+
+// auditIdSeq issues the audit record ids. The KSUID time has the 1 second precision, so the payload starts with the
+// sub-second part of the time, then the random part follows. The ids of the same second issued by the process are
+// increasing in addition, so the records appended at once are listed in the order of the appends.
+type auditIdSeq struct {
+	lock *sync.Mutex
+	last ksuid.KSUID
+}
+
+var auditIds = &auditIdSeq{
+	lock: &sync.Mutex{},
+}
+
+func (seq *auditIdSeq) next(t time.Time) (id ksuid.KSUID) {
+	id, _ = ksuid.NewRandomWithTime(t)
+	payload := id.Payload()
+	binary.BigEndian.PutUint32(payload, uint32(t.Nanosecond()))
+	id, _ = ksuid.FromParts(t, payload)
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+	if id.Timestamp() == seq.last.Timestamp() && ksuid.Compare(id, seq.last) <= 0 {
+		id = seq.last.Next()
+	}
+	seq.last = id
+	return
+}
+
+func encodeAudit(rec model.AuditRecord) (dst recAudit) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	if rec.Id == "" {
+		rec.Id = auditIds.next(rec.Time).String()
+	}
+	dst = recAudit{
+		Id:           rec.Id,
+		Time:         rec.Time.UTC(),
+		Action:       rec.Action.String(),
+		ChannelId:    rec.ChannelId,
+		Link:         rec.Link,
+		GroupId:      rec.GroupId,
+		UserId:       rec.UserId,
+		ActorGroupId: rec.Actor.GroupId,
+		ActorUserId:  rec.Actor.UserId,
+		ActorSystem:  rec.Actor.System,
+		Reason:       rec.Reason,
+	}
+	return
+}
+
+func (rec recAudit) decode() (dst model.AuditRecord) {
+	dst = model.AuditRecord{
+		Id:        rec.Id,
+		Time:      rec.Time.UTC(),
+		ChannelId: rec.ChannelId,
+		Link:      rec.Link,
+		GroupId:   rec.GroupId,
+		UserId:    rec.UserId,
+		Actor: model.Actor{
+			GroupId: rec.ActorGroupId,
+			UserId:  rec.ActorUserId,
+			System:  rec.ActorSystem,
+		},
+		Reason: rec.Reason,
+	}
+	for _, t := range []model.ChannelChangeType{model.ChannelChangeCreate, model.ChannelChangeUpdate, model.ChannelChangeDelete} {
+		if t.String() == rec.Action {
+			dst.Action = t
+			break
+		}
+	}
+	return
+}
+
+// matches is the in-process equivalent of the Mongo audit query: the user matches either the owner or the actor.
+func (rec recAudit) matches(filter model.AuditFilter) (ok bool) {
+	switch {
+	case filter.ChannelId != 0 && rec.ChannelId != filter.ChannelId:
+	case filter.Link != "" && rec.Link != filter.Link:
+	case filter.GroupId == "" && filter.UserId == "":
+		ok = true
+	default:
+		ok = matchesUser(rec.GroupId, rec.UserId, filter) || matchesUser(rec.ActorGroupId, rec.ActorUserId, filter)
+	}
+	return
+}
+
+func matchesUser(groupId, userId string, filter model.AuditFilter) bool {
+	return (filter.GroupId == "" || groupId == filter.GroupId) && (filter.UserId == "" || userId == filter.UserId)
+}
+
+// auditExpiration returns the audit record of the channel removed by the retention.
+func auditExpiration(t time.Time, rec recChan) model.AuditRecord {
+	return model.AuditRecord{
+		Time:      t,
+		Action:    model.ChannelChangeDelete,
+		ChannelId: rec.Id,
+		Link:      rec.Link,
+		GroupId:   rec.GroupId,
+		UserId:    rec.UserId,
+		Actor: model.Actor{
+			System: model.ActorSystemRetention,
+		},
+		Reason: fmt.Sprintf("no posts since %s", rec.Last.Format(time.DateOnly)),
+	}
+}
@@ -2,58 +2,167 @@ package storage
 
 import (
     "context"
+    "errors"
+    "fmt"
     "github.com/awakari/source-telegram/model"
+    "github.com/cenkalti/backoff/v4"
     "github.com/hashicorp/golang-lru/v2/expirable"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "sync/atomic"
     "time"
 )
 
+// localCache is the read-through cache of the channels by link. The channels missing in the storage are cached too.
+// The entries are invalidated on the own writes and on the changes watched in the storage, so the changes made by the
+// other replicas are seen as soon as notified. The TTL is the only bound for the staleness when the storage can't
+// watch the changes.
 type localCache struct {
-    stor  Storage
-    cache *expirable.LRU[string, model.Channel]
+    stor   Storage
+    cache  *expirable.LRU[string, cacheEntry]
+    gen    *atomic.Uint64
+    cancel context.CancelFunc
 }
 
+// cacheEntry is either the channel found or the negative entry for the missing one.
+type cacheEntry struct {
+    ch    model.Channel
+    found bool
+}
+
+const cacheResultHit = "hit"
+const cacheResultHitMissing = "hit_missing"
+const cacheResultMiss = "miss"
+
+const cacheSourceWrite = "write"
+const cacheSourceWatch = "watch"
+
+var metricCacheReads = promauto.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "awakari_source_telegram_channel_cache_reads_total",
+        Help: "Awakari source telegram: channel cache reads by result",
+    },
+    []string{"result"},
+)
+
+var metricCacheInvalidations = promauto.NewCounterVec(
+    prometheus.CounterOpts{
+        Name: "awakari_source_telegram_channel_cache_invalidations_total",
+        Help: "Awakari source telegram: channel cache invalidations by source",
+    },
+    []string{"source"},
+)
+
 func NewLocalCache(stor Storage, size int, ttl time.Duration) Storage {
-    c := expirable.NewLRU[string, model.Channel](size, nil, ttl)
-    return localCache{
-        stor:  stor,
-        cache: c,
+    ctx, cancel := context.WithCancel(context.Background())
+    lc := localCache{
+        stor:   stor,
+        cache:  expirable.NewLRU[string, cacheEntry](size, nil, ttl),
+        gen:    &atomic.Uint64{},
+        cancel: cancel,
     }
+    go lc.watchLoop(ctx)
+    return lc
 }
 
 func (lc localCache) Close() error {
+    lc.cancel()
     lc.cache.Purge()
     return lc.stor.Close()
 }
 
+func (lc localCache) Ping(ctx context.Context) (err error) {
+    return lc.stor.Ping(ctx)
+}
+
 func (lc localCache) Create(ctx context.Context, ch model.Channel) (err error) {
     err = lc.stor.Create(ctx, ch)
-    if err != nil {
-        lc.cache.Add(ch.Link, ch)
+    if err == nil {
+        // drop the negative entry if any, the stored channel is read through on demand
+        lc.remove(cacheSourceWrite, ch.Link)
     }
     return
 }
 
 func (lc localCache) Read(ctx context.Context, link string) (ch model.Channel, err error) {
-    var found bool
-    ch, found = lc.cache.Get(link)
-    if !found {
+    e, found := lc.cache.Get(link)
+    switch {
+    case !found:
+        metricCacheReads.WithLabelValues(cacheResultMiss).Inc()
+        gen := lc.gen.Load()
         ch, err = lc.stor.Read(ctx, link)
+        switch {
+        case lc.gen.Load() != gen:
+            // invalidated meanwhile, the result might be stale already
+        case err == nil && ch.Link == link:
+            // not cached by the former link, the rename would be missed otherwise
+            lc.cache.Add(link, cacheEntry{
+                ch:    ch,
+                found: true,
+            })
+        case errors.Is(err, ErrNotFound):
+            lc.cache.Add(link, cacheEntry{})
+        }
+    case e.found:
+        metricCacheReads.WithLabelValues(cacheResultHit).Inc()
+        ch = e.ch
+    default:
+        metricCacheReads.WithLabelValues(cacheResultHitMissing).Inc()
+        err = fmt.Errorf("%w by link %s", ErrNotFound, link)
     }
     return
 }
 
 func (lc localCache) Update(ctx context.Context, link string, last time.Time) (err error) {
     err = lc.stor.Update(ctx, link, last)
-    if err != nil {
-        lc.cache.Remove(link)
+    if err == nil {
+        lc.remove(cacheSourceWrite, link)
     }
     return
 }
 
-func (lc localCache) Delete(ctx context.Context, link string) (err error) {
-    err = lc.stor.Delete(ctx, link)
-    if err != nil {
-        lc.cache.Remove(link)
+func (lc localCache) SetStale(ctx context.Context, link string, since time.Time) (err error) {
+    err = lc.stor.SetStale(ctx, link, since)
+    if err == nil {
+        lc.remove(cacheSourceWrite, link)
+    }
+    return
+}
+
+func (lc localCache) UpdateLabel(ctx context.Context, link, label string) (err error) {
+    err = lc.stor.UpdateLabel(ctx, link, label)
+    if err == nil {
+        lc.remove(cacheSourceWrite, link)
+    }
+    return
+}
+
+func (lc localCache) CountByLabel(ctx context.Context) (counts map[string]int64, err error) {
+    return lc.stor.CountByLabel(ctx)
+}
+
+func (lc localCache) UpdateLink(ctx context.Context, id int64, link string) (linkOld string, err error) {
+    linkOld, err = lc.stor.UpdateLink(ctx, id, link)
+    if err == nil {
+        lc.remove(cacheSourceWrite, linkOld, link)
+    }
+    return
+}
+
+func (lc localCache) Delete(ctx context.Context, link, reason string) (err error) {
+    err = lc.stor.Delete(ctx, link, reason)
+    if err == nil {
+        // the entry by the current link is invalidated on the change notification if deleted by the former link
+        lc.remove(cacheSourceWrite, link)
+    }
+    return
+}
+
+func (lc localCache) Restore(ctx context.Context, link string) (ch model.Channel, err error) {
+    ch, err = lc.stor.Restore(ctx, link)
+    if err == nil {
+        // drop the negative entry if any
+        lc.remove(cacheSourceWrite, link)
     }
     return
 }
@@ -62,3 +171,89 @@ func (lc localCache) GetPage(ctx context.Context, filter model.ChannelFilter, li
     page, err = lc.stor.GetPage(ctx, filter, limit, cursor, order)
     return
 }
+
+func (lc localCache) Iterate(ctx context.Context, filter model.ChannelFilter, batchSize uint32, consume func(ch model.Channel) (err error)) (err error) {
+    return lc.stor.Iterate(ctx, filter, batchSize, consume)
+}
+
+func (lc localCache) Watch(ctx context.Context, lbl *string, consume func(chg model.ChannelChange) (err error)) (err error) {
+    return lc.stor.Watch(ctx, lbl, consume)
+}
+
+func (lc localCache) AppendAudit(ctx context.Context, rec model.AuditRecord) (err error) {
+    return lc.stor.AppendAudit(ctx, rec)
+}
+
+func (lc localCache) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+    return lc.stor.GetAuditPage(ctx, filter, limit, cursor)
+}
+
+func (lc localCache) AddStats(ctx context.Context, chanId int64, t time.Time, delta model.ChannelCounters) (err error) {
+    return lc.stor.AddStats(ctx, chanId, t, delta)
+}
+
+func (lc localCache) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+    return lc.stor.GetStats(ctx, chanIds, since)
+}
+
+// watchLoop invalidates the entries changed by anybody until closed. The cache is purged on every (re)start, the
+// changes might have been missed meanwhile.
+func (lc localCache) watchLoop(ctx context.Context) {
+    b := backoff.NewExponentialBackOff()
+    b.MaxElapsedTime = 0
+    _ = backoff.Retry(
+        func() (err error) {
+            lc.gen.Add(1)
+            lc.cache.Purge()
+            err = lc.stor.Watch(ctx, nil, lc.invalidate)
+            if errors.Is(err, ErrWatchUnsupported) {
+                err = backoff.Permanent(err)
+            }
+            return
+        },
+        backoff.WithContext(b, ctx),
+    )
+}
+
+func (lc localCache) invalidate(chg model.ChannelChange) (err error) {
+    switch {
+    case chg.Channel == nil && chg.Before == nil:
+        // deleted, but it's unknown which one
+        lc.gen.Add(1)
+        lc.cache.Purge()
+        metricCacheInvalidations.WithLabelValues(cacheSourceWatch).Inc()
+    case chg.Before == nil && chg.Channel != nil:
+        // the former link is unknown
+        lc.removeById(cacheSourceWatch, chg.Channel.Link, chg.Channel.Id)
+    default:
+        var links []string
+        for _, ch := range []*model.Channel{chg.Before, chg.Channel} {
+            if ch != nil {
+                links = append(links, ch.Link)
+            }
+        }
+        lc.remove(cacheSourceWatch, links...)
+    }
+    return
+}
+
+func (lc localCache) remove(src string, links ...string) {
+    lc.gen.Add(1)
+    for _, link := range links {
+        lc.cache.Remove(link)
+    }
+    metricCacheInvalidations.WithLabelValues(src).Inc()
+}
+
+// removeById removes the entry by the link and any other entry of the same channel, e.g. by the link before rename.
+func (lc localCache) removeById(src, link string, id int64) {
+    lc.gen.Add(1)
+    lc.cache.Remove(link)
+    for _, k := range lc.cache.Keys() {
+        e, found := lc.cache.Peek(k)
+        if found && e.found && e.ch.Id == id {
+            lc.cache.Remove(k)
+        }
+    }
+    metricCacheInvalidations.WithLabelValues(src).Inc()
+}
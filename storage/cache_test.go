@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/model"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// storageReadCounter counts the reads reaching the underlying storage.
+type storageReadCounter struct {
+	Storage
+	reads *atomic.Int64
+}
+
+func (s storageReadCounter) Read(ctx context.Context, link string) (ch model.Channel, err error) {
+	s.reads.Add(1)
+	return s.Storage.Read(ctx, link)
+}
+
+func newTestLocalCache(t *testing.T) (lc Storage, stor Storage, reads *atomic.Int64) {
+	stor = newTestStorageBolt(t, 0)
+	reads = &atomic.Int64{}
+	lc = NewLocalCache(storageReadCounter{Storage: stor, reads: reads}, 10, time.Minute)
+	t.Cleanup(func() {
+		lc.(localCache).cancel()
+	})
+	// the cache is purged when the watch starts
+	require.Eventually(t, func() bool {
+		sb := stor.(storageBolt)
+		sb.watchers.lock.Lock()
+		defer sb.watchers.lock.Unlock()
+		return len(sb.watchers.subs) == 1
+	}, time.Second, 10*time.Millisecond)
+	return
+}
+
+func TestLocalCache_Read(t *testing.T) {
+	ctx := context.TODO()
+	lc, stor, reads := newTestLocalCache(t)
+	require.Nil(t, stor.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	_, err := stor.UpdateLink(ctx, -1001, "https://t.me/chan1")
+	require.Nil(t, err)
+	hits := testutil.ToFloat64(metricCacheReads.WithLabelValues(cacheResultHit))
+	hitsMissing := testutil.ToFloat64(metricCacheReads.WithLabelValues(cacheResultHitMissing))
+	misses := testutil.ToFloat64(metricCacheReads.WithLabelValues(cacheResultMiss))
+	cases := map[string]struct {
+		link  string
+		err   error
+		reads int64
+	}{
+		"found": {
+			link:  "https://t.me/chan1",
+			reads: 1,
+		},
+		"missing": {
+			link:  "https://t.me/chan2",
+			err:   ErrNotFound,
+			reads: 1,
+		},
+		"former link is not cached": {
+			link:  "https://t.me/chan0",
+			reads: 2,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			readsBefore := reads.Load()
+			for i := 0; i < 2; i++ {
+				ch, err := lc.Read(ctx, c.link)
+				assert.ErrorIs(t, err, c.err)
+				if c.err == nil {
+					assert.Equal(t, int64(-1001), ch.Id)
+				}
+			}
+			assert.Equal(t, c.reads, reads.Load()-readsBefore)
+		})
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricCacheReads.WithLabelValues(cacheResultHit))-hits)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricCacheReads.WithLabelValues(cacheResultHitMissing))-hitsMissing)
+	assert.Equal(t, float64(4), testutil.ToFloat64(metricCacheReads.WithLabelValues(cacheResultMiss))-misses)
+}
+
+func TestLocalCache_Write(t *testing.T) {
+	ctx := context.TODO()
+	lc, _, _ := newTestLocalCache(t)
+	// negative entry is dropped on create
+	_, err := lc.Read(ctx, "https://t.me/chan0")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Nil(t, lc.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	_, err = lc.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	// cached entry is dropped on update
+	require.Nil(t, lc.UpdateLabel(ctx, "https://t.me/chan0", "1"))
+	ch, err := lc.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, "1", ch.Label)
+	// and on rename
+	_, err = lc.UpdateLink(ctx, -1001, "https://t.me/chan1")
+	require.Nil(t, err)
+	ch, err = lc.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, "https://t.me/chan1", ch.Link)
+	_, err = lc.Read(ctx, "https://t.me/chan1")
+	require.Nil(t, err)
+	// and on delete
+	require.Nil(t, lc.Delete(ctx, "https://t.me/chan1", ""))
+	_, err = lc.Read(ctx, "https://t.me/chan1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalCache_Watch(t *testing.T) {
+	ctx := context.TODO()
+	lc, stor, _ := newTestLocalCache(t)
+	// the changes below are made by "another replica", bypassing the cache
+	invalidations := testutil.ToFloat64(metricCacheInvalidations.WithLabelValues(cacheSourceWatch))
+	require.Nil(t, stor.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	require.Eventually(t, func() bool {
+		_, err := lc.Read(ctx, "https://t.me/chan0")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	require.Nil(t, stor.UpdateLabel(ctx, "https://t.me/chan0", "1"))
+	assert.Eventually(t, func() bool {
+		ch, err := lc.Read(ctx, "https://t.me/chan0")
+		return err == nil && ch.Label == "1"
+	}, time.Second, 10*time.Millisecond)
+	require.Nil(t, stor.Delete(ctx, "https://t.me/chan0", ""))
+	assert.Eventually(t, func() bool {
+		_, err := lc.Read(ctx, "https://t.me/chan0")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.LessOrEqual(t, float64(3), testutil.ToFloat64(metricCacheInvalidations.WithLabelValues(cacheSourceWatch))-invalidations)
+}
+
+func TestLocalCache_invalidate(t *testing.T) {
+	// no watch loop purging the cache
+	lc := localCache{
+		stor:  NewStorageMock(),
+		cache: expirable.NewLRU[string, cacheEntry](10, nil, time.Minute),
+		gen:   &atomic.Uint64{},
+	}
+	ch0 := model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}
+	ch1 := model.Channel{
+		Id:   -1002,
+		Link: "https://t.me/chan1",
+	}
+	cases := map[string]struct {
+		chg  model.ChannelChange
+		keys []string
+	}{
+		"by link": {
+			chg: model.ChannelChange{
+				Type:    model.ChannelChangeUpdate,
+				Channel: &ch0,
+				Before:  &ch0,
+			},
+			keys: []string{"https://t.me/chan1", "https://t.me/chan2"},
+		},
+		"renamed without the former link": {
+			chg: model.ChannelChange{
+				Type: model.ChannelChangeUpdate,
+				Channel: &model.Channel{
+					Id:   -1001,
+					Link: "https://t.me/chan3",
+				},
+			},
+			keys: []string{"https://t.me/chan1", "https://t.me/chan2"},
+		},
+		"unknown deleted": {
+			chg: model.ChannelChange{
+				Type: model.ChannelChangeDelete,
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			lc.cache.Purge()
+			lc.cache.Add(ch0.Link, cacheEntry{ch: ch0, found: true})
+			lc.cache.Add(ch1.Link, cacheEntry{ch: ch1, found: true})
+			lc.cache.Add("https://t.me/chan2", cacheEntry{})
+			assert.Nil(t, lc.invalidate(c.chg))
+			assert.ElementsMatch(t, c.keys, lc.cache.Keys())
+		})
+	}
+}
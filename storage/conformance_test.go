@@ -0,0 +1,962 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-telegram/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConformance pins the semantics shared by every Storage backend, newStor should return an empty storage and
+// release it when the test is complete.
+func testConformance(t *testing.T, newStor func(t *testing.T) Storage) {
+	suite := map[string]func(t *testing.T, s Storage){
+		"create":       conformanceCreate,
+		"read":         conformanceRead,
+		"update":       conformanceUpdate,
+		"update link":  conformanceUpdateLink,
+		"set stale":    conformanceSetStale,
+		"update label": conformanceUpdateLabel,
+		"delete":       conformanceDelete,
+		"restore":      conformanceRestore,
+		"get page":     conformanceGetPage,
+		"iterate":      conformanceIterate,
+		"search":       conformanceSearch,
+		"audit":        conformanceAudit,
+		"audit order":  conformanceAuditOrder,
+		"stats":        conformanceStats,
+	}
+	for k, test := range suite {
+		t.Run(k, func(t *testing.T) {
+			test(t, newStor(t))
+		})
+	}
+}
+
+// the backends may keep the milliseconds only
+var conformanceTime = time.Date(2024, 11, 4, 18, 49, 25, 123_000_000, time.UTC)
+
+func conformanceCreate(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	cases := map[string]struct {
+		in  model.Channel
+		err error
+	}{
+		"ok": {
+			in: model.Channel{
+				Id:   -1002,
+				Link: "https://t.me/chan1",
+			},
+		},
+		"dup id": {
+			in: model.Channel{
+				Id:   -1001,
+				Link: "https://t.me/chan2",
+			},
+			err: ErrConflict,
+		},
+		"dup link": {
+			in: model.Channel{
+				Id:   -1003,
+				Link: "https://t.me/chan0",
+			},
+			err: ErrConflict,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			err := s.Create(ctx, c.in)
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+	// all the fields are kept, e.g. when imported
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:    -1004,
+		Link:  "https://t.me/chan4",
+		Stale: conformanceTime,
+	}))
+	ch, err := s.Read(ctx, "https://t.me/chan4")
+	require.Nil(t, err)
+	assert.Equal(t, conformanceTime, ch.Stale)
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:           -1005,
+		Link:         "https://t.me/chan5",
+		Deleted:      time.Now().UTC(),
+		DeleteReason: "reason0",
+	}))
+	_, err = s.Read(ctx, "https://t.me/chan5")
+	assert.ErrorIs(t, err, ErrNotFound)
+	ch, err = s.Restore(ctx, "https://t.me/chan5")
+	require.Nil(t, err)
+	assert.Equal(t, int64(-1005), ch.Id)
+}
+
+func conformanceRead(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	ch := model.Channel{
+		Id:         -1001,
+		GroupId:    "group0",
+		UserId:     "user0",
+		Name:       "Channel 0",
+		Link:       "https://t.me/chan0",
+		Created:    conformanceTime,
+		Last:       conformanceTime.Add(time.Minute),
+		SubId:      "sub0",
+		Terms:      "foo bar",
+		Label:      "1",
+		Discussion: true,
+	}
+	require.Nil(t, s.Create(ctx, ch))
+	cases := map[string]struct {
+		link string
+		out  model.Channel
+		err  error
+	}{
+		"ok": {
+			link: "https://t.me/chan0",
+			out:  ch,
+		},
+		"missing": {
+			link: "https://t.me/chan1",
+			err:  ErrNotFound,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			out, err := s.Read(ctx, c.link)
+			assert.Equal(t, c.out, out)
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func conformanceUpdate(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+		Last: conformanceTime,
+	}))
+	last := time.Now().UTC().Truncate(time.Millisecond)
+	require.Nil(t, s.Update(ctx, "https://t.me/chan0", last))
+	ch, err := s.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, last, ch.Last)
+	err = s.Update(ctx, "https://t.me/chan1", last)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func conformanceUpdateLink(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Name: "Channel 0",
+		Link: "https://t.me/chan0",
+	}))
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1002,
+		Link: "https://t.me/chan2",
+	}))
+	linkOld, err := s.UpdateLink(ctx, -1001, "https://t.me/chan1")
+	require.Nil(t, err)
+	assert.Equal(t, "https://t.me/chan0", linkOld)
+	// the former link is resolved still
+	for _, link := range []string{"https://t.me/chan0", "https://t.me/chan1"} {
+		ch, err := s.Read(ctx, link)
+		require.Nil(t, err)
+		assert.Equal(t, int64(-1001), ch.Id)
+		assert.Equal(t, "https://t.me/chan1", ch.Link)
+		assert.Equal(t, "Channel 0", ch.Name)
+	}
+	// renamed back
+	linkOld, err = s.UpdateLink(ctx, -1001, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, "https://t.me/chan1", linkOld)
+	ch, err := s.Read(ctx, "https://t.me/chan1")
+	require.Nil(t, err)
+	assert.Equal(t, "https://t.me/chan0", ch.Link)
+	//
+	_, err = s.UpdateLink(ctx, -1003, "https://t.me/chan3")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.UpdateLink(ctx, -1001, "https://t.me/chan2")
+	assert.ErrorIs(t, err, ErrConflict)
+	// the channel is deleted by the former link too
+	require.Nil(t, s.Delete(ctx, "https://t.me/chan1", ""))
+	_, err = s.Read(ctx, "https://t.me/chan0")
+	assert.ErrorIs(t, err, ErrNotFound)
+	// the deleted channel is kept when the renamed one is missing
+	_, err = s.UpdateLink(ctx, -1003, "https://t.me/chan0")
+	assert.ErrorIs(t, err, ErrNotFound)
+	// the link of the deleted channel is taken over
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1003,
+		Link: "https://t.me/chan3",
+	}))
+	linkOld, err = s.UpdateLink(ctx, -1003, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, "https://t.me/chan3", linkOld)
+	ch, err = s.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, int64(-1003), ch.Id)
+	// the deleted channel is replaced
+	_, err = s.Restore(ctx, "https://t.me/chan0")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func conformanceSetStale(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	require.Nil(t, s.SetStale(ctx, "https://t.me/chan0", conformanceTime))
+	ch, err := s.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, conformanceTime, ch.Stale)
+	require.Nil(t, s.SetStale(ctx, "https://t.me/chan0", time.Time{}))
+	ch, err = s.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.True(t, ch.Stale.IsZero())
+	err = s.SetStale(ctx, "https://t.me/chan1", conformanceTime)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func conformanceUpdateLabel(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	for i, lbl := range []string{"", "1", "1"} {
+		require.Nil(t, s.Create(ctx, model.Channel{
+			Id:    int64(-1000 - i),
+			Link:  "https://t.me/chan" + strconv.Itoa(i),
+			Label: lbl,
+		}))
+	}
+	counts, err := s.CountByLabel(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"": 1, "1": 2}, counts)
+	require.Nil(t, s.UpdateLabel(ctx, "https://t.me/chan0", "2"))
+	require.Nil(t, s.UpdateLabel(ctx, "https://t.me/chan1", ""))
+	counts, err = s.CountByLabel(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"": 1, "1": 1, "2": 1}, counts)
+	ch, err := s.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, "2", ch.Label)
+	err = s.UpdateLabel(ctx, "https://t.me/chan3", "1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func conformanceDelete(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	require.Nil(t, s.Delete(ctx, "https://t.me/chan0", ""))
+	_, err := s.Read(ctx, "https://t.me/chan0")
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = s.Delete(ctx, "https://t.me/chan0", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+	// the id is free again
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan1",
+	}))
+}
+
+func conformanceRestore(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	src := model.Channel{
+		Id:         -1001,
+		GroupId:    "group0",
+		UserId:     "user0",
+		Name:       "chan0",
+		Link:       "https://t.me/chan0",
+		SubId:      "sub0",
+		Terms:      "foo bar",
+		Created:    conformanceTime,
+		Last:       conformanceTime,
+		Label:      "1",
+		Discussion: true,
+	}
+	require.Nil(t, s.Create(ctx, src))
+	_, err := s.Restore(ctx, src.Link)
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.Nil(t, s.Delete(ctx, src.Link, "reason0"))
+	_, err = s.Read(ctx, src.Link)
+	assert.ErrorIs(t, err, ErrNotFound)
+	// the deleted channels are hidden unless requested
+	page, err := s.GetPage(ctx, model.ChannelFilter{}, 10, "", model.OrderAsc)
+	require.Nil(t, err)
+	assert.Empty(t, page)
+	page, err = s.GetPage(ctx, model.ChannelFilter{Deleted: true}, 10, "", model.OrderAsc)
+	require.Nil(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "reason0", page[0].DeleteReason)
+	assert.False(t, page[0].Deleted.IsZero())
+	counts, err := s.CountByLabel(ctx)
+	require.Nil(t, err)
+	assert.Zero(t, counts["1"])
+	assert.ErrorIs(t, s.Update(ctx, src.Link, time.Now()), ErrNotFound)
+	//
+	ch, err := s.Restore(ctx, src.Link)
+	require.Nil(t, err)
+	assert.Equal(t, src, ch)
+	ch, err = s.Read(ctx, src.Link)
+	require.Nil(t, err)
+	assert.Equal(t, src.Label, ch.Label)
+	assert.True(t, ch.Deleted.IsZero())
+	assert.Empty(t, ch.DeleteReason)
+	_, err = s.Restore(ctx, src.Link)
+	assert.ErrorIs(t, err, ErrNotFound)
+	// the new channel replaces the deleted one with the same id
+	require.Nil(t, s.Delete(ctx, src.Link, ""))
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan1",
+	}))
+	_, err = s.Restore(ctx, src.Link)
+	assert.ErrorIs(t, err, ErrNotFound)
+	// the deleted channel is kept when the new one conflicts with another active channel, e.g. the import overwrite
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1002,
+		Link: "https://t.me/chan2",
+	}))
+	require.Nil(t, s.Delete(ctx, "https://t.me/chan1", "overwritten"))
+	err = s.Create(ctx, model.Channel{
+		Id:   -1002,
+		Link: "https://t.me/chan1",
+	})
+	assert.ErrorIs(t, err, ErrConflict)
+	ch, err = s.Restore(ctx, "https://t.me/chan1")
+	require.Nil(t, err)
+	assert.Equal(t, int64(-1001), ch.Id)
+}
+
+func conformanceGetPage(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	chans := []model.Channel{
+		{
+			Id:      -1000,
+			GroupId: "group0",
+			UserId:  "user0",
+			Name:    "Foo",
+			Link:    "https://t.me/chan0",
+		},
+		{
+			Id:      -1001,
+			GroupId: "group0",
+			UserId:  "user1",
+			Name:    "Bar",
+			Link:    "https://t.me/chan1",
+			Label:   "1",
+		},
+		{
+			Id:      -1002,
+			GroupId: "group0",
+			UserId:  "user0",
+			Name:    "Baz",
+			Link:    "https://t.me/chan2",
+			SubId:   "sub0",
+			Label:   "1",
+		},
+		{
+			Id:      -1003,
+			GroupId: "group1",
+			UserId:  "user0",
+			Name:    "Foo bar",
+			Link:    "https://t.me/chan3",
+		},
+	}
+	for _, ch := range chans {
+		require.Nil(t, s.Create(ctx, ch))
+	}
+	lblDefault := ""
+	lbl1 := "1"
+	cases := map[string]struct {
+		filter model.ChannelFilter
+		limit  uint32
+		cursor string
+		order  model.Order
+		links  []string
+	}{
+		"all": {
+			limit: 10,
+			links: []string{"https://t.me/chan0", "https://t.me/chan1", "https://t.me/chan2", "https://t.me/chan3"},
+		},
+		"limit": {
+			limit: 2,
+			links: []string{"https://t.me/chan0", "https://t.me/chan1"},
+		},
+		"cursor": {
+			limit:  2,
+			cursor: "https://t.me/chan1",
+			links:  []string{"https://t.me/chan2", "https://t.me/chan3"},
+		},
+		"desc": {
+			limit:  2,
+			cursor: "https://t.me/chan3",
+			order:  model.OrderDesc,
+			links:  []string{"https://t.me/chan2", "https://t.me/chan1"},
+		},
+		"default label": {
+			filter: model.ChannelFilter{
+				Label: &lblDefault,
+			},
+			limit: 10,
+			links: []string{"https://t.me/chan0", "https://t.me/chan3"},
+		},
+		"label": {
+			filter: model.ChannelFilter{
+				Label: &lbl1,
+			},
+			limit: 10,
+			links: []string{"https://t.me/chan1", "https://t.me/chan2"},
+		},
+		"user": {
+			filter: model.ChannelFilter{
+				GroupId: "group0",
+				UserId:  "user0",
+			},
+			limit: 10,
+			links: []string{"https://t.me/chan0", "https://t.me/chan2"},
+		},
+		"sub": {
+			filter: model.ChannelFilter{
+				SubId: "sub0",
+			},
+			limit: 10,
+			links: []string{"https://t.me/chan2"},
+		},
+		"pattern link": {
+			filter: model.ChannelFilter{
+				Pattern: "chan[12]$",
+				Regex:   true,
+			},
+			limit: 10,
+			links: []string{"https://t.me/chan1", "https://t.me/chan2"},
+		},
+		"pattern name": {
+			filter: model.ChannelFilter{
+				Pattern: "^Foo",
+				Regex:   true,
+			},
+			limit: 10,
+			links: []string{"https://t.me/chan0", "https://t.me/chan3"},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			page, err := s.GetPage(ctx, c.filter, c.limit, c.cursor, c.order)
+			require.Nil(t, err)
+			var links []string
+			for _, ch := range page {
+				links = append(links, ch.Link)
+			}
+			assert.Equal(t, c.links, links)
+		})
+	}
+}
+
+func conformanceIterate(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	var links []string
+	for i := 0; i < 5; i++ {
+		ch := model.Channel{
+			Id:      int64(-1000 - i),
+			GroupId: "group0",
+			UserId:  "user0",
+			Name:    "Channel " + strconv.Itoa(i),
+			Link:    "https://t.me/chan" + strconv.Itoa(i),
+			Created: conformanceTime,
+			Last:    conformanceTime.Add(time.Duration(i) * time.Minute),
+			SubId:   "sub" + strconv.Itoa(i),
+			Terms:   "foo bar",
+		}
+		if i%2 == 1 {
+			ch.Label = "1"
+		}
+		require.Nil(t, s.Create(ctx, ch))
+		links = append(links, ch.Link)
+	}
+	lbl1 := "1"
+	errStop := errors.New("stop")
+	cases := map[string]struct {
+		filter    model.ChannelFilter
+		batchSize uint32
+		stopAt    int
+		links     []string
+		err       error
+	}{
+		"all by 2": {
+			batchSize: 2,
+			links:     links,
+		},
+		"all by exact count": {
+			batchSize: 5,
+			links:     links,
+		},
+		"default batch size": {
+			links: links,
+		},
+		"label": {
+			filter: model.ChannelFilter{
+				Label: &lbl1,
+			},
+			batchSize: 1,
+			links:     []string{"https://t.me/chan1", "https://t.me/chan3"},
+		},
+		"consumer failure": {
+			batchSize: 2,
+			stopAt:    3,
+			links:     links[:3],
+			err:       errStop,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var got []string
+			err := s.Iterate(ctx, c.filter, c.batchSize, func(ch model.Channel) (err error) {
+				got = append(got, ch.Link)
+				if c.stopAt > 0 && len(got) == c.stopAt {
+					err = errStop
+				}
+				return
+			})
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.links, got)
+		})
+	}
+	// full projection
+	err := s.Iterate(ctx, model.ChannelFilter{Label: &lbl1}, 1, func(ch model.Channel) (err error) {
+		assert.Equal(t, "group0", ch.GroupId)
+		assert.Equal(t, "user0", ch.UserId)
+		assert.Equal(t, conformanceTime, ch.Created)
+		assert.False(t, ch.Last.IsZero())
+		assert.NotEmpty(t, ch.SubId)
+		assert.Equal(t, "foo bar", ch.Terms)
+		assert.Equal(t, "1", ch.Label)
+		return
+	})
+	require.Nil(t, err)
+	// the consumer may delete the channels meanwhile
+	var got []string
+	err = s.Iterate(ctx, model.ChannelFilter{}, 2, func(ch model.Channel) (err error) {
+		got = append(got, ch.Link)
+		err = s.Delete(ctx, ch.Link, "")
+		return
+	})
+	require.Nil(t, err)
+	assert.Equal(t, links, got)
+}
+
+func conformanceSearch(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	chans := []model.Channel{
+		{
+			Id:    -1000,
+			Name:  "Astronomy (daily)",
+			Link:  "https://t.me/astro_daily",
+			Terms: "space telescope",
+		},
+		{
+			Id:    -1001,
+			Name:  "a.b news",
+			Link:  "https://t.me/ab_news",
+			Terms: "astronomy",
+		},
+		{
+			Id:   -1002,
+			Name: "axb [test]",
+			Link: "https://t.me/axb",
+		},
+		{
+			Id:    -1003,
+			Name:  "Space",
+			Link:  "https://t.me/space",
+			Terms: "astronomy telescope",
+		},
+	}
+	for _, ch := range chans {
+		require.Nil(t, s.Create(ctx, ch))
+	}
+	cases := map[string]struct {
+		filter model.ChannelFilter
+		cursor string
+		links  []string
+		err    error
+	}{
+		"literal dot": {
+			filter: model.ChannelFilter{
+				Pattern: "a.b",
+			},
+			links: []string{"https://t.me/ab_news"},
+		},
+		"literal parenthesis": {
+			filter: model.ChannelFilter{
+				Pattern: "(daily",
+			},
+			links: []string{"https://t.me/astro_daily"},
+		},
+		"literal bracket": {
+			filter: model.ChannelFilter{
+				Pattern: "[test",
+			},
+			links: []string{"https://t.me/axb"},
+		},
+		"literal regex is not matched": {
+			filter: model.ChannelFilter{
+				Pattern: "^a.*$",
+			},
+		},
+		"literal backslash": {
+			filter: model.ChannelFilter{
+				Pattern: `\`,
+			},
+		},
+		"case sensitive by default": {
+			filter: model.ChannelFilter{
+				Pattern: "SPACE",
+			},
+		},
+		"ignore case": {
+			filter: model.ChannelFilter{
+				Pattern:    "SPACE",
+				IgnoreCase: true,
+			},
+			links: []string{"https://t.me/space"},
+		},
+		"regex": {
+			filter: model.ChannelFilter{
+				Pattern: "^a.b",
+				Regex:   true,
+			},
+			links: []string{"https://t.me/ab_news", "https://t.me/axb"},
+		},
+		"regex ignore case": {
+			filter: model.ChannelFilter{
+				Pattern:    "^ASTRO",
+				Regex:      true,
+				IgnoreCase: true,
+			},
+			links: []string{"https://t.me/astro_daily"},
+		},
+		"invalid regex": {
+			filter: model.ChannelFilter{
+				Pattern: "(a",
+				Regex:   true,
+			},
+			err: ErrInvalidFilter,
+		},
+		"backtracking regex": {
+			filter: model.ChannelFilter{
+				Pattern: `(a)\1`,
+				Regex:   true,
+			},
+			err: ErrInvalidFilter,
+		},
+		"nested quantifiers": {
+			filter: model.ChannelFilter{
+				Pattern: `(a+)+$`,
+				Regex:   true,
+			},
+			err: ErrInvalidFilter,
+		},
+		"nested counted quantifiers": {
+			filter: model.ChannelFilter{
+				Pattern: `(?:x(ab){2,})*`,
+				Regex:   true,
+			},
+			err: ErrInvalidFilter,
+		},
+		"sequential quantifiers": {
+			filter: model.ChannelFilter{
+				Pattern: `^a+.?b*`,
+				Regex:   true,
+			},
+			links: []string{"https://t.me/ab_news", "https://t.me/axb"},
+		},
+		"too long pattern": {
+			filter: model.ChannelFilter{
+				Pattern: strings.Repeat("a", PatternLenMax+1),
+			},
+			err: ErrInvalidFilter,
+		},
+		"text ranked": {
+			filter: model.ChannelFilter{
+				Text: "Astronomy",
+			},
+			// the name match outweighs the terms one
+			links: []string{"https://t.me/astro_daily", "https://t.me/ab_news", "https://t.me/space"},
+		},
+		"text any word": {
+			filter: model.ChannelFilter{
+				Text: "telescope news",
+			},
+			links: []string{"https://t.me/ab_news", "https://t.me/astro_daily", "https://t.me/space"},
+		},
+		"text and pattern": {
+			filter: model.ChannelFilter{
+				Text:    "telescope",
+				Pattern: "Space",
+			},
+			links: []string{"https://t.me/space"},
+		},
+		"text single page": {
+			filter: model.ChannelFilter{
+				Text: "Astronomy",
+			},
+			cursor: "https://t.me/astro_daily",
+		},
+		"text not found": {
+			filter: model.ChannelFilter{
+				Text: "weather",
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			page, err := s.GetPage(ctx, c.filter, 10, c.cursor, model.OrderAsc)
+			assert.ErrorIs(t, err, c.err)
+			var links []string
+			for _, ch := range page {
+				links = append(links, ch.Link)
+			}
+			assert.Equal(t, c.links, links)
+		})
+	}
+}
+
+func conformanceAudit(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	recs := []model.AuditRecord{
+		{
+			Action:    model.ChannelChangeCreate,
+			ChannelId: -1001,
+			Link:      "https://t.me/chan0",
+			GroupId:   "group0",
+			UserId:    "user0",
+			Actor: model.Actor{
+				GroupId: "group0",
+				UserId:  "user0",
+			},
+		},
+		{
+			Action:    model.ChannelChangeCreate,
+			ChannelId: -1002,
+			Link:      "https://t.me/chan1",
+			GroupId:   "group0",
+			Actor: model.Actor{
+				GroupId: "group0",
+			},
+			Reason: "found by the search: foo",
+		},
+		{
+			Action:    model.ChannelChangeUpdate,
+			ChannelId: -1001,
+			Link:      "https://t.me/chan2",
+			GroupId:   "group0",
+			UserId:    "user0",
+			Actor: model.Actor{
+				System: model.ActorSystemRename,
+			},
+			Reason: "renamed from https://t.me/chan0",
+		},
+		{
+			Action:    model.ChannelChangeDelete,
+			ChannelId: -1002,
+			Link:      "https://t.me/chan1",
+			GroupId:   "group0",
+			Actor: model.Actor{
+				GroupId: "group1",
+				UserId:  "user1",
+			},
+		},
+	}
+	for i, rec := range recs {
+		rec.Time = conformanceTime.Add(time.Duration(i) * time.Second)
+		require.Nil(t, s.AppendAudit(ctx, rec))
+	}
+	cases := map[string]struct {
+		filter model.AuditFilter
+		limit  uint32
+		cursor int // index of the last record returned before, -1 for none
+		out    []int
+	}{
+		"by channel id": {
+			filter: model.AuditFilter{
+				ChannelId: -1001,
+			},
+			limit:  10,
+			cursor: -1,
+			out:    []int{2, 0},
+		},
+		"by link": {
+			filter: model.AuditFilter{
+				Link: "https://t.me/chan1",
+			},
+			limit:  10,
+			cursor: -1,
+			out:    []int{3, 1},
+		},
+		"by owner or actor": {
+			filter: model.AuditFilter{
+				GroupId: "group0",
+				UserId:  "user0",
+			},
+			limit:  10,
+			cursor: -1,
+			out:    []int{2, 0},
+		},
+		"by actor only": {
+			filter: model.AuditFilter{
+				UserId: "user1",
+			},
+			limit:  10,
+			cursor: -1,
+			out:    []int{3},
+		},
+		"by group, first page": {
+			filter: model.AuditFilter{
+				GroupId: "group0",
+			},
+			limit:  2,
+			cursor: -1,
+			out:    []int{3, 2},
+		},
+		"by group, next page": {
+			filter: model.AuditFilter{
+				GroupId: "group0",
+			},
+			limit:  2,
+			cursor: 2,
+			out:    []int{1, 0},
+		},
+	}
+	all, err := s.GetAuditPage(ctx, model.AuditFilter{}, 10, "")
+	require.Nil(t, err)
+	require.Len(t, all, len(recs))
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var cursor string
+			if c.cursor >= 0 {
+				cursor = all[len(all)-1-c.cursor].Id
+			}
+			page, err := s.GetAuditPage(ctx, c.filter, c.limit, cursor)
+			require.Nil(t, err)
+			require.Len(t, page, len(c.out))
+			for i, j := range c.out {
+				expected := recs[j]
+				expected.Id = page[i].Id
+				expected.Time = conformanceTime.Add(time.Duration(j) * time.Second)
+				assert.Equal(t, expected, page[i])
+			}
+		})
+	}
+}
+
+func conformanceAuditOrder(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	// the same second
+	for i := 0; i < 10; i++ {
+		require.Nil(t, s.AppendAudit(ctx, model.AuditRecord{
+			Time:      conformanceTime,
+			Action:    model.ChannelChangeUpdate,
+			ChannelId: -1001,
+			Link:      fmt.Sprintf("https://t.me/chan%d", i),
+		}))
+	}
+	var links []string
+	var cursor string
+	for {
+		page, err := s.GetAuditPage(ctx, model.AuditFilter{}, 3, cursor)
+		require.Nil(t, err)
+		if len(page) == 0 {
+			break
+		}
+		for _, rec := range page {
+			assert.Equal(t, conformanceTime, rec.Time)
+			links = append(links, rec.Link)
+		}
+		cursor = page[len(page)-1].Id
+	}
+	var expected []string
+	for i := 9; i >= 0; i-- {
+		expected = append(expected, fmt.Sprintf("https://t.me/chan%d", i))
+	}
+	assert.Equal(t, expected, links)
+}
+
+func conformanceStats(t *testing.T, s Storage) {
+	ctx := context.TODO()
+	day0 := time.Now().UTC().Add(-24 * time.Hour)
+	day1 := time.Now().UTC()
+	require.Nil(t, s.AddStats(ctx, -1001, day0, model.ChannelCounters{
+		Seen:      2,
+		Published: 1,
+		Dropped: map[string]uint64{
+			"nobot": 1,
+		},
+		TextBytes: 10,
+	}))
+	require.Nil(t, s.AddStats(ctx, -1001, day1, model.ChannelCounters{
+		Seen:      3,
+		Published: 1,
+		Failed:    1,
+		Dropped: map[string]uint64{
+			"nobot":       1,
+			"unsupported": 1,
+		},
+		TextBytes: 5,
+	}))
+	require.Nil(t, s.AddStats(ctx, -1001, day1, model.ChannelCounters{
+		Seen:      1,
+		Published: 1,
+		TextBytes: 7,
+	}))
+	require.Nil(t, s.AddStats(ctx, -1002, day1, model.ChannelCounters{}))
+	//
+	stats, err := s.GetStats(ctx, []int64{-1001, -1002}, day0)
+	require.Nil(t, err)
+	require.Len(t, stats, 1)
+	h := stats[-1001]
+	assert.Equal(t, int64(-1001), h.ChannelId)
+	assert.Equal(t, model.ChannelCounters{
+		Seen:      6,
+		Published: 3,
+		Failed:    1,
+		Dropped: map[string]uint64{
+			"nobot":       2,
+			"unsupported": 1,
+		},
+		TextBytes: 22,
+	}, h.Total)
+	assert.False(t, h.Updated.IsZero())
+	require.Len(t, h.Days, 2)
+	assert.Equal(t, model.Day(day1), h.Days[0].Date)
+	assert.Equal(t, model.ChannelCounters{
+		Seen:      4,
+		Published: 2,
+		Failed:    1,
+		Dropped: map[string]uint64{
+			"nobot":       1,
+			"unsupported": 1,
+		},
+		TextBytes: 12,
+	}, h.Days[0].Counters)
+	assert.Equal(t, model.Day(day0), h.Days[1].Date)
+	// the total is returned regardless of the days requested
+	stats, err = s.GetStats(ctx, []int64{-1001}, day1)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(6), stats[-1001].Total.Seen)
+	assert.Len(t, stats[-1001].Days, 1)
+}
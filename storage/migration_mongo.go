@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-telegram/config"
+	"github.com/segmentio/ksuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// migrationMongo is the single versioned change of the stored channels. The step must be idempotent: it's applied
+// again when the process stops before the version is recorded.
+type migrationMongo struct {
+	version uint32
+	descr   string
+	up      func(ctx context.Context, sm storageMongo) (err error)
+}
+
+// migrationsMongo are applied in the order of the versions, append the new steps only. The step changing the index
+// options should drop the index, it's created again with the actual options by ensureIndices after the steps.
+var migrationsMongo = []migrationMongo{
+	{
+		version: 1,
+		descr:   "unset the empty optional fields",
+		up:      unsetEmpty,
+	},
+	{
+		version: 2,
+		descr:   "backfill the creation time",
+		up:      backfillCreated,
+	},
+}
+
+// metaCollSuffix is appended to the channels collection name to get the migrations metadata collection name.
+const metaCollSuffix = "-meta"
+
+const metaIdLock = "lock"
+const metaIdSchema = "schema"
+
+const attrMetaId = "_id"
+const attrMetaOwner = "owner"
+const attrMetaUntil = "until"
+const attrMetaVersion = "version"
+const attrMetaApplied = "applied"
+
+// migrationLockTtl is the lease of the migrations lock, it's taken over by another replica when the owner died.
+const migrationLockTtl = 10 * time.Minute
+const migrationLockPollInterval = time.Second
+
+type recSchema struct {
+	Version uint32 `bson:"version"`
+}
+
+type recApplied struct {
+	Version uint32    `bson:"version"`
+	Descr   string    `bson:"descr"`
+	At      time.Time `bson:"at"`
+}
+
+type recIndex struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+}
+
+// migrate brings the collections to the latest schema version and the actual indices. The replicas starting
+// concurrently wait for the one holding the lock.
+func (sm storageMongo) migrate(ctx context.Context, meta *mongo.Collection, cfgDb config.DbConfig) (err error) {
+	owner := ksuid.New().String()
+	err = lockMigration(ctx, meta, owner)
+	if err == nil {
+		defer func() {
+			err = errors.Join(err, unlockMigration(context.WithoutCancel(ctx), meta, owner))
+		}()
+		var version uint32
+		version, err = schemaVersion(ctx, meta)
+		for _, m := range migrationsMongo {
+			if err != nil {
+				break
+			}
+			if m.version <= version {
+				continue
+			}
+			err = m.up(ctx, sm)
+			if err == nil {
+				err = setSchemaVersion(ctx, meta, m)
+			}
+			if err != nil {
+				err = fmt.Errorf("schema migration %d (%s) failed: %w", m.version, m.descr, err)
+			}
+		}
+		if err == nil {
+			err = sm.syncTtl(ctx, sm.coll, map[string]time.Duration{
+				attrLast:    cfgDb.Table.Retention,
+				attrDeleted: cfgDb.Table.DeleteGrace,
+			})
+		}
+		if err == nil {
+			_, err = sm.ensureIndices(ctx, cfgDb.Table.Retention, cfgDb.Table.DeleteGrace)
+		}
+		if err == nil {
+			err = sm.syncTtl(ctx, sm.audit, map[string]time.Duration{
+				attrAuditTime: cfgDb.Audit.Retention,
+			})
+		}
+		if err == nil {
+			_, err = sm.ensureAuditIndices(ctx, cfgDb.Audit.Retention)
+		}
+		if err == nil {
+			err = sm.syncTtl(ctx, sm.stats, map[string]time.Duration{
+				attrStatsUpdated: cfgDb.Stats.Retention,
+			})
+		}
+		if err == nil {
+			_, err = sm.ensureStatsIndices(ctx, cfgDb.Stats.Retention)
+		}
+	}
+	return
+}
+
+func lockMigration(ctx context.Context, meta *mongo.Collection, owner string) (err error) {
+	for {
+		now := time.Now().UTC()
+		_, err = meta.UpdateOne(
+			ctx,
+			bson.M{
+				attrMetaId: metaIdLock,
+				attrMetaUntil: bson.M{
+					"$lt": now,
+				},
+			},
+			bson.M{
+				"$set": bson.M{
+					attrMetaOwner: owner,
+					attrMetaUntil: now.Add(migrationLockTtl),
+				},
+			},
+			options.Update().SetUpsert(true),
+		)
+		// the upsert conflicts with the lock held by another owner
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("failed to acquire the schema migration lock: %w", ctx.Err())
+			return
+		case <-time.After(migrationLockPollInterval):
+		}
+	}
+	return
+}
+
+func unlockMigration(ctx context.Context, meta *mongo.Collection, owner string) (err error) {
+	_, err = meta.DeleteOne(ctx, bson.M{
+		attrMetaId:    metaIdLock,
+		attrMetaOwner: owner,
+	})
+	return
+}
+
+func schemaVersion(ctx context.Context, meta *mongo.Collection) (version uint32, err error) {
+	var rec recSchema
+	err = meta.FindOne(ctx, bson.M{attrMetaId: metaIdSchema}).Decode(&rec)
+	switch {
+	case err == nil:
+		version = rec.Version
+	case errors.Is(err, mongo.ErrNoDocuments):
+		err = nil
+	}
+	return
+}
+
+func setSchemaVersion(ctx context.Context, meta *mongo.Collection, m migrationMongo) (err error) {
+	_, err = meta.UpdateOne(
+		ctx,
+		bson.M{
+			attrMetaId: metaIdSchema,
+		},
+		bson.M{
+			"$set": bson.M{
+				attrMetaVersion: m.version,
+			},
+			"$push": bson.M{
+				attrMetaApplied: recApplied{
+					Version: m.version,
+					Descr:   m.descr,
+					At:      time.Now().UTC(),
+				},
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return
+}
+
+// syncTtl updates the TTL of the existing expiring indices by the field in place, these can't be created again with
+// another TTL.
+func (sm storageMongo) syncTtl(ctx context.Context, coll *mongo.Collection, ttls map[string]time.Duration) (err error) {
+	var cursor *mongo.Cursor
+	cursor, err = coll.Indexes().List(ctx)
+	var indices []recIndex
+	if err == nil {
+		err = cursor.All(ctx, &indices)
+	}
+	for _, idx := range indices {
+		if err != nil {
+			break
+		}
+		if idx.ExpireAfterSeconds == nil || len(idx.Key) != 1 {
+			continue
+		}
+		retention, found := ttls[idx.Key[0].Key]
+		ttl := int64(retention / time.Second)
+		if found && *idx.ExpireAfterSeconds != ttl {
+			err = sm.db.RunCommand(ctx, bson.D{
+				{
+					Key:   "collMod",
+					Value: coll.Name(),
+				},
+				{
+					Key: "index",
+					Value: bson.M{
+						"name":               idx.Name,
+						"expireAfterSeconds": ttl,
+					},
+				},
+			}).Err()
+		}
+	}
+	return
+}
+
+// unsetEmpty removes the optional fields stored empty before these were omitted, so the sparse indices and the
+// default label (missing) queries cover these channels.
+func unsetEmpty(ctx context.Context, sm storageMongo) (err error) {
+	for _, attr := range []string{attrUserId, attrSubId, attrTerms, attrLabel} {
+		if err != nil {
+			break
+		}
+		_, err = sm.coll.UpdateMany(
+			ctx,
+			bson.M{
+				attr: "",
+			},
+			bson.M{
+				"$unset": bson.M{
+					attr: "",
+				},
+			},
+		)
+	}
+	return
+}
+
+// backfillCreated sets the creation time of the channels stored before it was tracked to the last update time, the
+// closest time known.
+func backfillCreated(ctx context.Context, sm storageMongo) (err error) {
+	_, err = sm.coll.UpdateMany(
+		ctx,
+		bson.M{
+			attrCreated: bson.M{
+				"$exists": false,
+			},
+			attrLast: bson.M{
+				"$exists": true,
+			},
+		},
+		mongo.Pipeline{
+			{
+				{
+					Key: "$set",
+					Value: bson.M{
+						attrCreated: "$" + attrLast,
+					},
+				},
+			},
+		},
+	)
+	return
+}
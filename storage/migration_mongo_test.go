@@ -0,0 +1,14 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMigrationsMongo_Versions(t *testing.T) {
+	for i, m := range migrationsMongo {
+		assert.Equal(t, uint32(i+1), m.version, "versions should be sequential from 1")
+		assert.NotEmpty(t, m.descr)
+		assert.NotNil(t, m.up)
+	}
+}
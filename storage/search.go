@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/awakari/source-telegram/model"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// PatternLenMax limits the filter pattern length, the longer one is rejected before it reaches the database.
+const PatternLenMax = 256
+
+// TextResultsMax limits the full-text search results. These are ranked by the relevance and returned as the single
+// page, so the cursor is not applicable.
+const TextResultsMax = 100
+
+// the relative weights of the channel attributes in the full-text search
+const textWeightName = 10
+const textWeightLink = 5
+const textWeightTerms = 1
+
+// patternExpr returns the regular expression for the filter pattern. The literal pattern is escaped, so it's matched
+// as a substring. The regular expression pattern is validated with RE2, so the backtracking constructs (e.g. the back
+// references) are rejected. The nested quantifiers (e.g. "(a+)+") are rejected too: RE2 matches these in the linear
+// time, but the database engine backtracks exponentially.
+func patternExpr(filter model.ChannelFilter) (expr string, err error) {
+	switch {
+	case len(filter.Pattern) > PatternLenMax:
+		err = fmt.Errorf("%w: pattern is longer than %d", ErrInvalidFilter, PatternLenMax)
+	case filter.Regex:
+		expr = filter.Pattern
+		var re *syntax.Regexp
+		re, err = syntax.Parse(expr, syntax.Perl)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("%w: %s", ErrInvalidFilter, err)
+		case nestedRepeat(re, false):
+			err = fmt.Errorf("%w: nested quantifiers are not supported", ErrInvalidFilter)
+		}
+	default:
+		expr = regexp.QuoteMeta(filter.Pattern)
+	}
+	return
+}
+
+// nestedRepeat returns true if the expression has the repetition inside another repetition.
+func nestedRepeat(re *syntax.Regexp, repeated bool) (nested bool) {
+	var repeat bool
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		repeat = true
+	case syntax.OpRepeat:
+		repeat = re.Max == -1 || re.Max > 1
+	}
+	nested = repeat && repeated
+	for _, sub := range re.Sub {
+		if nested {
+			break
+		}
+		nested = nestedRepeat(sub, repeated || repeat)
+	}
+	return
+}
+
+// textLimit returns the full-text search results limit, at most TextResultsMax.
+func textLimit(limit uint32) uint32 {
+	if limit == 0 || limit > TextResultsMax {
+		limit = TextResultsMax
+	}
+	return limit
+}
+
+// compilePattern returns the compiled filter pattern for the backends matching in the process.
+func compilePattern(filter model.ChannelFilter) (pattern *regexp.Regexp, err error) {
+	var expr string
+	expr, err = patternExpr(filter)
+	if err == nil {
+		if filter.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		pattern, err = regexp.Compile(expr)
+	}
+	return
+}
+
+// textTokens splits the text into the lower case words, similar to the Mongo text index without the language.
+func textTokens(text string) (tokens []string) {
+	tokens = strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return
+}
+
+// textScore returns the weighted count of the query words found in the channel attributes, 0 if none found.
+func textScore(query []string, ch model.Channel) (score int) {
+	weighted := []struct {
+		tokens []string
+		weight int
+	}{
+		{
+			tokens: textTokens(ch.Name),
+			weight: textWeightName,
+		},
+		{
+			tokens: textTokens(ch.Link),
+			weight: textWeightLink,
+		},
+		{
+			tokens: textTokens(ch.Terms),
+			weight: textWeightTerms,
+		},
+	}
+	for _, q := range query {
+		for _, w := range weighted {
+			for _, t := range w.tokens {
+				if t == q {
+					score += w.weight
+				}
+			}
+		}
+	}
+	return
+}
+
+// rankText sorts the channels by the text score descending, then by the link.
+func rankText(chans []model.Channel, scores map[string]int) {
+	sort.SliceStable(chans, func(i, j int) bool {
+		si, sj := scores[chans[i].Link], scores[chans[j].Link]
+		if si != sj {
+			return si > sj
+		}
+		return chans[i].Link < chans[j].Link
+	})
+}
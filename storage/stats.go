@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"github.com/awakari/source-telegram/model"
+	"strconv"
+	"time"
+)
+
+// recStats is the channel counters stored by both backends, either the channel total (no day) or the daily bucket.
+type recStats struct {
+	Id                    string    `bson:"_id"`
+	ChannelId             int64     `bson:"channelId"`
+	Day                   time.Time `bson:"day,omitempty"`
+	Updated               time.Time `bson:"updated"`
+	model.ChannelCounters `bson:",inline"`
+}
+
+const attrStatsId = "_id"
+const attrStatsChannelId = "channelId"
+const attrStatsDay = "day"
+const attrStatsUpdated = "updated"
+const attrStatsSeen = "seen"
+const attrStatsPublished = "published"
+const attrStatsFailed = "failed"
+const attrStatsDropped = "dropped"
+const attrStatsTextBytes = "textBytes"
+
+// statsCollSuffix is appended to the channels collection name to get the stats collection name.
+const statsCollSuffix = "-stats"
+
+// statsId returns the id of the channel total when the day is zero, the id of the daily bucket otherwise.
+func statsId(chanId int64, day time.Time) (id string) {
+	id = strconv.FormatInt(chanId, 10)
+	if !day.IsZero() {
+		id += "/" + day.Format(time.DateOnly)
+	}
+	return
+}
+
+// decodeStats groups the records by the channel, the daily buckets are expected ordered by the day descending.
+func decodeStats(recs []recStats) (stats map[int64]model.ChannelStatsHistory) {
+	stats = map[int64]model.ChannelStatsHistory{}
+	for _, rec := range recs {
+		h, found := stats[rec.ChannelId]
+		if !found {
+			h.ChannelId = rec.ChannelId
+		}
+		switch rec.Day.IsZero() {
+		case true:
+			h.Total = rec.ChannelCounters
+			h.Updated = rec.Updated.UTC()
+		default:
+			h.Days = append(h.Days, model.ChannelStatsDay{
+				Date:     rec.Day.UTC(),
+				Counters: rec.ChannelCounters,
+			})
+		}
+		stats[rec.ChannelId] = h
+	}
+	return
+}
@@ -3,6 +3,7 @@ package storage
 import (
     "context"
     "errors"
+    "github.com/awakari/source-telegram/config"
     "github.com/awakari/source-telegram/model"
     "io"
     "time"
@@ -10,13 +11,94 @@ import (
 
 type Storage interface {
     io.Closer
+    // Ping checks whether the storage is reachable.
+    Ping(ctx context.Context) (err error)
+    // Create stores the channel with all the fields, the channel created deleted (e.g. imported) is hidden the same way
+    // as the one deleted later.
     Create(ctx context.Context, ch model.Channel) (err error)
     Read(ctx context.Context, link string) (ch model.Channel, err error)
     Update(ctx context.Context, link string, last time.Time) (err error)
-    Delete(ctx context.Context, link string) (err error)
+    UpdateLink(ctx context.Context, id int64, link string) (linkOld string, err error)
+    SetStale(ctx context.Context, link string, since time.Time) (err error)
+    UpdateLabel(ctx context.Context, link, label string) (err error)
+    CountByLabel(ctx context.Context) (counts map[string]int64, err error)
+    // Delete marks the channel deleted with the reason. The deleted channel is hidden from the reads and the updates
+    // unless restored, it's removed after the grace period. Create replaces the deleted channel with the same id or link.
+    Delete(ctx context.Context, link, reason string) (err error)
+    // Restore brings the deleted channel back with all its fields, returns ErrNotFound if there's no deleted channel by
+    // the link.
+    Restore(ctx context.Context, link string) (ch model.Channel, err error)
     GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error)
+    // Iterate passes every channel matching the filter to the consumer ordered by the link and the id. The channels
+    // are read by the batches of the given size, every next batch starts after the last channel consumed, so the
+    // channels changed meanwhile are neither skipped nor repeated unless renamed. Stops on the first consumer failure.
+    Iterate(ctx context.Context, filter model.ChannelFilter, batchSize uint32, consume func(ch model.Channel) (err error)) (err error)
+    // Watch blocks passing the channel changes matching the label (any if nil) to the consumer until the context is
+    // done, the consumer fails or the changes stream is interrupted. Returns ErrWatchUnsupported if the backend can't
+    // watch.
+    Watch(ctx context.Context, lbl *string, consume func(chg model.ChannelChange) (err error)) (err error)
+    // AppendAudit records the channel change. The audit records are never updated, they expire after the audit
+    // retention period only. The id and the time are assigned unless set.
+    AppendAudit(ctx context.Context, rec model.AuditRecord) (err error)
+    // GetAuditPage returns the audit records matching the filter, the latest first, starting after the cursor id.
+    GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error)
+    // AddStats adds the channel counters delta to the total and to the daily bucket of the given time. The stats are
+    // kept by the channel id, so these survive the renames, and expire when not updated during the stats retention.
+    AddStats(ctx context.Context, chanId int64, t time.Time, delta model.ChannelCounters) (err error)
+    // GetStats returns the stats of the channels by the id with the daily buckets since the given time, the channels
+    // without any stats are omitted.
+    GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error)
 }
 
 var ErrNotFound = errors.New("channel not found")
 var ErrInternal = errors.New("internal failure")
 var ErrConflict = errors.New("channel with the same id is already present")
+var ErrWatchUnsupported = errors.New("changes watch is not supported")
+var ErrInvalidFilter = errors.New("invalid channel filter")
+
+// IterateBatchSizeDefault is used when the batch size is not specified.
+const IterateBatchSizeDefault = 100
+
+// the storage backends selectable by the DbConfig.Type
+const TypeMongo = "mongo"
+const TypeBolt = "bolt"
+
+// NewStorage returns the backend selected by the config type, MongoDB by default.
+func NewStorage(ctx context.Context, cfgDb config.DbConfig) (s Storage, err error) {
+    switch cfgDb.Type {
+    case TypeBolt:
+        s, err = newStorageBolt(cfgDb)
+    default:
+        s, err = newStorageMongo(ctx, cfgDb)
+    }
+    return
+}
+
+// iterate consumes the batches until the incomplete one, the backends implement reading the single batch after the
+// given channel (from the beginning if nil) only.
+func iterate(
+    ctx context.Context,
+    batchSize uint32,
+    getBatch func(ctx context.Context, after *model.Channel, limit uint32) (batch []model.Channel, err error),
+    consume func(ch model.Channel) (err error),
+) (err error) {
+    if batchSize == 0 {
+        batchSize = IterateBatchSizeDefault
+    }
+    var after *model.Channel
+    for err == nil {
+        var batch []model.Channel
+        batch, err = getBatch(ctx, after, batchSize)
+        for _, ch := range batch {
+            if err != nil {
+                break
+            }
+            err = consume(ch)
+        }
+        if uint32(len(batch)) < batchSize {
+            break
+        }
+        after = &batch[len(batch)-1]
+    }
+    return
+}
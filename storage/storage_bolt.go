@@ -0,0 +1,872 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-telegram/config"
+	"github.com/awakari/source-telegram/model"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// storageBolt keeps the channels in the embedded single-file database, the records are JSON-encoded by the link key.
+// The unique id and aliases are maintained as the separate index buckets pointing to the link.
+type storageBolt struct {
+	db             *bolt.DB
+	retention      time.Duration
+	deleteGrace    time.Duration
+	auditRetention time.Duration
+	statsRetention time.Duration
+	stop           chan struct{}
+	watchers       *boltWatchers
+}
+
+// boltWatchers fans the committed changes out to the in-process watchers, there are no other writers to the file.
+type boltWatchers struct {
+	lock *sync.Mutex
+	subs map[chan model.ChannelChange]struct{}
+}
+
+var bucketChans = []byte("chans")
+var bucketIds = []byte("ids")
+var bucketAliases = []byte("aliases")
+
+// bucketAudit keeps the audit records by the id, the KSUID keys are ordered by the time.
+var bucketAudit = []byte("audit")
+
+// bucketStats keeps the channel stats by the channel id followed by the day, the total goes first with the zero day.
+var bucketStats = []byte("stats")
+
+const boltOpenTimeout = 10 * time.Second
+
+// boltPurgeInterval is the expired channels removal period, similar to the Mongo TTL monitor.
+const boltPurgeInterval = time.Minute
+
+// boltWatchBufferSize is the count of the changes a watcher may be behind before it's terminated, should be enough to
+// absorb the whole joined channels refresh.
+const boltWatchBufferSize = 1_024
+
+func newStorageBolt(cfgDb config.DbConfig) (s Storage, err error) {
+	err = os.MkdirAll(filepath.Dir(cfgDb.Path), 0o700)
+	var db *bolt.DB
+	if err == nil {
+		db, err = bolt.Open(cfgDb.Path, 0o600, &bolt.Options{
+			Timeout: boltOpenTimeout,
+		})
+	}
+	if err == nil {
+		err = db.Update(func(tx *bolt.Tx) (err error) {
+			for _, b := range [][]byte{bucketChans, bucketIds, bucketAliases, bucketAudit, bucketStats} {
+				_, err = tx.CreateBucketIfNotExists(b)
+				if err != nil {
+					break
+				}
+			}
+			return
+		})
+		if err != nil {
+			_ = db.Close()
+		}
+	}
+	switch err {
+	case nil:
+		sb := storageBolt{
+			db:             db,
+			retention:      cfgDb.Table.Retention,
+			deleteGrace:    cfgDb.Table.DeleteGrace,
+			auditRetention: cfgDb.Audit.Retention,
+			statsRetention: cfgDb.Stats.Retention,
+			stop:           make(chan struct{}),
+			watchers: &boltWatchers{
+				lock: &sync.Mutex{},
+				subs: map[chan model.ChannelChange]struct{}{},
+			},
+		}
+		go sb.purgeLoop()
+		s = sb
+	default:
+		err = fmt.Errorf("%w: %s", ErrInternal, err)
+	}
+	return
+}
+
+func (sb storageBolt) Close() error {
+	close(sb.stop)
+	return sb.db.Close()
+}
+
+func (sb storageBolt) Ping(ctx context.Context) (err error) {
+	err = sb.db.View(func(tx *bolt.Tx) (err error) {
+		if tx.Bucket(bucketChans) == nil {
+			err = errors.New("channels bucket is missing")
+		}
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInternal, err)
+	}
+	return
+}
+
+func (sb storageBolt) Create(ctx context.Context, ch model.Channel) (err error) {
+	rec := recChan{
+		Id:         ch.Id,
+		GroupId:    ch.GroupId,
+		UserId:     ch.UserId,
+		Name:       ch.Name,
+		Link:       ch.Link,
+		Last:       ch.Last,
+		Created:    ch.Created,
+		SubId:      ch.SubId,
+		Terms:      ch.Terms,
+		Label:      ch.Label,
+		Discussion: ch.Discussion,
+		Stale:      ch.Stale,
+		// set when the channel is imported deleted
+		Deleted:      ch.Deleted,
+		DeleteReason: ch.DeleteReason,
+	}
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		for _, link := range [][]byte{[]byte(ch.Link), slices.Clone(tx.Bucket(bucketIds).Get(idKey(ch.Id)))} {
+			if err != nil || link == nil {
+				continue
+			}
+			v := tx.Bucket(bucketChans).Get(link)
+			if v == nil {
+				continue
+			}
+			var existing recChan
+			err = json.Unmarshal(v, &existing)
+			switch {
+			case err != nil:
+			case existing.Deleted.IsZero():
+				err = fmt.Errorf("%w: %s", ErrConflict, ch.Link)
+			default:
+				// the deleted channel with the same id or link is replaced
+				err = deleteRec(tx, existing)
+			}
+		}
+		if err == nil {
+			err = putRec(tx, rec)
+		}
+		return
+	})
+	if err == nil && rec.Deleted.IsZero() {
+		chNew := rec.decode()
+		sb.watchers.notify(model.ChannelChange{
+			Type:    model.ChannelChangeCreate,
+			Channel: &chNew,
+		})
+	}
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) Read(ctx context.Context, link string) (ch model.Channel, err error) {
+	var rec recChan
+	err = sb.db.View(func(tx *bolt.Tx) (err error) {
+		rec, err = sb.getRec(tx, link, true, false)
+		return
+	})
+	if err == nil {
+		ch = rec.decode()
+	}
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) Update(ctx context.Context, link string, last time.Time) (err error) {
+	err = sb.update(link, func(rec *recChan) {
+		rec.Last = last.UTC()
+	})
+	return
+}
+
+func (sb storageBolt) SetStale(ctx context.Context, link string, since time.Time) (err error) {
+	err = sb.update(link, func(rec *recChan) {
+		switch since.IsZero() {
+		case true:
+			rec.Stale = time.Time{}
+		default:
+			rec.Stale = since.UTC()
+		}
+	})
+	return
+}
+
+func (sb storageBolt) UpdateLabel(ctx context.Context, link, label string) (err error) {
+	err = sb.update(link, func(rec *recChan) {
+		rec.Label = label
+	})
+	return
+}
+
+// update modifies the channel found by the current link only, like the Mongo implementation does.
+func (sb storageBolt) update(link string, f func(rec *recChan)) (err error) {
+	var before, after recChan
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		before, err = sb.getRec(tx, link, false, false)
+		if err == nil {
+			after = before
+			f(&after)
+			err = putRec(tx, after)
+		}
+		return
+	})
+	if err == nil {
+		sb.notifyUpdate(before, after)
+	}
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) CountByLabel(ctx context.Context) (counts map[string]int64, err error) {
+	counts = map[string]int64{}
+	now := time.Now()
+	err = sb.db.View(func(tx *bolt.Tx) (err error) {
+		err = tx.Bucket(bucketChans).ForEach(func(k, v []byte) (err error) {
+			var rec recChan
+			err = json.Unmarshal(v, &rec)
+			if err == nil && !sb.expired(rec, now) && rec.Deleted.IsZero() {
+				counts[rec.Label]++
+			}
+			return
+		})
+		return
+	})
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) UpdateLink(ctx context.Context, id int64, link string) (linkOld string, err error) {
+	var before, after recChan
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		v := tx.Bucket(bucketIds).Get(idKey(id))
+		if v == nil {
+			err = fmt.Errorf("%w: %s", ErrNotFound, link)
+			return
+		}
+		var rec recChan
+		rec, err = sb.getRec(tx, string(v), false, false)
+		var vExisting []byte
+		if err == nil && rec.Link != link {
+			vExisting = tx.Bucket(bucketChans).Get([]byte(link))
+		}
+		if vExisting != nil {
+			var existing recChan
+			err = json.Unmarshal(vExisting, &existing)
+			switch {
+			case err != nil:
+			case existing.Deleted.IsZero():
+				err = fmt.Errorf("%w: %s", ErrConflict, link)
+			default:
+				// the deleted channel with the same link is replaced like on the creation
+				err = deleteRec(tx, existing)
+			}
+		}
+		if err == nil {
+			before = rec
+			linkOld = rec.Link
+			err = deleteRec(tx, rec)
+		}
+		if err == nil {
+			if !slices.Contains(rec.Aliases, rec.Link) {
+				rec.Aliases = append(rec.Aliases, rec.Link)
+			}
+			rec.Aliases = slices.DeleteFunc(rec.Aliases, func(alias string) bool {
+				return alias == link
+			})
+			rec.Link = link
+			err = putRec(tx, rec)
+		}
+		after = rec
+		return
+	})
+	if err == nil {
+		sb.notifyUpdate(before, after)
+	}
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) Delete(ctx context.Context, link, reason string) (err error) {
+	var before recChan
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		before, err = sb.getRec(tx, link, true, false)
+		if err == nil {
+			after := before
+			after.Deleted = time.Now().UTC()
+			after.DeleteReason = reason
+			err = putRec(tx, after)
+		}
+		return
+	})
+	if err == nil {
+		sb.notifyDelete(before)
+	}
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) Restore(ctx context.Context, link string) (ch model.Channel, err error) {
+	var rec recChan
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		rec, err = sb.getRec(tx, link, false, true)
+		if err == nil {
+			rec.Deleted = time.Time{}
+			rec.DeleteReason = ""
+			err = putRec(tx, rec)
+		}
+		return
+	})
+	if err == nil {
+		ch = rec.decode()
+		sb.watchers.notify(model.ChannelChange{
+			Type:    model.ChannelChangeCreate,
+			Channel: &ch,
+		})
+	}
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
+	var pattern *regexp.Regexp
+	pattern, err = compilePattern(filter)
+	switch {
+	case err != nil:
+	case filter.Text != "":
+		// nothing after the single page of the full-text search results
+		if cursor == "" {
+			page, err = sb.search(filter, pattern, textLimit(limit))
+		}
+	default:
+		now := time.Now()
+		err = sb.db.View(func(tx *bolt.Tx) (err error) {
+			c := tx.Bucket(bucketChans).Cursor()
+			var k, v []byte
+			var next func() ([]byte, []byte)
+			switch order {
+			case model.OrderDesc:
+				next = c.Prev
+				k, v = c.Seek([]byte(cursor))
+				switch {
+				case k == nil:
+					k, v = c.Last()
+				default:
+					k, v = c.Prev()
+				}
+			default:
+				next = c.Next
+				k, v = c.Seek([]byte(cursor))
+				if k != nil && string(k) == cursor {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && (limit == 0 || uint32(len(page)) < limit); k, v = next() {
+				if order == model.OrderDesc && string(k) >= cursor {
+					// the same as the Mongo "$lt" condition, nothing is less than the empty cursor
+					break
+				}
+				var rec recChan
+				err = json.Unmarshal(v, &rec)
+				if err != nil {
+					break
+				}
+				if !sb.gone(rec, now) && matches(rec, filter, pattern) {
+					page = append(page, rec.decode())
+				}
+			}
+			return
+		})
+	}
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) Iterate(ctx context.Context, filter model.ChannelFilter, batchSize uint32, consume func(ch model.Channel) (err error)) (err error) {
+	var pattern *regexp.Regexp
+	pattern, err = compilePattern(filter)
+	switch err {
+	case nil:
+		// the transaction is not held by the consumer, it may write to the storage
+		err = iterate(ctx, batchSize, func(ctx context.Context, after *model.Channel, limit uint32) (batch []model.Channel, err error) {
+			now := time.Now()
+			err = sb.db.View(func(tx *bolt.Tx) (err error) {
+				c := tx.Bucket(bucketChans).Cursor()
+				var k, v []byte
+				switch after {
+				case nil:
+					k, v = c.First()
+				default:
+					// the link is the unique key, so the order by the id is implied
+					k, v = c.Seek([]byte(after.Link))
+					if k != nil && string(k) == after.Link {
+						k, v = c.Next()
+					}
+				}
+				for ; k != nil && uint32(len(batch)) < limit; k, v = c.Next() {
+					var rec recChan
+					err = json.Unmarshal(v, &rec)
+					if err != nil {
+						break
+					}
+					if !sb.gone(rec, now) && matches(rec, filter, pattern) {
+						batch = append(batch, rec.decode())
+					}
+				}
+				return
+			})
+			err = decodeErrorBolt(err)
+			return
+		}, consume)
+	default:
+		err = decodeErrorBolt(err)
+	}
+	return
+}
+
+func matches(rec recChan, filter model.ChannelFilter, pattern *regexp.Regexp) (ok bool) {
+	ok = rec.Deleted.IsZero() != filter.Deleted
+	if ok && filter.Label != nil && rec.Label != *filter.Label {
+		ok = false
+	}
+	if ok && filter.UserId != "" {
+		ok = rec.GroupId == filter.GroupId && rec.UserId == filter.UserId
+	}
+	if ok && filter.SubId != "" {
+		ok = rec.SubId == filter.SubId
+	}
+	if ok {
+		ok = pattern.MatchString(rec.Link) || pattern.MatchString(rec.Name)
+	}
+	if ok && filter.Text != "" {
+		ok = textScore(textTokens(filter.Text), rec.decode()) > 0
+	}
+	return
+}
+
+// search returns the single page of the channels matching the text query ranked by the relevance.
+func (sb storageBolt) search(filter model.ChannelFilter, pattern *regexp.Regexp, limit uint32) (page []model.Channel, err error) {
+	query := textTokens(filter.Text)
+	scores := map[string]int{}
+	now := time.Now()
+	err = sb.db.View(func(tx *bolt.Tx) (err error) {
+		err = tx.Bucket(bucketChans).ForEach(func(k, v []byte) (err error) {
+			var rec recChan
+			err = json.Unmarshal(v, &rec)
+			if err == nil && !sb.gone(rec, now) && matches(rec, filter, pattern) {
+				ch := rec.decode()
+				scores[ch.Link] = textScore(query, ch)
+				page = append(page, ch)
+			}
+			return
+		})
+		return
+	})
+	rankText(page, scores)
+	if limit > 0 && uint32(len(page)) > limit {
+		page = page[:limit]
+	}
+	return
+}
+
+// getRec finds the channel by the link, optionally by the former link (alias) too. The expired channels are treated as
+// missing before the removal. The deleted channels are missing too unless the deleted one is requested.
+func (sb storageBolt) getRec(tx *bolt.Tx, link string, aliases, deleted bool) (rec recChan, err error) {
+	chans := tx.Bucket(bucketChans)
+	v := chans.Get([]byte(link))
+	if v == nil && aliases {
+		// the channel might have been renamed
+		linkNew := tx.Bucket(bucketAliases).Get([]byte(link))
+		if linkNew != nil {
+			v = chans.Get(linkNew)
+		}
+	}
+	switch v {
+	case nil:
+		err = fmt.Errorf("%w by link %s", ErrNotFound, link)
+	default:
+		err = json.Unmarshal(v, &rec)
+		now := time.Now()
+		if err == nil && (sb.gone(rec, now) || rec.Deleted.IsZero() == deleted) {
+			err = fmt.Errorf("%w by link %s", ErrNotFound, link)
+		}
+	}
+	return
+}
+
+func putRec(tx *bolt.Tx, rec recChan) (err error) {
+	var v []byte
+	v, err = json.Marshal(rec)
+	if err == nil {
+		err = tx.Bucket(bucketChans).Put([]byte(rec.Link), v)
+	}
+	if err == nil {
+		err = tx.Bucket(bucketIds).Put(idKey(rec.Id), []byte(rec.Link))
+	}
+	for _, alias := range rec.Aliases {
+		if err != nil {
+			break
+		}
+		err = tx.Bucket(bucketAliases).Put([]byte(alias), []byte(rec.Link))
+	}
+	return
+}
+
+func deleteRec(tx *bolt.Tx, rec recChan) (err error) {
+	err = tx.Bucket(bucketChans).Delete([]byte(rec.Link))
+	if err == nil {
+		err = tx.Bucket(bucketIds).Delete(idKey(rec.Id))
+	}
+	aliases := tx.Bucket(bucketAliases)
+	for _, alias := range rec.Aliases {
+		if err != nil {
+			break
+		}
+		// the alias might be reused by another channel since
+		if string(aliases.Get([]byte(alias))) == rec.Link {
+			err = aliases.Delete([]byte(alias))
+		}
+	}
+	return
+}
+
+func (sb storageBolt) expired(rec recChan, now time.Time) bool {
+	return sb.retention > 0 && !rec.Last.IsZero() && rec.Last.Add(sb.retention).Before(now)
+}
+
+// gone returns true when the channel is left for the purge only.
+func (sb storageBolt) gone(rec recChan, now time.Time) bool {
+	return sb.expired(rec, now) || sb.graceOver(rec, now)
+}
+
+// graceOver returns true when the deleted channel should be removed, like the Mongo TTL index on the deletion time.
+func (sb storageBolt) graceOver(rec recChan, now time.Time) bool {
+	return !rec.Deleted.IsZero() && !rec.Deleted.Add(sb.deleteGrace).After(now)
+}
+
+func (sb storageBolt) purgeLoop() {
+	t := time.NewTicker(boltPurgeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-sb.stop:
+			return
+		case <-t.C:
+			_ = sb.purge()
+		}
+	}
+}
+
+func (sb storageBolt) purge() (err error) {
+	now := time.Now()
+	var recs []recChan
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		recs = nil
+		var recsDeleted []recChan
+		err = tx.Bucket(bucketChans).ForEach(func(k, v []byte) (err error) {
+			var rec recChan
+			err = json.Unmarshal(v, &rec)
+			switch {
+			case err != nil:
+			case sb.graceOver(rec, now):
+				recsDeleted = append(recsDeleted, rec)
+			case sb.expired(rec, now) && rec.Deleted.IsZero():
+				recs = append(recs, rec)
+			}
+			return
+		})
+		for _, rec := range recsDeleted {
+			if err != nil {
+				break
+			}
+			// notified and audited on the deletion already
+			err = deleteRec(tx, rec)
+		}
+		for _, rec := range recs {
+			if err != nil {
+				break
+			}
+			err = deleteRec(tx, rec)
+			if err == nil {
+				err = putAudit(tx, encodeAudit(auditExpiration(now, rec)))
+			}
+		}
+		if err == nil && sb.auditRetention > 0 {
+			err = purgeAudit(tx, now.Add(-sb.auditRetention))
+		}
+		if err == nil && sb.statsRetention > 0 {
+			err = purgeStats(tx, now.Add(-sb.statsRetention))
+		}
+		return
+	})
+	if err == nil {
+		for _, rec := range recs {
+			sb.notifyDelete(rec)
+		}
+	}
+	return
+}
+
+// Watch consumes the changes committed by this process only, the file is not shared with other processes anyway.
+func (sb storageBolt) Watch(ctx context.Context, lbl *string, consume func(chg model.ChannelChange) (err error)) (err error) {
+	changes := sb.watchers.subscribe()
+	defer sb.watchers.unsubscribe(changes)
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-sb.stop:
+			return
+		case chg, ok := <-changes:
+			switch ok {
+			case true:
+				if chg.Matches(lbl) {
+					err = consume(chg)
+				}
+			default:
+				err = fmt.Errorf("%w: the changes watcher is more than %d changes behind", ErrInternal, boltWatchBufferSize)
+			}
+		}
+	}
+	return
+}
+
+func (sb storageBolt) notifyUpdate(before, after recChan) {
+	chBefore := before.decode()
+	chAfter := after.decode()
+	sb.watchers.notify(model.ChannelChange{
+		Type:    model.ChannelChangeUpdate,
+		Channel: &chAfter,
+		Before:  &chBefore,
+	})
+}
+
+func (sb storageBolt) notifyDelete(rec recChan) {
+	chBefore := rec.decode()
+	sb.watchers.notify(model.ChannelChange{
+		Type:   model.ChannelChangeDelete,
+		Before: &chBefore,
+	})
+}
+
+func (sb storageBolt) AppendAudit(ctx context.Context, rec model.AuditRecord) (err error) {
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		return putAudit(tx, encodeAudit(rec))
+	})
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+	err = sb.db.View(func(tx *bolt.Tx) (err error) {
+		c := tx.Bucket(bucketAudit).Cursor()
+		var k, v []byte
+		switch cursor {
+		case "":
+			k, v = c.Last()
+		default:
+			k, v = c.Seek([]byte(cursor))
+			switch {
+			case k == nil:
+				k, v = c.Last()
+			default:
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil && (limit == 0 || uint32(len(page)) < limit); k, v = c.Prev() {
+			var rec recAudit
+			err = json.Unmarshal(v, &rec)
+			if err != nil {
+				break
+			}
+			if rec.matches(filter) {
+				page = append(page, rec.decode())
+			}
+		}
+		return
+	})
+	err = decodeErrorBolt(err)
+	return
+}
+
+func putAudit(tx *bolt.Tx, rec recAudit) (err error) {
+	var v []byte
+	v, err = json.Marshal(rec)
+	if err == nil {
+		err = tx.Bucket(bucketAudit).Put([]byte(rec.Id), v)
+	}
+	return
+}
+
+// purgeAudit removes the audit records older than the given time, these are the first ones by the key.
+func purgeAudit(tx *bolt.Tx, before time.Time) (err error) {
+	var keys [][]byte
+	c := tx.Bucket(bucketAudit).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var rec recAudit
+		err = json.Unmarshal(v, &rec)
+		if err != nil || !rec.Time.Before(before) {
+			break
+		}
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err != nil {
+			break
+		}
+		err = tx.Bucket(bucketAudit).Delete(k)
+	}
+	return
+}
+
+func (sb storageBolt) AddStats(ctx context.Context, chanId int64, t time.Time, delta model.ChannelCounters) (err error) {
+	if delta.IsZero() {
+		return
+	}
+	updated := time.Now().UTC()
+	err = sb.db.Update(func(tx *bolt.Tx) (err error) {
+		b := tx.Bucket(bucketStats)
+		for _, day := range []time.Time{{}, model.Day(t)} {
+			if err != nil {
+				break
+			}
+			k := statsKey(chanId, day)
+			rec := recStats{
+				Id:        statsId(chanId, day),
+				ChannelId: chanId,
+				Day:       day,
+			}
+			if v := b.Get(k); v != nil {
+				err = json.Unmarshal(v, &rec)
+			}
+			if err == nil {
+				rec.Updated = updated
+				rec.Add(delta)
+				var v []byte
+				v, err = json.Marshal(rec)
+				if err == nil {
+					err = b.Put(k, v)
+				}
+			}
+		}
+		return
+	})
+	err = decodeErrorBolt(err)
+	return
+}
+
+func (sb storageBolt) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+	since = model.Day(since)
+	var recs []recStats
+	err = sb.db.View(func(tx *bolt.Tx) (err error) {
+		c := tx.Bucket(bucketStats).Cursor()
+		for _, chanId := range chanIds {
+			prefix := idKey(chanId)
+			// the latest day first
+			var recsChan []recStats
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				var rec recStats
+				err = json.Unmarshal(v, &rec)
+				if err != nil {
+					return
+				}
+				if rec.Day.IsZero() || !rec.Day.Before(since) {
+					recsChan = append(recsChan, rec)
+				}
+			}
+			slices.Reverse(recsChan)
+			recs = append(recs, recsChan...)
+		}
+		return
+	})
+	stats = decodeStats(recs)
+	err = decodeErrorBolt(err)
+	return
+}
+
+func statsKey(chanId int64, day time.Time) (k []byte) {
+	k = idKey(chanId)
+	var d int64
+	if !day.IsZero() {
+		d = day.Unix()
+	}
+	k = binary.BigEndian.AppendUint64(k, uint64(d))
+	return
+}
+
+// purgeStats removes the stats records not updated since the given time, like the Mongo TTL index.
+func purgeStats(tx *bolt.Tx, before time.Time) (err error) {
+	var keys [][]byte
+	err = tx.Bucket(bucketStats).ForEach(func(k, v []byte) (err error) {
+		var rec recStats
+		err = json.Unmarshal(v, &rec)
+		if err == nil && rec.Updated.Before(before) {
+			keys = append(keys, slices.Clone(k))
+		}
+		return
+	})
+	for _, k := range keys {
+		if err != nil {
+			break
+		}
+		err = tx.Bucket(bucketStats).Delete(k)
+	}
+	return
+}
+
+func (bw *boltWatchers) subscribe() (changes chan model.ChannelChange) {
+	changes = make(chan model.ChannelChange, boltWatchBufferSize)
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+	bw.subs[changes] = struct{}{}
+	return
+}
+
+func (bw *boltWatchers) unsubscribe(changes chan model.ChannelChange) {
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+	delete(bw.subs, changes)
+}
+
+// notify never blocks the writer: the consumer may write to the storage itself, so the watcher that is too slow is
+// terminated instead, it's expected to resync and watch again.
+func (bw *boltWatchers) notify(chg model.ChannelChange) {
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+	for changes := range bw.subs {
+		select {
+		case changes <- chg:
+		default:
+			delete(bw.subs, changes)
+			close(changes)
+		}
+	}
+}
+
+func idKey(id int64) (k []byte) {
+	k = make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(id))
+	return
+}
+
+func decodeErrorBolt(src error) (dst error) {
+	switch {
+	case src == nil:
+	case errors.Is(src, ErrNotFound), errors.Is(src, ErrConflict), errors.Is(src, ErrInternal), errors.Is(src, ErrInvalidFilter):
+		dst = src
+	default:
+		dst = fmt.Errorf("%w: %s", ErrInternal, src)
+	}
+	return
+}
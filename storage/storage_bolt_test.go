@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/config"
+	"github.com/awakari/source-telegram/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStorageBolt(t *testing.T, retention time.Duration) (s Storage) {
+	dbCfg := config.DbConfig{
+		Type: TypeBolt,
+		Path: filepath.Join(t.TempDir(), "channels.db"),
+	}
+	dbCfg.Table.Retention = retention
+	dbCfg.Table.DeleteGrace = time.Hour
+	s, err := NewStorage(context.TODO(), dbCfg)
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		assert.Nil(t, s.Close())
+	})
+	return
+}
+
+func TestStorageBolt_Conformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Storage {
+		return newTestStorageBolt(t, 0)
+	})
+}
+
+func TestStorageBolt_Retention(t *testing.T) {
+	ctx := context.TODO()
+	s := newTestStorageBolt(t, time.Hour)
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+		Last: time.Now().Add(-2 * time.Hour),
+	}))
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1002,
+		Link: "https://t.me/chan1",
+		Last: time.Now(),
+	}))
+	// never expires without any post
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1003,
+		Link: "https://t.me/chan2",
+	}))
+	//
+	_, err := s.Read(ctx, "https://t.me/chan0")
+	assert.ErrorIs(t, err, ErrNotFound)
+	page, err := s.GetPage(ctx, model.ChannelFilter{}, 10, "", model.OrderAsc)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(page))
+	assert.Equal(t, "https://t.me/chan1", page[0].Link)
+	assert.Equal(t, "https://t.me/chan2", page[1].Link)
+	counts, err := s.CountByLabel(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"": 2}, counts)
+	// the expired channel id is released after the purge only
+	err = s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan3",
+	})
+	assert.ErrorIs(t, err, ErrConflict)
+	require.Nil(t, s.(storageBolt).purge())
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan3",
+	}))
+	// the expiration is audited
+	audit, err := s.GetAuditPage(ctx, model.AuditFilter{ChannelId: -1001}, 10, "")
+	require.Nil(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, model.ChannelChangeDelete, audit[0].Action)
+	assert.Equal(t, "https://t.me/chan0", audit[0].Link)
+	assert.Equal(t, model.ActorSystemRetention, audit[0].Actor.System)
+}
+
+func TestStorageBolt_DeleteGrace(t *testing.T) {
+	ctx := context.TODO()
+	dbCfg := config.DbConfig{
+		Type: TypeBolt,
+		Path: filepath.Join(t.TempDir(), "channels.db"),
+	}
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	defer s.Close()
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	require.Nil(t, s.Delete(ctx, "https://t.me/chan0", "reason0"))
+	// can't be restored after the grace period even before the purge
+	_, err = s.Restore(ctx, "https://t.me/chan0")
+	assert.ErrorIs(t, err, ErrNotFound)
+	page, err := s.GetPage(ctx, model.ChannelFilter{Deleted: true}, 10, "", model.OrderAsc)
+	require.Nil(t, err)
+	assert.Empty(t, page)
+	require.Nil(t, s.(storageBolt).purge())
+	// the id is released
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan1",
+	}))
+	// the removal after the grace period is not audited again
+	audit, err := s.GetAuditPage(ctx, model.AuditFilter{ChannelId: -1001}, 10, "")
+	require.Nil(t, err)
+	assert.Empty(t, audit)
+}
+
+func TestStorageBolt_AuditRetention(t *testing.T) {
+	ctx := context.TODO()
+	dbCfg := config.DbConfig{
+		Type: TypeBolt,
+		Path: filepath.Join(t.TempDir(), "channels.db"),
+	}
+	dbCfg.Audit.Retention = time.Hour
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	defer s.Close()
+	require.Nil(t, s.AppendAudit(ctx, model.AuditRecord{
+		Time:      time.Now().Add(-2 * time.Hour),
+		ChannelId: -1001,
+		Link:      "https://t.me/chan0",
+	}))
+	require.Nil(t, s.AppendAudit(ctx, model.AuditRecord{
+		ChannelId: -1001,
+		Link:      "https://t.me/chan0",
+		Action:    model.ChannelChangeDelete,
+	}))
+	require.Nil(t, s.(storageBolt).purge())
+	audit, err := s.GetAuditPage(ctx, model.AuditFilter{ChannelId: -1001}, 10, "")
+	require.Nil(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, model.ChannelChangeDelete, audit[0].Action)
+}
+
+func TestStorageBolt_StatsRetention(t *testing.T) {
+	ctx := context.TODO()
+	dbCfg := config.DbConfig{
+		Type: TypeBolt,
+		Path: filepath.Join(t.TempDir(), "channels.db"),
+	}
+	dbCfg.Stats.Retention = time.Hour
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	defer s.Close()
+	require.Nil(t, s.AddStats(ctx, -1001, time.Now(), model.ChannelCounters{
+		Seen: 1,
+	}))
+	// not updated since the retention period
+	require.Nil(t, s.(storageBolt).db.Update(func(tx *bolt.Tx) (err error) {
+		return purgeStats(tx, time.Now().Add(time.Minute))
+	}))
+	require.Nil(t, s.AddStats(ctx, -1002, time.Now(), model.ChannelCounters{
+		Seen: 1,
+	}))
+	require.Nil(t, s.(storageBolt).purge())
+	stats, err := s.GetStats(ctx, []int64{-1001, -1002}, time.Time{})
+	require.Nil(t, err)
+	require.Len(t, stats, 1)
+	assert.Len(t, stats[-1002].Days, 1)
+}
+
+func TestStorageBolt_Reopen(t *testing.T) {
+	ctx := context.TODO()
+	dbCfg := config.DbConfig{
+		Type: TypeBolt,
+		Path: filepath.Join(t.TempDir(), "data", "channels.db"),
+	}
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+	}))
+	_, err = s.UpdateLink(ctx, -1001, "https://t.me/chan1")
+	require.Nil(t, err)
+	require.Nil(t, s.Close())
+	//
+	s, err = NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	defer s.Close()
+	assert.Nil(t, s.Ping(ctx))
+	ch, err := s.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, "https://t.me/chan1", ch.Link)
+}
+
+func TestStorageBolt_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	s := newTestStorageBolt(t, 0)
+	changes := make(chan model.ChannelChange, 10)
+	errWatch := make(chan error, 1)
+	go func() {
+		lbl := "1"
+		errWatch <- s.Watch(ctx, &lbl, func(chg model.ChannelChange) (err error) {
+			changes <- chg
+			return
+		})
+	}()
+	// wait for the subscription
+	require.Eventually(t, func() bool {
+		sb := s.(storageBolt)
+		sb.watchers.lock.Lock()
+		defer sb.watchers.lock.Unlock()
+		return len(sb.watchers.subs) == 1
+	}, time.Second, 10*time.Millisecond)
+	//
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:    -1001,
+		Link:  "https://t.me/chan0",
+		Label: "1",
+	}))
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1002,
+		Link: "https://t.me/chan1",
+	}))
+	_, err := s.UpdateLink(ctx, -1001, "https://t.me/chan2")
+	require.Nil(t, err)
+	require.Nil(t, s.UpdateLabel(ctx, "https://t.me/chan2", "2"))
+	require.Nil(t, s.UpdateLabel(ctx, "https://t.me/chan1", "1"))
+	require.Nil(t, s.Delete(ctx, "https://t.me/chan1", ""))
+	//
+	expected := []struct {
+		typ    model.ChannelChangeType
+		link   string
+		before string
+	}{
+		{typ: model.ChannelChangeCreate, link: "https://t.me/chan0"},
+		{typ: model.ChannelChangeUpdate, link: "https://t.me/chan2", before: "https://t.me/chan0"},
+		{typ: model.ChannelChangeUpdate, link: "https://t.me/chan2", before: "https://t.me/chan2"},
+		{typ: model.ChannelChangeUpdate, link: "https://t.me/chan1", before: "https://t.me/chan1"},
+		{typ: model.ChannelChangeDelete, before: "https://t.me/chan1"},
+	}
+	for _, e := range expected {
+		select {
+		case chg := <-changes:
+			assert.Equal(t, e.typ, chg.Type)
+			switch e.link {
+			case "":
+				assert.Nil(t, chg.Channel)
+			default:
+				require.NotNil(t, chg.Channel)
+				assert.Equal(t, e.link, chg.Channel.Link)
+			}
+			switch e.before {
+			case "":
+				assert.Nil(t, chg.Before)
+			default:
+				require.NotNil(t, chg.Before)
+				assert.Equal(t, e.before, chg.Before.Link)
+			}
+		case <-time.After(time.Second):
+			require.Fail(t, "change is not received", "%+v", e)
+		}
+	}
+	assert.Empty(t, changes)
+	cancel()
+	assert.ErrorIs(t, <-errWatch, context.Canceled)
+}
@@ -2,8 +2,9 @@ package storage
 
 import (
     "context"
-    "fmt"
+    "errors"
     "github.com/awakari/source-telegram/model"
+    "github.com/awakari/source-telegram/util"
     "log/slog"
     "time"
 )
@@ -23,42 +24,157 @@ func NewStorageLogging(stor Storage, log *slog.Logger) Storage {
 func (sl storageLogging) Close() (err error) {
     err = sl.stor.Close()
     ll := sl.logLevel(err)
-    sl.log.Log(context.TODO(), ll, fmt.Sprintf("storage.Close(): %s", err))
+    sl.log.LogAttrs(context.TODO(), ll, "storage.Close", util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) Ping(ctx context.Context) (err error) {
+    err = sl.stor.Ping(ctx)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.Ping", util.LogErr(err))
     return
 }
 
 func (sl storageLogging) Create(ctx context.Context, ch model.Channel) (err error) {
     err = sl.stor.Create(ctx, ch)
     ll := sl.logLevel(err)
-    sl.log.Log(ctx, ll, fmt.Sprintf("storage.Create(ch=%+v): %s", ch, err))
+    sl.log.LogAttrs(ctx, ll, "storage.Create", slog.String("link", ch.Link), slog.Int64("chatId", ch.Id), slog.String("groupId", ch.GroupId), slog.String("userId", ch.UserId), util.LogErr(err))
     return
 }
 
 func (sl storageLogging) Read(ctx context.Context, link string) (ch model.Channel, err error) {
     ch, err = sl.stor.Read(ctx, link)
     ll := sl.logLevel(err)
-    sl.log.Log(ctx, ll, fmt.Sprintf("storage.Read(%s): %+v, %s", link, ch, err))
+    sl.log.LogAttrs(ctx, ll, "storage.Read", slog.String("link", link), slog.Int64("chatId", ch.Id), util.LogErr(err))
     return
 }
 
 func (sl storageLogging) Update(ctx context.Context, link string, last time.Time) (err error) {
     err = sl.stor.Update(ctx, link, last)
     ll := sl.logLevel(err)
-    sl.log.Log(ctx, ll, fmt.Sprintf("storage.Update(%s, %s): %s", link, last, err))
+    sl.log.LogAttrs(ctx, ll, "storage.Update", slog.String("link", link), slog.Time("last", last), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) SetStale(ctx context.Context, link string, since time.Time) (err error) {
+    err = sl.stor.SetStale(ctx, link, since)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.SetStale", slog.String("link", link), slog.Time("since", since), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) UpdateLabel(ctx context.Context, link, label string) (err error) {
+    err = sl.stor.UpdateLabel(ctx, link, label)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.UpdateLabel", slog.String("link", link), slog.String("label", label), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) CountByLabel(ctx context.Context) (counts map[string]int64, err error) {
+    counts, err = sl.stor.CountByLabel(ctx)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.CountByLabel", slog.Any("counts", counts), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) UpdateLink(ctx context.Context, id int64, link string) (linkOld string, err error) {
+    linkOld, err = sl.stor.UpdateLink(ctx, id, link)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.UpdateLink", slog.Int64("chatId", id), slog.String("link", link), slog.String("linkOld", linkOld), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) Delete(ctx context.Context, link, reason string) (err error) {
+    err = sl.stor.Delete(ctx, link, reason)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.Delete", slog.String("link", link), slog.String("reason", reason), util.LogErr(err))
     return
 }
 
-func (sl storageLogging) Delete(ctx context.Context, link string) (err error) {
-    err = sl.stor.Delete(ctx, link)
+func (sl storageLogging) Restore(ctx context.Context, link string) (ch model.Channel, err error) {
+    ch, err = sl.stor.Restore(ctx, link)
     ll := sl.logLevel(err)
-    sl.log.Log(ctx, ll, fmt.Sprintf("storage.Delete(%s): %s", link, err))
+    sl.log.LogAttrs(ctx, ll, "storage.Restore", slog.String("link", link), slog.Int64("chatId", ch.Id), util.LogErr(err))
     return
 }
 
 func (sl storageLogging) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
     page, err = sl.stor.GetPage(ctx, filter, limit, cursor, order)
     ll := sl.logLevel(err)
-    sl.log.Log(ctx, ll, fmt.Sprintf("storage.GetPage(filter=%+v, limit=%d, cursor=%s, order=%s): %d, %s", filter, limit, cursor, order, len(page), err))
+    sl.log.LogAttrs(ctx, ll, "storage.GetPage", slog.Any("filter", filter), slog.Any("limit", limit), slog.String("cursor", cursor), slog.String("order", order.String()), slog.Int("count", len(page)), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) Iterate(ctx context.Context, filter model.ChannelFilter, batchSize uint32, consume func(ch model.Channel) (err error)) (err error) {
+    var count int
+    err = sl.stor.Iterate(ctx, filter, batchSize, func(ch model.Channel) (err error) {
+        count++
+        return consume(ch)
+    })
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.Iterate", slog.Any("filter", filter), slog.Any("batchSize", batchSize), slog.Int("count", count), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) Watch(ctx context.Context, lbl *string, consume func(chg model.ChannelChange) (err error)) (err error) {
+    err = sl.stor.Watch(ctx, lbl, func(chg model.ChannelChange) (err error) {
+        err = consume(chg)
+        attrs := []slog.Attr{
+            labelAttr(lbl),
+            slog.String("type", chg.Type.String()),
+        }
+        switch {
+        case chg.Channel != nil:
+            attrs = append(attrs, slog.String("link", chg.Channel.Link), slog.Int64("chatId", chg.Channel.Id))
+        case chg.Before != nil:
+            attrs = append(attrs, slog.String("link", chg.Before.Link), slog.Int64("chatId", chg.Before.Id))
+        }
+        sl.log.LogAttrs(ctx, sl.logLevel(err), "storage.Watch: change", append(attrs, util.LogErr(err))...)
+        return
+    })
+    ll := sl.logLevel(err)
+    if errors.Is(err, ErrWatchUnsupported) || errors.Is(err, context.Canceled) {
+        ll = slog.LevelInfo
+    }
+    sl.log.LogAttrs(ctx, ll, "storage.Watch", labelAttr(lbl), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) AppendAudit(ctx context.Context, rec model.AuditRecord) (err error) {
+    err = sl.stor.AppendAudit(ctx, rec)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.AppendAudit", slog.String("link", rec.Link), slog.String("action", rec.Action.String()), slog.Any("actor", rec.Actor), slog.String("reason", rec.Reason), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+    page, err = sl.stor.GetAuditPage(ctx, filter, limit, cursor)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.GetAuditPage", slog.Any("filter", filter), slog.Any("limit", limit), slog.String("cursor", cursor), slog.Int("count", len(page)), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) AddStats(ctx context.Context, chanId int64, t time.Time, delta model.ChannelCounters) (err error) {
+    err = sl.stor.AddStats(ctx, chanId, t, delta)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.AddStats", slog.Int64("chatId", chanId), slog.Time("time", t), slog.Any("delta", delta), util.LogErr(err))
+    return
+}
+
+func (sl storageLogging) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+    stats, err = sl.stor.GetStats(ctx, chanIds, since)
+    ll := sl.logLevel(err)
+    sl.log.LogAttrs(ctx, ll, "storage.GetStats", slog.Any("chatIds", chanIds), slog.Time("since", since), slog.Int("count", len(stats)), util.LogErr(err))
+    return
+}
+
+func labelAttr(lbl *string) (attr slog.Attr) {
+    switch lbl {
+    case nil:
+        attr = slog.String("label", "*")
+    default:
+        attr = slog.String("label", *lbl)
+    }
     return
 }
 
@@ -17,6 +17,10 @@ func (s storageMock) Close() error {
     return nil
 }
 
+func (s storageMock) Ping(ctx context.Context) (err error) {
+    return
+}
+
 func (s storageMock) Create(ctx context.Context, ch model.Channel) (err error) {
     switch ch.Name {
     case "fail":
@@ -53,7 +57,17 @@ func (s storageMock) Update(ctx context.Context, link string, last time.Time) (e
     return
 }
 
-func (s storageMock) Delete(ctx context.Context, link string) (err error) {
+func (s storageMock) SetStale(ctx context.Context, link string, since time.Time) (err error) {
+    switch link {
+    case "fail":
+        err = ErrInternal
+    case "missing":
+        err = ErrNotFound
+    }
+    return
+}
+
+func (s storageMock) UpdateLabel(ctx context.Context, link, label string) (err error) {
     switch link {
     case "fail":
         err = ErrInternal
@@ -63,6 +77,52 @@ func (s storageMock) Delete(ctx context.Context, link string) (err error) {
     return
 }
 
+func (s storageMock) CountByLabel(ctx context.Context) (counts map[string]int64, err error) {
+    counts = map[string]int64{
+        "":  2,
+        "1": 1,
+    }
+    return
+}
+
+func (s storageMock) UpdateLink(ctx context.Context, id int64, link string) (linkOld string, err error) {
+    switch link {
+    case "fail":
+        err = ErrInternal
+    case "missing":
+        err = ErrNotFound
+    case "conflict":
+        err = ErrConflict
+    default:
+        linkOld = "https://t.me/channel0"
+    }
+    return
+}
+
+func (s storageMock) Delete(ctx context.Context, link, reason string) (err error) {
+    switch link {
+    case "fail":
+        err = ErrInternal
+    case "missing":
+        err = ErrNotFound
+    }
+    return
+}
+
+func (s storageMock) Restore(ctx context.Context, link string) (ch model.Channel, err error) {
+    switch link {
+    case "fail":
+        err = ErrInternal
+    case "missing":
+        err = ErrNotFound
+    default:
+        ch.Id = -1001801930101
+        ch.Name = "channel0"
+        ch.Link = link
+    }
+    return
+}
+
 func (s storageMock) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
     switch cursor {
     case "":
@@ -93,3 +153,96 @@ func (s storageMock) GetPage(ctx context.Context, filter model.ChannelFilter, li
     }
     return
 }
+
+func (s storageMock) Iterate(ctx context.Context, filter model.ChannelFilter, batchSize uint32, consume func(ch model.Channel) (err error)) (err error) {
+    for _, ch := range []model.Channel{
+        {
+            Id:   -1001801930101,
+            Name: "channel0",
+            Link: "https://t.me/channel0",
+        },
+        {
+            Id:   -1001754252633,
+            Name: "channel1",
+            Link: "https://t.me/channel1",
+        },
+    } {
+        err = consume(ch)
+        if err != nil {
+            break
+        }
+    }
+    return
+}
+
+func (s storageMock) Watch(ctx context.Context, lbl *string, consume func(chg model.ChannelChange) (err error)) (err error) {
+    err = ErrWatchUnsupported
+    return
+}
+
+func (s storageMock) AppendAudit(ctx context.Context, rec model.AuditRecord) (err error) {
+    if rec.Link == "fail" {
+        err = ErrInternal
+    }
+    return
+}
+
+func (s storageMock) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+    switch filter.Link {
+    case "fail":
+        err = ErrInternal
+    default:
+        page = []model.AuditRecord{
+            {
+                Id:        "2ob2MB7IGTwakXv4bdXkZ5vt8Br",
+                Time:      time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC),
+                Action:    model.ChannelChangeDelete,
+                ChannelId: -1001801930101,
+                Link:      "https://t.me/channel0",
+                Actor: model.Actor{
+                    System: model.ActorSystemRefresh,
+                },
+                Reason: "nobot",
+            },
+        }
+    }
+    return
+}
+
+func (s storageMock) AddStats(ctx context.Context, chanId int64, t time.Time, delta model.ChannelCounters) (err error) {
+    if chanId == 0 {
+        err = ErrInternal
+    }
+    return
+}
+
+func (s storageMock) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+    stats = map[int64]model.ChannelStatsHistory{}
+    for _, chanId := range chanIds {
+        if chanId == 0 {
+            err = ErrInternal
+            break
+        }
+        day := time.Date(2024, 11, 4, 0, 0, 0, 0, time.UTC)
+        counters := model.ChannelCounters{
+            Seen:      3,
+            Published: 2,
+            Dropped: map[string]uint64{
+                "unsupported": 1,
+            },
+            TextBytes: 42,
+        }
+        stats[chanId] = model.ChannelStatsHistory{
+            ChannelId: chanId,
+            Total:     counters,
+            Days: []model.ChannelStatsDay{
+                {
+                    Date:     day,
+                    Counters: counters,
+                },
+            },
+            Updated: day.Add(18 * time.Hour),
+        }
+    }
+    return
+}
@@ -3,27 +3,37 @@ package storage
 import (
 	"context"
 	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/awakari/source-telegram/config"
 	"github.com/awakari/source-telegram/model"
+	"github.com/segmentio/ksuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"time"
 )
 
 type recChan struct {
-	Id      int64     `bson:"id"`
-	GroupId string    `bson:"groupId"`
-	UserId  string    `bson:"userId,omitempty"`
-	Name    string    `bson:"name"`
-	Link    string    `bson:"link"`
-	Created time.Time `bson:"created,omitempty"`
-	Last    time.Time `bson:"last,omitempty"`
-	SubId   string    `bson:"subId,omitempty"`
-	Terms   string    `bson:"terms,omitempty"`
-	Label   string    `bson:"label,omitempty"`
+	Id         int64     `bson:"id"`
+	GroupId    string    `bson:"groupId"`
+	UserId     string    `bson:"userId,omitempty"`
+	Name       string    `bson:"name"`
+	Link       string    `bson:"link"`
+	Created    time.Time `bson:"created,omitempty"`
+	Last       time.Time `bson:"last,omitempty"`
+	SubId      string    `bson:"subId,omitempty"`
+	Terms      string    `bson:"terms,omitempty"`
+	Label      string    `bson:"label,omitempty"`
+	Discussion bool      `bson:"discussion,omitempty"`
+	Aliases    []string  `bson:"aliases,omitempty"`
+	Stale      time.Time `bson:"stale,omitempty"`
+	// the soft deletion marker
+	Deleted      time.Time `bson:"deleted,omitempty"`
+	DeleteReason string    `bson:"deleteReason,omitempty"`
 }
 
 const attrId = "id"
@@ -36,11 +46,42 @@ const attrLast = "last"
 const attrSubId = "subId"
 const attrTerms = "terms"
 const attrLabel = "label"
+const attrDiscussion = "discussion"
+const attrAliases = "aliases"
+const attrStale = "stale"
+const attrDeleted = "deleted"
+const attrDeleteReason = "deleteReason"
+
+// notDeleted is the condition selecting the active channels only.
+var notDeleted = bson.M{
+	"$exists": false,
+}
+
+type recChange struct {
+	OperationType            string              `bson:"operationType"`
+	ClusterTime              primitive.Timestamp `bson:"clusterTime"`
+	FullDocument             *recChan            `bson:"fullDocument"`
+	FullDocumentBeforeChange *recChan            `bson:"fullDocumentBeforeChange"`
+}
+
+const opInsert = "insert"
+const opUpdate = "update"
+const opReplace = "replace"
+const opDelete = "delete"
+
+// regexQueryTimeout bounds the server time of the query with the user-defined regular expression.
+const regexQueryTimeout = 10 * time.Second
+
+// the server error codes when the change streams are not available, e.g. for the standalone server
+const codeChangeStreamReplicaSetOnly = 40573
+const codeUnrecognizedPipelineStage = 40324
 
 type storageMongo struct {
-	conn *mongo.Client
-	db   *mongo.Database
-	coll *mongo.Collection
+	conn  *mongo.Client
+	db    *mongo.Database
+	coll  *mongo.Collection
+	audit *mongo.Collection
+	stats *mongo.Collection
 }
 
 var optsSrvApi = options.ServerAPI(options.ServerAPIVersion1)
@@ -89,7 +130,52 @@ var projGet = bson.D{
 		Key:   attrLabel,
 		Value: 1,
 	},
+	{
+		Key:   attrDiscussion,
+		Value: 1,
+	},
+	{
+		Key:   attrStale,
+		Value: 1,
+	},
+	{
+		Key:   attrDeleted,
+		Value: 1,
+	},
+	{
+		Key:   attrDeleteReason,
+		Value: 1,
+	},
+}
+var optsWatch = options.
+	ChangeStream().
+	SetFullDocument(options.UpdateLookup).
+	SetFullDocumentBeforeChange(options.WhenAvailable)
+var pipelineWatch = mongo.Pipeline{
+	{
+		{
+			Key: "$match",
+			Value: bson.M{
+				"operationType": bson.M{
+					"$in": bson.A{
+						opInsert,
+						opUpdate,
+						opReplace,
+						opDelete,
+					},
+				},
+			},
+		},
+	},
 }
+var optsRestore = options.
+	FindOneAndUpdate().
+	SetReturnDocument(options.After).
+	SetProjection(projGet)
+var optsUpdateLink = options.
+	FindOneAndUpdate().
+	SetReturnDocument(options.Before).
+	SetProjection(projGet)
 var sortGetBatchAsc = bson.D{
 	{
 		Key:   attrLink,
@@ -102,8 +188,46 @@ var sortGetBatchDesc = bson.D{
 		Value: -1,
 	},
 }
+var sortText = bson.D{
+	{
+		Key: "score",
+		Value: bson.M{
+			"$meta": "textScore",
+		},
+	},
+	{
+		Key:   attrLink,
+		Value: 1,
+	},
+}
+var sortAudit = bson.D{
+	{
+		Key:   attrAuditId,
+		Value: -1,
+	},
+}
+var sortStats = bson.D{
+	{
+		Key:   attrStatsChannelId,
+		Value: 1,
+	},
+	{
+		Key:   attrStatsDay,
+		Value: -1,
+	},
+}
+var sortIterate = bson.D{
+	{
+		Key:   attrLink,
+		Value: 1,
+	},
+	{
+		Key:   attrId,
+		Value: 1,
+	},
+}
 
-func NewStorage(ctx context.Context, cfgDb config.DbConfig) (s Storage, err error) {
+func newStorageMongo(ctx context.Context, cfgDb config.DbConfig) (s Storage, err error) {
 	clientOpts := options.
 		Client().
 		ApplyURI(cfgDb.Uri).
@@ -127,7 +251,24 @@ func NewStorage(ctx context.Context, cfgDb config.DbConfig) (s Storage, err erro
 		sm.conn = conn
 		sm.db = db
 		sm.coll = coll
-		_, err = sm.ensureIndices(ctx, cfgDb.Table.Retention)
+		sm.audit = db.Collection(cfgDb.Table.Name + auditCollSuffix)
+		sm.stats = db.Collection(cfgDb.Table.Name + statsCollSuffix)
+		err = sm.migrate(ctx, db.Collection(cfgDb.Table.Name+metaCollSuffix), cfgDb)
+	}
+	if err == nil {
+		// best effort, requires MongoDB 6.0+: without the pre-images the deleted channel is unknown to the watchers
+		_ = sm.db.RunCommand(ctx, bson.D{
+			{
+				Key:   "collMod",
+				Value: cfgDb.Table.Name,
+			},
+			{
+				Key: "changeStreamPreAndPostImages",
+				Value: bson.M{
+					"enabled": true,
+				},
+			},
+		}).Err()
 	}
 	if err == nil {
 		s = sm
@@ -135,7 +276,7 @@ func NewStorage(ctx context.Context, cfgDb config.DbConfig) (s Storage, err erro
 	return
 }
 
-func (sm storageMongo) ensureIndices(ctx context.Context, retentionPeriod time.Duration) ([]string, error) {
+func (sm storageMongo) ensureIndices(ctx context.Context, retentionPeriod, deleteGrace time.Duration) ([]string, error) {
 	return sm.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{
 			Keys: bson.D{
@@ -159,6 +300,46 @@ func (sm storageMongo) ensureIndices(ctx context.Context, retentionPeriod time.D
 				Index().
 				SetUnique(true),
 		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrName,
+					Value: "text",
+				},
+				{
+					Key:   attrLink,
+					Value: "text",
+				},
+				{
+					Key:   attrTerms,
+					Value: "text",
+				},
+			},
+			Options: options.
+				Index().
+				SetWeights(bson.D{
+					{
+						Key:   attrName,
+						Value: textWeightName,
+					},
+					{
+						Key:   attrLink,
+						Value: textWeightLink,
+					},
+					{
+						Key:   attrTerms,
+						Value: textWeightTerms,
+					},
+				}).
+				// no stemming and stop words, the channel names are in any language
+				SetDefaultLanguage("none"),
+		},
+		{
+			Keys: sortIterate,
+			Options: options.
+				Index().
+				SetUnique(false),
+		},
 		{
 			Keys: bson.D{
 				{
@@ -171,6 +352,19 @@ func (sm storageMongo) ensureIndices(ctx context.Context, retentionPeriod time.D
 				SetExpireAfterSeconds(int32(retentionPeriod / time.Second)).
 				SetUnique(false),
 		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrDeleted,
+					Value: 1,
+				},
+			},
+			Options: options.
+				Index().
+				SetExpireAfterSeconds(int32(deleteGrace / time.Second)).
+				SetSparse(true).
+				SetUnique(false),
+		},
 		{
 			Keys: bson.D{
 				{
@@ -195,6 +389,115 @@ func (sm storageMongo) ensureIndices(ctx context.Context, retentionPeriod time.D
 				SetSparse(true).
 				SetUnique(false),
 		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrAliases,
+					Value: 1,
+				},
+			},
+			Options: options.
+				Index().
+				SetSparse(true).
+				SetUnique(false),
+		},
+	})
+}
+
+func (sm storageMongo) ensureAuditIndices(ctx context.Context, retentionPeriod time.Duration) ([]string, error) {
+	return sm.audit.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{
+					Key:   attrAuditChannelId,
+					Value: 1,
+				},
+				{
+					Key:   attrAuditId,
+					Value: -1,
+				},
+			},
+		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrAuditLink,
+					Value: 1,
+				},
+				{
+					Key:   attrAuditId,
+					Value: -1,
+				},
+			},
+		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrAuditGroupId,
+					Value: 1,
+				},
+				{
+					Key:   attrAuditUserId,
+					Value: 1,
+				},
+				{
+					Key:   attrAuditId,
+					Value: -1,
+				},
+			},
+			Options: options.
+				Index().
+				SetSparse(true),
+		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrAuditActorGroupId,
+					Value: 1,
+				},
+				{
+					Key:   attrAuditActorUserId,
+					Value: 1,
+				},
+				{
+					Key:   attrAuditId,
+					Value: -1,
+				},
+			},
+			Options: options.
+				Index().
+				SetSparse(true),
+		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrAuditTime,
+					Value: 1,
+				},
+			},
+			Options: options.
+				Index().
+				SetExpireAfterSeconds(int32(retentionPeriod / time.Second)),
+		},
+	})
+}
+
+func (sm storageMongo) ensureStatsIndices(ctx context.Context, retentionPeriod time.Duration) ([]string, error) {
+	return sm.stats.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: sortStats,
+		},
+		{
+			Keys: bson.D{
+				{
+					Key:   attrStatsUpdated,
+					Value: 1,
+				},
+			},
+			Options: options.
+				Index().
+				SetExpireAfterSeconds(int32(retentionPeriod / time.Second)),
+		},
 	})
 }
 
@@ -202,46 +505,95 @@ func (sm storageMongo) Close() error {
 	return sm.conn.Disconnect(context.TODO())
 }
 
+func (sm storageMongo) Ping(ctx context.Context) (err error) {
+	err = sm.conn.Ping(ctx, readpref.Primary())
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInternal, err)
+	}
+	return
+}
+
 func (sm storageMongo) Create(ctx context.Context, ch model.Channel) (err error) {
 	rec := recChan{
-		Id:      ch.Id,
-		GroupId: ch.GroupId,
-		UserId:  ch.UserId,
-		Name:    ch.Name,
-		Link:    ch.Link,
-		Last:    ch.Last,
-		Created: ch.Created,
-		SubId:   ch.SubId,
-		Terms:   ch.Terms,
-		Label:   ch.Label,
-	}
-	_, err = sm.coll.InsertOne(ctx, rec)
+		Id:         ch.Id,
+		GroupId:    ch.GroupId,
+		UserId:     ch.UserId,
+		Name:       ch.Name,
+		Link:       ch.Link,
+		Last:       ch.Last,
+		Created:    ch.Created,
+		SubId:      ch.SubId,
+		Terms:      ch.Terms,
+		Label:      ch.Label,
+		Discussion: ch.Discussion,
+		Stale:      ch.Stale,
+		// set when the channel is imported deleted
+		Deleted:      ch.Deleted,
+		DeleteReason: ch.DeleteReason,
+	}
+	sameIdOrLink := []bson.M{
+		{
+			attrId: ch.Id,
+		},
+		{
+			attrLink: ch.Link,
+		},
+	}
+	// check the active channels first, otherwise the deleted channel would be removed even when the insert fails
+	var active int64
+	active, err = sm.coll.CountDocuments(
+		ctx,
+		bson.M{
+			"$or": sameIdOrLink,
+			attrDeleted: bson.M{
+				"$exists": false,
+			},
+		},
+		options.Count().SetLimit(1),
+	)
+	if err == nil && active > 0 {
+		err = fmt.Errorf("%w: %s", ErrConflict, ch.Link)
+		return
+	}
+	// the deleted channel with the same id or link is replaced
+	if err == nil {
+		_, err = sm.coll.DeleteMany(ctx, bson.M{
+			"$or": sameIdOrLink,
+			attrDeleted: bson.M{
+				"$exists": true,
+			},
+		})
+	}
+	if err == nil {
+		_, err = sm.coll.InsertOne(ctx, rec)
+	}
 	err = decodeError(err, ch.Link)
 	return
 }
 
 func (sm storageMongo) Read(ctx context.Context, link string) (ch model.Channel, err error) {
 	q := bson.M{
-		attrLink: link,
+		attrLink:    link,
+		attrDeleted: notDeleted,
 	}
 	var result *mongo.SingleResult
 	result = sm.coll.FindOne(ctx, q, optsGet)
 	err = result.Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the channel might have been renamed
+		q = bson.M{
+			attrAliases: link,
+			attrDeleted: notDeleted,
+		}
+		result = sm.coll.FindOne(ctx, q, optsGet)
+		err = result.Err()
+	}
 	var rec recChan
 	if err == nil {
 		err = result.Decode(&rec)
 	}
 	if err == nil {
-		ch.Id = rec.Id
-		ch.GroupId = rec.GroupId
-		ch.UserId = rec.UserId
-		ch.Name = rec.Name
-		ch.Link = rec.Link
-		ch.Created = rec.Created
-		ch.Last = rec.Last
-		ch.SubId = rec.SubId
-		ch.Terms = rec.Terms
-		ch.Label = rec.Label
+		ch = rec.decode()
 	}
 	err = decodeError(err, link)
 	return
@@ -249,7 +601,8 @@ func (sm storageMongo) Read(ctx context.Context, link string) (ch model.Channel,
 
 func (sm storageMongo) Update(ctx context.Context, link string, last time.Time) (err error) {
 	q := bson.M{
-		attrLink: link,
+		attrLink:    link,
+		attrDeleted: notDeleted,
 	}
 	u := bson.M{
 		"$set": bson.M{
@@ -269,15 +622,31 @@ func (sm storageMongo) Update(ctx context.Context, link string, last time.Time)
 	return
 }
 
-func (sm storageMongo) Delete(ctx context.Context, link string) (err error) {
+func (sm storageMongo) SetStale(ctx context.Context, link string, since time.Time) (err error) {
 	q := bson.M{
-		attrLink: link,
+		attrLink:    link,
+		attrDeleted: notDeleted,
+	}
+	var u bson.M
+	switch since.IsZero() {
+	case true:
+		u = bson.M{
+			"$unset": bson.M{
+				attrStale: "",
+			},
+		}
+	default:
+		u = bson.M{
+			"$set": bson.M{
+				attrStale: since.UTC(),
+			},
+		}
 	}
-	var result *mongo.DeleteResult
-	result, err = sm.coll.DeleteOne(ctx, q)
+	var result *mongo.UpdateResult
+	result, err = sm.coll.UpdateOne(ctx, q, u)
 	switch err {
 	case nil:
-		if result.DeletedCount < 1 {
+		if result.MatchedCount < 1 {
 			err = fmt.Errorf("%w by link %s", ErrNotFound, link)
 		}
 	default:
@@ -286,8 +655,295 @@ func (sm storageMongo) Delete(ctx context.Context, link string) (err error) {
 	return
 }
 
+func (sm storageMongo) UpdateLabel(ctx context.Context, link, label string) (err error) {
+	q := bson.M{
+		attrLink:    link,
+		attrDeleted: notDeleted,
+	}
+	var u bson.M
+	switch label {
+	case "":
+		u = bson.M{
+			"$unset": bson.M{
+				attrLabel: "",
+			},
+		}
+	default:
+		u = bson.M{
+			"$set": bson.M{
+				attrLabel: label,
+			},
+		}
+	}
+	var result *mongo.UpdateResult
+	result, err = sm.coll.UpdateOne(ctx, q, u)
+	switch err {
+	case nil:
+		if result.MatchedCount < 1 {
+			err = fmt.Errorf("%w by link %s", ErrNotFound, link)
+		}
+	default:
+		err = decodeError(err, link)
+	}
+	return
+}
+
+func (sm storageMongo) CountByLabel(ctx context.Context) (counts map[string]int64, err error) {
+	pipeline := mongo.Pipeline{
+		{
+			{
+				Key: "$match",
+				Value: bson.M{
+					attrDeleted: notDeleted,
+				},
+			},
+		},
+		{
+			{
+				Key: "$group",
+				Value: bson.M{
+					"_id": "$" + attrLabel,
+					"count": bson.M{
+						"$sum": 1,
+					},
+				},
+			},
+		},
+	}
+	var cur *mongo.Cursor
+	cur, err = sm.coll.Aggregate(ctx, pipeline)
+	if err == nil {
+		defer cur.Close(ctx)
+		counts = map[string]int64{}
+		for cur.Next(ctx) {
+			var rec struct {
+				Label *string `bson:"_id"`
+				Count int64   `bson:"count"`
+			}
+			err = cur.Decode(&rec)
+			if err != nil {
+				break
+			}
+			var lbl string
+			if rec.Label != nil {
+				lbl = *rec.Label
+			}
+			counts[lbl] += rec.Count
+		}
+	}
+	err = decodeError(err, "")
+	return
+}
+
+func (sm storageMongo) UpdateLink(ctx context.Context, id int64, link string) (linkOld string, err error) {
+	q := bson.M{
+		attrId:      id,
+		attrDeleted: notDeleted,
+	}
+	// single stage pipeline: the expressions refer to the document state before the update
+	u := mongo.Pipeline{
+		{
+			{
+				Key: "$set",
+				Value: bson.M{
+					attrAliases: bson.M{
+						"$setDifference": bson.A{
+							bson.M{
+								"$setUnion": bson.A{
+									bson.M{
+										"$ifNull": bson.A{
+											"$" + attrAliases,
+											bson.A{},
+										},
+									},
+									bson.A{
+										"$" + attrLink,
+									},
+								},
+							},
+							bson.A{
+								link,
+							},
+						},
+					},
+					attrLink: link,
+				},
+			},
+		},
+	}
+	// the deleted channel with the same link is replaced like on the creation, unless the renamed one is missing
+	var found int64
+	found, err = sm.coll.CountDocuments(ctx, q, options.Count().SetLimit(1))
+	switch {
+	case err != nil:
+	case found == 0:
+		err = mongo.ErrNoDocuments
+	default:
+		_, err = sm.coll.DeleteMany(ctx, bson.M{
+			attrLink: link,
+			attrDeleted: bson.M{
+				"$exists": true,
+			},
+		})
+	}
+	var rec recChan
+	if err == nil {
+		err = sm.coll.FindOneAndUpdate(ctx, q, u, optsUpdateLink).Decode(&rec)
+	}
+	if err == nil {
+		linkOld = rec.Link
+	}
+	err = decodeError(err, link)
+	return
+}
+
+func (sm storageMongo) Delete(ctx context.Context, link, reason string) (err error) {
+	q := bson.M{
+		attrLink:    link,
+		attrDeleted: notDeleted,
+	}
+	set := bson.M{
+		attrDeleted: time.Now().UTC(),
+	}
+	if reason != "" {
+		set[attrDeleteReason] = reason
+	}
+	u := bson.M{
+		"$set": set,
+	}
+	var result *mongo.UpdateResult
+	result, err = sm.coll.UpdateOne(ctx, q, u)
+	if err == nil && result.MatchedCount < 1 {
+		// the channel might have been renamed
+		q = bson.M{
+			attrAliases: link,
+			attrDeleted: notDeleted,
+		}
+		result, err = sm.coll.UpdateOne(ctx, q, u)
+	}
+	switch err {
+	case nil:
+		if result.MatchedCount < 1 {
+			err = fmt.Errorf("%w by link %s", ErrNotFound, link)
+		}
+	default:
+		err = decodeError(err, link)
+	}
+	return
+}
+
+func (sm storageMongo) Restore(ctx context.Context, link string) (ch model.Channel, err error) {
+	q := bson.M{
+		attrLink: link,
+		attrDeleted: bson.M{
+			"$exists": true,
+		},
+	}
+	u := bson.M{
+		"$unset": bson.M{
+			attrDeleted:      "",
+			attrDeleteReason: "",
+		},
+	}
+	var rec recChan
+	err = sm.coll.FindOneAndUpdate(ctx, q, u, optsRestore).Decode(&rec)
+	if err == nil {
+		ch = rec.decode()
+	}
+	err = decodeError(err, link)
+	return
+}
+
 func (sm storageMongo) GetPage(ctx context.Context, filter model.ChannelFilter, limit uint32, cursor string, order model.Order) (page []model.Channel, err error) {
-	q := bson.M{}
+	optsList := options.
+		Find().
+		SetLimit(int64(limit)).
+		SetShowRecordID(false).
+		SetProjection(projGet)
+	var clauses []bson.M
+	switch {
+	case filter.Text != "":
+		// ranked by the relevance, the single page only
+		optsList = optsList.SetSort(sortText).SetLimit(int64(textLimit(limit)))
+	case order == model.OrderDesc:
+		clauses = append(clauses, bson.M{
+			attrLink: bson.M{
+				"$lt": cursor,
+			},
+		})
+		optsList = optsList.SetSort(sortGetBatchDesc)
+	default:
+		clauses = append(clauses, bson.M{
+			attrLink: bson.M{
+				"$gt": cursor,
+			},
+		})
+		optsList = optsList.SetSort(sortGetBatchAsc)
+	}
+	if filter.Regex {
+		optsList = optsList.SetMaxTime(regexQueryTimeout)
+	}
+	var q bson.M
+	q, err = filterQuery(filter, clauses...)
+	switch {
+	case err != nil:
+	case filter.Text != "" && cursor != "":
+		// nothing after the single page of the full-text search results
+	default:
+		page, err = sm.find(ctx, q, optsList)
+	}
+	err = decodeError(err, cursor)
+	return
+}
+
+func (sm storageMongo) Iterate(ctx context.Context, filter model.ChannelFilter, batchSize uint32, consume func(ch model.Channel) (err error)) (err error) {
+	err = iterate(ctx, batchSize, func(ctx context.Context, after *model.Channel, limit uint32) (batch []model.Channel, err error) {
+		var clauses []bson.M
+		if after != nil {
+			// the link is unique, the id makes the order stable anyway
+			clauses = append(clauses, bson.M{
+				"$or": []bson.M{
+					{
+						attrLink: bson.M{
+							"$gt": after.Link,
+						},
+					},
+					{
+						attrLink: after.Link,
+						attrId: bson.M{
+							"$gt": after.Id,
+						},
+					},
+				},
+			})
+		}
+		optsIterate := options.
+			Find().
+			SetLimit(int64(limit)).
+			SetShowRecordID(false).
+			SetProjection(projGet).
+			SetSort(sortIterate)
+		if filter.Regex {
+			optsIterate = optsIterate.SetMaxTime(regexQueryTimeout)
+		}
+		var q bson.M
+		q, err = filterQuery(filter, clauses...)
+		if err == nil {
+			batch, err = sm.find(ctx, q, optsIterate)
+		}
+		err = decodeError(err, "")
+		return
+	}, consume)
+	return
+}
+
+// filterQuery returns the query matching the filter and the additional clauses.
+func filterQuery(filter model.ChannelFilter, clauses ...bson.M) (q bson.M, err error) {
+	q = bson.M{
+		attrDeleted: bson.M{
+			"$exists": filter.Deleted,
+		},
+	}
 	lbl := filter.Label
 	if lbl != nil {
 		switch *lbl {
@@ -306,62 +962,289 @@ func (sm storageMongo) GetPage(ctx context.Context, filter model.ChannelFilter,
 	if filter.SubId != "" {
 		q[attrSubId] = filter.SubId
 	}
-	optsList := options.
-		Find().
-		SetLimit(int64(limit)).
-		SetShowRecordID(false).
-		SetProjection(projGet)
-	var clauseCursor bson.M
-	switch order {
-	case model.OrderDesc:
-		clauseCursor = bson.M{
-			"$lt": cursor,
-		}
-		optsList = optsList.SetSort(sortGetBatchDesc)
-	default:
-		clauseCursor = bson.M{
-			"$gt": cursor,
+	if filter.Text != "" {
+		q["$text"] = bson.M{
+			"$search": filter.Text,
 		}
-		optsList = optsList.SetSort(sortGetBatchAsc)
 	}
-	q["$and"] = []bson.M{
-		{
-			attrLink: clauseCursor,
-		},
-		{
+	var expr string
+	expr, err = patternExpr(filter)
+	if err == nil && expr != "" {
+		var regexOpts string
+		if filter.IgnoreCase {
+			regexOpts = "i"
+		}
+		clauses = append(clauses, bson.M{
 			"$or": []bson.M{
 				{
 					attrLink: bson.M{
-						"$regex": filter.Pattern,
+						"$regex":   expr,
+						"$options": regexOpts,
 					},
 				},
 				{
 					attrName: bson.M{
-						"$regex": filter.Pattern,
+						"$regex":   expr,
+						"$options": regexOpts,
 					},
 				},
 			},
-		},
+		})
+	}
+	if len(clauses) > 0 {
+		q["$and"] = clauses
 	}
+	return
+}
+
+func (sm storageMongo) find(ctx context.Context, q bson.M, opts *options.FindOptions) (page []model.Channel, err error) {
 	var cur *mongo.Cursor
-	cur, err = sm.coll.Find(ctx, q, optsList)
+	cur, err = sm.coll.Find(ctx, q, opts)
 	if err == nil {
+		defer cur.Close(ctx)
 		for cur.Next(ctx) {
 			var rec recChan
 			err = errors.Join(err, cur.Decode(&rec))
 			if err == nil {
-				page = append(page, model.Channel{
-					Id:      rec.Id,
-					GroupId: rec.GroupId,
-					UserId:  rec.UserId,
-					Name:    rec.Name,
-					Link:    rec.Link,
-					Label:   rec.Label,
-				})
+				page = append(page, rec.decode())
 			}
 		}
+		err = errors.Join(err, cur.Err())
 	}
-	err = decodeError(err, cursor)
+	return
+}
+
+func (sm storageMongo) Watch(ctx context.Context, lbl *string, consume func(chg model.ChannelChange) (err error)) (err error) {
+	var stream *mongo.ChangeStream
+	stream, err = sm.coll.Watch(ctx, pipelineWatch, optsWatch)
+	if err == nil {
+		defer stream.Close(context.TODO())
+		for err == nil && stream.Next(ctx) {
+			var rec recChange
+			err = stream.Decode(&rec)
+			if err == nil && rec.expired() {
+				err = sm.auditExpiration(ctx, rec)
+			}
+			if err == nil {
+				chg := rec.decode()
+				if chg.Matches(lbl) {
+					err = consume(chg)
+				}
+			}
+		}
+		if err == nil {
+			err = stream.Err()
+		}
+	}
+	var errSrv mongo.ServerError
+	switch {
+	case err == nil:
+	case errors.As(err, &errSrv) && (errSrv.HasErrorCode(codeChangeStreamReplicaSetOnly) || errSrv.HasErrorCode(codeUnrecognizedPipelineStage)):
+		err = fmt.Errorf("%w: %s", ErrWatchUnsupported, err)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+	default:
+		err = decodeError(err, "")
+	}
+	return
+}
+
+// auditExpiration records the channel removed by the TTL index. Every watcher receives the same deletion, so the record
+// id is derived from the operation time to be recorded once.
+func (sm storageMongo) auditExpiration(ctx context.Context, rec recChange) (err error) {
+	t := time.Unix(int64(rec.ClusterTime.T), 0)
+	payload := make([]byte, len(ksuid.Nil.Payload()))
+	binary.BigEndian.PutUint32(payload, rec.ClusterTime.I)
+	binary.BigEndian.PutUint64(payload[4:], uint64(rec.FullDocumentBeforeChange.Id))
+	id, _ := ksuid.FromParts(t, payload)
+	auditRec := auditExpiration(t, *rec.FullDocumentBeforeChange)
+	auditRec.Id = id.String()
+	err = sm.AppendAudit(ctx, auditRec)
+	if errors.Is(err, ErrConflict) {
+		err = nil // recorded by another watcher
+	}
+	return
+}
+
+func (sm storageMongo) AppendAudit(ctx context.Context, rec model.AuditRecord) (err error) {
+	_, err = sm.audit.InsertOne(ctx, encodeAudit(rec))
+	err = decodeError(err, rec.Link)
+	return
+}
+
+func (sm storageMongo) GetAuditPage(ctx context.Context, filter model.AuditFilter, limit uint32, cursor string) (page []model.AuditRecord, err error) {
+	q := bson.M{}
+	if filter.ChannelId != 0 {
+		q[attrAuditChannelId] = filter.ChannelId
+	}
+	if filter.Link != "" {
+		q[attrAuditLink] = filter.Link
+	}
+	if filter.GroupId != "" || filter.UserId != "" {
+		// either the owner or the actor
+		q["$or"] = []bson.M{
+			auditUserQuery(attrAuditGroupId, attrAuditUserId, filter),
+			auditUserQuery(attrAuditActorGroupId, attrAuditActorUserId, filter),
+		}
+	}
+	if cursor != "" {
+		q[attrAuditId] = bson.M{
+			"$lt": cursor,
+		}
+	}
+	optsAudit := options.
+		Find().
+		SetLimit(int64(limit)).
+		SetSort(sortAudit)
+	var cur *mongo.Cursor
+	cur, err = sm.audit.Find(ctx, q, optsAudit)
+	var recs []recAudit
+	if err == nil {
+		err = cur.All(ctx, &recs)
+	}
+	for _, rec := range recs {
+		page = append(page, rec.decode())
+	}
+	err = decodeError(err, "")
+	return
+}
+
+func (sm storageMongo) AddStats(ctx context.Context, chanId int64, t time.Time, delta model.ChannelCounters) (err error) {
+	if delta.IsZero() {
+		return
+	}
+	inc := bson.M{
+		attrStatsSeen:      delta.Seen,
+		attrStatsPublished: delta.Published,
+		attrStatsFailed:    delta.Failed,
+		attrStatsTextBytes: delta.TextBytes,
+	}
+	for reason, n := range delta.Dropped {
+		inc[attrStatsDropped+"."+reason] = n
+	}
+	updated := time.Now().UTC()
+	var writes []mongo.WriteModel
+	for _, day := range []time.Time{{}, model.Day(t)} {
+		insert := bson.M{
+			attrStatsChannelId: chanId,
+		}
+		if !day.IsZero() {
+			insert[attrStatsDay] = day
+		}
+		writes = append(
+			writes,
+			mongo.
+				NewUpdateOneModel().
+				SetFilter(bson.M{
+					attrStatsId: statsId(chanId, day),
+				}).
+				SetUpdate(bson.M{
+					"$inc": inc,
+					"$set": bson.M{
+						attrStatsUpdated: updated,
+					},
+					"$setOnInsert": insert,
+				}).
+				SetUpsert(true),
+		)
+	}
+	_, err = sm.stats.BulkWrite(ctx, writes)
+	err = decodeError(err, "")
+	return
+}
+
+func (sm storageMongo) GetStats(ctx context.Context, chanIds []int64, since time.Time) (stats map[int64]model.ChannelStatsHistory, err error) {
+	q := bson.M{
+		attrStatsChannelId: bson.M{
+			"$in": chanIds,
+		},
+		"$or": []bson.M{
+			{
+				attrStatsDay: bson.M{
+					"$exists": false,
+				},
+			},
+			{
+				attrStatsDay: bson.M{
+					"$gte": model.Day(since),
+				},
+			},
+		},
+	}
+	var cur *mongo.Cursor
+	cur, err = sm.stats.Find(ctx, q, options.Find().SetSort(sortStats))
+	var recs []recStats
+	if err == nil {
+		err = cur.All(ctx, &recs)
+	}
+	stats = decodeStats(recs)
+	err = decodeError(err, "")
+	return
+}
+
+func auditUserQuery(attrGroupId, attrUserId string, filter model.AuditFilter) (q bson.M) {
+	q = bson.M{}
+	if filter.GroupId != "" {
+		q[attrGroupId] = filter.GroupId
+	}
+	if filter.UserId != "" {
+		q[attrUserId] = filter.UserId
+	}
+	return
+}
+
+// expired returns true when the active channel is removed by the TTL index, the deleted ones are audited on the deletion
+// already. The pre-image is required to know this, so the expiration is not audited without it.
+func (rec recChange) expired() bool {
+	return rec.OperationType == opDelete && rec.FullDocumentBeforeChange != nil && rec.FullDocumentBeforeChange.Deleted.IsZero()
+}
+
+// decode treats the soft deletion as the deletion and the restoration as the creation.
+func (rec recChange) decode() (chg model.ChannelChange) {
+	deleted := rec.FullDocument != nil && !rec.FullDocument.Deleted.IsZero()
+	deletedBefore := rec.FullDocumentBeforeChange != nil && !rec.FullDocumentBeforeChange.Deleted.IsZero()
+	switch {
+	case rec.OperationType == opDelete, deleted:
+		chg.Type = model.ChannelChangeDelete
+	case rec.OperationType == opInsert:
+		chg.Type = model.ChannelChangeCreate
+	case deletedBefore:
+		chg.Type = model.ChannelChangeCreate
+	default:
+		chg.Type = model.ChannelChangeUpdate
+	}
+	if rec.FullDocument != nil && chg.Type != model.ChannelChangeDelete {
+		ch := rec.FullDocument.decode()
+		chg.Channel = &ch
+	}
+	switch {
+	case chg.Type == model.ChannelChangeCreate:
+		// nothing before the creation or the restoration
+	case rec.FullDocumentBeforeChange != nil:
+		before := rec.FullDocumentBeforeChange.decode()
+		chg.Before = &before
+	case deleted:
+		// the channel marked deleted is known even without the pre-image
+		before := rec.FullDocument.decode()
+		chg.Before = &before
+	}
+	return
+}
+
+func (rec recChan) decode() (ch model.Channel) {
+	ch.Id = rec.Id
+	ch.GroupId = rec.GroupId
+	ch.UserId = rec.UserId
+	ch.Name = rec.Name
+	ch.Link = rec.Link
+	ch.Created = rec.Created
+	ch.Last = rec.Last
+	ch.SubId = rec.SubId
+	ch.Terms = rec.Terms
+	ch.Label = rec.Label
+	ch.Discussion = rec.Discussion
+	ch.Stale = rec.Stale
+	ch.Deleted = rec.Deleted
+	ch.DeleteReason = rec.DeleteReason
 	return
 }
 
@@ -372,6 +1255,8 @@ func decodeError(src error, link string) (dst error) {
 		dst = fmt.Errorf("%w: %s", ErrNotFound, link)
 	case mongo.IsDuplicateKeyError(src):
 		dst = fmt.Errorf("%w: %s", ErrConflict, link)
+	case errors.Is(src, ErrInvalidFilter):
+		dst = src
 	default:
 		dst = fmt.Errorf("%w: %s", ErrInternal, src)
 	}
@@ -2,12 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/awakari/source-telegram/config"
 	"github.com/awakari/source-telegram/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"os"
 	"strconv"
 	"testing"
@@ -36,8 +38,31 @@ func TestNewStorage(t *testing.T) {
 	clear(ctx, t, s.(storageMongo))
 }
 
+func TestStorageMongo_Conformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Storage {
+		dbCfg := config.DbConfig{
+			Uri:  dbUri,
+			Name: "sources",
+		}
+		dbCfg.Table.Name = fmt.Sprintf("tgchans-test-%d", time.Now().UnixMicro())
+		dbCfg.Tls.Enabled = true
+		dbCfg.Tls.Insecure = true
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+		s, err := NewStorage(ctx, dbCfg)
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			clear(context.TODO(), t, s.(storageMongo))
+		})
+		return s
+	})
+}
+
 func clear(ctx context.Context, t *testing.T, s storageMongo) {
 	require.Nil(t, s.coll.Drop(ctx))
+	require.Nil(t, s.db.Collection(s.coll.Name()+metaCollSuffix).Drop(ctx))
+	require.Nil(t, s.audit.Drop(ctx))
+	require.Nil(t, s.stats.Drop(ctx))
 	require.Nil(t, s.Close())
 }
 
@@ -120,11 +145,12 @@ func TestStorageMongo_Read(t *testing.T) {
 	defer clear(ctx, t, s.(storageMongo))
 	//
 	_, err = sm.coll.InsertOne(ctx, bson.M{
-		attrId:      -1001801930101,
-		attrGroupId: "group0",
-		attrUserId:  "user0",
-		attrName:    "channel 0",
-		attrLink:    "https://t.me/chan0",
+		attrId:         -1001801930101,
+		attrGroupId:    "group0",
+		attrUserId:     "user0",
+		attrName:       "channel 0",
+		attrLink:       "https://t.me/chan0",
+		attrDiscussion: true,
 	})
 	require.Nil(t, err)
 	//
@@ -136,11 +162,12 @@ func TestStorageMongo_Read(t *testing.T) {
 		"ok": {
 			link: "https://t.me/chan0",
 			out: model.Channel{
-				Id:      -1001801930101,
-				GroupId: "group0",
-				UserId:  "user0",
-				Name:    "channel 0",
-				Link:    "https://t.me/chan0",
+				Id:         -1001801930101,
+				GroupId:    "group0",
+				UserId:     "user0",
+				Name:       "channel 0",
+				Link:       "https://t.me/chan0",
+				Discussion: true,
 			},
 		},
 		"dup id": {
@@ -199,7 +226,7 @@ func TestStorageMongo_Delete(t *testing.T) {
 	//
 	for k, c := range cases {
 		t.Run(k, func(t *testing.T) {
-			err = s.Delete(ctx, c.link)
+			err = s.Delete(ctx, c.link, "")
 			assert.ErrorIs(t, err, c.err)
 		})
 	}
@@ -402,3 +429,345 @@ func TestStorageMongo_GetPage(t *testing.T) {
 		})
 	}
 }
+
+func TestStorageMongo_UpdateLink(t *testing.T) {
+	//
+	collName := fmt.Sprintf("tgchans-test-%d", time.Now().UnixMicro())
+	dbCfg := config.DbConfig{
+		Uri:  dbUri,
+		Name: "sources",
+	}
+	dbCfg.Table.Name = collName
+	dbCfg.Tls.Enabled = true
+	dbCfg.Tls.Insecure = true
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+	defer cancel()
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	assert.NotNil(t, s)
+	//
+	sm := s.(storageMongo)
+	defer clear(ctx, t, s.(storageMongo))
+	//
+	_, err = sm.coll.InsertOne(ctx, bson.M{
+		attrId:   -1001801930101,
+		attrLink: "@chan0",
+	})
+	require.Nil(t, err)
+	_, err = sm.coll.InsertOne(ctx, bson.M{
+		attrId:   -1001801930102,
+		attrLink: "@chan2",
+	})
+	require.Nil(t, err)
+	//
+	cases := []struct {
+		name    string
+		id      int64
+		link    string
+		linkOld string
+		err     error
+	}{
+		{
+			name:    "ok",
+			id:      -1001801930101,
+			link:    "@chan1",
+			linkOld: "@chan0",
+		},
+		{
+			name: "missing",
+			id:   -1001801930103,
+			link: "@chan3",
+			err:  ErrNotFound,
+		},
+		{
+			name: "conflict",
+			id:   -1001801930101,
+			link: "@chan2",
+			err:  ErrConflict,
+		},
+		{
+			name:    "rename back",
+			id:      -1001801930101,
+			link:    "@chan0",
+			linkOld: "@chan1",
+		},
+	}
+	//
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var linkOld string
+			linkOld, err = s.UpdateLink(ctx, c.id, c.link)
+			assert.Equal(t, c.linkOld, linkOld)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				var ch model.Channel
+				ch, err = s.Read(ctx, c.linkOld)
+				assert.Nil(t, err)
+				assert.Equal(t, c.id, ch.Id)
+				assert.Equal(t, c.link, ch.Link)
+			}
+		})
+	}
+}
+
+func TestStorageMongo_SetStale(t *testing.T) {
+	//
+	collName := fmt.Sprintf("tgchans-test-%d", time.Now().UnixMicro())
+	dbCfg := config.DbConfig{
+		Uri:  dbUri,
+		Name: "sources",
+	}
+	dbCfg.Table.Name = collName
+	dbCfg.Tls.Enabled = true
+	dbCfg.Tls.Insecure = true
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+	defer cancel()
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	assert.NotNil(t, s)
+	//
+	sm := s.(storageMongo)
+	defer clear(ctx, t, s.(storageMongo))
+	//
+	_, err = sm.coll.InsertOne(ctx, bson.M{
+		attrId:   -1001801930101,
+		attrLink: "https://t.me/chan0",
+	})
+	require.Nil(t, err)
+	//
+	since := time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)
+	cases := []struct {
+		name  string
+		link  string
+		since time.Time
+		err   error
+	}{
+		{
+			name:  "flag",
+			link:  "https://t.me/chan0",
+			since: since,
+		},
+		{
+			name: "unflag",
+			link: "https://t.me/chan0",
+		},
+		{
+			name:  "missing",
+			link:  "https://t.me/chan1",
+			since: since,
+			err:   ErrNotFound,
+		},
+	}
+	//
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err = s.SetStale(ctx, c.link, c.since)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				var ch model.Channel
+				ch, err = s.Read(ctx, c.link)
+				assert.Nil(t, err)
+				assert.Equal(t, c.since, ch.Stale.UTC())
+			}
+		})
+	}
+}
+
+func TestStorageMongo_UpdateLabel(t *testing.T) {
+	//
+	collName := fmt.Sprintf("tgchans-test-%d", time.Now().UnixMicro())
+	dbCfg := config.DbConfig{
+		Uri:  dbUri,
+		Name: "sources",
+	}
+	dbCfg.Table.Name = collName
+	dbCfg.Tls.Enabled = true
+	dbCfg.Tls.Insecure = true
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+	defer cancel()
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	assert.NotNil(t, s)
+	//
+	sm := s.(storageMongo)
+	defer clear(ctx, t, s.(storageMongo))
+	//
+	for i, lbl := range []string{"", "", "1", "2"} {
+		rec := bson.M{
+			attrId:   -1001801930100 - int64(i),
+			attrLink: fmt.Sprintf("https://t.me/chan%d", i),
+		}
+		if lbl != "" {
+			rec[attrLabel] = lbl
+		}
+		_, err = sm.coll.InsertOne(ctx, rec)
+		require.Nil(t, err)
+	}
+	//
+	var counts map[string]int64
+	counts, err = s.CountByLabel(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"": 2, "1": 1, "2": 1}, counts)
+	//
+	err = s.UpdateLabel(ctx, "https://t.me/chan0", "1")
+	require.Nil(t, err)
+	err = s.UpdateLabel(ctx, "https://t.me/chan3", "")
+	require.Nil(t, err)
+	err = s.UpdateLabel(ctx, "https://t.me/chan4", "1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	counts, err = s.CountByLabel(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"": 2, "1": 2}, counts)
+}
+
+// the TTL monitor is simulated by removing the channels directly
+func TestStorageMongo_WatchExpiration(t *testing.T) {
+	dbCfg := config.DbConfig{
+		Uri:  dbUri,
+		Name: "sources",
+	}
+	dbCfg.Table.Name = fmt.Sprintf("tgchans-test-%d", time.Now().UnixMicro())
+	dbCfg.Tls.Enabled = true
+	dbCfg.Tls.Insecure = true
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	sm := s.(storageMongo)
+	defer clear(context.TODO(), t, sm)
+	//
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1001,
+		Link: "https://t.me/chan0",
+		Last: time.Now().Add(-time.Hour),
+	}))
+	require.Nil(t, s.Create(ctx, model.Channel{
+		Id:   -1002,
+		Link: "https://t.me/chan1",
+	}))
+	require.Nil(t, s.Delete(ctx, "https://t.me/chan1", "by the owner"))
+	// every watcher receives the same deletions
+	ctxWatch, cancelWatch := context.WithCancel(ctx)
+	defer cancelWatch()
+	const watchers = 2
+	deletes := make(chan model.ChannelChange, 10)
+	errWatch := make(chan error, watchers)
+	for i := 0; i < watchers; i++ {
+		go func() {
+			errWatch <- s.Watch(ctxWatch, nil, func(chg model.ChannelChange) (err error) {
+				if chg.Type == model.ChannelChangeDelete {
+					deletes <- chg
+				}
+				return
+			})
+		}()
+	}
+	select {
+	case err = <-errWatch:
+		if errors.Is(err, ErrWatchUnsupported) {
+			t.Skip(err)
+		}
+		require.Fail(t, "watch failed", err)
+	case <-time.After(time.Second):
+		// the change streams are opened
+	}
+	_, err = sm.coll.DeleteMany(ctx, bson.M{
+		attrId: bson.M{
+			"$in": bson.A{-1001, -1002},
+		},
+	})
+	require.Nil(t, err)
+	var noPreImages bool
+	for i := 0; i < 2*watchers; i++ {
+		select {
+		case chg := <-deletes:
+			noPreImages = noPreImages || chg.Before == nil
+		case err = <-errWatch:
+			require.Fail(t, "watch failed", err)
+		case <-time.After(10 * time.Second):
+			require.Fail(t, "deletion is not received")
+		}
+	}
+	cancelWatch()
+	for i := 0; i < watchers; i++ {
+		assert.ErrorIs(t, <-errWatch, context.Canceled)
+	}
+	if noPreImages {
+		t.Skip("the expiration is not audited without the pre-images, MongoDB 6.0+ is required")
+	}
+	// the expired channel is recorded once, the deleted one was recorded on the deletion by the service already
+	audit, err := s.GetAuditPage(ctx, model.AuditFilter{ChannelId: -1001}, 10, "")
+	require.Nil(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, model.ChannelChangeDelete, audit[0].Action)
+	assert.Equal(t, "https://t.me/chan0", audit[0].Link)
+	assert.Equal(t, model.ActorSystemRetention, audit[0].Actor.System)
+	audit, err = s.GetAuditPage(ctx, model.AuditFilter{ChannelId: -1002}, 10, "")
+	require.Nil(t, err)
+	assert.Empty(t, audit)
+}
+
+func TestStorageMongo_Migrate(t *testing.T) {
+	//
+	collName := fmt.Sprintf("tgchans-test-%d", time.Now().UnixMicro())
+	dbCfg := config.DbConfig{
+		Uri:  dbUri,
+		Name: "sources",
+	}
+	dbCfg.Table.Name = collName
+	dbCfg.Table.Retention = 24 * time.Hour
+	dbCfg.Tls.Enabled = true
+	dbCfg.Tls.Insecure = true
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	sm := s.(storageMongo)
+	defer clear(ctx, t, sm)
+	meta := sm.db.Collection(collName + metaCollSuffix)
+	version, err := schemaVersion(ctx, meta)
+	require.Nil(t, err)
+	assert.Equal(t, migrationsMongo[len(migrationsMongo)-1].version, version)
+	//
+	last := time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC)
+	_, err = sm.coll.InsertOne(ctx, bson.M{
+		attrId:    -1001801930101,
+		attrLink:  "https://t.me/chan0",
+		attrLast:  last,
+		attrLabel: "",
+		attrSubId: "",
+	})
+	require.Nil(t, err)
+	// the steps are applied again when the version is not recorded
+	_, err = meta.DeleteOne(ctx, bson.M{attrMetaId: metaIdSchema})
+	require.Nil(t, err)
+	dbCfg.Table.Retention = 48 * time.Hour
+	require.Nil(t, sm.migrate(ctx, meta, dbCfg))
+	var rec bson.M
+	require.Nil(t, sm.coll.FindOne(ctx, bson.M{attrId: -1001801930101}).Decode(&rec))
+	assert.NotContains(t, rec, attrLabel)
+	assert.NotContains(t, rec, attrSubId)
+	assert.Equal(t, last, rec[attrCreated].(primitive.DateTime).Time().UTC())
+	ch, err := s.Read(ctx, "https://t.me/chan0")
+	require.Nil(t, err)
+	assert.Equal(t, last, ch.Created.UTC())
+	// retention is changed in place
+	cursor, err := sm.coll.Indexes().List(ctx)
+	require.Nil(t, err)
+	var indices []recIndex
+	require.Nil(t, cursor.All(ctx, &indices))
+	ttls := map[string]int64{}
+	for _, idx := range indices {
+		if idx.ExpireAfterSeconds != nil {
+			ttls[idx.Key[0].Key] = *idx.ExpireAfterSeconds
+		}
+	}
+	assert.Equal(t, int64(48*time.Hour/time.Second), ttls[attrLast])
+	// the lock held by another replica
+	require.Nil(t, lockMigration(ctx, meta, "replica1"))
+	ctxLocked, cancelLocked := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelLocked()
+	assert.ErrorIs(t, sm.migrate(ctxLocked, meta, dbCfg), context.DeadlineExceeded)
+	require.Nil(t, unlockMigration(ctx, meta, "replica1"))
+	assert.Nil(t, sm.migrate(ctx, meta, dbCfg))
+}
@@ -0,0 +1,73 @@
+package telegram
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// dbEntries are the TDLib database files and the downloaded files directories (when the files directory is not set).
+var dbEntries = []string{
+	"td.binlog",
+	"td_test.binlog",
+	"db.sqlite",
+	"db.sqlite-shm",
+	"db.sqlite-wal",
+	"animations",
+	"audio",
+	"documents",
+	"music",
+	"passport",
+	"photos",
+	"profile_photos",
+	"secret",
+	"secret_thumbnails",
+	"stickers",
+	"temp",
+	"thumbnails",
+	"video_notes",
+	"videos",
+	"voice",
+	"wallpapers",
+}
+
+// DbDir returns the TDLib database directory of the account, every account keeps it in the own subdirectory.
+func DbDir(root string, idx int) string {
+	return filepath.Join(root, strconv.Itoa(idx))
+}
+
+// MigrateDbDir moves the TDLib database from the root directory, where it was kept when the single account was hosted,
+// to the account subdirectory. Nothing is moved when the root directory contains no database. Returns the moved entries.
+func MigrateDbDir(root string, idx int) (moved []string, err error) {
+	if root == "" {
+		root = "."
+	}
+	_, err = os.Stat(filepath.Join(root, dbEntries[0]))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		err = nil
+		return
+	case err != nil:
+		return
+	}
+	dst := DbDir(root, idx)
+	if _, errDst := os.Stat(filepath.Join(dst, dbEntries[0])); errDst == nil {
+		err = errors.New("the TDLib database is present in both " + root + " and " + dst + ", resolve manually")
+		return
+	}
+	err = os.MkdirAll(dst, 0700)
+	for _, name := range dbEntries {
+		if err != nil {
+			break
+		}
+		err = os.Rename(filepath.Join(root, name), filepath.Join(dst, name))
+		switch {
+		case err == nil:
+			moved = append(moved, name)
+		case errors.Is(err, os.ErrNotExist):
+			err = nil
+		}
+	}
+	return
+}
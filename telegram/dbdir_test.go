@@ -0,0 +1,39 @@
+package telegram
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateDbDir(t *testing.T) {
+	root := t.TempDir()
+	// nothing to move
+	moved, err := MigrateDbDir(root, 1)
+	require.Nil(t, err)
+	assert.Empty(t, moved)
+	// the flat layout
+	require.Nil(t, os.WriteFile(filepath.Join(root, "td.binlog"), []byte("binlog"), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(root, "db.sqlite"), []byte("db"), 0600))
+	require.Nil(t, os.Mkdir(filepath.Join(root, "photos"), 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(root, "other.txt"), []byte("other"), 0600))
+	moved, err = MigrateDbDir(root, 1)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"td.binlog", "db.sqlite", "photos"}, moved)
+	data, err := os.ReadFile(filepath.Join(DbDir(root, 1), "td.binlog"))
+	require.Nil(t, err)
+	assert.Equal(t, "binlog", string(data))
+	assert.DirExists(t, filepath.Join(DbDir(root, 1), "photos"))
+	assert.FileExists(t, filepath.Join(root, "other.txt"))
+	assert.NoFileExists(t, filepath.Join(root, "td.binlog"))
+	// moved once
+	moved, err = MigrateDbDir(root, 1)
+	require.Nil(t, err)
+	assert.Empty(t, moved)
+	// both layouts present
+	require.Nil(t, os.WriteFile(filepath.Join(root, "td.binlog"), []byte("binlog"), 0600))
+	_, err = MigrateDbDir(root, 1)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,161 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MethodClass groups the TDLib methods limited by Telegram together, a flood wait pauses the whole class.
+type MethodClass string
+
+const (
+	MethodClassChats  MethodClass = "chats"
+	MethodClassSearch MethodClass = "search"
+	MethodClassJoin   MethodClass = "join"
+	MethodClassSend   MethodClass = "send"
+	MethodClassAdmin  MethodClass = "admin"
+	// MethodClassMessages is used by the incoming messages handling which should never wait.
+	MethodClassMessages MethodClass = "messages"
+)
+
+type FloodControl interface {
+	// Do invokes the call, the call is delayed while the method class is paused and retried after the pause when
+	// it fails with a flood wait. Returns ErrFloodWait immediately if the pause is longer than the configured max.
+	Do(class MethodClass, call func() error) (err error)
+	// DoNow invokes the call w/o waiting, returns ErrFloodWait immediately if the method class is paused or the call
+	// fails with a flood wait. The call is not retried.
+	DoNow(class MethodClass, call func() error) (err error)
+	// Waits returns the current pause deadlines by the method class.
+	Waits() (waits map[MethodClass]time.Time)
+}
+
+type floodControl struct {
+	account int
+	waitMax time.Duration
+	lock    *sync.Mutex
+	until   map[MethodClass]time.Time
+}
+
+const codeTooManyRequests = 429
+
+var ErrFloodWait = errors.New("flood wait")
+
+var patternRetryAfter = regexp.MustCompile(`(?:retry after |FLOOD_WAIT_)(\d+)`)
+
+var metricFloodWaits = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "awakari_source_telegram_flood_waits_total",
+		Help: "Awakari source telegram: flood waits received from Telegram",
+	},
+	[]string{"account", "class"},
+)
+
+var metricFloodWaitUntil = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "awakari_source_telegram_flood_wait_until_timestamp_seconds",
+		Help: "Awakari source telegram: the time when the method class pause ends",
+	},
+	[]string{"account", "class"},
+)
+
+func NewFloodControl(account int, waitMax time.Duration) FloodControl {
+	return floodControl{
+		account: account,
+		waitMax: waitMax,
+		lock:    &sync.Mutex{},
+		until:   map[MethodClass]time.Time{},
+	}
+}
+
+func (fc floodControl) Do(class MethodClass, call func() error) (err error) {
+	for {
+		err = fc.await(class)
+		if err == nil {
+			err = call()
+		}
+		d, flood := FloodWait(err)
+		if !flood {
+			break
+		}
+		fc.pause(class, d)
+	}
+	return
+}
+
+func (fc floodControl) DoNow(class MethodClass, call func() error) (err error) {
+	fc.lock.Lock()
+	until := fc.until[class]
+	fc.lock.Unlock()
+	if d := time.Until(until); d > 0 {
+		err = fmt.Errorf("%w: %s, retry in %s", ErrFloodWait, class, d)
+		return
+	}
+	err = call()
+	d, flood := FloodWait(err)
+	if flood {
+		fc.pause(class, d)
+		err = fmt.Errorf("%w: %s, retry in %s", ErrFloodWait, class, d)
+	}
+	return
+}
+
+func (fc floodControl) Waits() (waits map[MethodClass]time.Time) {
+	waits = map[MethodClass]time.Time{}
+	now := time.Now()
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	for class, until := range fc.until {
+		if until.After(now) {
+			waits[class] = until
+		}
+	}
+	return
+}
+
+func (fc floodControl) await(class MethodClass) (err error) {
+	fc.lock.Lock()
+	until := fc.until[class]
+	fc.lock.Unlock()
+	d := time.Until(until)
+	switch {
+	case d > fc.waitMax:
+		err = fmt.Errorf("%w: %s, retry in %s", ErrFloodWait, class, d)
+	case d > 0:
+		time.Sleep(d)
+	}
+	return
+}
+
+func (fc floodControl) pause(class MethodClass, d time.Duration) {
+	until := time.Now().Add(d)
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if until.After(fc.until[class]) {
+		fc.until[class] = until
+	}
+	acc := strconv.Itoa(fc.account)
+	metricFloodWaits.WithLabelValues(acc, string(class)).Inc()
+	metricFloodWaitUntil.WithLabelValues(acc, string(class)).Set(float64(fc.until[class].Unix()))
+}
+
+// FloodWait returns the pause required by Telegram if the error is the flood wait one.
+func FloodWait(err error) (d time.Duration, flood bool) {
+	var respErr client.ResponseError
+	if errors.As(err, &respErr) && respErr.Err != nil && respErr.Err.Code == codeTooManyRequests {
+		flood = true
+		d = time.Second // the default pause when the message doesn't contain the value
+		groups := patternRetryAfter.FindStringSubmatch(respErr.Err.Message)
+		if len(groups) > 1 {
+			secs, _ := strconv.Atoi(groups[1])
+			d = time.Duration(secs) * time.Second
+		}
+	}
+	return
+}
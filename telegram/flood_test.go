@@ -0,0 +1,122 @@
+package telegram
+
+import (
+	"errors"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFloodWait(t *testing.T) {
+	cases := map[string]struct {
+		err   error
+		d     time.Duration
+		flood bool
+	}{
+		"nil": {},
+		"other": {
+			err: errors.New("fail"),
+		},
+		"other response error": {
+			err: client.ResponseError{Err: &client.Error{Code: 400, Message: "CHANNEL_INVALID"}},
+		},
+		"retry after": {
+			err:   client.ResponseError{Err: &client.Error{Code: 429, Message: "Too Many Requests: retry after 42"}},
+			d:     42 * time.Second,
+			flood: true,
+		},
+		"flood wait": {
+			err:   client.ResponseError{Err: &client.Error{Code: 429, Message: "FLOOD_WAIT_3"}},
+			d:     3 * time.Second,
+			flood: true,
+		},
+		"no value": {
+			err:   client.ResponseError{Err: &client.Error{Code: 429, Message: "Too Many Requests"}},
+			d:     time.Second,
+			flood: true,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			d, flood := FloodWait(c.err)
+			assert.Equal(t, c.flood, flood)
+			assert.Equal(t, c.d, d)
+		})
+	}
+}
+
+func TestFloodControl_Do(t *testing.T) {
+	fc := NewFloodControl(0, time.Minute)
+	errFlood := client.ResponseError{Err: &client.Error{Code: 429, Message: "FLOOD_WAIT_1"}}
+	// the 1st attempt fails with the flood wait, the call is retried after the pause
+	var attempts int
+	start := time.Now()
+	err := fc.Do(MethodClassJoin, func() error {
+		attempts++
+		if attempts == 1 {
+			return errFlood
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	// other classes are not affected
+	err = fc.Do(MethodClassChats, func() error {
+		return errors.New("fail")
+	})
+	assert.EqualError(t, err, "fail")
+}
+
+func TestFloodControl_WaitMax(t *testing.T) {
+	fc := NewFloodControl(0, time.Second)
+	errFlood := client.ResponseError{Err: &client.Error{Code: 429, Message: "Too Many Requests: retry after 3600"}}
+	var attempts int
+	err := fc.Do(MethodClassSearch, func() error {
+		attempts++
+		return errFlood
+	})
+	assert.ErrorIs(t, err, ErrFloodWait)
+	assert.Equal(t, 1, attempts)
+	waits := fc.Waits()
+	assert.Len(t, waits, 1)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), waits[MethodClassSearch], time.Minute)
+	// the paused class is rejected w/o calling
+	err = fc.Do(MethodClassSearch, func() error {
+		attempts++
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrFloodWait)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestFloodControl_DoNow(t *testing.T) {
+	fc := NewFloodControl(0, time.Hour)
+	errFlood := client.ResponseError{Err: &client.Error{Code: 429, Message: "FLOOD_WAIT_60"}}
+	// the flood wait is returned w/o the retry
+	var attempts int
+	start := time.Now()
+	err := fc.DoNow(MethodClassMessages, func() error {
+		attempts++
+		return errFlood
+	})
+	assert.ErrorIs(t, err, ErrFloodWait)
+	assert.Equal(t, 1, attempts)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), fc.Waits()[MethodClassMessages], 10*time.Second)
+	// the paused class is rejected w/o calling and w/o waiting
+	err = fc.DoNow(MethodClassMessages, func() error {
+		attempts++
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrFloodWait)
+	assert.Equal(t, 1, attempts)
+	// other classes are not affected
+	err = fc.DoNow(MethodClassChats, func() error {
+		attempts++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+}
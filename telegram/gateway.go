@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"github.com/akurilov/go-tdlib/client"
+	"time"
+)
+
+// Gateway is the subset of the TDLib client API used by the service and the handlers.
+type Gateway interface {
+	// account
+	GetMe() (*client.User, error)
+	// GetOption is executed locally, e.g. for the "version" option.
+	GetOption(req *client.GetOptionRequest) (client.OptionValue, error)
+
+	// chats
+	GetChat(req *client.GetChatRequest) (*client.Chat, error)
+	GetChats(req *client.GetChatsRequest) (*client.Chats, error)
+	GetSupergroup(req *client.GetSupergroupRequest) (*client.Supergroup, error)
+	GetSupergroupFullInfo(req *client.GetSupergroupFullInfoRequest) (*client.SupergroupFullInfo, error)
+	SearchPublicChat(req *client.SearchPublicChatRequest) (*client.Chat, error)
+	SearchPublicChats(req *client.SearchPublicChatsRequest) (*client.Chats, error)
+	AddRecentlyFoundChat(req *client.AddRecentlyFoundChatRequest) (*client.Ok, error)
+
+	// join/leave
+	JoinChat(req *client.JoinChatRequest) (*client.Ok, error)
+	LeaveChat(req *client.LeaveChatRequest) (*client.Ok, error)
+
+	// history
+	GetChatHistory(req *client.GetChatHistoryRequest) (*client.Messages, error)
+	GetMessageThread(req *client.GetMessageThreadRequest) (*client.MessageThreadInfo, error)
+
+	// own channels administration
+	CreateNewSupergroupChat(req *client.CreateNewSupergroupChatRequest) (*client.Chat, error)
+	SetSupergroupUsername(req *client.SetSupergroupUsernameRequest) (*client.Ok, error)
+	SetChatPhoto(req *client.SetChatPhotoRequest) (*client.Ok, error)
+	SetChatMemberStatus(req *client.SetChatMemberStatusRequest) (*client.Ok, error)
+
+	// send
+	SendMessage(req *client.SendMessageRequest) (*client.Message, error)
+
+	// FloodWaits returns the current flood wait deadlines by the method class.
+	FloodWaits() (waits map[MethodClass]time.Time)
+}
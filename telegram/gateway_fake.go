@@ -0,0 +1,446 @@
+package telegram
+
+import (
+	"fmt"
+	"github.com/akurilov/go-tdlib/client"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GatewayFake is the scriptable in-memory Gateway for tests. The state fields may be set directly before use, the
+// Errors override the result of the corresponding method by its name, e.g. "JoinChat".
+type GatewayFake struct {
+	Chats               map[int64]*client.Chat
+	Supergroups         map[int64]*client.Supergroup
+	SupergroupFullInfos map[int64]*client.SupergroupFullInfo
+	// Joined contains the joined chat ids in the order returned by GetChats.
+	Joined []int64
+	// SearchResults contains the found chat ids by the query.
+	SearchResults map[string][]int64
+	// Messages contains the chat history by the chat id, the latest message first.
+	Messages       map[int64][]*client.Message
+	MessageThreads map[int64]*client.MessageThreadInfo
+	Errors         map[string]error
+	Waits          map[MethodClass]time.Time
+	Me             *client.User
+	// Options contains the option values by the name.
+	Options map[string]client.OptionValue
+
+	// Calls contains the called method names in order.
+	Calls          []string
+	Photos         map[int64]client.InputChatPhoto
+	MemberStatuses []*client.SetChatMemberStatusRequest
+	Sent           []*client.SendMessageRequest
+
+	lock   *sync.Mutex
+	nextId int64
+}
+
+const fakeChatIdOffsetSupergroup = -1_000_000_000_000
+const fakeCodeBadRequest = 400
+
+func NewGatewayFake() *GatewayFake {
+	return &GatewayFake{
+		Chats:               map[int64]*client.Chat{},
+		Supergroups:         map[int64]*client.Supergroup{},
+		SupergroupFullInfos: map[int64]*client.SupergroupFullInfo{},
+		SearchResults:       map[string][]int64{},
+		Messages:            map[int64][]*client.Message{},
+		MessageThreads:      map[int64]*client.MessageThreadInfo{},
+		Errors:              map[string]error{},
+		Waits:               map[MethodClass]time.Time{},
+		Me:                  &client.User{},
+		Options:             map[string]client.OptionValue{},
+		Photos:              map[int64]client.InputChatPhoto{},
+		lock:                &sync.Mutex{},
+		nextId:              1_000_000,
+	}
+}
+
+// AddSupergroup registers the public channel (or group) with the username, returns the supergroup and its full info to
+// adjust the remaining attributes like the member count, status, description or linked chat.
+func (g *GatewayFake) AddSupergroup(sgId int64, title, username string, isChannel bool) (sg *client.Supergroup, info *client.SupergroupFullInfo) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.addSupergroup(sgId, title, username, isChannel)
+}
+
+// ChatId returns the chat id of the supergroup.
+func (g *GatewayFake) ChatId(sgId int64) int64 {
+	return fakeChatIdOffsetSupergroup - sgId
+}
+
+func (g *GatewayFake) addSupergroup(sgId int64, title, username string, isChannel bool) (sg *client.Supergroup, info *client.SupergroupFullInfo) {
+	chatId := g.ChatId(sgId)
+	g.Chats[chatId] = &client.Chat{
+		Id: chatId,
+		Type: &client.ChatTypeSupergroup{
+			SupergroupId: sgId,
+			IsChannel:    isChannel,
+		},
+		Title: title,
+	}
+	sg = &client.Supergroup{
+		Id:        sgId,
+		Status:    &client.ChatMemberStatusLeft{},
+		IsChannel: isChannel,
+	}
+	if username != "" {
+		sg.Usernames = &client.Usernames{
+			ActiveUsernames:  []string{username},
+			EditableUsername: username,
+		}
+	}
+	g.Supergroups[sgId] = sg
+	info = &client.SupergroupFullInfo{}
+	g.SupergroupFullInfos[sgId] = info
+	return
+}
+
+func (g *GatewayFake) call(method string) (err error) {
+	g.Calls = append(g.Calls, method)
+	err = g.Errors[method]
+	return
+}
+
+func (g *GatewayFake) chat(chatId int64) (chat *client.Chat, err error) {
+	chat = g.Chats[chatId]
+	if chat == nil {
+		err = errNotFound("chat", chatId)
+	}
+	return
+}
+
+func errNotFound(kind string, id int64) error {
+	return client.ResponseError{
+		Err: &client.Error{
+			Code:    fakeCodeBadRequest,
+			Message: fmt.Sprintf("%s %d not found", kind, id),
+		},
+	}
+}
+
+func (g *GatewayFake) GetMe() (me *client.User, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetMe")
+	if err == nil {
+		me = g.Me
+	}
+	return
+}
+
+func (g *GatewayFake) GetOption(req *client.GetOptionRequest) (val client.OptionValue, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetOption")
+	if err == nil {
+		val = g.Options[req.Name]
+		if val == nil {
+			val = &client.OptionValueEmpty{}
+		}
+	}
+	return
+}
+
+func (g *GatewayFake) GetChat(req *client.GetChatRequest) (chat *client.Chat, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetChat")
+	if err == nil {
+		chat, err = g.chat(req.ChatId)
+	}
+	return
+}
+
+func (g *GatewayFake) GetChats(req *client.GetChatsRequest) (chats *client.Chats, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetChats")
+	if err == nil {
+		chats = &client.Chats{
+			ChatIds: slices.Clone(g.Joined),
+		}
+		if req.Limit > 0 && len(chats.ChatIds) > int(req.Limit) {
+			chats.ChatIds = chats.ChatIds[:req.Limit]
+		}
+		chats.TotalCount = int32(len(chats.ChatIds))
+	}
+	return
+}
+
+func (g *GatewayFake) GetSupergroup(req *client.GetSupergroupRequest) (sg *client.Supergroup, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetSupergroup")
+	if err == nil {
+		sg = g.Supergroups[req.SupergroupId]
+		if sg == nil {
+			err = errNotFound("supergroup", req.SupergroupId)
+		}
+	}
+	return
+}
+
+func (g *GatewayFake) GetSupergroupFullInfo(req *client.GetSupergroupFullInfoRequest) (info *client.SupergroupFullInfo, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetSupergroupFullInfo")
+	if err == nil {
+		info = g.SupergroupFullInfos[req.SupergroupId]
+		if info == nil {
+			err = errNotFound("supergroup", req.SupergroupId)
+		}
+	}
+	return
+}
+
+// SearchPublicChat resolves the active username, accepts the "@username" and "https://t.me/username" forms too.
+func (g *GatewayFake) SearchPublicChat(req *client.SearchPublicChatRequest) (chat *client.Chat, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("SearchPublicChat")
+	if err == nil {
+		username := req.Username[strings.LastIndex(req.Username, "/")+1:]
+		username = strings.TrimPrefix(username, "@")
+		for _, c := range g.Chats {
+			sgType, isSg := c.Type.(*client.ChatTypeSupergroup)
+			if !isSg {
+				continue
+			}
+			sg := g.Supergroups[sgType.SupergroupId]
+			if sg != nil && sg.Usernames != nil && slices.ContainsFunc(sg.Usernames.ActiveUsernames, func(u string) bool {
+				return strings.EqualFold(u, username)
+			}) {
+				chat = c
+				break
+			}
+		}
+		if chat == nil {
+			err = client.ResponseError{
+				Err: &client.Error{
+					Code:    fakeCodeBadRequest,
+					Message: "USERNAME_NOT_OCCUPIED",
+				},
+			}
+		}
+	}
+	return
+}
+
+func (g *GatewayFake) SearchPublicChats(req *client.SearchPublicChatsRequest) (chats *client.Chats, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("SearchPublicChats")
+	if err == nil {
+		chats = &client.Chats{
+			ChatIds: slices.Clone(g.SearchResults[req.Query]),
+		}
+		chats.TotalCount = int32(len(chats.ChatIds))
+	}
+	return
+}
+
+func (g *GatewayFake) AddRecentlyFoundChat(req *client.AddRecentlyFoundChatRequest) (ok *client.Ok, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("AddRecentlyFoundChat")
+	if err == nil {
+		_, err = g.chat(req.ChatId)
+	}
+	if err == nil {
+		ok = &client.Ok{}
+	}
+	return
+}
+
+func (g *GatewayFake) JoinChat(req *client.JoinChatRequest) (ok *client.Ok, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("JoinChat")
+	var chat *client.Chat
+	if err == nil {
+		chat, err = g.chat(req.ChatId)
+	}
+	if err == nil {
+		if !slices.Contains(g.Joined, req.ChatId) {
+			g.Joined = append(g.Joined, req.ChatId)
+		}
+		g.setStatus(chat, &client.ChatMemberStatusMember{})
+		ok = &client.Ok{}
+	}
+	return
+}
+
+func (g *GatewayFake) LeaveChat(req *client.LeaveChatRequest) (ok *client.Ok, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("LeaveChat")
+	var chat *client.Chat
+	if err == nil {
+		chat, err = g.chat(req.ChatId)
+	}
+	if err == nil {
+		g.Joined = slices.DeleteFunc(g.Joined, func(chatId int64) bool {
+			return chatId == req.ChatId
+		})
+		g.setStatus(chat, &client.ChatMemberStatusLeft{})
+		ok = &client.Ok{}
+	}
+	return
+}
+
+func (g *GatewayFake) setStatus(chat *client.Chat, status client.ChatMemberStatus) {
+	if sgType, isSg := chat.Type.(*client.ChatTypeSupergroup); isSg {
+		if sg := g.Supergroups[sgType.SupergroupId]; sg != nil {
+			sg.Status = status
+		}
+	}
+}
+
+func (g *GatewayFake) GetChatHistory(req *client.GetChatHistoryRequest) (msgs *client.Messages, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetChatHistory")
+	if err == nil {
+		msgs = &client.Messages{}
+		for _, msg := range g.Messages[req.ChatId] {
+			if req.Limit > 0 && len(msgs.Messages) >= int(req.Limit) {
+				break
+			}
+			if req.FromMessageId == 0 || msg.Id < req.FromMessageId {
+				msgs.Messages = append(msgs.Messages, msg)
+			}
+		}
+		msgs.TotalCount = int32(len(msgs.Messages))
+	}
+	return
+}
+
+func (g *GatewayFake) GetMessageThread(req *client.GetMessageThreadRequest) (thread *client.MessageThreadInfo, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("GetMessageThread")
+	if err == nil {
+		thread = g.MessageThreads[req.MessageId]
+		if thread == nil || thread.ChatId != req.ChatId {
+			thread = nil
+			err = errNotFound("message", req.MessageId)
+		}
+	}
+	return
+}
+
+func (g *GatewayFake) CreateNewSupergroupChat(req *client.CreateNewSupergroupChatRequest) (chat *client.Chat, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("CreateNewSupergroupChat")
+	if err == nil {
+		g.nextId++
+		sg, info := g.addSupergroup(g.nextId, req.Title, "", req.IsChannel)
+		sg.Status = &client.ChatMemberStatusCreator{
+			IsMember: true,
+		}
+		info.Description = req.Description
+		chat = g.Chats[g.ChatId(sg.Id)]
+		g.Joined = append(g.Joined, chat.Id)
+	}
+	return
+}
+
+func (g *GatewayFake) SetSupergroupUsername(req *client.SetSupergroupUsernameRequest) (ok *client.Ok, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("SetSupergroupUsername")
+	if err == nil {
+		sg := g.Supergroups[req.SupergroupId]
+		switch {
+		case sg == nil:
+			err = errNotFound("supergroup", req.SupergroupId)
+		case g.usernameOccupied(req.Username):
+			err = client.ResponseError{
+				Err: &client.Error{
+					Code:    fakeCodeBadRequest,
+					Message: "USERNAME_OCCUPIED",
+				},
+			}
+		default:
+			sg.Usernames = &client.Usernames{
+				ActiveUsernames:  []string{req.Username},
+				EditableUsername: req.Username,
+			}
+			ok = &client.Ok{}
+		}
+	}
+	return
+}
+
+func (g *GatewayFake) usernameOccupied(username string) (occupied bool) {
+	for _, sg := range g.Supergroups {
+		if sg.Usernames != nil && slices.ContainsFunc(sg.Usernames.ActiveUsernames, func(u string) bool {
+			return strings.EqualFold(u, username)
+		}) {
+			occupied = true
+			break
+		}
+	}
+	return
+}
+
+func (g *GatewayFake) SetChatPhoto(req *client.SetChatPhotoRequest) (ok *client.Ok, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("SetChatPhoto")
+	if err == nil {
+		_, err = g.chat(req.ChatId)
+	}
+	if err == nil {
+		g.Photos[req.ChatId] = req.Photo
+		ok = &client.Ok{}
+	}
+	return
+}
+
+func (g *GatewayFake) SetChatMemberStatus(req *client.SetChatMemberStatusRequest) (ok *client.Ok, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("SetChatMemberStatus")
+	if err == nil {
+		_, err = g.chat(req.ChatId)
+	}
+	if err == nil {
+		g.MemberStatuses = append(g.MemberStatuses, req)
+		ok = &client.Ok{}
+	}
+	return
+}
+
+func (g *GatewayFake) SendMessage(req *client.SendMessageRequest) (msg *client.Message, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = g.call("SendMessage")
+	if err == nil {
+		_, err = g.chat(req.ChatId)
+	}
+	if err == nil {
+		g.Sent = append(g.Sent, req)
+		g.nextId++
+		msg = &client.Message{
+			Id:     g.nextId,
+			ChatId: req.ChatId,
+		}
+	}
+	return
+}
+
+func (g *GatewayFake) FloodWaits() (waits map[MethodClass]time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	waits = map[MethodClass]time.Time{}
+	for class, until := range g.Waits {
+		waits[class] = until
+	}
+	return
+}
@@ -0,0 +1,156 @@
+package telegram
+
+import (
+	"errors"
+	"github.com/akurilov/go-tdlib/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"time"
+)
+
+type gatewayMetrics struct {
+	gw      Gateway
+	account string
+}
+
+var metricCallErrors = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "awakari_source_telegram_tdlib_errors_total",
+		Help: "Awakari source telegram: TDLib call errors by method and error code",
+	},
+	[]string{"account", "method", "code"},
+)
+
+func NewGatewayMetrics(gw Gateway, account int) Gateway {
+	return gatewayMetrics{
+		gw:      gw,
+		account: strconv.Itoa(account),
+	}
+}
+
+func (gm gatewayMetrics) GetMe() (result *client.User, err error) {
+	result, err = gm.gw.GetMe()
+	gm.observe("GetMe", err)
+	return
+}
+
+func (gm gatewayMetrics) GetOption(req *client.GetOptionRequest) (result client.OptionValue, err error) {
+	result, err = gm.gw.GetOption(req)
+	gm.observe("GetOption", err)
+	return
+}
+
+func (gm gatewayMetrics) GetChat(req *client.GetChatRequest) (result *client.Chat, err error) {
+	result, err = gm.gw.GetChat(req)
+	gm.observe("GetChat", err)
+	return
+}
+
+func (gm gatewayMetrics) GetChats(req *client.GetChatsRequest) (result *client.Chats, err error) {
+	result, err = gm.gw.GetChats(req)
+	gm.observe("GetChats", err)
+	return
+}
+
+func (gm gatewayMetrics) GetSupergroup(req *client.GetSupergroupRequest) (result *client.Supergroup, err error) {
+	result, err = gm.gw.GetSupergroup(req)
+	gm.observe("GetSupergroup", err)
+	return
+}
+
+func (gm gatewayMetrics) GetSupergroupFullInfo(req *client.GetSupergroupFullInfoRequest) (result *client.SupergroupFullInfo, err error) {
+	result, err = gm.gw.GetSupergroupFullInfo(req)
+	gm.observe("GetSupergroupFullInfo", err)
+	return
+}
+
+func (gm gatewayMetrics) SearchPublicChat(req *client.SearchPublicChatRequest) (result *client.Chat, err error) {
+	result, err = gm.gw.SearchPublicChat(req)
+	gm.observe("SearchPublicChat", err)
+	return
+}
+
+func (gm gatewayMetrics) SearchPublicChats(req *client.SearchPublicChatsRequest) (result *client.Chats, err error) {
+	result, err = gm.gw.SearchPublicChats(req)
+	gm.observe("SearchPublicChats", err)
+	return
+}
+
+func (gm gatewayMetrics) AddRecentlyFoundChat(req *client.AddRecentlyFoundChatRequest) (result *client.Ok, err error) {
+	result, err = gm.gw.AddRecentlyFoundChat(req)
+	gm.observe("AddRecentlyFoundChat", err)
+	return
+}
+
+func (gm gatewayMetrics) JoinChat(req *client.JoinChatRequest) (result *client.Ok, err error) {
+	result, err = gm.gw.JoinChat(req)
+	gm.observe("JoinChat", err)
+	return
+}
+
+func (gm gatewayMetrics) LeaveChat(req *client.LeaveChatRequest) (result *client.Ok, err error) {
+	result, err = gm.gw.LeaveChat(req)
+	gm.observe("LeaveChat", err)
+	return
+}
+
+func (gm gatewayMetrics) GetChatHistory(req *client.GetChatHistoryRequest) (result *client.Messages, err error) {
+	result, err = gm.gw.GetChatHistory(req)
+	gm.observe("GetChatHistory", err)
+	return
+}
+
+func (gm gatewayMetrics) GetMessageThread(req *client.GetMessageThreadRequest) (result *client.MessageThreadInfo, err error) {
+	result, err = gm.gw.GetMessageThread(req)
+	gm.observe("GetMessageThread", err)
+	return
+}
+
+func (gm gatewayMetrics) CreateNewSupergroupChat(req *client.CreateNewSupergroupChatRequest) (result *client.Chat, err error) {
+	result, err = gm.gw.CreateNewSupergroupChat(req)
+	gm.observe("CreateNewSupergroupChat", err)
+	return
+}
+
+func (gm gatewayMetrics) SetSupergroupUsername(req *client.SetSupergroupUsernameRequest) (result *client.Ok, err error) {
+	result, err = gm.gw.SetSupergroupUsername(req)
+	gm.observe("SetSupergroupUsername", err)
+	return
+}
+
+func (gm gatewayMetrics) SetChatPhoto(req *client.SetChatPhotoRequest) (result *client.Ok, err error) {
+	result, err = gm.gw.SetChatPhoto(req)
+	gm.observe("SetChatPhoto", err)
+	return
+}
+
+func (gm gatewayMetrics) SetChatMemberStatus(req *client.SetChatMemberStatusRequest) (result *client.Ok, err error) {
+	result, err = gm.gw.SetChatMemberStatus(req)
+	gm.observe("SetChatMemberStatus", err)
+	return
+}
+
+func (gm gatewayMetrics) SendMessage(req *client.SendMessageRequest) (result *client.Message, err error) {
+	result, err = gm.gw.SendMessage(req)
+	gm.observe("SendMessage", err)
+	return
+}
+
+func (gm gatewayMetrics) FloodWaits() (waits map[MethodClass]time.Time) {
+	return gm.gw.FloodWaits()
+}
+
+func (gm gatewayMetrics) observe(method string, err error) {
+	if err != nil {
+		code := "other"
+		var respErr client.ResponseError
+		switch {
+		case errors.Is(err, ErrFloodWait):
+			code = "flood"
+		case errors.As(err, &respErr) && respErr.Err != nil:
+			code = strconv.Itoa(int(respErr.Err.Code))
+		}
+		metricCallErrors.WithLabelValues(gm.account, method, code).Inc()
+	}
+}
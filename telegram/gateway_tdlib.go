@@ -0,0 +1,164 @@
+package telegram
+
+import (
+	"github.com/akurilov/go-tdlib/client"
+	"time"
+)
+
+// gatewayTdlib routes the calls to the TDLib client through the flood control.
+type gatewayTdlib struct {
+	clientTg *client.Client
+	fc       FloodControl
+}
+
+func NewGateway(clientTg *client.Client, fc FloodControl) Gateway {
+	return gatewayTdlib{
+		clientTg: clientTg,
+		fc:       fc,
+	}
+}
+
+func (g gatewayTdlib) FloodWaits() (waits map[MethodClass]time.Time) {
+	return g.fc.Waits()
+}
+
+func (g gatewayTdlib) AddRecentlyFoundChat(req *client.AddRecentlyFoundChatRequest) (resp *client.Ok, err error) {
+	err = g.fc.Do(MethodClassChats, func() (err error) {
+		resp, err = g.clientTg.AddRecentlyFoundChat(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) CreateNewSupergroupChat(req *client.CreateNewSupergroupChatRequest) (resp *client.Chat, err error) {
+	err = g.fc.Do(MethodClassAdmin, func() (err error) {
+		resp, err = g.clientTg.CreateNewSupergroupChat(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) GetMe() (resp *client.User, err error) {
+	err = g.fc.Do(MethodClassChats, func() (err error) {
+		resp, err = g.clientTg.GetMe()
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) GetOption(req *client.GetOptionRequest) (resp client.OptionValue, err error) {
+	resp, err = client.GetOption(req)
+	return
+}
+
+func (g gatewayTdlib) GetChat(req *client.GetChatRequest) (resp *client.Chat, err error) {
+	err = g.fc.Do(MethodClassChats, func() (err error) {
+		resp, err = g.clientTg.GetChat(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) GetChatHistory(req *client.GetChatHistoryRequest) (resp *client.Messages, err error) {
+	err = g.fc.Do(MethodClassChats, func() (err error) {
+		resp, err = g.clientTg.GetChatHistory(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) GetChats(req *client.GetChatsRequest) (resp *client.Chats, err error) {
+	err = g.fc.Do(MethodClassChats, func() (err error) {
+		resp, err = g.clientTg.GetChats(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) GetMessageThread(req *client.GetMessageThreadRequest) (resp *client.MessageThreadInfo, err error) {
+	err = g.fc.DoNow(MethodClassMessages, func() (err error) {
+		resp, err = g.clientTg.GetMessageThread(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) GetSupergroup(req *client.GetSupergroupRequest) (resp *client.Supergroup, err error) {
+	err = g.fc.Do(MethodClassChats, func() (err error) {
+		resp, err = g.clientTg.GetSupergroup(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) GetSupergroupFullInfo(req *client.GetSupergroupFullInfoRequest) (resp *client.SupergroupFullInfo, err error) {
+	err = g.fc.Do(MethodClassChats, func() (err error) {
+		resp, err = g.clientTg.GetSupergroupFullInfo(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) JoinChat(req *client.JoinChatRequest) (resp *client.Ok, err error) {
+	err = g.fc.Do(MethodClassJoin, func() (err error) {
+		resp, err = g.clientTg.JoinChat(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) LeaveChat(req *client.LeaveChatRequest) (resp *client.Ok, err error) {
+	err = g.fc.Do(MethodClassJoin, func() (err error) {
+		resp, err = g.clientTg.LeaveChat(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) SearchPublicChat(req *client.SearchPublicChatRequest) (resp *client.Chat, err error) {
+	err = g.fc.Do(MethodClassSearch, func() (err error) {
+		resp, err = g.clientTg.SearchPublicChat(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) SearchPublicChats(req *client.SearchPublicChatsRequest) (resp *client.Chats, err error) {
+	err = g.fc.Do(MethodClassSearch, func() (err error) {
+		resp, err = g.clientTg.SearchPublicChats(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) SendMessage(req *client.SendMessageRequest) (resp *client.Message, err error) {
+	err = g.fc.Do(MethodClassSend, func() (err error) {
+		resp, err = g.clientTg.SendMessage(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) SetChatMemberStatus(req *client.SetChatMemberStatusRequest) (resp *client.Ok, err error) {
+	err = g.fc.Do(MethodClassAdmin, func() (err error) {
+		resp, err = g.clientTg.SetChatMemberStatus(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) SetChatPhoto(req *client.SetChatPhotoRequest) (resp *client.Ok, err error) {
+	err = g.fc.Do(MethodClassAdmin, func() (err error) {
+		resp, err = g.clientTg.SetChatPhoto(req)
+		return
+	})
+	return
+}
+
+func (g gatewayTdlib) SetSupergroupUsername(req *client.SetSupergroupUsernameRequest) (resp *client.Ok, err error) {
+	err = g.fc.Do(MethodClassAdmin, func() (err error) {
+		resp, err = g.clientTg.SetSupergroupUsername(req)
+		return
+	})
+	return
+}
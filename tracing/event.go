@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// the W3C trace context is always used for the events, regardless of the global propagator
+var propagator = propagation.TraceContext{}
+
+// eventCarrier stores the trace context in the CloudEvent extension attributes, e.g. "traceparent".
+type eventCarrier struct {
+	evt *pb.CloudEvent
+}
+
+func (ec eventCarrier) Get(key string) (val string) {
+	if attr, found := ec.evt.Attributes[key]; found && attr != nil {
+		val = attr.GetCeString()
+	}
+	return
+}
+
+func (ec eventCarrier) Set(key, val string) {
+	if ec.evt.Attributes == nil {
+		ec.evt.Attributes = map[string]*pb.CloudEventAttributeValue{}
+	}
+	ec.evt.Attributes[key] = &pb.CloudEventAttributeValue{
+		Attr: &pb.CloudEventAttributeValue_CeString{
+			CeString: val,
+		},
+	}
+}
+
+func (ec eventCarrier) Keys() (keys []string) {
+	for _, k := range propagator.Fields() {
+		if _, found := ec.evt.Attributes[k]; found {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// Inject writes the trace context of the current span into the event, so the downstream services continue the trace.
+func Inject(ctx context.Context, evt *pb.CloudEvent) {
+	propagator.Inject(ctx, eventCarrier{evt: evt})
+}
+
+// Extract returns the context with the remote span from the event, if any.
+func Extract(ctx context.Context, evt *pb.CloudEvent) context.Context {
+	return propagator.Extract(ctx, eventCarrier{evt: evt})
+}
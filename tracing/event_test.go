@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestInjectExtract(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	ctx, span := tp.Tracer("test").Start(context.TODO(), "publish")
+	evt := &pb.CloudEvent{
+		Id: "evt0",
+	}
+	Inject(ctx, evt)
+	span.End()
+	//
+	traceparent := evt.Attributes["traceparent"].GetCeString()
+	assert.Regexp(t, `^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`, traceparent)
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+	// the downstream continues the same trace
+	ctxDownstream := Extract(context.TODO(), evt)
+	scRemote := trace.SpanContextFromContext(ctxDownstream)
+	assert.True(t, scRemote.IsRemote())
+	assert.Equal(t, span.SpanContext().TraceID(), scRemote.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), scRemote.SpanID())
+	_, spanDownstream := tp.Tracer("test").Start(ctxDownstream, "consume")
+	spanDownstream.End()
+	spans := exp.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext.SpanID(), spans[1].Parent.SpanID())
+}
+
+func TestExtract_Missing(t *testing.T) {
+	ctx := Extract(context.TODO(), &pb.CloudEvent{})
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
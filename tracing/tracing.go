@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+	"github.com/awakari/source-telegram/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "source-telegram"
+
+const scopeName = "github.com/awakari/source-telegram"
+
+// Tracer returns the tracer of the globally registered provider, noop until the provider is set.
+func Tracer() trace.Tracer {
+	return otel.Tracer(scopeName)
+}
+
+// NewProvider exports the spans via OTLP gRPC when the endpoint is configured, otherwise the spans are only
+// sampled to propagate the trace context downstream.
+func NewProvider(ctx context.Context, cfg config.TracingConfig, replica string) (tp *sdktrace.TracerProvider, err error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceInstanceID(replica),
+		)),
+	}
+	if cfg.Endpoint != "" {
+		var exp sdktrace.SpanExporter
+		exp, err = otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(cfg.Endpoint), otlptracegrpc.WithInsecure())
+		if err != nil {
+			return
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+	tp = sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return
+}
@@ -0,0 +1,375 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-telegram/model"
+	"io"
+	"strconv"
+	"time"
+)
+
+// the channels dump formats
+const FormatJsonl = "jsonl"
+const FormatCsv = "csv"
+
+var ErrFormat = errors.New("unsupported dump format")
+
+// ErrRow is the failure to decode the single row, the next rows may be decoded still.
+var ErrRow = errors.New("invalid row")
+
+// Encoder writes the channels dump with all the channel fields.
+type Encoder interface {
+	Encode(ch model.Channel) (err error)
+	// Flush writes the buffered rows.
+	Flush() (err error)
+}
+
+// Decoder reads the channels dump written by the Encoder of the same format.
+type Decoder interface {
+	// Decode returns the next channel and its row number starting from 1 (the CSV header is not counted), io.EOF when
+	// there are no more rows. The error wrapping ErrRow is specific to the row, the decoding may be continued.
+	Decode() (ch model.Channel, row uint32, err error)
+}
+
+// the CSV columns, the header is mandatory and the columns may go in any order, the missing ones are left empty
+const colId = "id"
+const colGroupId = "groupId"
+const colUserId = "userId"
+const colName = "name"
+const colLink = "link"
+const colCreated = "created"
+const colLast = "last"
+const colSubId = "subId"
+const colTerms = "terms"
+const colLabel = "label"
+const colDiscussion = "discussion"
+const colStale = "stale"
+const colDeleted = "deleted"
+const colDeleteReason = "deleteReason"
+
+var csvHeader = []string{
+	colId,
+	colGroupId,
+	colUserId,
+	colName,
+	colLink,
+	colCreated,
+	colLast,
+	colSubId,
+	colTerms,
+	colLabel,
+	colDiscussion,
+	colStale,
+	colDeleted,
+	colDeleteReason,
+}
+
+// jsonlLineLenMax is the longest JSON line accepted, the channel terms are the only long field.
+const jsonlLineLenMax = 1 << 20
+
+type recJson struct {
+	Id           int64      `json:"id"`
+	GroupId      string     `json:"groupId,omitempty"`
+	UserId       string     `json:"userId,omitempty"`
+	Name         string     `json:"name,omitempty"`
+	Link         string     `json:"link"`
+	Created      *time.Time `json:"created,omitempty"`
+	Last         *time.Time `json:"last,omitempty"`
+	SubId        string     `json:"subId,omitempty"`
+	Terms        string     `json:"terms,omitempty"`
+	Label        string     `json:"label,omitempty"`
+	Discussion   bool       `json:"discussion,omitempty"`
+	Stale        *time.Time `json:"stale,omitempty"`
+	Deleted      *time.Time `json:"deleted,omitempty"`
+	DeleteReason string     `json:"deleteReason,omitempty"`
+}
+
+type jsonlEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+type jsonlDecoder struct {
+	s   *bufio.Scanner
+	row uint32
+}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+	// cols contains the column index by the name, nil until the header is read
+	cols map[string]int
+	row  uint32
+}
+
+func NewEncoder(w io.Writer, format string) (enc Encoder, err error) {
+	switch format {
+	case FormatJsonl:
+		bw := bufio.NewWriter(w)
+		enc = jsonlEncoder{
+			w:   bw,
+			enc: json.NewEncoder(bw),
+		}
+	case FormatCsv:
+		enc = &csvEncoder{
+			w: csv.NewWriter(w),
+		}
+	default:
+		err = fmt.Errorf("%w: %q", ErrFormat, format)
+	}
+	return
+}
+
+func NewDecoder(r io.Reader, format string) (dec Decoder, err error) {
+	switch format {
+	case FormatJsonl:
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), jsonlLineLenMax)
+		dec = &jsonlDecoder{
+			s: s,
+		}
+	case FormatCsv:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		dec = &csvDecoder{
+			r: cr,
+		}
+	default:
+		err = fmt.Errorf("%w: %q", ErrFormat, format)
+	}
+	return
+}
+
+func (e jsonlEncoder) Encode(ch model.Channel) (err error) {
+	err = e.enc.Encode(recJson{
+		Id:           ch.Id,
+		GroupId:      ch.GroupId,
+		UserId:       ch.UserId,
+		Name:         ch.Name,
+		Link:         ch.Link,
+		Created:      timeRef(ch.Created),
+		Last:         timeRef(ch.Last),
+		SubId:        ch.SubId,
+		Terms:        ch.Terms,
+		Label:        ch.Label,
+		Discussion:   ch.Discussion,
+		Stale:        timeRef(ch.Stale),
+		Deleted:      timeRef(ch.Deleted),
+		DeleteReason: ch.DeleteReason,
+	})
+	return
+}
+
+func (e jsonlEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+func (d *jsonlDecoder) Decode() (ch model.Channel, row uint32, err error) {
+	for {
+		if !d.s.Scan() {
+			err = d.s.Err()
+			if err == nil {
+				err = io.EOF
+			}
+			return
+		}
+		d.row++
+		if len(d.s.Bytes()) > 0 {
+			break
+		}
+	}
+	row = d.row
+	var rec recJson
+	err = json.Unmarshal(d.s.Bytes(), &rec)
+	switch err {
+	case nil:
+		ch = model.Channel{
+			Id:           rec.Id,
+			GroupId:      rec.GroupId,
+			UserId:       rec.UserId,
+			Name:         rec.Name,
+			Link:         rec.Link,
+			Created:      timeVal(rec.Created),
+			Last:         timeVal(rec.Last),
+			SubId:        rec.SubId,
+			Terms:        rec.Terms,
+			Label:        rec.Label,
+			Discussion:   rec.Discussion,
+			Stale:        timeVal(rec.Stale),
+			Deleted:      timeVal(rec.Deleted),
+			DeleteReason: rec.DeleteReason,
+		}
+	default:
+		err = fmt.Errorf("%w: %s", ErrRow, err)
+	}
+	return
+}
+
+func (e *csvEncoder) Encode(ch model.Channel) (err error) {
+	if !e.header {
+		err = e.w.Write(csvHeader)
+		e.header = true
+	}
+	if err == nil {
+		err = e.w.Write([]string{
+			strconv.FormatInt(ch.Id, 10),
+			ch.GroupId,
+			ch.UserId,
+			ch.Name,
+			ch.Link,
+			formatTime(ch.Created),
+			formatTime(ch.Last),
+			ch.SubId,
+			ch.Terms,
+			ch.Label,
+			strconv.FormatBool(ch.Discussion),
+			formatTime(ch.Stale),
+			formatTime(ch.Deleted),
+			ch.DeleteReason,
+		})
+	}
+	return
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (d *csvDecoder) Decode() (ch model.Channel, row uint32, err error) {
+	if d.cols == nil {
+		err = d.readHeader()
+		if err != nil {
+			return
+		}
+	}
+	var rec []string
+	rec, err = d.r.Read()
+	d.row++
+	row = d.row
+	var errParse *csv.ParseError
+	switch {
+	case errors.As(err, &errParse):
+		err = fmt.Errorf("%w: %s", ErrRow, err)
+	case err != nil:
+		// the source failure, io.EOF included
+	case len(rec) != len(d.cols):
+		err = fmt.Errorf("%w: expected %d fields, got %d", ErrRow, len(d.cols), len(rec))
+	default:
+		ch, err = d.decode(rec)
+	}
+	return
+}
+
+func (d *csvDecoder) readHeader() (err error) {
+	var header []string
+	header, err = d.r.Read()
+	if err == nil {
+		cols := map[string]int{}
+		for i, col := range header {
+			if _, known := csvColumns[col]; !known {
+				err = fmt.Errorf("%w: unknown CSV column %q", ErrFormat, col)
+				break
+			}
+			cols[col] = i
+		}
+		if _, found := cols[colLink]; err == nil && !found {
+			err = fmt.Errorf("%w: missing CSV column %q", ErrFormat, colLink)
+		}
+		if err == nil {
+			d.cols = cols
+		}
+	}
+	return
+}
+
+var csvColumns = func() (cols map[string]struct{}) {
+	cols = map[string]struct{}{}
+	for _, col := range csvHeader {
+		cols[col] = struct{}{}
+	}
+	return
+}()
+
+func (d *csvDecoder) decode(rec []string) (ch model.Channel, err error) {
+	field := func(col string) (v string) {
+		if i, found := d.cols[col]; found {
+			v = rec[i]
+		}
+		return
+	}
+	ch = model.Channel{
+		GroupId:      field(colGroupId),
+		UserId:       field(colUserId),
+		Name:         field(colName),
+		Link:         field(colLink),
+		SubId:        field(colSubId),
+		Terms:        field(colTerms),
+		Label:        field(colLabel),
+		DeleteReason: field(colDeleteReason),
+	}
+	var errs []error
+	if v := field(colId); v != "" {
+		var errField error
+		ch.Id, errField = strconv.ParseInt(v, 10, 64)
+		errs = append(errs, errField)
+	}
+	if v := field(colDiscussion); v != "" {
+		var errField error
+		ch.Discussion, errField = strconv.ParseBool(v)
+		errs = append(errs, errField)
+	}
+	for col, dst := range map[string]*time.Time{
+		colCreated: &ch.Created,
+		colLast:    &ch.Last,
+		colStale:   &ch.Stale,
+		colDeleted: &ch.Deleted,
+	} {
+		var errField error
+		*dst, errField = parseTime(field(col))
+		errs = append(errs, errField)
+	}
+	err = errors.Join(errs...)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrRow, err)
+	}
+	return
+}
+
+func timeRef(t time.Time) (ref *time.Time) {
+	if !t.IsZero() {
+		t = t.UTC()
+		ref = &t
+	}
+	return
+}
+
+func timeVal(ref *time.Time) (t time.Time) {
+	if ref != nil {
+		t = ref.UTC()
+	}
+	return
+}
+
+func formatTime(t time.Time) (s string) {
+	if !t.IsZero() {
+		s = t.UTC().Format(time.RFC3339Nano)
+	}
+	return
+}
+
+func parseTime(s string) (t time.Time, err error) {
+	if s != "" {
+		t, err = time.Parse(time.RFC3339Nano, s)
+		t = t.UTC()
+	}
+	return
+}
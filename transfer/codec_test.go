@@ -0,0 +1,126 @@
+package transfer
+
+import (
+	"bytes"
+	"github.com/awakari/source-telegram/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	chans := []model.Channel{
+		{
+			Id:           -1001,
+			GroupId:      "group0",
+			UserId:       "user0",
+			Name:         "chan, \"0\"",
+			Link:         "https://t.me/chan0",
+			Created:      time.Date(2024, 11, 4, 18, 49, 25, 123_000_000, time.UTC),
+			Last:         time.Date(2024, 11, 5, 18, 49, 25, 0, time.UTC),
+			SubId:        "sub0",
+			Terms:        "foo\nbar",
+			Label:        "1",
+			Discussion:   true,
+			Stale:        time.Date(2024, 11, 6, 0, 0, 0, 0, time.UTC),
+			Deleted:      time.Date(2024, 11, 7, 0, 0, 0, 0, time.UTC),
+			DeleteReason: "reason0",
+		},
+		{
+			Id:   -1002,
+			Link: "https://t.me/chan1",
+		},
+	}
+	for _, format := range []string{FormatJsonl, FormatCsv} {
+		t.Run(format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			enc, err := NewEncoder(buf, format)
+			require.Nil(t, err)
+			for _, ch := range chans {
+				require.Nil(t, enc.Encode(ch))
+			}
+			require.Nil(t, enc.Flush())
+			dec, err := NewDecoder(buf, format)
+			require.Nil(t, err)
+			for i, ch := range chans {
+				got, row, err := dec.Decode()
+				require.Nil(t, err)
+				assert.Equal(t, uint32(i+1), row)
+				assert.Equal(t, ch, got)
+			}
+			_, _, err = dec.Decode()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestCodec_InvalidRows(t *testing.T) {
+	cases := map[string]struct {
+		format string
+		in     string
+		links  []string
+		rows   []uint32
+		err    error
+	}{
+		"jsonl": {
+			format: FormatJsonl,
+			in:     "{\"id\":-1001,\"link\":\"@chan0\"}\n\n{\"id\":\"x\"}\n{\"id\":-1003,\"link\":\"@chan2\"}\n",
+			links:  []string{"@chan0", "", "@chan2"},
+			rows:   []uint32{1, 3, 4},
+		},
+		"csv": {
+			format: FormatCsv,
+			in:     "link,id,created\n@chan0,-1001,\n@chan1,x,2024-11-04\n@chan2\n@chan3,-1004,2024-11-04T18:49:25Z\n",
+			links:  []string{"@chan0", "", "", "@chan3"},
+			rows:   []uint32{1, 2, 3, 4},
+		},
+		"csv unknown column": {
+			format: FormatCsv,
+			in:     "link,foo\n@chan0,bar\n",
+			err:    ErrFormat,
+		},
+		"csv missing link": {
+			format: FormatCsv,
+			in:     "id\n-1001\n",
+			err:    ErrFormat,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			dec, err := NewDecoder(strings.NewReader(c.in), c.format)
+			require.Nil(t, err)
+			var links []string
+			var rows []uint32
+			for {
+				ch, row, err := dec.Decode()
+				if err == io.EOF {
+					break
+				}
+				if c.err != nil {
+					assert.ErrorIs(t, err, c.err)
+					return
+				}
+				switch err {
+				case nil:
+					links = append(links, ch.Link)
+				default:
+					assert.ErrorIs(t, err, ErrRow)
+					links = append(links, "")
+				}
+				rows = append(rows, row)
+			}
+			assert.Equal(t, c.links, links)
+			assert.Equal(t, c.rows, rows)
+		})
+	}
+}
+
+func TestNewEncoder_Format(t *testing.T) {
+	_, err := NewEncoder(io.Discard, "xml")
+	assert.ErrorIs(t, err, ErrFormat)
+	_, err = NewDecoder(strings.NewReader(""), "xml")
+	assert.ErrorIs(t, err, ErrFormat)
+}
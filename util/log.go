@@ -1,6 +1,19 @@
 package util
 
-import "log/slog"
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+)
+
+const LogFormatJson = "json"
+
+type ctxKeyLogAttrs struct{}
+
+type ctxHandler struct {
+	slog.Handler
+}
 
 func LogLevel(err error) (lvl slog.Level) {
 	switch err {
@@ -11,3 +24,61 @@ func LogLevel(err error) (lvl slog.Level) {
 	}
 	return
 }
+
+// LogErr returns the error attribute, the empty one is omitted from the record when there's no error.
+func LogErr(err error) (attr slog.Attr) {
+	if err != nil {
+		attr = slog.Any("err", err)
+	}
+	return
+}
+
+// WithLogAttrs returns the context carrying the request-scoped attributes to add to every record logged with it.
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev := LogAttrs(ctx)
+	next := make([]slog.Attr, 0, len(prev)+len(attrs))
+	next = append(next, prev...)
+	next = append(next, attrs...)
+	return context.WithValue(ctx, ctxKeyLogAttrs{}, next)
+}
+
+func LogAttrs(ctx context.Context) (attrs []slog.Attr) {
+	if ctx != nil {
+		attrs, _ = ctx.Value(ctxKeyLogAttrs{}).([]slog.Attr)
+	}
+	return
+}
+
+// NewLogHandler returns the JSON or text handler which adds the context attributes and the trace id to every record.
+func NewLogHandler(w io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	var h slog.Handler
+	switch format {
+	case LogFormatJson:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		h = slog.NewTextHandler(w, opts)
+	}
+	return ctxHandler{
+		Handler: h,
+	}
+}
+
+func (ch ctxHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(LogAttrs(ctx)...)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("traceId", sc.TraceID().String()), slog.String("spanId", sc.SpanID().String()))
+	}
+	return ch.Handler.Handle(ctx, r)
+}
+
+func (ch ctxHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return ctxHandler{
+		Handler: ch.Handler.WithAttrs(attrs),
+	}
+}
+
+func (ch ctxHandler) WithGroup(name string) slog.Handler {
+	return ctxHandler{
+		Handler: ch.Handler.WithGroup(name),
+	}
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := slog.New(NewLogHandler(buf, LogFormatJson, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx := WithLogAttrs(context.TODO(), slog.Int("account", 1))
+	ctx = WithLogAttrs(ctx, slog.Int64("chatId", -1001))
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(ctx, "test")
+	defer span.End()
+	//
+	log.LogAttrs(ctx, slog.LevelDebug, "pub.Publish", slog.String("evtId", "evt0"), LogErr(nil))
+	log.With("replica", "0").LogAttrs(ctx, slog.LevelError, "pub.Publish", slog.String("evtId", "evt1"), LogErr(errors.New("fail")))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	//
+	var rec map[string]any
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "pub.Publish", rec["msg"])
+	assert.Equal(t, "evt0", rec["evtId"])
+	assert.Equal(t, 1.0, rec["account"])
+	assert.Equal(t, -1001.0, rec["chatId"])
+	assert.Equal(t, span.SpanContext().TraceID().String(), rec["traceId"])
+	assert.NotContains(t, rec, "err")
+	//
+	rec = nil
+	require.Nil(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, "fail", rec["err"])
+	assert.Equal(t, "0", rec["replica"])
+	assert.Equal(t, 1.0, rec["account"])
+}
+
+func TestNewLogHandler_Text(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := slog.New(NewLogHandler(buf, "text", nil))
+	log.InfoContext(WithLogAttrs(context.TODO(), slog.String("link", "https://t.me/chan0")), "storage.Read")
+	assert.Contains(t, buf.String(), `msg=storage.Read link=https://t.me/chan0`)
+}